@@ -7,7 +7,9 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
+	"github.com/gorilla/websocket"
 	"golang.org/x/net/proxy"
 )
 
@@ -45,6 +47,37 @@ func GetProxiedHttpClient(proxyUrl string) (*http.Client, error) {
 	}
 }
 
+// GetProxiedWebsocketDialer is GetProxiedHttpClient for websocket connections.
+func GetProxiedWebsocketDialer(proxyUrl string) (*websocket.Dialer, error) {
+	dialer := &websocket.Dialer{
+		HandshakeTimeout: 10 * time.Second,
+	}
+	if proxyUrl == "" {
+		return dialer, nil
+	}
+
+	u, err := url.Parse(proxyUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasPrefix(proxyUrl, "http"):
+		dialer.Proxy = http.ProxyURL(u)
+	case strings.HasPrefix(proxyUrl, "socks"):
+		socksDialer, err := proxy.FromURL(u, proxy.Direct)
+		if err != nil {
+			return nil, err
+		}
+		dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return socksDialer.(proxy.ContextDialer).DialContext(ctx, network, addr)
+		}
+	default:
+		return nil, errors.New("unsupported proxy type: " + proxyUrl)
+	}
+	return dialer, nil
+}
+
 func ProxiedHttpRequest(method, url, proxyUrl string) (*http.Response, error) {
 	client, err := GetProxiedHttpClient(proxyUrl)
 	if err != nil {
@@ -22,6 +22,7 @@ var Logo = ""
 var TopUpLink = ""
 var ChatLink = ""
 var QuotaPerUnit = 500 * 1000.0 // $0.002 / 1K tokens
+var BatchRatio = 0.5            // 批处理请求的计费倍率
 var DisplayInCurrencyEnabled = true
 var DisplayTokenStatEnabled = true
 var EmailNotificationsEnabled = true
@@ -107,3 +108,52 @@ var RootUserEmail = ""
 var BatchUpdateEnabled = false
 
 var BlankReplyRetryEnabled = true
+
+// FileUpstreamEnabled forwards /v1/files uploads to a channel serving the "files" model instead of local storage
+var FileUpstreamEnabled = false
+
+// ResponseCacheEnabled turns on the chat completion response cache for the tokens and groups opting in
+var ResponseCacheEnabled = false
+var ResponseCacheTTL = 3600     // 缓存默认有效期（秒）
+var ResponseCacheHitRatio = 0.1 // 命中缓存时的计费倍率
+
+// 渠道熔断：按渠道和模型统计滚动窗口内的错误率与延迟，超过阈值时暂停选用，冷却后放行探测请求
+var CircuitBreakerEnabled = true
+var CircuitBreakerWindow = 60          // 统计窗口（秒）
+var CircuitBreakerMinRequests = 10     // 窗口内请求数达到该值才判断是否熔断
+var CircuitBreakerErrorRate = 0.5      // 错误率阈值
+var CircuitBreakerLatencyThreshold = 0 // 平均延迟阈值（毫秒），0 表示不按延迟熔断
+var CircuitBreakerOpenDuration = 30    // 熔断持续时间（秒），之后进入半开状态
+
+// 渠道限流：所有渠道都达到 RPM/TPM/并发上限时，请求排队等待空闲渠道
+var ChannelQueueSize = 100   // 同时排队的请求数上限
+var ChannelQueueTimeout = 10 // 排队等待的最长时间（秒）
+
+// RoutingStrategy is the default way to pick among channels of the same priority, see common.GetRoutingStrategy
+var RoutingStrategy = "weighted"
+var RoutingLatencyEWMAAlpha = 0.3 // 延迟 EWMA 中最新一次请求所占的比重
+
+// StickyRoutingEnabled routes the requests of one conversation to the same channel so upstream prompt caches hit,
+// conversations are told apart by the X-Session-Id header or by the system prompt and leading messages
+var StickyRoutingEnabled = false
+var StickyRoutingTTL = 3600   // 会话与渠道绑定的有效期（秒）
+var StickyRoutingMessages = 1 // 计算会话哈希时取开头的非系统消息数
+
+// StreamFailoverEnabled continues an interrupted chat completion stream on another channel,
+// the first events are held back so an upstream failing early is replaced without the client noticing.
+var StreamFailoverEnabled = false
+var StreamFailoverBufferEvents = 5 // 开始向客户端发送前暂存的事件数
+
+// ModelSyncEnabled periodically fetches the model list of every channel from upstream and records the changes,
+// new and retired models are only applied to the channels when the auto options are on.
+var ModelSyncEnabled = false
+var ModelSyncInterval = 1440 // 同步间隔（分钟）
+var ModelSyncAutoAddEnabled = false
+var ModelSyncAutoRemoveEnabled = false
+
+// QuotaReconcileEnabled periodically compares the quota ledger with the balances of users and tokens
+// and notifies the admin of the accounts that drifted.
+var QuotaReconcileEnabled = true
+var QuotaReconcileInterval = 1440 // 对账间隔（分钟）
+
+var SubscriptionRemindDays = 3 // 订阅到期前几天发送续费提醒，0 为不提醒
@@ -25,8 +25,19 @@ var BatchUpdateInterval = GetOrDefault("BATCH_UPDATE_INTERVAL", 5)
 
 var RelayTimeout = GetOrDefault("RELAY_TIMEOUT", 0) // unit is second
 
+var BatchJobWorkers = GetOrDefault("BATCH_JOB_WORKERS", 4)
+
+var BatchPollInterval = GetOrDefault("BATCH_POLL_INTERVAL", 10) // unit is second
+
+var FineTuningPollInterval = GetOrDefault("FINE_TUNING_POLL_INTERVAL", 60) // unit is second
+
 const (
-	RequestIdKey = "X-Oneapi-Request-Id"
+	RequestIdKey      = "X-Oneapi-Request-Id"
+	BatchIdKey        = "X-Chatapi-Batch-Id"
+	ResponseCacheKey  = "X-Chatapi-Response-Cache"
+	ChannelKeyIdKey   = "X-Chatapi-Channel-Key-Id"
+	RequestedModelKey = "X-Chatapi-Requested-Model"
+	TokenDetailsKey   = "X-Chatapi-Token-Details"
 )
 
 const (
@@ -82,6 +93,11 @@ const (
 	RedemptionCodeStatusUsed     = 3 // also don't use 0
 )
 
+const (
+	PlanStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	PlanStatusDisabled = 2 // also don't use 0
+)
+
 const (
 	ChannelStatusUnknown          = 0
 	ChannelStatusEnabled          = 1 // don't use 0, 0 is the default value!
@@ -21,4 +21,14 @@ const (
 	BaseURL           = "base_url"
 	AvailableModels   = "available_models"
 	ContentType       = "content_type"
+	StreamInterrupted = "stream_interrupted"  // 上游流式响应未正常结束
+	StreamAborted     = "stream_aborted"      // 已发送部分内容的流式响应无法由其他渠道接续，不再重试
+	HedgeContext      = "hedge_context"       // 对冲请求的上游请求上下文，落后的一方被取消
+	HedgeLost         = "hedge_lost"          // 对冲请求中落后的一方，不计费
+	HedgeStartTime    = "hedge_start_time"    // 对冲请求中返回结果的一方的开始时间
+	FirstResponseTime = "first_response_time" // 响应第一次写给客户端的时间
+	StickyRoutingKey  = "sticky_routing_key"  // 会话粘性路由的绑定键，选好密钥后保存绑定
+	StickyKeyId       = "sticky_key_id"       // 会话上次使用的密钥
+	PlanModels        = "plan_models"         // 订阅套餐允许使用的模型
+	ClaudeNative      = "claude_native"       // 渠道直接处理 Claude 原生请求（/v1/messages）
 )
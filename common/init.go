@@ -23,7 +23,9 @@ func printHelp() {
 	fmt.Println("Usage: one-api [--port <port>] [--log-dir <log directory>] [--version] [--help]")
 }
 
-func init() {
+// Init parses the command line and reads the settings from the environment. It isn't an init function, so
+// packages can be imported without parsing flags, e.g. by tests whose flags the testing package defines later.
+func Init() {
 	flag.Parse()
 
 	if *PrintVersion {
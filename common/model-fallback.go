@@ -0,0 +1,43 @@
+package common
+
+import "encoding/json"
+
+// ModelFallback lists, per requested model, the models tried in order once every channel of the
+// requested model has failed, GroupModelFallback overrides it for a group.
+var ModelFallback = map[string][]string{}
+var GroupModelFallback = map[string]map[string][]string{}
+
+func ModelFallback2JSONString() string {
+	jsonBytes, err := json.Marshal(ModelFallback)
+	if err != nil {
+		SysError("error marshalling model fallback: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelFallbackByJSONString(jsonStr string) error {
+	ModelFallback = make(map[string][]string)
+	return json.Unmarshal([]byte(jsonStr), &ModelFallback)
+}
+
+func GroupModelFallback2JSONString() string {
+	jsonBytes, err := json.Marshal(GroupModelFallback)
+	if err != nil {
+		SysError("error marshalling group model fallback: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupModelFallbackByJSONString(jsonStr string) error {
+	GroupModelFallback = make(map[string]map[string][]string)
+	return json.Unmarshal([]byte(jsonStr), &GroupModelFallback)
+}
+
+// GetModelFallbacks returns the fallback chain of model for group, without the model itself.
+func GetModelFallbacks(group string, model string) []string {
+	chain, ok := GroupModelFallback[group][model]
+	if !ok {
+		chain = ModelFallback[model]
+	}
+	return chain
+}
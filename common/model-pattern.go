@@ -0,0 +1,90 @@
+package common
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// 渠道模型、令牌可用模型、模型映射和模型倍率中可以使用模型规则：
+// 含 * 的为通配规则，如 gpt-4o-*、ft:gpt-4o:*；以 regex: 开头的为正则规则，需匹配完整模型名。
+// 精确的模型名优先，其次是最长的匹配规则。
+
+const modelRegexPrefix = "regex:"
+
+var modelPatterns sync.Map // pattern -> *regexp.Regexp, nil when invalid
+
+func IsModelPattern(name string) bool {
+	return strings.Contains(name, "*") || strings.HasPrefix(name, modelRegexPrefix)
+}
+
+func compileModelPattern(pattern string) *regexp.Regexp {
+	if value, ok := modelPatterns.Load(pattern); ok {
+		return value.(*regexp.Regexp)
+	}
+	var expr string
+	if strings.HasPrefix(pattern, modelRegexPrefix) {
+		expr = "^(?:" + strings.TrimPrefix(pattern, modelRegexPrefix) + ")$"
+	} else {
+		parts := strings.Split(pattern, "*")
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		expr = "^" + strings.Join(parts, ".*") + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		SysError("invalid model pattern " + pattern + ": " + err.Error())
+		re = nil
+	}
+	modelPatterns.Store(pattern, re)
+	return re
+}
+
+// ValidateModelPattern reports a regex pattern that doesn't compile.
+func ValidateModelPattern(name string) error {
+	if !strings.HasPrefix(name, modelRegexPrefix) {
+		return nil
+	}
+	_, err := regexp.Compile(strings.TrimPrefix(name, modelRegexPrefix))
+	return err
+}
+
+// MatchModel tells whether model is name itself or matched by the pattern name.
+func MatchModel(name string, model string) bool {
+	if name == model {
+		return true
+	}
+	if !IsModelPattern(name) {
+		return false
+	}
+	re := compileModelPattern(name)
+	return re != nil && re.MatchString(model)
+}
+
+// MatchModelAny tells whether model is matched by any of names.
+func MatchModelAny(names []string, model string) bool {
+	for _, name := range names {
+		if MatchModel(name, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindModelKey returns the key of m applying to model, model itself when present, otherwise the longest matching pattern.
+func FindModelKey[V any](m map[string]V, model string) (string, bool) {
+	if _, ok := m[model]; ok {
+		return model, true
+	}
+	best := ""
+	for key := range m {
+		if !IsModelPattern(key) || len(key) < len(best) || (len(key) == len(best) && key > best) {
+			continue
+		}
+		if MatchModel(key, model) {
+			best = key
+		}
+	}
+	return best, best != ""
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchModel(t *testing.T) {
+	tests := []struct {
+		name    string
+		model   string
+		matched bool
+	}{
+		{"gpt-4o", "gpt-4o", true},
+		{"gpt-4o", "gpt-4o-mini", false},
+		{"gpt-4o-*", "gpt-4o-mini", true},
+		{"gpt-4o-*", "gpt-4o-", true},
+		{"gpt-4o-*", "gpt-4o", false},
+		{"*-preview", "o1-preview", true},
+		{"ft:gpt-4o:*", "ft:gpt-4o:org:custom:abc", true},
+		{"gpt-4o.*", "gpt-4o-mini", false}, // 通配规则中的 . 不是正则
+		{"claude-*-sonnet-*", "claude-3-5-sonnet-20241022", true},
+		{"claude-*-sonnet-*", "claude-3-5-haiku-20241022", false},
+		{"regex:gpt-4o(-mini)?", "gpt-4o-mini", true},
+		{"regex:gpt-4o(-mini)?", "gpt-4o-mini-2024", false}, // 正则需匹配完整模型名
+		{"regex:gpt-4o|o1", "o1", true},
+		{"regex:gpt-4o|o1", "xo1", false},
+		{"regex:gpt-(4o", "gpt-(4o", false}, // 无效的正则不匹配任何模型
+		{"regex:gpt-(4o", "regex:gpt-(4o", true},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.matched, MatchModel(test.name, test.model), "%s matching %s", test.name, test.model)
+	}
+}
+
+func TestFindModelKey(t *testing.T) {
+	ratios := map[string]float64{
+		"gpt-4o":                 1,
+		"gpt-4o-*":               2,
+		"gpt-4o-mini-*":          3,
+		"regex:gpt-4o-mini-\\d+": 4,
+		"*-mini-2024-07-18":      5,
+		"claude-*":               6,
+		"*-sonnet-*":             7,
+		"o1-*":                   8,
+		"o3-*":                   9,
+	}
+	tests := []struct {
+		model string
+		key   string
+		found bool
+	}{
+		{"gpt-4o", "gpt-4o", true},                 // 精确的模型名优先
+		{"gpt-4o-audio", "gpt-4o-*", true},         // 只有一个规则匹配
+		{"gpt-4o-mini-tts", "gpt-4o-mini-*", true}, // 更长的规则优先
+		{"gpt-4o-mini-2024", "regex:gpt-4o-mini-\\d+", true},
+		{"gpt-4o-mini-2024-07-18", "*-mini-2024-07-18", true}, // 正则和通配规则同样按长度比较
+		{"claude-3-sonnet-x", "*-sonnet-*", true},             // 重叠的规则中更长的优先
+		{"claude-opus", "claude-*", true},
+		{"o1-x", "o1-*", true},
+		{"gpt-3.5-turbo", "", false},
+	}
+	for _, test := range tests {
+		key, found := FindModelKey(ratios, test.model)
+		assert.Equal(t, test.found, found, test.model)
+		assert.Equal(t, test.key, key, test.model)
+	}
+}
+
+func TestFindModelKeySameLength(t *testing.T) {
+	// 同样长的规则按字典序取最小的，结果与 map 的遍历顺序无关
+	ratios := map[string]int{"ab-*": 1, "*-cd": 2, "a*cd": 3}
+	for i := 0; i < 20; i++ {
+		key, found := FindModelKey(ratios, "ab-cd")
+		assert.True(t, found)
+		assert.Equal(t, "*-cd", key)
+	}
+}
@@ -175,6 +175,12 @@ var ModelRatio = map[string]float64{
 	"deepl-zh":                  25.0 / 1000 * USD,
 	"deepl-en":                  25.0 / 1000 * USD,
 	"deepl-ja":                  25.0 / 1000 * USD,
+
+	// https://openai.com/api/pricing/ realtime text tokens
+	"gpt-4o-realtime-preview":                 2.5, // $0.005 / 1K tokens
+	"gpt-4o-realtime-preview-2024-10-01":      2.5,
+	"gpt-4o-mini-realtime-preview":            0.3, // $0.0006 / 1K tokens
+	"gpt-4o-mini-realtime-preview-2024-12-17": 0.3,
 }
 
 var CompletionRatio = map[string]float64{}
@@ -240,6 +246,58 @@ var ModelPrice = map[string]float64{
 	"midjourney":         0.1,
 }
 
+// RerankRatio is the price in USD of one search unit of a rerank model,
+// rerank models missing here are billed per token with ModelRatio.
+// https://cohere.com/pricing
+var RerankRatio = map[string]float64{
+	"rerank-english-v2.0":      0.001,
+	"rerank-multilingual-v2.0": 0.001,
+	"rerank-english-v3.0":      0.002,
+	"rerank-multilingual-v3.0": 0.002,
+	"rerank-v3.5":              0.002,
+}
+
+// AudioRatio is the ratio of the audio input tokens, in the same unit as ModelRatio,
+// the audio tokens of models missing here are billed as text tokens.
+// https://openai.com/api/pricing/
+var AudioRatio = map[string]float64{
+	"gpt-4o-realtime-preview":                 50, // $0.1 / 1K tokens
+	"gpt-4o-realtime-preview-2024-10-01":      50,
+	"gpt-4o-mini-realtime-preview":            5, // $0.01 / 1K tokens
+	"gpt-4o-mini-realtime-preview-2024-12-17": 5,
+}
+
+// AudioCompletionRatio is the ratio of the audio output tokens to the audio input tokens.
+var AudioCompletionRatio = map[string]float64{
+	"gpt-4o-realtime-preview":                 2,
+	"gpt-4o-realtime-preview-2024-10-01":      2,
+	"gpt-4o-mini-realtime-preview":            2,
+	"gpt-4o-mini-realtime-preview-2024-12-17": 2,
+}
+
+// CacheRatio is the ratio of the prompt tokens read from the prompt cache to the other prompt tokens,
+// the cached tokens of models missing here are billed as prompt tokens.
+var CacheRatio = map[string]float64{
+	"gpt-4o*":    0.5,
+	"o1*":        0.5,
+	"o3*":        0.25,
+	"o4-mini*":   0.25,
+	"gpt-4.1*":   0.25,
+	"claude-*":   0.1,
+	"deepseek-*": 0.1,
+	"gemini-*":   0.25,
+}
+
+// CacheCreationRatio is the ratio of the prompt tokens written to the prompt cache to the other prompt tokens.
+// https://www.anthropic.com/pricing
+var CacheCreationRatio = map[string]float64{
+	"claude-*": 1.25,
+}
+
+// ReasoningRatio is the ratio of the reasoning tokens to the other completion tokens,
+// the reasoning tokens of models missing here are billed as completion tokens.
+var ReasoningRatio = map[string]float64{}
+
 func ModelRatioJSONString() string {
 	jsonBytes, err := json.Marshal(ModelRatio)
 	if err != nil {
@@ -266,29 +324,162 @@ func UpdateModelRatio2ByJSONString(jsonStr string) error {
 	return json.Unmarshal([]byte(jsonStr), &ModelPrice)
 }
 
-func GetModelRatio(name string) float64 {
-	if strings.HasPrefix(name, "gpt-4-gizmo") {
-		name = "gpt-4-gizmo-*"
+func RerankRatioJSONString() string {
+	jsonBytes, err := json.Marshal(RerankRatio)
+	if err != nil {
+		SysError("error marshalling rerank ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateRerankRatioByJSONString(jsonStr string) error {
+	RerankRatio = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &RerankRatio)
+}
+
+// GetRerankRatio returns the search unit price of a rerank model, false if it is billed per token.
+func GetRerankRatio(name string) (float64, bool) {
+	ratio, ok := RerankRatio[name]
+	return ratio, ok
+}
+
+func AudioRatioJSONString() string {
+	jsonBytes, err := json.Marshal(AudioRatio)
+	if err != nil {
+		SysError("error marshalling audio ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateAudioRatioByJSONString(jsonStr string) error {
+	AudioRatio = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &AudioRatio)
+}
+
+func AudioCompletionRatioJSONString() string {
+	jsonBytes, err := json.Marshal(AudioCompletionRatio)
+	if err != nil {
+		SysError("error marshalling audio completion ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateAudioCompletionRatioByJSONString(jsonStr string) error {
+	AudioCompletionRatio = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &AudioCompletionRatio)
+}
+
+func CacheRatioJSONString() string {
+	jsonBytes, err := json.Marshal(CacheRatio)
+	if err != nil {
+		SysError("error marshalling cache ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateCacheRatioByJSONString(jsonStr string) error {
+	CacheRatio = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &CacheRatio)
+}
+
+func CacheCreationRatioJSONString() string {
+	jsonBytes, err := json.Marshal(CacheCreationRatio)
+	if err != nil {
+		SysError("error marshalling cache creation ratio: " + err.Error())
 	}
-	ratio, ok := ModelRatio[name]
+	return string(jsonBytes)
+}
+
+func UpdateCacheCreationRatioByJSONString(jsonStr string) error {
+	CacheCreationRatio = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &CacheCreationRatio)
+}
+
+func ReasoningRatioJSONString() string {
+	jsonBytes, err := json.Marshal(ReasoningRatio)
+	if err != nil {
+		SysError("error marshalling reasoning ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateReasoningRatioByJSONString(jsonStr string) error {
+	ReasoningRatio = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &ReasoningRatio)
+}
+
+func GetCacheRatio(name string) float64 {
+	if key, ok := FindModelKey(CacheRatio, name); ok {
+		return CacheRatio[key]
+	}
+	return 1
+}
+
+func GetCacheCreationRatio(name string) float64 {
+	if key, ok := FindModelKey(CacheCreationRatio, name); ok {
+		return CacheCreationRatio[key]
+	}
+	return 1
+}
+
+func GetReasoningRatio(name string) float64 {
+	if key, ok := FindModelKey(ReasoningRatio, name); ok {
+		return ReasoningRatio[key]
+	}
+	return 1
+}
+
+// GetAudioRatio returns the audio input ratio of a model, its text ratio if it has none.
+func GetAudioRatio(name string) float64 {
+	if ratio, ok := AudioRatio[name]; ok {
+		return ratio
+	}
+	return GetModelRatio(name)
+}
+
+// GetAudioCompletionRatio returns the audio output ratio of a model relative to GetAudioRatio.
+func GetAudioCompletionRatio(name string) float64 {
+	if ratio, ok := AudioCompletionRatio[name]; ok {
+		return ratio
+	}
+	if _, ok := AudioRatio[name]; ok {
+		return 2
+	}
+	return GetCompletionRatio(name)
+}
+
+func GetModelRatio(name string) float64 {
+	key, ok := FindModelKey(ModelRatio, name)
 	if !ok {
 		SysError("model ratio not found: " + name)
 		return 15
 	}
-	return ratio
+	return ModelRatio[key]
 }
 
 func GetModelRatio2(name string) (float64, bool) {
-	if strings.HasPrefix(name, "gpt-4-gizmo") {
-		name = "gpt-4-gizmo-*"
-	}
-	ratio, ok := ModelPrice[name]
+	key, ok := FindModelKey(ModelPrice, name)
 	if !ok {
-		ratio, ok = ModelPrice["default"] // 尝试获取默认
+		key = "default" // 尝试获取默认
 	}
+	ratio, ok := ModelPrice[key]
 	return ratio, ok
 }
 
+// GetModelRatioPattern returns the pattern the ratio of the model comes from, empty when it has its own ratio.
+func GetModelRatioPattern(name string) string {
+	if _, ok := ModelPrice[name]; ok {
+		return ""
+	}
+	if key, ok := FindModelKey(ModelPrice, name); ok {
+		return key
+	}
+	if key, ok := FindModelKey(ModelRatio, name); ok && key != name {
+		return key
+	}
+	return ""
+}
+
 func CompletionRatio2JSONString() string {
 	jsonBytes, err := json.Marshal(CompletionRatio)
 	if err != nil {
@@ -302,8 +493,8 @@ func UpdateCompletionRatioByJSONString(jsonStr string) error {
 }
 
 func GetCompletionRatio(name string) float64 {
-	if ratio, ok := CompletionRatio[name]; ok {
-		return ratio
+	if key, ok := FindModelKey(CompletionRatio, name); ok {
+		return CompletionRatio[key]
 	}
 	if strings.HasPrefix(name, "gpt-3.5") {
 		if strings.HasSuffix(name, "1106") {
@@ -323,6 +514,9 @@ func GetCompletionRatio(name string) float64 {
 		}
 		return 4.0 / 3.0
 	}
+	if strings.Contains(name, "realtime") {
+		return 4
+	}
 	if strings.HasPrefix(name, "gpt-4") {
 		if strings.HasSuffix(name, "preview") || strings.Contains(name, "turbo") {
 			return 3
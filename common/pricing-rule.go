@@ -0,0 +1,235 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// 模型的计价规则：按提示词长度分档、按时段优惠，分组可以用自己的规则覆盖。
+// 规则以模型名或模型规则为键，只调整按 token 计费的价格，按次计费的价格不受影响。
+
+type PricingTier struct {
+	MinPromptTokens      int     `json:"min_prompt_tokens"` // 提示词超过该 token 数时适用
+	PromptMultiplier     float64 `json:"prompt_multiplier"`
+	CompletionMultiplier float64 `json:"completion_multiplier,omitempty"` // 未设置时同 PromptMultiplier
+}
+
+type OffPeakWindow struct {
+	Start      string  `json:"start"` // 15:04 格式，按 PricingTimezone 计算
+	End        string  `json:"end"`   // 早于 Start 时跨零点
+	Multiplier float64 `json:"multiplier"`
+	Weekdays   []int   `json:"weekdays,omitempty"` // 0 为周日，为空时每天适用
+}
+
+type PricingRule struct {
+	Tiers   []PricingTier          `json:"tiers,omitempty"`
+	OffPeak []OffPeakWindow        `json:"off_peak,omitempty"`
+	Groups  map[string]PricingRule `json:"groups,omitempty"` // 分组的规则整体替换模型的规则
+}
+
+// Pricing is what the pricing rules make of a request, the multipliers apply on top of the model ratio.
+type Pricing struct {
+	PromptMultiplier     float64
+	CompletionMultiplier float64
+	Description          string
+}
+
+var PricingRules = map[string]PricingRule{}
+
+// PricingTimezone 时段优惠使用的时区，为空时使用服务器时区
+var PricingTimezone = ""
+
+var pricingLocation = time.Local
+var pricingLock sync.RWMutex
+
+func PricingRules2JSONString() string {
+	pricingLock.RLock()
+	defer pricingLock.RUnlock()
+	jsonBytes, err := json.Marshal(PricingRules)
+	if err != nil {
+		SysError("error marshalling pricing rules: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdatePricingRulesByJSONString(jsonStr string) error {
+	rules, err := parsePricingRules(jsonStr)
+	if err != nil {
+		return err
+	}
+	pricingLock.Lock()
+	PricingRules = rules
+	pricingLock.Unlock()
+	return nil
+}
+
+func UpdatePricingTimezone(name string) error {
+	location := time.Local
+	if name != "" {
+		var err error
+		location, err = time.LoadLocation(name)
+		if err != nil {
+			return err
+		}
+	}
+	pricingLock.Lock()
+	PricingTimezone = name
+	pricingLocation = location
+	pricingLock.Unlock()
+	return nil
+}
+
+// ValidatePricingRules reports the first problem of the pricing rules in jsonStr.
+func ValidatePricingRules(jsonStr string) error {
+	_, err := parsePricingRules(jsonStr)
+	return err
+}
+
+func parsePricingRules(jsonStr string) (map[string]PricingRule, error) {
+	rules := make(map[string]PricingRule)
+	err := json.Unmarshal([]byte(jsonStr), &rules)
+	if err != nil {
+		return nil, err
+	}
+	for name, rule := range rules {
+		if err := ValidateModelPattern(name); err != nil {
+			return nil, fmt.Errorf("模型规则 %s 无效：%s", name, err.Error())
+		}
+		if err := validatePricingRule(rule); err != nil {
+			return nil, fmt.Errorf("模型 %s 的计价规则无效：%s", name, err.Error())
+		}
+		for group, groupRule := range rule.Groups {
+			if len(groupRule.Groups) > 0 {
+				return nil, fmt.Errorf("模型 %s 分组 %s 的计价规则不能再按分组覆盖", name, group)
+			}
+			if err := validatePricingRule(groupRule); err != nil {
+				return nil, fmt.Errorf("模型 %s 分组 %s 的计价规则无效：%s", name, group, err.Error())
+			}
+		}
+	}
+	return rules, nil
+}
+
+func validatePricingRule(rule PricingRule) error {
+	for _, tier := range rule.Tiers {
+		if tier.MinPromptTokens < 0 || tier.CompletionMultiplier < 0 {
+			return fmt.Errorf("档位 %d 的参数不能为负数", tier.MinPromptTokens)
+		}
+		// 倍率为 0 会让请求免费，多半是漏填
+		if tier.PromptMultiplier <= 0 {
+			return fmt.Errorf("档位 %d 的输入倍率必须大于 0", tier.MinPromptTokens)
+		}
+	}
+	for _, window := range rule.OffPeak {
+		if _, err := time.Parse("15:04", window.Start); err != nil {
+			return fmt.Errorf("时段开始时间 %s 格式错误", window.Start)
+		}
+		if _, err := time.Parse("15:04", window.End); err != nil {
+			return fmt.Errorf("时段结束时间 %s 格式错误", window.End)
+		}
+		if window.Start == window.End {
+			return fmt.Errorf("时段 %s-%s 的开始和结束时间相同", window.Start, window.End)
+		}
+		if window.Multiplier <= 0 {
+			return fmt.Errorf("时段 %s-%s 的倍率必须大于 0", window.Start, window.End)
+		}
+		for _, weekday := range window.Weekdays {
+			if weekday < 0 || weekday > 6 {
+				return fmt.Errorf("时段 %s-%s 的星期 %d 无效", window.Start, window.End, weekday)
+			}
+		}
+	}
+	return nil
+}
+
+// GetPricingRule returns the pricing rule of the model for the group, false when the model has none.
+func GetPricingRule(model string, group string) (PricingRule, bool) {
+	pricingLock.RLock()
+	defer pricingLock.RUnlock()
+	key, ok := FindModelKey(PricingRules, model)
+	if !ok {
+		return PricingRule{}, false
+	}
+	rule := PricingRules[key]
+	if groupRule, ok := rule.Groups[group]; ok {
+		return groupRule, true
+	}
+	rule.Groups = nil
+	return rule, true
+}
+
+// GetPricing applies the pricing rules of the model to a request of the group with promptTokens
+// prompt tokens made at t.
+func GetPricing(model string, group string, promptTokens int, t time.Time) Pricing {
+	pricing := Pricing{PromptMultiplier: 1, CompletionMultiplier: 1}
+	rule, ok := GetPricingRule(model, group)
+	if !ok {
+		return pricing
+	}
+	if tier, ok := rule.tier(promptTokens); ok {
+		completionMultiplier := tier.CompletionMultiplier
+		if completionMultiplier == 0 {
+			completionMultiplier = tier.PromptMultiplier
+		}
+		pricing.PromptMultiplier *= tier.PromptMultiplier
+		pricing.CompletionMultiplier *= completionMultiplier
+		pricing.Description += fmt.Sprintf("，提示词超过 %d tokens 输入倍率 %.2f 输出倍率 %.2f", tier.MinPromptTokens, tier.PromptMultiplier, completionMultiplier)
+	}
+	if window, ok := rule.offPeakWindow(t); ok {
+		pricing.PromptMultiplier *= window.Multiplier
+		pricing.CompletionMultiplier *= window.Multiplier
+		pricing.Description += fmt.Sprintf("，%s-%s 时段倍率 %.2f", window.Start, window.End, window.Multiplier)
+	}
+	return pricing
+}
+
+// tier returns the tier with the highest threshold below promptTokens.
+func (rule PricingRule) tier(promptTokens int) (PricingTier, bool) {
+	var best PricingTier
+	found := false
+	for _, tier := range rule.Tiers {
+		if promptTokens > tier.MinPromptTokens && (!found || tier.MinPromptTokens > best.MinPromptTokens) {
+			best = tier
+			found = true
+		}
+	}
+	return best, found
+}
+
+// offPeakWindow returns the first off-peak window t falls in.
+func (rule PricingRule) offPeakWindow(t time.Time) (OffPeakWindow, bool) {
+	if len(rule.OffPeak) == 0 {
+		return OffPeakWindow{}, false
+	}
+	pricingLock.RLock()
+	local := t.In(pricingLocation)
+	pricingLock.RUnlock()
+	minute := local.Hour()*60 + local.Minute()
+	for _, window := range rule.OffPeak {
+		start, err1 := time.Parse("15:04", window.Start)
+		end, err2 := time.Parse("15:04", window.End)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		startMinute := start.Hour()*60 + start.Minute()
+		endMinute := end.Hour()*60 + end.Minute()
+		weekday := int(local.Weekday())
+		var inWindow bool
+		if startMinute <= endMinute {
+			inWindow = minute >= startMinute && minute < endMinute
+		} else {
+			// 跨零点的时段，零点之后属于前一天开始的时段
+			inWindow = minute >= startMinute || minute < endMinute
+			if minute < endMinute {
+				weekday = (weekday + 6) % 7
+			}
+		}
+		if inWindow && (len(window.Weekdays) == 0 || slices.Contains(window.Weekdays, weekday)) {
+			return window, true
+		}
+	}
+	return OffPeakWindow{}, false
+}
@@ -0,0 +1,130 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupPricingTest(t *testing.T, rules string) {
+	previousRules, previousTimezone := PricingRules2JSONString(), PricingTimezone
+	t.Cleanup(func() {
+		_ = UpdatePricingRulesByJSONString(previousRules)
+		_ = UpdatePricingTimezone(previousTimezone)
+	})
+	assert.NoError(t, UpdatePricingTimezone("UTC"))
+	if !assert.NoError(t, UpdatePricingRulesByJSONString(rules)) {
+		t.FailNow()
+	}
+}
+
+func TestValidatePricingRules(t *testing.T) {
+	tests := []struct {
+		rules string
+		valid bool
+	}{
+		{`{"gpt-4o":{"tiers":[{"min_prompt_tokens":128000,"prompt_multiplier":2}]}}`, true},
+		{`{"gpt-4o":{"tiers":[{"min_prompt_tokens":128000,"prompt_multiplier":2,"completion_multiplier":1.5}]}}`, true},
+		{`{"gpt-4o":{"tiers":[{"min_prompt_tokens":128000}]}}`, false},
+		{`{"gpt-4o":{"tiers":[{"min_prompt_tokens":128000,"prompt_multiplier":0}]}}`, false},
+		{`{"gpt-4o":{"tiers":[{"min_prompt_tokens":-1,"prompt_multiplier":2}]}}`, false},
+		{`{"gpt-4o":{"tiers":[{"min_prompt_tokens":1,"prompt_multiplier":2,"completion_multiplier":-1}]}}`, false},
+		{`{"gpt-4o":{"off_peak":[{"start":"22:00","end":"06:00","multiplier":0.5}]}}`, true},
+		{`{"gpt-4o":{"off_peak":[{"start":"22:00","end":"06:00"}]}}`, false},
+		{`{"gpt-4o":{"off_peak":[{"start":"22:00","end":"22:00","multiplier":0.5}]}}`, false},
+		{`{"gpt-4o":{"off_peak":[{"start":"24:00","end":"06:00","multiplier":0.5}]}}`, false},
+		{`{"gpt-4o":{"off_peak":[{"start":"22:00","end":"06:00","multiplier":0.5,"weekdays":[7]}]}}`, false},
+		{`{"gpt-4o":{"groups":{"vip":{"off_peak":[{"start":"22:00","end":"06:00","multiplier":0}]}}}}`, false},
+		{`{"gpt-4o":{"groups":{"vip":{"groups":{"svip":{}}}}}}`, false},
+		{`{"regex:gpt-(4o":{}}`, false},
+	}
+	for _, test := range tests {
+		err := ValidatePricingRules(test.rules)
+		assert.Equal(t, test.valid, err == nil, "%s: %v", test.rules, err)
+	}
+}
+
+func TestPricingTiers(t *testing.T) {
+	setupPricingTest(t, `{"gpt-4o":{"tiers":[
+		{"min_prompt_tokens":128000,"prompt_multiplier":2,"completion_multiplier":1.5},
+		{"min_prompt_tokens":32000,"prompt_multiplier":1.2}
+	]}}`)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		promptTokens         int
+		promptMultiplier     float64
+		completionMultiplier float64
+	}{
+		{0, 1, 1},
+		{32000, 1, 1}, // 超过阈值才适用
+		{32001, 1.2, 1.2},
+		{128000, 1.2, 1.2},
+		{128001, 2, 1.5},
+	}
+	for _, test := range tests {
+		pricing := GetPricing("gpt-4o", "default", test.promptTokens, now)
+		assert.Equal(t, test.promptMultiplier, pricing.PromptMultiplier, "%d prompt tokens", test.promptTokens)
+		assert.Equal(t, test.completionMultiplier, pricing.CompletionMultiplier, "%d prompt tokens", test.promptTokens)
+	}
+	assert.Equal(t, Pricing{PromptMultiplier: 1, CompletionMultiplier: 1}, GetPricing("gpt-4o-mini", "default", 200000, now))
+}
+
+func TestPricingOffPeakAcrossMidnight(t *testing.T) {
+	// 周五 22:00 到周六 06:00
+	setupPricingTest(t, `{"gpt-4o":{"off_peak":[{"start":"22:00","end":"06:00","multiplier":0.5,"weekdays":[5]}]}}`)
+	tests := []struct {
+		time       time.Time
+		multiplier float64
+	}{
+		{time.Date(2024, 1, 5, 21, 59, 0, 0, time.UTC), 1},   // 周五开始前
+		{time.Date(2024, 1, 5, 22, 0, 0, 0, time.UTC), 0.5},  // 周五开始
+		{time.Date(2024, 1, 5, 23, 59, 0, 0, time.UTC), 0.5}, // 周五零点前
+		{time.Date(2024, 1, 6, 0, 0, 0, 0, time.UTC), 0.5},   // 周六零点属于周五开始的时段
+		{time.Date(2024, 1, 6, 5, 59, 0, 0, time.UTC), 0.5},
+		{time.Date(2024, 1, 6, 6, 0, 0, 0, time.UTC), 1},     // 结束时间不含
+		{time.Date(2024, 1, 6, 22, 30, 0, 0, time.UTC), 1},   // 周六开始的时段不适用
+		{time.Date(2024, 1, 5, 1, 0, 0, 0, time.UTC), 1},     // 周五凌晨属于周四开始的时段
+		{time.Date(2024, 1, 12, 23, 0, 0, 0, time.UTC), 0.5}, // 下一个周五
+	}
+	for _, test := range tests {
+		pricing := GetPricing("gpt-4o", "default", 100, test.time)
+		assert.Equal(t, test.multiplier, pricing.PromptMultiplier, test.time.Format(time.RFC1123))
+		assert.Equal(t, test.multiplier, pricing.CompletionMultiplier, test.time.Format(time.RFC1123))
+	}
+}
+
+func TestPricingOffPeakAcrossWeekBoundary(t *testing.T) {
+	// 周六 23:00 到周日 02:00，周日开始的时段到周一
+	setupPricingTest(t, `{"gpt-4o":{"off_peak":[{"start":"23:00","end":"02:00","multiplier":0.8,"weekdays":[6,0]}]}}`)
+	tests := []struct {
+		time       time.Time
+		multiplier float64
+	}{
+		{time.Date(2024, 1, 6, 23, 30, 0, 0, time.UTC), 0.8}, // 周六
+		{time.Date(2024, 1, 7, 1, 0, 0, 0, time.UTC), 0.8},   // 周日凌晨，属于周六
+		{time.Date(2024, 1, 7, 23, 30, 0, 0, time.UTC), 0.8}, // 周日
+		{time.Date(2024, 1, 8, 1, 0, 0, 0, time.UTC), 0.8},   // 周一凌晨，属于周日
+		{time.Date(2024, 1, 8, 23, 30, 0, 0, time.UTC), 1},   // 周一
+		{time.Date(2024, 1, 6, 1, 0, 0, 0, time.UTC), 1},     // 周六凌晨，属于周五
+	}
+	for _, test := range tests {
+		pricing := GetPricing("gpt-4o", "default", 100, test.time)
+		assert.Equal(t, test.multiplier, pricing.PromptMultiplier, test.time.Format(time.RFC1123))
+	}
+}
+
+func TestPricingTimezone(t *testing.T) {
+	setupPricingTest(t, `{"gpt-4o":{"off_peak":[{"start":"00:00","end":"08:00","multiplier":0.5}]}}`)
+	assert.NoError(t, UpdatePricingTimezone("Asia/Shanghai"))
+	// UTC 20:00 是北京时间次日 04:00
+	assert.Equal(t, 0.5, GetPricing("gpt-4o", "default", 100, time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)).PromptMultiplier)
+	assert.Equal(t, 1.0, GetPricing("gpt-4o", "default", 100, time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC)).PromptMultiplier)
+}
+
+func TestPricingGroupRule(t *testing.T) {
+	setupPricingTest(t, `{"gpt-4o-*":{"tiers":[{"min_prompt_tokens":1000,"prompt_multiplier":2}],"groups":{"vip":{"tiers":[{"min_prompt_tokens":1000,"prompt_multiplier":1.5}]}}}}`)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 2.0, GetPricing("gpt-4o-mini", "default", 2000, now).PromptMultiplier)
+	assert.Equal(t, 1.5, GetPricing("gpt-4o-mini", "vip", 2000, now).PromptMultiplier, "the group rule replaces the model rule")
+}
@@ -0,0 +1,24 @@
+package common
+
+import "encoding/json"
+
+// GroupHedgeDelay lists the groups whose requests are hedged, with the time in milliseconds to wait for
+// the first byte of the first channel before the same request is sent to a second channel.
+var GroupHedgeDelay = map[string]int{}
+
+func GroupHedgeDelayJSONString() string {
+	jsonBytes, err := json.Marshal(GroupHedgeDelay)
+	if err != nil {
+		SysError("error marshalling group hedge delay: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupHedgeDelayByJSONString(jsonStr string) error {
+	GroupHedgeDelay = make(map[string]int)
+	return json.Unmarshal([]byte(jsonStr), &GroupHedgeDelay)
+}
+
+func GetGroupHedgeDelay(group string) int {
+	return GroupHedgeDelay[group]
+}
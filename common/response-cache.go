@@ -0,0 +1,86 @@
+package common
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// GroupResponseCacheTTL lists the groups whose chat completions are cached, with the TTL in seconds,
+// 0 means the default ResponseCacheTTL.
+var GroupResponseCacheTTL = map[string]int{}
+
+func GroupResponseCacheTTLJSONString() string {
+	jsonBytes, err := json.Marshal(GroupResponseCacheTTL)
+	if err != nil {
+		SysError("error marshalling group response cache ttl: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupResponseCacheTTLByJSONString(jsonStr string) error {
+	GroupResponseCacheTTL = make(map[string]int)
+	return json.Unmarshal([]byte(jsonStr), &GroupResponseCacheTTL)
+}
+
+func GetGroupResponseCacheTTL(group string) (int, bool) {
+	ttl, ok := GroupResponseCacheTTL[group]
+	return ttl, ok
+}
+
+const responseCacheMaxItems = 10000
+
+type responseCacheItem struct {
+	value    string
+	expireAt time.Time
+}
+
+// responseCache is the in memory store used when Redis is disabled.
+var responseCache = struct {
+	sync.Mutex
+	items map[string]responseCacheItem
+}{items: make(map[string]responseCacheItem)}
+
+// ResponseCacheGet returns the cached response stored under key, from Redis when enabled.
+func ResponseCacheGet(key string) (string, bool) {
+	if RedisEnabled {
+		value, err := RedisGet(key)
+		if err != nil {
+			return "", false
+		}
+		return value, true
+	}
+	responseCache.Lock()
+	defer responseCache.Unlock()
+	item, ok := responseCache.items[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(item.expireAt) {
+		delete(responseCache.items, key)
+		return "", false
+	}
+	return item.value, true
+}
+
+func ResponseCacheSet(key string, value string, ttl time.Duration) error {
+	if RedisEnabled {
+		return RedisSet(key, value, ttl)
+	}
+	responseCache.Lock()
+	defer responseCache.Unlock()
+	now := time.Now()
+	if len(responseCache.items) >= responseCacheMaxItems {
+		for k, item := range responseCache.items {
+			if now.After(item.expireAt) {
+				delete(responseCache.items, k)
+			}
+		}
+		if len(responseCache.items) >= responseCacheMaxItems {
+			// 内存缓存已满，放弃本次写入
+			return nil
+		}
+	}
+	responseCache.items[key] = responseCacheItem{value: value, expireAt: now.Add(ttl)}
+	return nil
+}
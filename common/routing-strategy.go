@@ -0,0 +1,94 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common/config"
+)
+
+// 渠道选择策略，同一优先级内的渠道按策略挑选
+const (
+	RoutingStrategyWeighted      = "weighted"       // 按权重随机
+	RoutingStrategyLatency       = "latency"        // 最近延迟最低
+	RoutingStrategyCost          = "cost"           // 成本最低
+	RoutingStrategyLeastInflight = "least_inflight" // 进行中请求最少
+	RoutingStrategyRoundRobin    = "round_robin"    // 轮询
+)
+
+// GroupRoutingStrategy and ModelRoutingStrategy override the default RoutingStrategy,
+// the model setting wins over the group setting.
+var GroupRoutingStrategy = map[string]string{}
+var ModelRoutingStrategy = map[string]string{}
+
+func IsValidRoutingStrategy(strategy string) bool {
+	switch strategy {
+	case RoutingStrategyWeighted, RoutingStrategyLatency, RoutingStrategyCost, RoutingStrategyLeastInflight, RoutingStrategyRoundRobin:
+		return true
+	}
+	return false
+}
+
+func validateRoutingStrategies(strategies map[string]string) error {
+	for name, strategy := range strategies {
+		if !IsValidRoutingStrategy(strategy) {
+			return fmt.Errorf("unknown routing strategy %q for %s", strategy, name)
+		}
+	}
+	return nil
+}
+
+func GroupRoutingStrategy2JSONString() string {
+	jsonBytes, err := json.Marshal(GroupRoutingStrategy)
+	if err != nil {
+		SysError("error marshalling group routing strategy: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupRoutingStrategyByJSONString(jsonStr string) error {
+	strategies := make(map[string]string)
+	err := json.Unmarshal([]byte(jsonStr), &strategies)
+	if err != nil {
+		return err
+	}
+	if err = validateRoutingStrategies(strategies); err != nil {
+		return err
+	}
+	GroupRoutingStrategy = strategies
+	return nil
+}
+
+func ModelRoutingStrategy2JSONString() string {
+	jsonBytes, err := json.Marshal(ModelRoutingStrategy)
+	if err != nil {
+		SysError("error marshalling model routing strategy: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelRoutingStrategyByJSONString(jsonStr string) error {
+	strategies := make(map[string]string)
+	err := json.Unmarshal([]byte(jsonStr), &strategies)
+	if err != nil {
+		return err
+	}
+	if err = validateRoutingStrategies(strategies); err != nil {
+		return err
+	}
+	ModelRoutingStrategy = strategies
+	return nil
+}
+
+// GetRoutingStrategy returns the strategy used to pick among the channels of group serving model.
+func GetRoutingStrategy(group string, model string) string {
+	if strategy, ok := ModelRoutingStrategy[model]; ok {
+		return strategy
+	}
+	if strategy, ok := GroupRoutingStrategy[group]; ok {
+		return strategy
+	}
+	if IsValidRoutingStrategy(config.RoutingStrategy) {
+		return config.RoutingStrategy
+	}
+	return RoutingStrategyWeighted
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"one-api/common"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var LocalStoragePath = common.GetOrDefaultString("FILE_STORAGE_PATH", "./files")
+
+type LocalStorage struct {
+	Root string
+}
+
+func init() {
+	Register("local", &LocalStorage{Root: LocalStoragePath})
+}
+
+func (s *LocalStorage) path(key string) (string, error) {
+	cleaned := filepath.Clean("/" + key)
+	if cleaned == "/" || strings.Contains(key, "..") {
+		return "", errors.New("invalid storage key")
+	}
+	return filepath.Join(s.Root, cleaned), nil
+}
+
+func (s *LocalStorage) Save(key string, reader io.Reader) (int64, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	fd, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+	return io.Copy(fd, reader)
+}
+
+func (s *LocalStorage) Open(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *LocalStorage) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
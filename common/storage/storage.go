@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"one-api/common"
+	"sync"
+)
+
+// Storage is the backend used to keep user uploaded files (Files API, batch output...).
+// Keys are slash separated relative paths, e.g. "12/file-xxx".
+type Storage interface {
+	Save(key string, reader io.Reader) (int64, error)
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+var backends = make(map[string]Storage)
+var backendsLock sync.RWMutex
+
+// Backend is the name of the storage backend in use, local disk by default
+var Backend = common.GetOrDefaultString("FILE_STORAGE", "local")
+
+func Register(name string, backend Storage) {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+	backends[name] = backend
+}
+
+func GetStorage() (Storage, error) {
+	backendsLock.RLock()
+	defer backendsLock.RUnlock()
+	backend, ok := backends[Backend]
+	if !ok {
+		return nil, fmt.Errorf("file storage backend %s not registered", Backend)
+	}
+	return backend, nil
+}
@@ -0,0 +1,510 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/relay/channel/openai"
+	"one-api/relay/controller"
+	dbmodel "one-api/relay/model"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// runExpireSeconds is how long a run may stay unfinished, e.g. waiting for tool outputs
+const runExpireSeconds = 10 * 60
+
+// RelayAssistant adapts the assistants API handlers, which are served from the gateway DB, to gin.
+func RelayAssistant(handler func(c *gin.Context) *dbmodel.ErrorWithStatusCode) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bizErr := handler(c)
+		if bizErr != nil {
+			bizErr.Error.Message = common.MessageWithRequestId(bizErr.Error.Message, c.GetString("X-Chatapi-Request-Id"))
+			c.JSON(bizErr.StatusCode, gin.H{
+				"error": bizErr.Error,
+			})
+		}
+	}
+}
+
+func newRun(c *gin.Context, thread *model.Thread, request *dbmodel.RunRequest) (*model.Run, *dbmodel.ErrorWithStatusCode) {
+	if request.Stream {
+		return nil, openai.ErrorWrapper(errors.New("stream is not supported for runs"), "invalid_run_request", http.StatusBadRequest)
+	}
+	assistant, err := model.GetUserAssistant(thread.UserId, request.AssistantId)
+	if err != nil {
+		return nil, openai.ErrorWrapper(fmt.Errorf("No assistant found with id '%s'.", request.AssistantId), "not_found", http.StatusNotFound)
+	}
+	active, err := model.HasActiveRun(thread.ThreadId, common.GetTimestamp())
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "get_runs_failed", http.StatusInternalServerError)
+	}
+	if active {
+		return nil, openai.ErrorWrapper(fmt.Errorf("Thread %s already has an active run.", thread.ThreadId), "thread_locked", http.StatusBadRequest)
+	}
+	now := common.GetTimestamp()
+	run := &model.Run{
+		RunId:                  "run_" + common.GetUUID(),
+		ThreadId:               thread.ThreadId,
+		AssistantId:            assistant.AssistantId,
+		UserId:                 thread.UserId,
+		TokenId:                c.GetInt("token_id"),
+		Status:                 model.RunStatusQueued,
+		Model:                  assistant.Model,
+		Instructions:           assistant.Instructions,
+		AdditionalInstructions: request.AdditionalInstructions,
+		Tools:                  assistant.Tools,
+		Metadata:               controller.MarshalMetadata(request.Metadata),
+		Temperature:            assistant.Temperature,
+		TopP:                   assistant.TopP,
+		MaxCompletionTokens:    request.MaxCompletionTokens,
+		CreatedAt:              now,
+		ExpiresAt:              now + runExpireSeconds,
+	}
+	if request.Model != "" {
+		run.Model = request.Model
+	}
+	if request.Instructions != nil {
+		run.Instructions = *request.Instructions
+	}
+	if request.Tools != nil {
+		run.Tools = controller.MarshalTools(request.Tools)
+	}
+	if request.Temperature != nil {
+		run.Temperature = request.Temperature
+	}
+	if request.TopP != nil {
+		run.TopP = request.TopP
+	}
+	for i := range request.AdditionalMessages {
+		message, bizErr := controller.NewThreadMessage(thread, &request.AdditionalMessages[i])
+		if bizErr != nil {
+			return nil, bizErr
+		}
+		err = message.Insert()
+		if err != nil {
+			return nil, openai.ErrorWrapper(err, "insert_message_failed", http.StatusInternalServerError)
+		}
+	}
+	err = run.Insert()
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "insert_run_failed", http.StatusInternalServerError)
+	}
+	go executeRun(run)
+	return run, nil
+}
+
+func CreateRun(c *gin.Context) *dbmodel.ErrorWithStatusCode {
+	thread, err := model.GetUserThread(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		return openai.ErrorWrapper(fmt.Errorf("No thread found with id '%s'.", c.Param("id")), "not_found", http.StatusNotFound)
+	}
+	var request dbmodel.RunRequest
+	err = common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_run_request", http.StatusBadRequest)
+	}
+	run, bizErr := newRun(c, thread, &request)
+	if bizErr != nil {
+		return bizErr
+	}
+	c.JSON(http.StatusOK, controller.RunObject(run))
+	return nil
+}
+
+func CreateThreadAndRun(c *gin.Context) *dbmodel.ErrorWithStatusCode {
+	var request dbmodel.RunRequest
+	err := common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_run_request", http.StatusBadRequest)
+	}
+	if request.Thread == nil {
+		request.Thread = &dbmodel.ThreadRequest{}
+	}
+	thread, bizErr := controller.CreateThreadWithMessages(c.GetInt("id"), request.Thread)
+	if bizErr != nil {
+		return bizErr
+	}
+	run, bizErr := newRun(c, thread, &request)
+	if bizErr != nil {
+		return bizErr
+	}
+	c.JSON(http.StatusOK, controller.RunObject(run))
+	return nil
+}
+
+func SubmitToolOutputs(c *gin.Context) *dbmodel.ErrorWithStatusCode {
+	run, bizErr := controller.GetUserThreadRun(c)
+	if bizErr != nil {
+		return bizErr
+	}
+	if run.Status == model.RunStatusRequiresAction && run.ExpiresAt > 0 && run.ExpiresAt < common.GetTimestamp() {
+		ok, err := model.UpdateRunStatus(run.RunId, []string{model.RunStatusRequiresAction}, model.RunStatusExpired, map[string]interface{}{
+			"required_action": "",
+		})
+		if err != nil {
+			return openai.ErrorWrapper(err, "update_run_failed", http.StatusInternalServerError)
+		}
+		if ok {
+			run.Status = model.RunStatusExpired
+		}
+	}
+	if run.Status != model.RunStatusRequiresAction {
+		return openai.ErrorWrapper(fmt.Errorf("Runs in status \"%s\" do not accept tool outputs.", run.Status), "invalid_run_status", http.StatusBadRequest)
+	}
+	var request dbmodel.SubmitToolOutputsRequest
+	err := common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_tool_outputs", http.StatusBadRequest)
+	}
+	steps, err := model.GetRunSteps(run.RunId, "desc", 0, 1)
+	if err != nil || len(steps) == 0 || steps[0].Type != model.RunStepTypeToolCalls {
+		return openai.ErrorWrapper(errors.New("run has no pending tool calls"), "invalid_run_status", http.StatusBadRequest)
+	}
+	step := steps[0]
+	var details dbmodel.RunStepDetails
+	_ = json.Unmarshal([]byte(step.StepDetails), &details)
+	outputs := make(map[string]string, len(request.ToolOutputs))
+	for _, output := range request.ToolOutputs {
+		outputs[output.ToolCallId] = output.Output
+	}
+	for i := range details.ToolCalls {
+		output, ok := outputs[details.ToolCalls[i].Id]
+		if !ok {
+			return openai.ErrorWrapper(fmt.Errorf("Expected tool outputs for call ids %s", details.ToolCalls[i].Id), "invalid_tool_outputs", http.StatusBadRequest)
+		}
+		details.ToolCalls[i].Function.Output = &output
+	}
+	ok, err := model.UpdateRunStatus(run.RunId, []string{model.RunStatusRequiresAction}, model.RunStatusQueued, map[string]interface{}{
+		"required_action": "",
+	})
+	if err != nil || !ok {
+		return openai.ErrorWrapper(errors.New("run is no longer waiting for tool outputs"), "invalid_run_status", http.StatusBadRequest)
+	}
+	stepDetails, _ := json.Marshal(details)
+	step.StepDetails = string(stepDetails)
+	step.Status = model.RunStatusCompleted
+	step.CompletedAt = common.GetTimestamp()
+	err = step.Update()
+	if err != nil {
+		return openai.ErrorWrapper(err, "update_run_step_failed", http.StatusInternalServerError)
+	}
+	run.Status = model.RunStatusQueued
+	run.RequiredAction = ""
+	go executeRun(run)
+	c.JSON(http.StatusOK, controller.RunObject(run))
+	return nil
+}
+
+func CancelRun(c *gin.Context) *dbmodel.ErrorWithStatusCode {
+	run, bizErr := controller.GetUserThreadRun(c)
+	if bizErr != nil {
+		return bizErr
+	}
+	// a run waiting for tool outputs has nothing executing and is cancelled right away
+	ok, err := model.UpdateRunStatus(run.RunId, []string{model.RunStatusRequiresAction}, model.RunStatusCancelled, map[string]interface{}{
+		"cancelled_at": common.GetTimestamp(),
+	})
+	if err == nil && !ok {
+		ok, err = model.UpdateRunStatus(run.RunId, []string{model.RunStatusQueued, model.RunStatusInProgress}, model.RunStatusCancelling, nil)
+	}
+	if err != nil {
+		return openai.ErrorWrapper(err, "cancel_run_failed", http.StatusInternalServerError)
+	}
+	if !ok {
+		return openai.ErrorWrapper(fmt.Errorf("Cannot cancel run with status '%s'.", run.Status), "invalid_run_status", http.StatusBadRequest)
+	}
+	run, bizErr = controller.GetUserThreadRun(c)
+	if bizErr != nil {
+		return bizErr
+	}
+	c.JSON(http.StatusOK, controller.RunObject(run))
+	return nil
+}
+
+// buildRunMessages turns the thread history and the tool calls of the run so far into a chat request.
+func buildRunMessages(run *model.Run) ([]dbmodel.Message, error) {
+	var messages []dbmodel.Message
+	instructions := strings.TrimSpace(run.Instructions + "\n" + run.AdditionalInstructions)
+	if instructions != "" {
+		messages = append(messages, dbmodel.Message{Role: "system", Content: instructions})
+	}
+	threadMessages, err := model.GetThreadMessagesBefore(run.ThreadId, run.RunId)
+	if err != nil {
+		return nil, err
+	}
+	for _, threadMessage := range threadMessages {
+		var parts []dbmodel.MessageContent
+		_ = json.Unmarshal([]byte(threadMessage.Content), &parts)
+		if len(parts) == 1 && parts[0].Text != nil {
+			messages = append(messages, dbmodel.Message{Role: threadMessage.Role, Content: parts[0].Text.Value})
+			continue
+		}
+		var content []any
+		for _, part := range parts {
+			if part.Text != nil {
+				content = append(content, map[string]any{"type": "text", "text": part.Text.Value})
+			} else if part.ImageUrl != nil {
+				content = append(content, map[string]any{"type": "image_url", "image_url": part.ImageUrl})
+			}
+		}
+		messages = append(messages, dbmodel.Message{Role: threadMessage.Role, Content: content})
+	}
+	steps, err := model.GetRunSteps(run.RunId, "asc", 0, 1000)
+	if err != nil {
+		return nil, err
+	}
+	for _, step := range steps {
+		if step.Type != model.RunStepTypeToolCalls {
+			continue
+		}
+		var details dbmodel.RunStepDetails
+		_ = json.Unmarshal([]byte(step.StepDetails), &details)
+		assistantMessage := dbmodel.Message{Role: "assistant", Content: ""}
+		var toolMessages []dbmodel.Message
+		for _, toolCall := range details.ToolCalls {
+			assistantMessage.ToolCalls = append(assistantMessage.ToolCalls, dbmodel.Tool{
+				Id:   toolCall.Id,
+				Type: "function",
+				Function: dbmodel.Function{
+					Name:      toolCall.Function.Name,
+					Arguments: toolCall.Function.Arguments,
+				},
+			})
+			output := ""
+			if toolCall.Function.Output != nil {
+				output = *toolCall.Function.Output
+			}
+			toolMessages = append(toolMessages, dbmodel.Message{Role: "tool", Content: output, ToolCallId: toolCall.Id})
+		}
+		messages = append(messages, assistantMessage)
+		messages = append(messages, toolMessages...)
+	}
+	return messages, nil
+}
+
+// ResumeInterruptedRuns executes again the runs that were queued or executing when the process stopped.
+func ResumeInterruptedRuns() {
+	runs, err := model.RequeueInterruptedRuns(common.GetTimestamp())
+	if err != nil {
+		common.SysError("failed to requeue interrupted runs: " + err.Error())
+		return
+	}
+	if len(runs) > 0 {
+		common.SysLog(fmt.Sprintf("resuming %d interrupted runs", len(runs)))
+	}
+	for _, run := range runs {
+		go executeRun(run)
+	}
+}
+
+// AutomaticallyExpireRuns expires the runs left unfinished past their expiry, e.g. waiting for tool outputs
+// that never come.
+func AutomaticallyExpireRuns() {
+	for {
+		time.Sleep(time.Minute)
+		count, err := model.ExpireRuns(common.GetTimestamp())
+		if err != nil {
+			common.SysError("failed to expire runs: " + err.Error())
+			continue
+		}
+		if count > 0 {
+			common.SysLog(fmt.Sprintf("expired %d runs", count))
+		}
+	}
+}
+
+func failRun(run *model.Run, code string, message string) {
+	lastError, _ := json.Marshal(dbmodel.RunError{Code: code, Message: message})
+	_, err := model.UpdateRunStatus(run.RunId, []string{model.RunStatusInProgress, model.RunStatusCancelling}, model.RunStatusFailed, map[string]interface{}{
+		"failed_at":         common.GetTimestamp(),
+		"last_error":        string(lastError),
+		"prompt_tokens":     run.PromptTokens,
+		"completion_tokens": run.CompletionTokens,
+	})
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to update run %s: %s", run.RunId, err.Error()))
+	}
+}
+
+// executeRun performs the next step of a run: one chat completion through the relay pipeline,
+// billed like any other request. Function tool calls suspend the run until tool outputs are
+// submitted, otherwise the answer is added to the thread and the run completes.
+func executeRun(run *model.Run) {
+	ok, err := model.UpdateRunStatus(run.RunId, []string{model.RunStatusQueued}, model.RunStatusInProgress, map[string]interface{}{
+		"started_at": common.GetTimestamp(),
+	})
+	if err != nil || !ok {
+		// cancelled before it started
+		_, _ = model.UpdateRunStatus(run.RunId, []string{model.RunStatusCancelling}, model.RunStatusCancelled, map[string]interface{}{
+			"cancelled_at": common.GetTimestamp(),
+		})
+		return
+	}
+	messages, err := buildRunMessages(run)
+	if err != nil {
+		failRun(run, "server_error", err.Error())
+		return
+	}
+	request := &dbmodel.GeneralOpenAIRequest{
+		Model:     run.Model,
+		Messages:  messages,
+		MaxTokens: uint(run.MaxCompletionTokens),
+	}
+	if run.Temperature != nil {
+		request.Temperature = *run.Temperature
+	}
+	if run.TopP != nil {
+		request.TopP = *run.TopP
+	}
+	// only function tools are executed, by the client, code_interpreter and file_search are not available
+	for _, tool := range controller.UnmarshalTools(run.Tools) {
+		if tool.Type == "function" {
+			request.Tools = append(request.Tools, tool)
+		}
+	}
+
+	stepId := "step_" + common.GetUUID()
+	ctx := context.WithValue(context.Background(), common.RequestIdKey, stepId)
+	c, recorder, bizErr := newInternalRelayContext(ctx, run.TokenId, "/v1/chat/completions", request)
+	if bizErr != nil {
+		code, _ := bizErr.Code.(string)
+		failRun(run, code, bizErr.Message)
+		return
+	}
+	defer middleware.ReleaseChannelLimit(c)
+	Relay(c)
+	if recorder.Code != http.StatusOK {
+		relayErr := internalResponseError(recorder)
+		failRun(run, "server_error", relayErr.Message)
+		return
+	}
+	var response openai.TextResponse
+	err = json.Unmarshal(recorder.Body.Bytes(), &response)
+	if err != nil || len(response.Choices) == 0 {
+		failRun(run, "server_error", "invalid response from upstream")
+		return
+	}
+	run.PromptTokens += response.Usage.PromptTokens
+	run.CompletionTokens += response.Usage.CompletionTokens
+	now := common.GetTimestamp()
+	step := &model.RunStep{
+		StepId:           stepId,
+		RunId:            run.RunId,
+		ThreadId:         run.ThreadId,
+		AssistantId:      run.AssistantId,
+		UserId:           run.UserId,
+		PromptTokens:     response.Usage.PromptTokens,
+		CompletionTokens: response.Usage.CompletionTokens,
+		CreatedAt:        now,
+	}
+	choice := response.Choices[0]
+
+	if len(choice.ToolCalls) > 0 {
+		details := dbmodel.RunStepDetails{Type: model.RunStepTypeToolCalls}
+		requiredAction := dbmodel.RequiredAction{Type: "submit_tool_outputs"}
+		for _, toolCall := range choice.ToolCalls {
+			arguments, ok := toolCall.Function.Arguments.(string)
+			if !ok {
+				argumentsBytes, _ := json.Marshal(toolCall.Function.Arguments)
+				arguments = string(argumentsBytes)
+			}
+			details.ToolCalls = append(details.ToolCalls, dbmodel.RunStepToolCall{
+				Id:       toolCall.Id,
+				Type:     "function",
+				Function: dbmodel.ToolFunction{Name: toolCall.Function.Name, Arguments: arguments},
+			})
+			requiredAction.SubmitToolOutputs.ToolCalls = append(requiredAction.SubmitToolOutputs.ToolCalls, dbmodel.Tool{
+				Id:       toolCall.Id,
+				Type:     "function",
+				Function: dbmodel.Function{Name: toolCall.Function.Name, Arguments: arguments},
+			})
+		}
+		stepDetails, _ := json.Marshal(details)
+		step.Type = model.RunStepTypeToolCalls
+		step.Status = model.RunStatusInProgress
+		step.StepDetails = string(stepDetails)
+		err = step.Insert()
+		if err != nil {
+			failRun(run, "server_error", err.Error())
+			return
+		}
+		requiredActionJSON, _ := json.Marshal(requiredAction)
+		ok, err = model.UpdateRunStatus(run.RunId, []string{model.RunStatusInProgress}, model.RunStatusRequiresAction, map[string]interface{}{
+			"required_action":   string(requiredActionJSON),
+			"prompt_tokens":     run.PromptTokens,
+			"completion_tokens": run.CompletionTokens,
+		})
+		if err == nil && !ok {
+			finishCancelledRun(run, step)
+		}
+		return
+	}
+
+	content, _ := json.Marshal([]dbmodel.MessageContent{{
+		Type: "text",
+		Text: &dbmodel.MessageText{Value: choice.StringContent(), Annotations: []any{}},
+	}})
+	message := &model.ThreadMessage{
+		MessageId:   "msg_" + common.GetUUID(),
+		ThreadId:    run.ThreadId,
+		UserId:      run.UserId,
+		Role:        "assistant",
+		Content:     string(content),
+		AssistantId: run.AssistantId,
+		RunId:       run.RunId,
+		CreatedAt:   now,
+	}
+	err = message.Insert()
+	if err != nil {
+		failRun(run, "server_error", err.Error())
+		return
+	}
+	stepDetails, _ := json.Marshal(dbmodel.RunStepDetails{
+		Type:            model.RunStepTypeMessageCreation,
+		MessageCreation: &dbmodel.MessageCreation{MessageId: message.MessageId},
+	})
+	step.Type = model.RunStepTypeMessageCreation
+	step.Status = model.RunStatusCompleted
+	step.StepDetails = string(stepDetails)
+	step.CompletedAt = now
+	err = step.Insert()
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to insert run step %s: %s", step.StepId, err.Error()))
+	}
+	ok, err = model.UpdateRunStatus(run.RunId, []string{model.RunStatusInProgress}, model.RunStatusCompleted, map[string]interface{}{
+		"completed_at":      now,
+		"prompt_tokens":     run.PromptTokens,
+		"completion_tokens": run.CompletionTokens,
+	})
+	if err == nil && !ok {
+		finishCancelledRun(run, nil)
+	}
+}
+
+// finishCancelledRun completes a cancellation requested while the run was executing.
+func finishCancelledRun(run *model.Run, step *model.RunStep) {
+	now := common.GetTimestamp()
+	if step != nil {
+		step.Status = model.RunStatusCancelled
+		step.CancelledAt = now
+		if err := step.Update(); err != nil {
+			common.SysError(fmt.Sprintf("failed to update run step %s: %s", step.StepId, err.Error()))
+		}
+	}
+	_, err := model.UpdateRunStatus(run.RunId, []string{model.RunStatusCancelling}, model.RunStatusCancelled, map[string]interface{}{
+		"cancelled_at":      now,
+		"prompt_tokens":     run.PromptTokens,
+		"completion_tokens": run.CompletionTokens,
+	})
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to update run %s: %s", run.RunId, err.Error()))
+	}
+}
@@ -0,0 +1,331 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/relay/channel/openai"
+	"one-api/relay/controller"
+	dbmodel "one-api/relay/model"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBatchLines follows the OpenAI limit of requests per batch
+const maxBatchLines = 50000
+
+func relayBatch(c *gin.Context) *dbmodel.ErrorWithStatusCode {
+	batchId := c.Param("id")
+	if batchId == "" {
+		if c.Request.Method == http.MethodGet {
+			return controller.RelayBatchList(c)
+		}
+		return controller.RelayBatchCreate(c)
+	}
+	batch, err := model.GetUserBatch(c.GetInt("id"), batchId)
+	if err != nil {
+		return openai.ErrorWrapper(fmt.Errorf("No such Batch object: %s", batchId), "batch_not_found", http.StatusNotFound)
+	}
+	if strings.HasSuffix(c.Request.URL.Path, "/cancel") {
+		return controller.RelayBatchCancel(c, batch)
+	}
+	return controller.RelayBatchRetrieve(c, batch)
+}
+
+// RelayBatch serves /v1/batches. Batches are executed asynchronously by the batch workers.
+func RelayBatch(c *gin.Context) {
+	bizErr := relayBatch(c)
+	if bizErr != nil {
+		bizErr.Error.Message = common.MessageWithRequestId(bizErr.Error.Message, c.GetString("X-Chatapi-Request-Id"))
+		c.JSON(bizErr.StatusCode, gin.H{
+			"error": bizErr.Error,
+		})
+	}
+}
+
+type batchTask struct {
+	batch    *model.Batch
+	line     *dbmodel.BatchRequestLine
+	result   *dbmodel.BatchResponseLine
+	failed   bool
+	wg       *sync.WaitGroup
+	progress *batchProgress
+}
+
+type batchProgress struct {
+	completed int64
+	failed    int64
+}
+
+var batchTasks = make(chan *batchTask)
+
+// StartBatchWorkers starts the worker pool that executes batch lines and polls for new batches.
+func StartBatchWorkers() {
+	err := model.FailInterruptedBatches()
+	if err != nil {
+		common.SysError("failed to reset interrupted batches: " + err.Error())
+	}
+	for i := 0; i < common.BatchJobWorkers; i++ {
+		go func() {
+			for task := range batchTasks {
+				task.result, task.failed = runBatchLine(task.batch, task.line)
+				if task.failed {
+					atomic.AddInt64(&task.progress.failed, 1)
+				} else {
+					atomic.AddInt64(&task.progress.completed, 1)
+				}
+				task.wg.Done()
+			}
+		}()
+	}
+	for {
+		err := model.ExpireBatches(common.GetTimestamp())
+		if err != nil {
+			common.SysError("failed to expire batches: " + err.Error())
+		}
+		batches, err := model.GetBatchesByStatus(model.BatchStatusValidating, 10)
+		if err != nil {
+			common.SysError("failed to get pending batches: " + err.Error())
+		}
+		for _, batch := range batches {
+			ok, err := model.ClaimBatch(batch.Id)
+			if err != nil || !ok {
+				continue
+			}
+			batch.Status = model.BatchStatusInProgress
+			batch.InProgressAt = common.GetTimestamp()
+			processBatch(batch)
+		}
+		time.Sleep(time.Duration(common.BatchPollInterval) * time.Second)
+	}
+}
+
+func failBatch(batch *model.Batch, line int, code string, message string) {
+	jsonBytes, _ := json.Marshal(dbmodel.BatchErrors{
+		Object: "list",
+		Data:   []dbmodel.BatchError{{Code: code, Message: message, Line: line}},
+	})
+	batch.Errors = string(jsonBytes)
+	batch.Status = model.BatchStatusFailed
+	batch.FailedAt = common.GetTimestamp()
+	ok, err := batch.Transition([]string{model.BatchStatusInProgress}, "errors", "failed_at")
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+		return
+	}
+	if !ok {
+		// 批处理已被取消，按取消完成
+		batch.Status = model.BatchStatusCancelled
+		batch.CancelledAt = batch.FailedAt
+		_, err = batch.Transition([]string{model.BatchStatusCancelling}, "cancelled_at")
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+		}
+	}
+}
+
+func readBatchLines(batch *model.Batch) ([]*dbmodel.BatchRequestLine, *dbmodel.BatchError) {
+	inputFile, err := model.GetUserFile(batch.UserId, batch.InputFileId)
+	if err != nil {
+		return nil, &dbmodel.BatchError{Code: "file_not_found", Message: "input file not found"}
+	}
+	reader, err := controller.OpenFileContent(inputFile)
+	if err != nil {
+		return nil, &dbmodel.BatchError{Code: "file_not_found", Message: err.Error()}
+	}
+	defer reader.Close()
+	var lines []*dbmodel.BatchRequestLine
+	customIds := make(map[string]bool)
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var line dbmodel.BatchRequestLine
+		err := json.Unmarshal([]byte(text), &line)
+		if err != nil {
+			return nil, &dbmodel.BatchError{Code: "invalid_json_line", Message: err.Error(), Line: lineNum}
+		}
+		if line.CustomId == "" || customIds[line.CustomId] {
+			return nil, &dbmodel.BatchError{Code: "duplicate_custom_id", Message: "custom_id must be present and unique", Line: lineNum}
+		}
+		customIds[line.CustomId] = true
+		if line.Url != batch.Endpoint || strings.ToUpper(line.Method) != http.MethodPost {
+			return nil, &dbmodel.BatchError{Code: "mismatched_endpoint", Message: fmt.Sprintf("line must be POST %s", batch.Endpoint), Line: lineNum}
+		}
+		lines = append(lines, &line)
+		if len(lines) > maxBatchLines {
+			return nil, &dbmodel.BatchError{Code: "too_many_requests", Message: fmt.Sprintf("a batch may contain at most %d requests", maxBatchLines), Line: lineNum}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &dbmodel.BatchError{Code: "invalid_file", Message: err.Error()}
+	}
+	if len(lines) == 0 {
+		return nil, &dbmodel.BatchError{Code: "empty_file", Message: "input file contains no requests"}
+	}
+	return lines, nil
+}
+
+func processBatch(batch *model.Batch) {
+	lines, lineErr := readBatchLines(batch)
+	if lineErr != nil {
+		failBatch(batch, lineErr.Line, lineErr.Code, lineErr.Message)
+		return
+	}
+	batch.TotalRequests = len(lines)
+	err := model.UpdateBatchTotalRequests(batch.Id, batch.TotalRequests)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+	}
+
+	progress := &batchProgress{}
+	tasks := make([]*batchTask, 0, len(lines))
+	wg := &sync.WaitGroup{}
+	cancelled := false
+	for i, line := range lines {
+		if i%common.BatchJobWorkers == 0 {
+			status, _ := model.GetBatchStatus(batch.Id)
+			if status == model.BatchStatusCancelling {
+				cancelled = true
+				break
+			}
+			err := model.UpdateBatchProgress(batch.Id, int(atomic.LoadInt64(&progress.completed)), int(atomic.LoadInt64(&progress.failed)))
+			if err != nil {
+				common.SysError(fmt.Sprintf("failed to update batch %s progress: %s", batch.BatchId, err.Error()))
+			}
+		}
+		task := &batchTask{batch: batch, line: line, wg: wg, progress: progress}
+		tasks = append(tasks, task)
+		wg.Add(1)
+		batchTasks <- task
+	}
+	wg.Wait()
+
+	batch.CompletedRequests = int(progress.completed)
+	batch.FailedRequests = int(progress.failed)
+	if !cancelled {
+		// the batch may have been cancelled after the last status check, the transition only succeeds when it wasn't
+		batch.Status = model.BatchStatusFinalizing
+		batch.FinalizingAt = common.GetTimestamp()
+		ok, err := batch.Transition([]string{model.BatchStatusInProgress}, "finalizing_at", "completed_requests", "failed_requests")
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+		}
+		cancelled = err == nil && !ok
+	}
+	if cancelled {
+		batch.Status = model.BatchStatusCancelling
+		err = model.UpdateBatchProgress(batch.Id, batch.CompletedRequests, batch.FailedRequests)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to update batch %s progress: %s", batch.BatchId, err.Error()))
+		}
+	}
+
+	var output, errorOutput bytes.Buffer
+	for _, task := range tasks {
+		jsonBytes, err := json.Marshal(task.result)
+		if err != nil {
+			continue
+		}
+		if task.failed {
+			errorOutput.Write(jsonBytes)
+			errorOutput.WriteByte('\n')
+		} else {
+			output.Write(jsonBytes)
+			output.WriteByte('\n')
+		}
+	}
+	if output.Len() > 0 {
+		file, err := controller.SaveBatchResultFile(batch, "batch_output", output.Bytes())
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to save batch %s output: %s", batch.BatchId, err.Error()))
+		} else {
+			batch.OutputFileId = file.FileId
+		}
+	}
+	if errorOutput.Len() > 0 {
+		file, err := controller.SaveBatchResultFile(batch, "batch_error", errorOutput.Bytes())
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to save batch %s errors: %s", batch.BatchId, err.Error()))
+		} else {
+			batch.ErrorFileId = file.FileId
+		}
+	}
+	now := common.GetTimestamp()
+	if cancelled {
+		batch.Status = model.BatchStatusCancelled
+		batch.CancelledAt = now
+		_, err = batch.Transition([]string{model.BatchStatusCancelling}, "output_file_id", "error_file_id", "cancelled_at")
+	} else {
+		batch.Status = model.BatchStatusCompleted
+		batch.CompletedAt = now
+		_, err = batch.Transition([]string{model.BatchStatusFinalizing}, "output_file_id", "error_file_id", "completed_at")
+	}
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+	}
+	common.SysLog(fmt.Sprintf("batch %s finished: %d completed, %d failed", batch.BatchId, batch.CompletedRequests, batch.FailedRequests))
+}
+
+func batchLineError(result *dbmodel.BatchResponseLine, bizErr *dbmodel.ErrorWithStatusCode) (*dbmodel.BatchResponseLine, bool) {
+	code, _ := bizErr.Code.(string)
+	result.Error = &dbmodel.BatchError{Code: code, Message: bizErr.Message}
+	return result, true
+}
+
+// runBatchLine replays one input line through the regular relay pipeline on behalf of the batch owner.
+func runBatchLine(batch *model.Batch, line *dbmodel.BatchRequestLine) (*dbmodel.BatchResponseLine, bool) {
+	requestId := "batch_req_" + common.GetUUID()
+	result := &dbmodel.BatchResponseLine{Id: requestId, CustomId: line.CustomId}
+
+	var request dbmodel.GeneralOpenAIRequest
+	err := json.Unmarshal(line.Body, &request)
+	if err != nil {
+		return batchLineError(result, openai.ErrorWrapper(err, "invalid_request", http.StatusBadRequest))
+	}
+	if request.Stream {
+		return batchLineError(result, openai.ErrorWrapper(errors.New("stream is not supported in batch requests"), "invalid_request", http.StatusBadRequest))
+	}
+	ctx := context.WithValue(context.Background(), common.RequestIdKey, requestId)
+	ctx = context.WithValue(ctx, common.BatchIdKey, batch.BatchId)
+	c, recorder, bizErr := newInternalRelayContext(ctx, batch.TokenId, line.Url, &request)
+	if bizErr != nil {
+		return batchLineError(result, bizErr)
+	}
+	defer middleware.ReleaseChannelLimit(c)
+	c.Set("batch_id", batch.BatchId)
+	Relay(c)
+
+	body := recorder.Body.Bytes()
+	if !json.Valid(body) {
+		body, _ = json.Marshal(string(body))
+	}
+	result.Response = &dbmodel.BatchLineResponse{
+		StatusCode: recorder.Code,
+		RequestId:  requestId,
+		Body:       body,
+	}
+	if recorder.Code == http.StatusOK {
+		return result, false
+	}
+	relayErr := internalResponseError(recorder)
+	code, _ := relayErr.Code.(string)
+	result.Error = &dbmodel.BatchError{Code: code, Message: relayErr.Message}
+	return result, true
+}
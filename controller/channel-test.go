@@ -172,7 +172,7 @@ func TestChannel(c *gin.Context) {
 	}
 
 	tik := time.Now()
-	err, _ = testChannel(channel, modelTest)
+	err, _ = testChannel(channel.WithSelectedKey(), modelTest)
 	tok := time.Now()
 	milliseconds := tok.Sub(tik).Milliseconds()
 	go channel.UpdateResponseTime(milliseconds)
@@ -232,6 +232,61 @@ func disableChannel(channelId int, channelName string, reason string) {
 	}
 }
 
+// testChannelKeys 逐个测试多密钥渠道的密钥，禁用失败的密钥并启用恢复的密钥，没有可用密钥时禁用渠道
+func testChannelKeys(channel *model.Channel, modelTest string, disableThreshold int64) {
+	err := model.SyncChannelKeys(channel)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to sync keys of channel #%d: %s", channel.Id, err.Error()))
+	}
+	autoBan := channel.AutoBan == nil || *channel.AutoBan != 0
+	keys := channel.GetKeys()
+	available := 0
+	var lastErr error
+	var totalMilliseconds int64
+	for _, key := range keys {
+		keyChannel := *channel
+		keyChannel.Key = key
+		keyId := model.GetChannelKeyId(channel.Id, key)
+		tik := time.Now()
+		err, openaiErr := testChannel(&keyChannel, modelTest)
+		milliseconds := time.Since(tik).Milliseconds()
+		totalMilliseconds += milliseconds
+		ban := false
+		if milliseconds > disableThreshold {
+			err = errors.New(fmt.Sprintf("响应时间 %.2fs 超过阈值 %.2fs", float64(milliseconds)/1000.0, float64(disableThreshold)/1000.0))
+			ban = true
+		}
+		if openaiErr != nil {
+			err = errors.New(fmt.Sprintf("type %s, code %v, message %s", openaiErr.Type, openaiErr.Code, openaiErr.Message))
+			ban = true
+		}
+		if !ban || !autoBan || keyId == 0 {
+			available++
+			if err == nil && keyId != 0 {
+				model.EnableChannelKey(channel.Id, keyId)
+			}
+			continue
+		}
+		lastErr = err
+		_, err = model.DisableChannelKey(channel.Id, keyId, err.Error())
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to disable key #%d of channel #%d: %s", keyId, channel.Id, err.Error()))
+		}
+	}
+	if available == 0 && len(keys) > 0 {
+		if channel.Status != common.ChannelStatusAutoDisabled {
+			disableChannel(channel.Id, channel.Name, "所有密钥均不可用，最后一个错误："+lastErr.Error())
+		}
+	} else if channel.Status == common.ChannelStatusAutoDisabled {
+		model.UpdateChannelStatusById(channel.Id, common.ChannelStatusEnabled)
+		notifyChannelEnabled(channel)
+		notifyWxPusherEnabled(channel)
+	}
+	if len(keys) > 0 {
+		channel.UpdateResponseTime(totalMilliseconds / int64(len(keys)))
+	}
+}
+
 func testAllChannels(notify bool) error {
 	notificationEmail := config.OptionMap["NotificationEmail"]
 	if notificationEmail == "" {
@@ -268,6 +323,11 @@ func testAllChannels(notify bool) error {
 			if modelTest == "" {
 				modelTest = "gpt-3.5-turbo"
 			}
+			if channel.IsMultiKey() {
+				testChannelKeys(channel, modelTest, disableThreshold)
+				time.Sleep(common.RequestInterval)
+				continue
+			}
 			err, openaiErr := testChannel(channel, modelTest)
 			tok := time.Now()
 			milliseconds := tok.Sub(tik).Milliseconds()
@@ -381,7 +441,7 @@ func testChannelAndHandleResult(channel *model.Channel, testInterval time.Durati
 	}
 
 	// 调用testChannel函数使用确定好的模型进行测试
-	err, _ := testChannel(channel, modelTest)
+	err, _ := testChannel(channel.WithSelectedKey(), modelTest)
 
 	if err == nil && channel.Status == common.ChannelStatusAutoDisabled {
 		// 测试通过，更新通道状态为启用
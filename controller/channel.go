@@ -1,7 +1,6 @@
 package controller
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"one-api/common"
@@ -96,8 +95,19 @@ func AddChannel(c *gin.Context) {
 		})
 		return
 	}
+	if err = validateChannelModels(&channel); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	channel.CreatedTime = common.GetTimestamp()
 	keys := strings.Split(channel.Key, "\n")
+	// 多密钥渠道的所有密钥属于同一个渠道，否则每行密钥创建一个渠道
+	if channel.IsMultiKey() {
+		keys = []string{channel.Key}
+	}
 	channels := make([]model.Channel, 0, len(keys))
 	for _, key := range keys {
 		//if key == "" {
@@ -197,6 +207,13 @@ func UpdateChannel(c *gin.Context) {
 		})
 		return
 	}
+	if err = validateChannelModels(&channel); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	err = channel.Update()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -230,27 +247,7 @@ func FetchUpstreamModels(c *gin.Context) {
 		})
 		return
 	}
-	if channel.Type != common.ChannelTypeOpenAI {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": "仅支持 OpenAI 类型渠道",
-		})
-		return
-	}
-	var baseURL string
-	if channel.BaseURL != nil && *channel.BaseURL != "" {
-		baseURL = *channel.BaseURL
-	} else {
-		baseURL = "https://api.openai.com"
-	}
-
-	// 移除末尾的 '/'（如果存在）
-	baseURL = strings.TrimRight(baseURL, "/")
-
-	// 添加 "/v1/models" 到 URL
-	url := fmt.Sprintf("%s/v1/models", baseURL)
-
-	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(channel.Key))
+	models, err := fetchChannelModels(channel)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -258,21 +255,31 @@ func FetchUpstreamModels(c *gin.Context) {
 		})
 		return
 	}
-
-	// 直接返回原始响应内容
-	var rawJSON json.RawMessage
-	err = json.Unmarshal(body, &rawJSON)
-	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": "无法解析上游返回的 JSON: " + err.Error(),
-		})
-		return
+	// 保持 OpenAI /v1/models 的返回格式
+	type modelObject struct {
+		Id string `json:"id"`
+	}
+	data := make([]modelObject, 0, len(models))
+	for _, name := range models {
+		data = append(data, modelObject{Id: name})
 	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
-		"data":    rawJSON,
+		"data":    gin.H{"data": data},
 	})
 }
+
+// validateChannelModels checks the regex patterns among the models and the model mapping of the channel.
+func validateChannelModels(channel *model.Channel) error {
+	models := strings.Split(channel.Models, ",")
+	for name := range channel.GetModelMapping() {
+		models = append(models, name)
+	}
+	for _, name := range models {
+		if err := common.ValidateModelPattern(name); err != nil {
+			return fmt.Errorf("模型规则 %s 无效：%s", name, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetChannelBreakers lists the circuit breakers of the channels with recent traffic or not closed.
+func GetChannelBreakers(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetChannelBreakerStatuses(),
+	})
+}
+
+// ResetChannelBreaker closes the breakers of a channel, only the one of ?model= when given.
+func ResetChannelBreaker(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	err = model.ResetChannelBreaker(id, c.Query("model"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,334 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/common/config"
+	"one-api/model"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 定时从上游获取各渠道的模型列表，与渠道模型对比后记录变更，按设置自动增删模型并通知管理员
+
+var errModelListUnsupported = errors.New("该渠道类型不支持获取模型列表")
+
+var modelSyncLock sync.Mutex
+
+// openAIModelListChannelTypes 支持 OpenAI 格式 /v1/models 的渠道类型
+var openAIModelListChannelTypes = map[int]bool{
+	common.ChannelTypeOpenAI:      true,
+	common.ChannelTypeAPI2D:       true,
+	common.ChannelTypeCloseAI:     true,
+	common.ChannelTypeOpenAISB:    true,
+	common.ChannelTypeOpenAIMax:   true,
+	common.ChannelTypeOhMyGPT:     true,
+	common.ChannelTypeCustom:      true,
+	common.ChannelTypeAIProxy:     true,
+	common.ChannelTypeAPI2GPT:     true,
+	common.ChannelTypeAIGC2D:      true,
+	common.ChannelTypeOpenRouter:  true,
+	common.ChannelTypeMoonshot:    true,
+	common.ChannelTypeGroq:        true,
+	common.ChannelTypeMistral:     true,
+	common.ChannelTypeLingYiWanWu: true,
+	common.ChannelTypeDeepSeek:    true,
+	common.ChannelTypeTogetherAI:  true,
+}
+
+// fetchChannelModels lists the models the upstream of the channel offers.
+func fetchChannelModels(channel *model.Channel) ([]string, error) {
+	baseURL := channel.GetBaseURL()
+	if baseURL == "" && channel.Type < len(common.ChannelBaseURLs) {
+		baseURL = common.ChannelBaseURLs[channel.Type]
+	}
+	baseURL = strings.TrimRight(baseURL, "/")
+	if baseURL == "" {
+		return nil, errors.New("渠道未设置代理地址")
+	}
+	key := channel.WithSelectedKey().Key
+	var response struct {
+		Data []struct {
+			Id string `json:"id"`
+		} `json:"data"`
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	var body []byte
+	var err error
+	switch {
+	case channel.Type == common.ChannelTypeGemini:
+		body, err = GetResponseBody("GET", fmt.Sprintf("%s/v1beta/models?pageSize=1000&key=%s", baseURL, key), channel, nil)
+	case channel.Type == common.ChannelTypeOllama:
+		body, err = GetResponseBody("GET", baseURL+"/api/tags", channel, nil)
+	case channel.Type == common.ChannelTypeCohere:
+		body, err = GetResponseBody("GET", baseURL+"/v1/models?page_size=1000", channel, GetAuthHeader(key))
+	case openAIModelListChannelTypes[channel.Type]:
+		body, err = GetResponseBody("GET", baseURL+"/v1/models", channel, GetAuthHeader(key))
+	default:
+		return nil, errModelListUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析上游返回的模型列表: %s", err.Error())
+	}
+	var models []string
+	for _, item := range response.Data {
+		models = append(models, item.Id)
+	}
+	for _, item := range response.Models {
+		// Gemini 的模型名带有 models/ 前缀
+		models = append(models, strings.TrimPrefix(item.Name, "models/"))
+	}
+	slices.Sort(models)
+	return slices.Compact(models), nil
+}
+
+// diffChannelModels compares the models of the channel with the upstream list. Patterns and mapped model names
+// are never retired, and upstream models covered by a pattern of the channel aren't new.
+func diffChannelModels(channel *model.Channel, upstream []string) (added []string, removed []string) {
+	var current []string
+	for _, name := range strings.Split(channel.Models, ",") {
+		if name != "" {
+			current = append(current, name)
+		}
+	}
+	mapping := channel.GetModelMapping()
+	for _, name := range current {
+		if _, ok := mapping[name]; ok || common.IsModelPattern(name) {
+			continue
+		}
+		if !slices.Contains(upstream, name) {
+			removed = append(removed, name)
+		}
+	}
+	for _, name := range upstream {
+		if name != "" && !common.MatchModelAny(current, name) {
+			added = append(added, name)
+		}
+	}
+	return added, removed
+}
+
+// syncChannelModels fetches the models of the channel and records the changes, applying them when enabled.
+// The change is nil when nothing changed since the last record.
+func syncChannelModels(channel *model.Channel) (*model.ChannelModelChange, error) {
+	upstream, err := fetchChannelModels(channel)
+	if err != nil {
+		return nil, err
+	}
+	// 上游返回空列表多半是临时故障，不据此下架模型
+	if len(upstream) == 0 {
+		return nil, errors.New("上游未返回任何模型")
+	}
+	added, removed := diffChannelModels(channel, upstream)
+	if len(added) == 0 && len(removed) == 0 {
+		return nil, nil
+	}
+	change := &model.ChannelModelChange{
+		ChannelId:   channel.Id,
+		ChannelName: channel.Name,
+		Added:       strings.Join(added, ","),
+		Removed:     strings.Join(removed, ","),
+	}
+	models := strings.Split(channel.Models, ",")
+	if config.ModelSyncAutoAddEnabled && len(added) > 0 {
+		models = append(models, added...)
+		change.Applied = true
+	}
+	if config.ModelSyncAutoRemoveEnabled && len(removed) > 0 {
+		models = slices.DeleteFunc(models, func(name string) bool {
+			return slices.Contains(removed, name)
+		})
+		change.Applied = true
+	}
+	if change.Applied {
+		models = slices.DeleteFunc(models, func(name string) bool {
+			return name == ""
+		})
+		err = model.UpdateChannelModels(channel, models)
+		if err != nil {
+			return nil, err
+		}
+	} else if last, err := model.GetChannelModelChanges(channel.Id, 0, 1); err == nil && len(last) > 0 &&
+		!last[0].Applied && last[0].Added == change.Added && last[0].Removed == change.Removed {
+		// 未应用的变更与上次相同，不重复记录和通知
+		return nil, nil
+	}
+	return change, change.Insert()
+}
+
+func describeChannelModelChange(change *model.ChannelModelChange) string {
+	description := fmt.Sprintf("渠道「%s」（#%d）", change.ChannelName, change.ChannelId)
+	if change.Added != "" {
+		description += "，新增模型：" + change.Added
+	}
+	if change.Removed != "" {
+		description += "，下架模型：" + change.Removed
+	}
+	if change.Applied {
+		description += "，已更新渠道模型"
+	} else {
+		description += "，未更新渠道模型"
+	}
+	return description
+}
+
+// syncAllChannelModels syncs the models of every enabled channel and notifies the admin of the changes.
+func syncAllChannelModels() error {
+	if !modelSyncLock.TryLock() {
+		return errors.New("模型同步正在进行中，请稍后再试")
+	}
+	defer modelSyncLock.Unlock()
+	channels, err := model.GetAllChannels(0, 0, true, false)
+	if err != nil {
+		return err
+	}
+	var changes []string
+	for _, channel := range channels {
+		if channel.Status != common.ChannelStatusEnabled {
+			continue
+		}
+		cfg, _ := channel.LoadConfig()
+		if cfg.NoModelSync {
+			continue
+		}
+		change, err := syncChannelModels(channel)
+		if err != nil {
+			if !errors.Is(err, errModelListUnsupported) {
+				common.SysError(fmt.Sprintf("failed to sync models of channel #%d: %s", channel.Id, err.Error()))
+			}
+			continue
+		}
+		if change != nil {
+			changes = append(changes, describeChannelModelChange(change))
+		}
+	}
+	if len(changes) > 0 {
+		notifyRootUser(fmt.Sprintf("%d 个渠道的上游模型有变化", len(changes)), strings.Join(changes, "\n"))
+	}
+	return nil
+}
+
+// notifyRootUser 通过邮件和 WxPusher 通知管理员
+func notifyRootUser(subject string, content string) {
+	emailNotifEnabled, _ := strconv.ParseBool(config.OptionMap["EmailNotificationsEnabled"])
+	if emailNotifEnabled {
+		notificationEmail := config.OptionMap["NotificationEmail"]
+		if notificationEmail == "" {
+			if config.RootUserEmail == "" {
+				config.RootUserEmail = model.GetRootUserEmail()
+			}
+			notificationEmail = config.RootUserEmail
+		}
+		err := common.SendEmail(subject, notificationEmail, strings.ReplaceAll(content, "\n", "<br>"))
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to send email: %s", err.Error()))
+		}
+	}
+	wxNotifEnabled, _ := strconv.ParseBool(config.OptionMap["WxPusherNotificationsEnabled"])
+	if wxNotifEnabled {
+		err := SendWxPusherNotification(subject, content)
+		if err != nil {
+			common.SysError(fmt.Sprintf("无法发送WxPusher通知: %s", err))
+		}
+	}
+}
+
+func AutomaticallySyncChannelModels() {
+	for {
+		interval := config.ModelSyncInterval
+		if interval <= 0 {
+			interval = 1440
+		}
+		time.Sleep(time.Duration(interval) * time.Minute)
+		if !config.ModelSyncEnabled {
+			continue
+		}
+		common.SysLog("syncing upstream models of channels")
+		err := syncAllChannelModels()
+		if err != nil {
+			common.SysError("failed to sync upstream models: " + err.Error())
+			continue
+		}
+		common.SysLog("upstream models synced")
+	}
+}
+
+func SyncAllChannelModels(c *gin.Context) {
+	go func() {
+		err := syncAllChannelModels()
+		if err != nil {
+			common.SysError("failed to sync upstream models: " + err.Error())
+		}
+	}()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func SyncChannelModels(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	change, err := syncChannelModels(channel)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    change,
+	})
+}
+
+func GetChannelModelChanges(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	changes, err := model.GetChannelModelChanges(channelId, p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    changes,
+	})
+}
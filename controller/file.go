@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/common/config"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/relay/channel/openai"
+	"one-api/relay/controller"
+	dbmodel "one-api/relay/model"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func relayFile(c *gin.Context) *dbmodel.ErrorWithStatusCode {
+	fileId := c.Param("id")
+	if fileId == "" {
+		if c.Request.Method == http.MethodGet {
+			return controller.RelayFileList(c)
+		}
+		if config.FileUpstreamEnabled {
+			err := middleware.SetupChannelForModel(c, controller.FileModelName)
+			if err != nil {
+				return openai.ErrorWrapper(err, "no_available_channel", http.StatusServiceUnavailable)
+			}
+			defer middleware.ReleaseChannelLimit(c)
+		}
+		return controller.RelayFileUpload(c)
+	}
+
+	file, err := model.GetUserFile(c.GetInt("id"), fileId)
+	if err != nil {
+		return openai.ErrorWrapper(fmt.Errorf("No such File object: %s", fileId), "file_not_found", http.StatusNotFound)
+	}
+	if file.ChannelId != 0 {
+		err = middleware.SetupContextForBoundChannel(c, file.ChannelId, controller.FileModelName)
+		if err != nil {
+			return openai.ErrorWrapper(err, "bound_channel_unavailable", http.StatusServiceUnavailable)
+		}
+	}
+	switch {
+	case c.Request.Method == http.MethodDelete:
+		return controller.RelayFileDelete(c, file)
+	case strings.HasSuffix(c.Request.URL.Path, "/content"):
+		return controller.RelayFileContent(c, file)
+	case c.Request.Method == http.MethodGet:
+		return controller.RelayFileRetrieve(c, file)
+	}
+	return openai.ErrorWrapper(errors.New("method not allowed"), "method_not_allowed", http.StatusMethodNotAllowed)
+}
+
+// RelayFile serves /v1/files. Files are kept in local storage unless FileUpstreamEnabled
+// is on, in which case they are uploaded to a channel and later requests go to that channel.
+func RelayFile(c *gin.Context) {
+	bizErr := relayFile(c)
+	if bizErr != nil {
+		bizErr.Error.Message = common.MessageWithRequestId(bizErr.Error.Message, c.GetString("X-Chatapi-Request-Id"))
+		c.JSON(bizErr.StatusCode, gin.H{
+			"error": bizErr.Error,
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/relay/channel/openai"
+	"one-api/relay/controller"
+	dbmodel "one-api/relay/model"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getFineTuningFilesChannel checks that the training and validation files of a new job belong to the user and
+// returns the channel they were uploaded to, the upstream only knows the files there.
+func getFineTuningFilesChannel(c *gin.Context) (int, *dbmodel.ErrorWithStatusCode) {
+	var request struct {
+		TrainingFile   string `json:"training_file"`
+		ValidationFile string `json:"validation_file"`
+	}
+	err := common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		return 0, openai.ErrorWrapper(err, "invalid_fine_tuning_request", http.StatusBadRequest)
+	}
+	if request.TrainingFile == "" {
+		return 0, openai.ErrorWrapper(errors.New("training_file is required"), "invalid_fine_tuning_request", http.StatusBadRequest)
+	}
+	channelId := 0
+	for _, fileId := range []string{request.TrainingFile, request.ValidationFile} {
+		if fileId == "" {
+			continue
+		}
+		file, err := model.GetUserFile(c.GetInt("id"), fileId)
+		if err != nil {
+			return 0, openai.ErrorWrapper(fmt.Errorf("No such File object: %s", fileId), "file_not_found", http.StatusBadRequest)
+		}
+		if file.ChannelId == 0 {
+			return 0, openai.ErrorWrapper(fmt.Errorf("File %s is kept in local storage and can't be used for fine-tuning", fileId), "invalid_file", http.StatusBadRequest)
+		}
+		if channelId != 0 && file.ChannelId != channelId {
+			return 0, openai.ErrorWrapper(errors.New("training_file and validation_file were uploaded to different channels"), "invalid_file", http.StatusBadRequest)
+		}
+		channelId = file.ChannelId
+	}
+	return channelId, nil
+}
+
+func relayFineTuning(c *gin.Context) *dbmodel.ErrorWithStatusCode {
+	jobId := c.Param("id")
+	if jobId == "" {
+		if c.Request.Method == http.MethodGet {
+			return controller.RelayFineTuningJobList(c)
+		}
+		channelId, bizErr := getFineTuningFilesChannel(c)
+		if bizErr != nil {
+			return bizErr
+		}
+		err := middleware.SetupContextForBoundChannel(c, channelId, c.GetString("model"))
+		if err != nil {
+			return openai.ErrorWrapper(err, "bound_channel_unavailable", http.StatusServiceUnavailable)
+		}
+		return controller.RelayFineTuningJobCreate(c)
+	}
+
+	job, err := model.GetUserFineTuningJob(c.GetInt("id"), jobId)
+	if err != nil {
+		return openai.ErrorWrapper(fmt.Errorf("No such fine-tuning job: %s", jobId), "fine_tuning_job_not_found", http.StatusNotFound)
+	}
+	err = middleware.SetupContextForBoundChannel(c, job.ChannelId, job.Model)
+	if err != nil {
+		return openai.ErrorWrapper(err, "bound_channel_unavailable", http.StatusServiceUnavailable)
+	}
+	switch {
+	case strings.HasSuffix(c.Request.URL.Path, "/cancel"):
+		return controller.RelayFineTuningJobCancel(c, job)
+	case strings.HasSuffix(c.Request.URL.Path, "/events"):
+		return controller.RelayFineTuningJobEvents(c)
+	}
+	return controller.RelayFineTuningJobRetrieve(c, job)
+}
+
+// RelayFineTuning serves /v1/fine_tuning/jobs. Jobs are created on the channel their files were uploaded to
+// and every later request for a job is sent to the channel that created it.
+func RelayFineTuning(c *gin.Context) {
+	bizErr := relayFineTuning(c)
+	if bizErr != nil {
+		bizErr.Error.Message = common.MessageWithRequestId(bizErr.Error.Message, c.GetString("X-Chatapi-Request-Id"))
+		c.JSON(bizErr.StatusCode, gin.H{
+			"error": bizErr.Error,
+		})
+	}
+}
+
+// SyncFineTuningJobs polls the unfinished jobs, so fine-tuned models become routable
+// even if their owner never retrieves the job.
+func SyncFineTuningJobs(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		jobs, err := model.GetUnfinishedFineTuningJobs()
+		if err != nil {
+			common.SysError("failed to get unfinished fine-tuning jobs: " + err.Error())
+			continue
+		}
+		for _, job := range jobs {
+			c, _, err := newBoundChannelContext(http.MethodGet, "/v1/fine_tuning/jobs/"+job.JobId, job.ChannelId, job.Model)
+			if err != nil {
+				common.SysError(fmt.Sprintf("failed to sync fine-tuning job %s: %s", job.JobId, err.Error()))
+				continue
+			}
+			bizErr := controller.RefreshFineTuningJob(c, job)
+			if bizErr != nil {
+				common.SysError(fmt.Sprintf("failed to sync fine-tuning job %s: %s", job.JobId, bizErr.Message))
+			}
+		}
+	}
+}
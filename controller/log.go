@@ -80,6 +80,7 @@ func GetUserLogs(c *gin.Context) {
 			Multiplier:       log.Multiplier,
 			UserQuota:        log.UserQuota,
 			Ip:               log.Ip,
+			TokenDetails:     log.TokenDetails,
 		}
 		responseLogs = append(responseLogs, responseLog)
 	}
@@ -0,0 +1,36 @@
+package controller_test
+
+import (
+	"one-api/common"
+	"one-api/common/config"
+	"one-api/model"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setGlobal sets the variable p points to for the duration of the test.
+func setGlobal[T any](t *testing.T, p *T, value T) {
+	previous := *p
+	*p = value
+	t.Cleanup(func() {
+		*p = previous
+	})
+}
+
+// setupRelayTest opens a fresh SQLite database in a temporary directory with the default options.
+func setupRelayTest(t *testing.T) {
+	setGlobal(t, &common.SQLitePath, filepath.Join(t.TempDir(), "one-api.db"))
+	if !assert.NoError(t, model.InitDB()) {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		_ = model.CloseDB()
+	})
+	if !assert.NoError(t, common.InitRedisClient()) {
+		t.FailNow()
+	}
+	model.InitOptionMap()
+	setGlobal(t, &config.ApproximateTokenEnabled, true)
+}
@@ -375,7 +375,7 @@ func compensateForTaskFailure(ctx context.Context, task *model.Midjourney) {
 		ratio := modelRatio * groupRatio
 		quota := int(ratio * config.QuotaPerUnit)
 		if quota != 0 {
-			err := model.IncreaseUserQuota(task.UserId, quota)
+			err := model.IncreaseUserQuota(task.UserId, quota, model.LedgerRef{Reason: model.LedgerReasonRefund, RefId: task.MjId})
 			if err != nil {
 				log.Println("fail to increase user quota")
 			}
@@ -7,6 +7,7 @@ import (
 	"one-api/common/config"
 	"one-api/model"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -101,6 +102,30 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "RoutingStrategy":
+		if !common.IsValidRoutingStrategy(option.Value) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "未知的渠道选择策略：" + option.Value,
+			})
+			return
+		}
+	case "PricingRules":
+		if err := common.ValidatePricingRules(option.Value); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "计价规则无效：" + err.Error(),
+			})
+			return
+		}
+	case "PricingTimezone":
+		if _, err := time.LoadLocation(option.Value); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的时区：" + option.Value,
+			})
+			return
+		}
 	}
 	err = model.UpdateOption(option.Key, option.Value)
 	if err != nil {
@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/common/config"
+	"one-api/model"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func describeQuotaDrifts(drifts []model.QuotaDrift) string {
+	var lines []string
+	for _, drift := range drifts {
+		lines = append(lines, fmt.Sprintf("%s 余额 %d，账本 %d，相差 %d", drift.Account, drift.Balance, drift.LedgerBalance, drift.Drift))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func AutomaticallyReconcileQuotaLedger() {
+	for {
+		interval := config.QuotaReconcileInterval
+		if interval <= 0 {
+			interval = 1440
+		}
+		time.Sleep(time.Duration(interval) * time.Minute)
+		if !config.QuotaReconcileEnabled {
+			continue
+		}
+		common.SysLog("reconciling quota ledger")
+		drifts, err := model.ReconcileQuotaLedger()
+		if err != nil {
+			common.SysError("failed to reconcile quota ledger: " + err.Error())
+			continue
+		}
+		if len(drifts) > 0 {
+			common.SysError(fmt.Sprintf("quota ledger drifted on %d accounts", len(drifts)))
+			notifyRootUser(fmt.Sprintf("%d 个账户的额度与账本不符", len(drifts)), describeQuotaDrifts(drifts))
+		}
+		common.SysLog("quota ledger reconciled")
+	}
+}
+
+func GetQuotaLedgers(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	account := c.Query("account")
+	if userId, _ := strconv.Atoi(c.Query("user_id")); userId != 0 {
+		account = model.UserLedgerAccount(userId)
+	} else if tokenId, _ := strconv.Atoi(c.Query("token_id")); tokenId != 0 {
+		account = model.TokenLedgerAccount(tokenId)
+	}
+	ledgers, total, err := model.GetQuotaLedgers(account, c.Query("reason"), c.Query("ref_id"), p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    ledgers,
+		"total":   total,
+	})
+}
+
+func ReconcileQuotaLedger(c *gin.Context) {
+	drifts, err := model.ReconcileQuotaLedger()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    drifts,
+	})
+}
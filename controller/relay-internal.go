@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/relay/channel/openai"
+	dbmodel "one-api/relay/model"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newInternalRelayContext builds the context TokenAuth and Distribute would have prepared for
+// a request of the given token, so requests issued by the gateway itself (batch lines, assistant
+// runs) go through the regular relay pipeline, billing and logging included. The response is
+// written to the returned recorder.
+func newInternalRelayContext(ctx context.Context, tokenId int, requestURL string, request *dbmodel.GeneralOpenAIRequest) (*gin.Context, *httptest.ResponseRecorder, *dbmodel.ErrorWithStatusCode) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, nil, openai.ErrorWrapper(err, "marshal_request_failed", http.StatusInternalServerError)
+	}
+	token, err := model.GetTokenById(tokenId)
+	if err != nil {
+		return nil, nil, openai.ErrorWrapper(errors.New("令牌已不存在"), "invalid_token", http.StatusUnauthorized)
+	}
+	token, err = model.ValidateUserToken(token.Key, request.Model)
+	if err != nil {
+		return nil, nil, openai.ErrorWrapper(err, "invalid_token", http.StatusUnauthorized)
+	}
+	userEnabled, err := model.CacheIsUserEnabled(token.UserId)
+	if err != nil || !userEnabled {
+		return nil, nil, openai.ErrorWrapper(errors.New("用户已被封禁"), "user_disabled", http.StatusForbidden)
+	}
+	if strings.HasPrefix(request.Model, "ft:") {
+		err = model.CheckFineTunedModelOwner(request.Model, token.UserId)
+		if err != nil {
+			return nil, nil, openai.ErrorWrapper(err, "model_not_allowed", http.StatusForbidden)
+		}
+	}
+	group := token.Group
+	if group == "" {
+		group, err = model.GetUserGroup(token.UserId)
+		if err != nil {
+			return nil, nil, openai.ErrorWrapper(errors.New("未能获取用户分组信息"), "invalid_group", http.StatusForbidden)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, err = http.NewRequestWithContext(ctx, http.MethodPost, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, openai.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	c.Request.Header.Set("Content-Type", "application/json")
+	if requestId, ok := ctx.Value(common.RequestIdKey).(string); ok {
+		c.Set(common.RequestIdKey, requestId)
+	}
+	c.Set("claude_original_request", false)
+	c.Set("is_tools", len(request.Tools) > 0)
+	c.Set("id", token.UserId)
+	c.Set("token_id", token.Id)
+	c.Set("token_name", token.Name)
+	c.Set("billing_enabled", token.BillingEnabled)
+	c.Set("token_budget_enabled", token.HasBudget())
+	c.Set("group", group)
+	c.Set("fixed_content", token.FixedContent)
+	c.Set("model", request.Model)
+	c.Set("original_model", request.Model)
+	c.Set("token_unlimited_quota", token.UnlimitedQuota)
+	if !token.UnlimitedQuota {
+		c.Set("token_quota", token.RemainQuota)
+	}
+	c.Set("consume_quota", true)
+	if statusCode, err := middleware.CheckPlanLimits(c, token.UserId, request.Model); err != nil {
+		code := "plan_limit_exceeded"
+		if statusCode == http.StatusInternalServerError {
+			code = "get_plan_failed"
+		}
+		return nil, nil, openai.ErrorWrapper(err, code, statusCode)
+	}
+	err = middleware.SetupChannelForModel(c, request.Model)
+	if err != nil {
+		return nil, nil, openai.ErrorWrapper(err, "no_available_channel", http.StatusServiceUnavailable)
+	}
+	return c, recorder, nil
+}
+
+// internalResponseError extracts the error a relayed request answered with.
+func internalResponseError(recorder *httptest.ResponseRecorder) *dbmodel.Error {
+	var errResponse struct {
+		Error dbmodel.Error `json:"error"`
+	}
+	if json.Unmarshal(recorder.Body.Bytes(), &errResponse) == nil && errResponse.Error.Message != "" {
+		return &errResponse.Error
+	}
+	return &dbmodel.Error{Message: http.StatusText(recorder.Code), Type: "chat_api_error", Code: "request_failed"}
+}
+
+// newBoundChannelContext builds a context for a request the gateway sends to a recorded channel
+// outside of any client request, e.g. polling the status of an upstream job.
+func newBoundChannelContext(method string, requestURL string, channelId int, modelName string) (*gin.Context, *httptest.ResponseRecorder, error) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	var err error
+	c.Request, err = http.NewRequest(method, requestURL, http.NoBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = middleware.SetupContextForBoundChannel(c, channelId, modelName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, recorder, nil
+}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -17,6 +18,7 @@ import (
 	"one-api/relay/util"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -36,6 +38,16 @@ func relay(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCode {
 		err = controller.RelayAudioHelper(c, relayMode)
 	case constant.RelayModeMessages:
 		err = controller.RelayClaude(c)
+	case constant.RelayModeResponses:
+		err = controller.RelayResponsesHelper(c)
+	case constant.RelayModeRerank:
+		err = controller.RelayRerankHelper(c)
+	case constant.RelayModeGeminiGenerateContent:
+		err = controller.RelayGeminiHelper(c)
+	case constant.RelayModeGeminiCountTokens:
+		err = controller.RelayGeminiCountTokens(c)
+	case constant.RelayModeRealtime:
+		err = controller.RelayRealtimeHelper(c)
 	default:
 		err = controller.RelayTextHelper(c)
 	}
@@ -45,60 +57,117 @@ func relay(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCode {
 func Relay(c *gin.Context) {
 	ctx := c.Request.Context()
 	relayMode := constant.Path2RelayMode(c.Request.URL.Path)
-	bizErr := relay(c, relayMode)
-	if bizErr == nil {
-		return
+	originalModel := c.GetString(ctxkey.OriginalModel)
+	group := c.GetString("group")
+	requestId := c.GetString("X-Chatapi-Request-Id")
+	var attemptsLog []string
+	c.Writer = &firstResponseWriter{ResponseWriter: c.Writer, c: c}
+	bizErr := relayWithRetry(c, relayMode, group, originalModel, &attemptsLog)
+	// 当前模型的渠道全部失败后，按回退链依次换用后面的模型，按实际使用的模型计费
+	for _, fallbackModel := range middleware.GetFallbackModels(c, originalModel) {
+		if bizErr == nil || bizErr.LocalError || !shouldRetry(c, bizErr.StatusCode) {
+			break
+		}
+		channel, err := middleware.SelectChannel(c, group, fallbackModel, false, []int{}, 0)
+		if err != nil {
+			continue
+		}
+		err = middleware.SetRequestModel(c, fallbackModel)
+		if err != nil {
+			common.Errorf(ctx, "model fallback is not supported for this request: %s", err.Error())
+			middleware.ReleaseChannelLimit(c)
+			break
+		}
+		attemptsLog = append(attemptsLog, fmt.Sprintf("模型回退: 模型「%s」不可用, 错误信息: %v, 改用模型「%s」渠道「%d」\n", originalModel, bizErr, fallbackModel, channel.Id))
+		common.Infof(ctx, "%s", attemptsLog)
+		middleware.SetupContextForSelectedChannel(c, channel, fallbackModel, strings.Join(attemptsLog, "\n"))
+		originalModel = fallbackModel
+		bizErr = relayWithRetry(c, relayMode, group, originalModel, &attemptsLog)
+	}
+	if bizErr != nil {
+		if bizErr.StatusCode == http.StatusTooManyRequests && !bizErr.LocalError {
+			bizErr.Error.Message = "当前分组上游负载已饱和，请稍后再试"
+		}
+		bizErr.Error.Message = common.MessageWithRequestId(bizErr.Error.Message, requestId)
+		// 流式响应已经开始发送时无法再改状态码，以事件的形式告知客户端，不发送 [DONE] 以免被当作完整的响应
+		if c.Writer.Written() {
+			jsonData, _ := json.Marshal(gin.H{"error": bizErr.Error})
+			c.Render(-1, common.CustomEvent{Data: "data: " + string(jsonData)})
+			return
+		}
+		c.JSON(bizErr.StatusCode, gin.H{
+			"error": bizErr.Error,
+		})
+	}
+}
+
+// relayWithRetry relays to the channel set up in the context and retries originalModel on other channels when it fails.
+func relayWithRetry(c *gin.Context, relayMode int, group string, originalModel string, attemptsLog *[]string) *dbmodel.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	startTime := time.Now()
+	primaryChannelId := c.GetInt("channel_id")
+	inflightDone := model.StartChannelRequest(primaryChannelId)
+	bizErr, hedgeFailedChannelIds := relayWithHedge(c, relayMode, group, originalModel, attemptsLog)
+	inflightDone()
+	// 对冲请求可能由第二个渠道返回，从它开始计时
+	if hedgeStartTime, ok := c.Value(ctxkey.HedgeStartTime).(time.Time); ok {
+		startTime = hedgeStartTime
+		c.Set(ctxkey.HedgeStartTime, nil)
+	}
+	recordChannelResult(c, originalModel, bizErr, startTime)
+	if bizErr == nil || bizErr.LocalError {
+		return bizErr
 	}
 	channelId := c.GetInt("channel_id")
 
 	lastFailedChannelId := channelId
 	channelName := c.GetString("channel_name")
-	group := c.GetString("group")
-	originalModel := c.GetString(ctxkey.OriginalModel)
 	processChannelRelayError(c, channelId, channelName, bizErr)
-	requestId := c.GetString("X-Chatapi-Request-Id")
 	retryTimes := config.RetryTimes
 	if !shouldRetry(c, bizErr.StatusCode) {
 		common.Errorf(ctx, "relay error happen, status code is %d, won't retry in this case", bizErr.StatusCode)
 		retryTimes = 0
 	}
-	var attemptsLog []string
-	failedChannelIds := []int{channelId}
+	failedChannelIds := append([]int{channelId}, hedgeFailedChannelIds...)
 	for i := retryTimes; i > 0; i-- {
 		value, _ := c.Get("is_tools")
 
 		// 尝试将值转换为bool类型
-		isTools, ok := value.(bool)
+		_, ok := value.(bool)
 		if !ok {
 			// 如果转换失败，处理类型不匹配的情况
 			fmt.Println("is_tools value is not of type bool")
-			return
+			return nil
 		}
 		valueclaudeoriginalrequest, _ := c.Get("claude_original_request")
-		isclaudeoriginalrequest, ok := valueclaudeoriginalrequest.(bool)
+		_, ok = valueclaudeoriginalrequest.(bool)
 		if !ok {
 			fmt.Println("claude_original_request value is not of type bool")
-			return
+			return nil
 		}
-		channel, err := model.CacheGetRandomSatisfiedChannel(group, originalModel, i != retryTimes, isTools, isclaudeoriginalrequest, failedChannelIds, i)
+		channel, err := middleware.SelectChannel(c, group, originalModel, i != retryTimes, failedChannelIds, i)
 		if err != nil {
-			common.Errorf(ctx, "CacheGetRandomSatisfiedChannel failed: %w", err)
+			common.Errorf(ctx, "SelectChannel failed: %v", err)
 			break
 		}
 
-		attemptsLog = append(attemptsLog, fmt.Sprintf("重试次数 #%d: 上次使用渠道「%d」, 错误信息: %v, 重试id:「%d」\n", retryTimes-i+1, lastFailedChannelId, bizErr, channel.Id))
-		common.Infof(ctx, "%s", attemptsLog)
+		*attemptsLog = append(*attemptsLog, fmt.Sprintf("重试次数 #%d: 上次使用渠道「%d」, 错误信息: %v, 重试id:「%d」\n", retryTimes-i+1, lastFailedChannelId, bizErr, channel.Id))
+		common.Infof(ctx, "%s", *attemptsLog)
 		if channel.Id == lastFailedChannelId {
 			continue
 		}
 
-		middleware.SetupContextForSelectedChannel(c, channel, originalModel, strings.Join(attemptsLog, "\n"))
+		middleware.SetupContextForSelectedChannel(c, channel, originalModel, strings.Join(*attemptsLog, "\n"))
 		requestBody, _ := common.GetRequestBody(c)
 
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+		startTime = time.Now()
+		inflightDone = model.StartChannelRequest(channel.Id)
 		bizErr = relay(c, relayMode)
-		if bizErr == nil {
-			return
+		inflightDone()
+		recordChannelResult(c, originalModel, bizErr, startTime)
+		if bizErr == nil || bizErr.LocalError {
+			return bizErr
 		}
 		channelId := c.GetInt("channel_id")
 		lastFailedChannelId = channelId
@@ -106,15 +175,7 @@ func Relay(c *gin.Context) {
 		channelName := c.GetString("channel_name")
 		processChannelRelayError(c, channelId, channelName, bizErr)
 	}
-	if bizErr != nil {
-		if bizErr.StatusCode == http.StatusTooManyRequests {
-			bizErr.Error.Message = "当前分组上游负载已饱和，请稍后再试"
-		}
-		bizErr.Error.Message = common.MessageWithRequestId(bizErr.Error.Message, requestId)
-		c.JSON(bizErr.StatusCode, gin.H{
-			"error": bizErr.Error,
-		})
-	}
+	return bizErr
 }
 
 func RelayMidjourney(c *gin.Context) {
@@ -157,10 +218,63 @@ func processChannelRelayError(ctx *gin.Context, channelId int, channelName strin
 	common.Errorf(ctx, "relay error (channel #%d): %s", channelId, err.Message)
 	// https://platform.openai.com/docs/guides/error-codes/api-errors
 	if util.ShouldDisableChannel(&err.Error, err.StatusCode) {
+		// 多密钥渠道只禁用出错的密钥，密钥全部禁用后才禁用渠道
+		if keyId := ctx.GetInt("channel_key_id"); keyId != 0 {
+			allDisabled, disableErr := model.DisableChannelKey(channelId, keyId, err.Message)
+			if disableErr != nil {
+				common.SysError(fmt.Sprintf("failed to disable key #%d of channel #%d: %s", keyId, channelId, disableErr.Error()))
+			}
+			if !allDisabled {
+				return
+			}
+		}
 		disableChannel(channelId, channelName, err.Message)
 	}
 }
 
+// firstResponseWriter records when the response is first written to the client, the latency routing
+// strategy uses the time to first token since the total time of a stream depends on the length of the output.
+type firstResponseWriter struct {
+	gin.ResponseWriter
+	c *gin.Context
+}
+
+func (w *firstResponseWriter) mark() {
+	if _, ok := w.c.Get(ctxkey.FirstResponseTime); !ok {
+		w.c.Set(ctxkey.FirstResponseTime, time.Now())
+	}
+}
+
+func (w *firstResponseWriter) Write(data []byte) (int, error) {
+	w.mark()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *firstResponseWriter) WriteString(s string) (int, error) {
+	w.mark()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// recordChannelResult 将本次尝试计入渠道熔断统计和延迟统计，请求本身的错误不算渠道失败
+func recordChannelResult(c *gin.Context, modelName string, bizErr *dbmodel.ErrorWithStatusCode, startTime time.Time) {
+	success := true
+	if bizErr != nil {
+		success = bizErr.LocalError || bizErr.StatusCode != http.StatusTooManyRequests && bizErr.StatusCode != http.StatusUnauthorized && bizErr.StatusCode/100 != 5
+	}
+	latency := time.Since(startTime)
+	model.RecordChannelResult(c.GetInt("channel_id"), modelName, success, latency)
+	switch {
+	case !success:
+		model.RecordChannelLatencyFailure(c.GetInt("channel_id"), modelName)
+	case bizErr == nil:
+		// 按首字时间统计延迟，没有写出响应时按总耗时
+		if firstResponseTime, ok := c.Value(ctxkey.FirstResponseTime).(time.Time); ok && firstResponseTime.After(startTime) {
+			latency = firstResponseTime.Sub(startTime)
+		}
+		model.RecordChannelLatency(c.GetInt("channel_id"), modelName, latency)
+	}
+}
+
 func RelayNotImplemented(c *gin.Context) {
 	err := dbmodel.Error{
 		Message: "API not implemented",
@@ -191,6 +305,9 @@ func shouldRetry(c *gin.Context, statusCode int) bool {
 		return false
 
 	}
+	if c.GetBool(ctxkey.StreamAborted) {
+		return false
+	}
 	if statusCode == http.StatusTooManyRequests {
 		return true
 	}
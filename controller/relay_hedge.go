@@ -0,0 +1,328 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/common/ctxkey"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/relay/channel/openai"
+	"one-api/relay/constant"
+	"one-api/relay/controller"
+	dbmodel "one-api/relay/model"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 请求对冲：首个渠道在等待时间内没有返回首字节时，同一请求再发给第二个渠道，
+// 先返回的一方胜出并计费，另一方被取消
+
+var errHedgeLost = errors.New("request answered by another channel")
+
+type hedgeAttempt struct {
+	c         *gin.Context
+	cancel    context.CancelFunc
+	channelId int
+	startTime time.Time
+	bizErr    *dbmodel.ErrorWithStatusCode
+	skipped   bool // 没有可用于对冲的渠道，未发出请求
+}
+
+type hedgeRace struct {
+	mu       sync.Mutex
+	winner   *hedgeAttempt
+	attempts []*hedgeAttempt
+	log      []string
+}
+
+// newAttempt copies the context for one more attempt at the request, nil once an attempt already answered.
+func (r *hedgeRace) newAttempt(c *gin.Context, requestBody []byte, channelId int) *hedgeAttempt {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.winner != nil {
+		return nil
+	}
+	hedgeCtx, cancel := context.WithCancel(c.Request.Context())
+	hc := c.Copy()
+	hc.Request = c.Request.Clone(hedgeCtx)
+	hc.Request.Body = io.NopCloser(bytes.NewReader(requestBody))
+	hc.Set(ctxkey.HedgeContext, hedgeCtx)
+	// 渠道限流的释放由各自的尝试负责
+	hc.Set("channel_limit_lease", nil)
+	controller.ForkStreamFailover(c, hc)
+	attempt := &hedgeAttempt{c: hc, cancel: cancel, channelId: channelId, startTime: time.Now()}
+	hc.Writer = &hedgeWriter{ResponseWriter: c.Writer, race: r, attempt: attempt, header: make(http.Header)}
+	r.attempts = append(r.attempts, attempt)
+	return attempt
+}
+
+// claim makes attempt the one answering the client unless another attempt already did, the others are cancelled.
+func (r *hedgeRace) claim(attempt *hedgeAttempt) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.winner != nil {
+		return r.winner == attempt
+	}
+	r.winner = attempt
+	for _, other := range r.attempts {
+		if other == attempt || other.skipped {
+			continue
+		}
+		// 尚未选出渠道的一方直接放弃
+		if other.channelId != 0 {
+			r.log = append(r.log, fmt.Sprintf("请求对冲: 渠道「%d」先返回首字节, 取消渠道「%d」\n", attempt.channelId, other.channelId))
+		}
+		other.c.Set(ctxkey.HedgeLost, true)
+		other.cancel()
+	}
+	attempt.c.Set("attemptsLog", strings.Join(r.log, "\n"))
+	return true
+}
+
+// assign records the channel chosen for the attempt, false once another attempt already answered.
+func (r *hedgeRace) assign(attempt *hedgeAttempt, channelId int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.winner != nil {
+		return false
+	}
+	attempt.channelId = channelId
+	return true
+}
+
+func (r *hedgeRace) skip(attempt *hedgeAttempt) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	attempt.skipped = true
+	attempt.bizErr = openai.ErrorWrapper(errors.New("no channel available for hedging"), "hedge_skipped", http.StatusServiceUnavailable)
+}
+
+func (r *hedgeRace) isWinner(attempt *hedgeAttempt) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.winner == attempt
+}
+
+func (r *hedgeRace) addLog(line string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.log = append(r.log, line)
+	return strings.Join(r.log, "\n")
+}
+
+// hedgeWriter keeps the output of an attempt away from the client until the attempt wins the race,
+// the attempt writing first wins.
+type hedgeWriter struct {
+	gin.ResponseWriter
+	race    *hedgeRace
+	attempt *hedgeAttempt
+	header  http.Header
+	status  int
+	won     bool
+}
+
+func (w *hedgeWriter) claim() bool {
+	if w.won {
+		return true
+	}
+	if !w.race.claim(w.attempt) {
+		return false
+	}
+	w.won = true
+	for key, values := range w.header {
+		w.ResponseWriter.Header()[key] = values
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	return true
+}
+
+func (w *hedgeWriter) Header() http.Header {
+	if w.won {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *hedgeWriter) WriteHeader(code int) {
+	if w.won {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *hedgeWriter) WriteHeaderNow() {
+	if w.claim() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *hedgeWriter) Write(data []byte) (int, error) {
+	if !w.claim() {
+		return 0, errHedgeLost
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *hedgeWriter) WriteString(s string) (int, error) {
+	if !w.claim() {
+		return 0, errHedgeLost
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *hedgeWriter) Flush() {
+	if w.won {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *hedgeWriter) Status() int {
+	if w.won {
+		return w.ResponseWriter.Status()
+	}
+	if w.status != 0 {
+		return w.status
+	}
+	return http.StatusOK
+}
+
+func (w *hedgeWriter) Size() int {
+	if w.won {
+		return w.ResponseWriter.Size()
+	}
+	return -1
+}
+
+func (w *hedgeWriter) Written() bool {
+	return w.won && w.ResponseWriter.Written()
+}
+
+// getHedgeDelay returns how long to wait for the first byte before hedging, 0 when the request isn't hedged.
+// The delay of the token takes precedence over the one of the group.
+func getHedgeDelay(c *gin.Context, relayMode int, group string) time.Duration {
+	if relayMode != constant.RelayModeChatCompletions && relayMode != constant.RelayModeCompletions {
+		return 0
+	}
+	if _, ok := c.Get(ctxkey.SpecificChannelId); ok {
+		return 0
+	}
+	delay := c.GetInt("token_hedge_delay")
+	if delay <= 0 {
+		delay = common.GetGroupHedgeDelay(group)
+	}
+	return time.Duration(delay) * time.Millisecond
+}
+
+// relayWithHedge relays to the channel set up in the context, and when it hasn't answered within the hedge delay
+// sends the same request to a second channel. The context ends up with the channel of the attempt that answered,
+// failedChannelIds lists the channels that failed on their own besides it.
+func relayWithHedge(c *gin.Context, relayMode int, group string, originalModel string, attemptsLog *[]string) (bizErr *dbmodel.ErrorWithStatusCode, failedChannelIds []int) {
+	delay := getHedgeDelay(c, relayMode, group)
+	if delay <= 0 {
+		return relay(c, relayMode), nil
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return relay(c, relayMode), nil
+	}
+	ctx := c.Request.Context()
+	race := &hedgeRace{log: append([]string{}, *attemptsLog...)}
+	defer func() {
+		*attemptsLog = race.log
+	}()
+	results := make(chan *hedgeAttempt, 2)
+	primary := race.newAttempt(c, requestBody, c.GetInt("channel_id"))
+	go func() {
+		primary.bizErr = relay(primary.c, relayMode)
+		results <- primary
+	}()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending := 1
+	var failed *hedgeAttempt
+	for {
+		select {
+		case <-timer.C:
+			secondary := race.newAttempt(c, requestBody, 0)
+			if secondary == nil {
+				continue
+			}
+			pending++
+			go relayHedgeAttempt(secondary, race, relayMode, group, originalModel, primary.channelId, delay, results)
+		case attempt := <-results:
+			pending--
+			if attempt.bizErr == nil {
+				// 成功但没有任何输出
+				race.claim(attempt)
+			}
+			switch {
+			case race.isWinner(attempt):
+			case attempt.skipped || attempt.c.GetBool(ctxkey.HedgeLost):
+				if pending > 0 || failed == nil {
+					continue
+				}
+				attempt, failed = failed, nil
+			case pending > 0:
+				// 另一方仍在进行，等它的结果
+				failed = attempt
+				continue
+			}
+			// 返回的尝试由调用方处理，另一个失败的尝试照常计入渠道统计
+			if failed != nil {
+				common.Errorf(ctx, "hedged attempt on channel #%d failed: %s", failed.channelId, failed.bizErr.Message)
+				recordChannelResult(failed.c, originalModel, failed.bizErr, failed.startTime)
+				processChannelRelayError(failed.c, failed.channelId, failed.c.GetString("channel_name"), failed.bizErr)
+				race.addLog(fmt.Sprintf("请求对冲: 渠道「%d」失败, 错误信息: %v\n", failed.channelId, failed.bizErr))
+				failedChannelIds = append(failedChannelIds, failed.channelId)
+			}
+			adoptHedgeAttempt(c, attempt)
+			return attempt.bizErr, failedChannelIds
+		}
+	}
+}
+
+// relayHedgeAttempt selects a second channel for the request and relays to it.
+func relayHedgeAttempt(attempt *hedgeAttempt, race *hedgeRace, relayMode int, group string, originalModel string, primaryChannelId int, delay time.Duration, results chan<- *hedgeAttempt) {
+	defer func() {
+		results <- attempt
+	}()
+	hc := attempt.c
+	channel, err := middleware.SelectChannel(hc, group, originalModel, false, []int{primaryChannelId}, 0)
+	if err != nil {
+		race.skip(attempt)
+		return
+	}
+	defer middleware.ReleaseChannelLimit(hc)
+	if channel.Id == primaryChannelId || !race.assign(attempt, channel.Id) {
+		race.skip(attempt)
+		return
+	}
+	attemptsLog := race.addLog(fmt.Sprintf("请求对冲: 渠道「%d」%dms 内未返回首字节, 同时请求渠道「%d」\n", primaryChannelId, delay.Milliseconds(), channel.Id))
+	middleware.SetupContextForSelectedChannel(hc, channel, originalModel, attemptsLog)
+	attempt.startTime = time.Now()
+	inflightDone := model.StartChannelRequest(channel.Id)
+	attempt.bizErr = relay(hc, relayMode)
+	inflightDone()
+}
+
+// adoptHedgeAttempt takes over the context of the attempt, the rest of the relay sees its channel.
+func adoptHedgeAttempt(c *gin.Context, attempt *hedgeAttempt) {
+	for key, value := range attempt.c.Keys {
+		if key == "channel_limit_lease" || key == ctxkey.HedgeContext || key == ctxkey.HedgeLost {
+			continue
+		}
+		c.Set(key, value)
+	}
+	c.Set(ctxkey.HedgeStartTime, attempt.startTime)
+}
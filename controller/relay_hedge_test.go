@@ -0,0 +1,105 @@
+package controller_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/common/config"
+	"one-api/model"
+	"one-api/router"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// streamUpstream answers chat completions with events carrying name, one event every interval.
+func streamUpstream(name string, events int, interval time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < events; i++ {
+			fmt.Fprintf(w, "data: {\"id\":\"%s\",\"model\":\"gpt-4o-mini\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"%s\"},\"finish_reason\":null}]}\n\n", name, name)
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(interval):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+}
+
+func TestHedgeWithStreamFailover(t *testing.T) {
+	setupRelayTest(t)
+	setGlobal(t, &config.RetryTimes, 0)
+	setGlobal(t, &config.StreamFailoverEnabled, true)
+	setGlobal(t, &config.StreamFailoverBufferEvents, 3)
+	// 第一个模型失败后回退，回退时请求上已有流式接续的状态，对冲的两个尝试同时写入
+	setGlobal(t, &common.ModelFallback, map[string][]string{"gpt-3.5-turbo": {"gpt-4o-mini"}})
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"error":{"message":"upstream failed","type":"server_error"}}`)
+	}))
+	defer failing.Close()
+	primary := streamUpstream("p", 6, 40*time.Millisecond)
+	defer primary.Close()
+	secondary := streamUpstream("s", 6, 40*time.Millisecond)
+	defer secondary.Close()
+
+	user := model.User{Username: "hedge", Password: "12345678", AffCode: "hedge", Group: "default", Status: common.UserStatusEnabled, Role: common.RoleCommonUser, Quota: 100000000}
+	assert.NoError(t, model.DB.Create(&user).Error)
+	key := strings.Repeat("a", 48)
+	assert.NoError(t, model.DB.Create(&model.Token{UserId: user.Id, Key: key, Name: "hedge", Status: common.TokenStatusEnabled, ExpiredTime: -1, UnlimitedQuota: true, HedgeDelay: 20}).Error)
+	high, low := int64(10), int64(0)
+	channels := []model.Channel{
+		{Type: common.ChannelTypeOpenAI, Key: "k0", Name: "failing", BaseURL: &failing.URL, Models: "gpt-3.5-turbo", Group: "default", Status: common.ChannelStatusEnabled},
+		{Type: common.ChannelTypeOpenAI, Key: "k1", Name: "primary", BaseURL: &primary.URL, Models: "gpt-4o-mini", Group: "default", Status: common.ChannelStatusEnabled, Priority: &high},
+		{Type: common.ChannelTypeOpenAI, Key: "k2", Name: "secondary", BaseURL: &secondary.URL, Models: "gpt-4o-mini", Group: "default", Status: common.ChannelStatusEnabled, Priority: &low},
+	}
+	for i := range channels {
+		assert.NoError(t, channels[i].Insert())
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	engine.Use(sessions.Sessions("session", cookie.NewStore([]byte("secret"))))
+	router.SetApiRouter(engine)
+	router.SetRelayRouter(engine)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	request, _ := http.NewRequest(http.MethodPost, server.URL+"/v1/chat/completions",
+		strings.NewReader(`{"model":"gpt-3.5-turbo","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	request.Header.Set("Authorization", "Bearer sk-"+key)
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var contents []string
+	for _, line := range strings.Split(string(body), "\n") {
+		if strings.Contains(line, `"content":"p"`) {
+			contents = append(contents, "p")
+		} else if strings.Contains(line, `"content":"s"`) {
+			contents = append(contents, "s")
+		}
+	}
+	assert.Len(t, contents, 6, string(body))
+	for _, content := range contents {
+		assert.Equal(t, contents[0], content, "the client only gets the output of the attempt that answered")
+	}
+	assert.True(t, strings.HasSuffix(strings.TrimSpace(string(body)), "data: [DONE]"), string(body))
+}
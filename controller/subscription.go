@@ -0,0 +1,323 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"one-api/common"
+	"one-api/common/config"
+	"one-api/model"
+	"strconv"
+	"strings"
+	"time"
+
+	epay "one-api/epay"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SubscriptionRequest struct {
+	UserId        int    `json:"user_id"`
+	PlanId        int    `json:"plan_id"`
+	Months        int    `json:"months"`
+	PaymentMethod string `json:"payment_method"`
+}
+
+func validatePlan(plan *model.Plan) error {
+	if len(plan.Name) == 0 || len(plan.Name) > 30 {
+		return fmt.Errorf("套餐名称长度必须在1-30之间")
+	}
+	if plan.Price < 0 || plan.MonthlyQuota < 0 || plan.RateLimit < 0 {
+		return fmt.Errorf("套餐的价格、额度和频率限制不能为负数")
+	}
+	for _, name := range strings.Split(plan.Models, ",") {
+		if err := common.ValidateModelPattern(name); err != nil {
+			return fmt.Errorf("模型规则 %s 无效：%s", name, err.Error())
+		}
+	}
+	return nil
+}
+
+func GetAllPlans(c *gin.Context) {
+	plans, err := model.GetAllPlans(false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plans,
+	})
+}
+
+func AddPlan(c *gin.Context) {
+	plan := model.Plan{}
+	err := c.ShouldBindJSON(&plan)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := validatePlan(&plan); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	plan.Id = 0
+	if plan.Status == 0 {
+		plan.Status = common.PlanStatusEnabled
+	}
+	err = plan.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plan,
+	})
+}
+
+func UpdatePlan(c *gin.Context) {
+	plan := model.Plan{}
+	err := c.ShouldBindJSON(&plan)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := validatePlan(&plan); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if _, err := model.GetPlanById(plan.Id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	err = plan.Update()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plan,
+	})
+}
+
+func DeletePlan(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.DeletePlanById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func GetAllSubscriptions(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	subscriptions, err := model.GetAllSubscriptions(userId, p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    subscriptions,
+	})
+}
+
+// GrantSubscription 管理员直接为用户开通订阅，不经过支付
+func GrantSubscription(c *gin.Context) {
+	var req SubscriptionRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	subscription, err := model.Subscribe(req.UserId, req.PlanId, req.Months, "")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    subscription,
+	})
+}
+
+func CancelSubscription(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.CancelSubscription(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func GetPlans(c *gin.Context) {
+	plans, err := model.GetAllPlans(true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plans,
+	})
+}
+
+func GetSelfSubscriptions(c *gin.Context) {
+	id := c.GetInt("id")
+	current, err := model.GetActiveSubscription(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	history, err := model.GetAllSubscriptions(id, p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"current": current,
+			"history": history,
+		},
+	})
+}
+
+// RequestSubscriptionEpay 通过易支付购买或续费订阅，支付成功后在 EpayNotify 中开通
+func RequestSubscriptionEpay(c *gin.Context) {
+	var req SubscriptionRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": err.Error()})
+		return
+	}
+	if req.Months < 1 || req.Months > 36 {
+		c.JSON(200, gin.H{"message": "error", "data": "订阅月数必须在1-36之间"})
+		return
+	}
+	plan, err := model.GetPlanById(req.PlanId)
+	if err != nil || plan.Status != common.PlanStatusEnabled {
+		c.JSON(200, gin.H{"message": "error", "data": "套餐不存在或已下架"})
+		return
+	}
+	var payType epay.PurchaseType
+	if req.PaymentMethod == "zfb" {
+		payType = epay.Alipay
+	}
+	if req.PaymentMethod == "wx" {
+		payType = epay.WechatPay
+	}
+	client := GetEpayClient()
+	if client == nil {
+		c.JSON(200, gin.H{"message": "error", "data": "当前管理员未配置支付信息"})
+		return
+	}
+	id := c.GetInt("id")
+	returnUrl, _ := url.Parse(config.ServerAddress + "/log")
+	notifyUrl, _ := url.Parse(config.ServerAddress + "/api/user/epay/notify")
+	tradeNo := "S" + strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	payMoney := plan.Price * float64(req.Months)
+	uri, params, err := client.Purchase(&epay.PurchaseArgs{
+		Type:           payType,
+		ServiceTradeNo: tradeNo,
+		Name:           plan.Name,
+		Money:          strconv.FormatFloat(payMoney, 'f', 2, 64),
+		Device:         epay.PC,
+		NotifyUrl:      notifyUrl,
+		ReturnUrl:      returnUrl,
+	})
+	if err != nil {
+		log.Printf("拉起订阅支付失败: %v", err)
+		c.JSON(200, gin.H{"message": "error", "data": "拉起支付失败"})
+		return
+	}
+	topUp := &model.TopUp{
+		UserId:     id,
+		Amount:     req.Months,
+		Money:      payMoney,
+		TradeNo:    tradeNo,
+		CreateTime: time.Now().Unix(),
+		Status:     "pending",
+		PlanId:     plan.Id,
+	}
+	err = topUp.Insert()
+	if err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": "创建订单失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "success", "data": params, "url": uri})
+}
@@ -9,6 +9,7 @@ import (
 	"one-api/common/network"
 	"one-api/model"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -87,6 +88,39 @@ func GetToken(c *gin.Context) {
 	return
 }
 
+func GetTokenBudget(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	userId := c.GetInt("id")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	token, err := model.GetTokenByIds(id, userId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	usages, err := model.GetTokenBudgetUsage(token)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    usages,
+	})
+}
+
 func GetTokenStatus(c *gin.Context) {
 	tokenId := c.GetInt("token_id")
 	userId := c.GetInt("id")
@@ -173,6 +207,12 @@ func AddToken(c *gin.Context) {
 		FixedContent:   token.FixedContent,
 		ExpiryMode:     token.ExpiryMode,
 		Duration:       token.Duration,
+		CacheEnabled:   token.CacheEnabled,
+		CacheTTL:       token.CacheTTL,
+		HedgeDelay:     token.HedgeDelay,
+		DailyBudget:    token.DailyBudget,
+		WeeklyBudget:   token.WeeklyBudget,
+		MonthlyBudget:  token.MonthlyBudget,
 	}
 	if cleanToken.ExpiryMode == "first_use" {
 		cleanToken.ExpiredTime = -1
@@ -251,6 +291,12 @@ func UpdateToken(c *gin.Context) {
         cleanToken.Subnet = token.Subnet
         cleanToken.ExpiryMode = token.ExpiryMode
         cleanToken.Duration = token.Duration
+        cleanToken.CacheEnabled = token.CacheEnabled
+        cleanToken.CacheTTL = token.CacheTTL
+        cleanToken.HedgeDelay = token.HedgeDelay
+        cleanToken.DailyBudget = token.DailyBudget
+        cleanToken.WeeklyBudget = token.WeeklyBudget
+        cleanToken.MonthlyBudget = token.MonthlyBudget
 
         if cleanToken.ExpiryMode == "first_use" {
             cleanToken.ExpiredTime = -1
@@ -381,6 +427,17 @@ func validateToken(c *gin.Context, token model.Token) error {
 			return fmt.Errorf("有效期不能超过一年")
 		}
 	}
+	if token.HedgeDelay < 0 {
+		return fmt.Errorf("对冲等待时间不能为负数")
+	}
+	if token.DailyBudget < 0 || token.WeeklyBudget < 0 || token.MonthlyBudget < 0 {
+		return fmt.Errorf("令牌预算不能为负数")
+	}
+	for _, name := range strings.Split(token.Models, ",") {
+		if err := common.ValidateModelPattern(name); err != nil {
+			return fmt.Errorf("模型规则 %s 无效：%s", name, err.Error())
+		}
+	}
 	return nil
 }
 
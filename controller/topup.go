@@ -157,8 +157,41 @@ func EpayNotify(c *gin.Context) {
 		log.Println(verifyInfo)
 		topUp := model.GetTopUpByTradeNo(verifyInfo.ServiceTradeNo)
 		if topUp != nil && topUp.Status == "pending" {
+			// 先认领订单，同一订单并发的回调只有一个能继续处理，其余的返回失败等待易支付再次回调
+			claimed, err := model.ClaimTopUp(topUp.TradeNo)
+			if err != nil || !claimed {
+				log.Printf("易支付回调认领订单失败: %v, %v", topUp, err)
+				_, writeErr := c.Writer.Write([]byte("fail"))
+				if writeErr != nil {
+					log.Println("易支付回调写入失败")
+				}
+				return
+			}
+			if topUp.PlanId != 0 {
+				// 订阅套餐的订单，Amount 为订阅月数。开通订阅、发放额度与将订单标记为成功在同一事务中，
+				// 失败时订单退回待处理，易支付会再次回调
+				_, err = model.Subscribe(topUp.UserId, topUp.PlanId, topUp.Amount, topUp.TradeNo)
+				if err != nil {
+					log.Printf("易支付回调开通订阅失败: %v, %v", topUp, err)
+					err = model.ReleaseTopUp(topUp.TradeNo)
+					if err != nil {
+						log.Printf("易支付回调退回订单失败: %v, %v", topUp, err)
+					}
+					_, writeErr := c.Writer.Write([]byte("fail"))
+					if writeErr != nil {
+						log.Println("易支付回调写入失败")
+					}
+					return
+				}
+				model.RecordLog(topUp.UserId, model.LogTypeTopup, 0, fmt.Sprintf("在线购买订阅成功，订阅 %d 个月，支付金额：%.2f", topUp.Amount, topUp.Money))
+				_, writeErr := c.Writer.Write([]byte("success"))
+				if writeErr != nil {
+					log.Println("易支付回调响应成功写入失败")
+				}
+				return
+			}
 			topUp.Status = "success"
-			err := topUp.Update()
+			err = topUp.Update()
 			if err != nil {
 				log.Printf("易支付回调更新订单失败: %v", topUp)
 				return
@@ -166,7 +199,7 @@ func EpayNotify(c *gin.Context) {
 			//user, _ := model.GetUserById(topUp.UserId, false)
 			//user.Quota += topUp.Amount * 500000
 			multipliedQuota := float64(topUp.Amount) * config.QuotaPerUnit
-			err = model.IncreaseUserQuota(topUp.UserId, int(multipliedQuota))
+			err = model.IncreaseUserQuota(topUp.UserId, int(multipliedQuota), model.LedgerRef{Reason: model.LedgerReasonTopup, RefId: topUp.TradeNo})
 			if err != nil {
 				log.Printf("易支付回调更新用户失败: %v", topUp)
 				return
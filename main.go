@@ -36,6 +36,7 @@ var adminIndexPage []byte
 var userIndexPage []byte
 
 func main() {
+	common.Init()
 	common.SetupLogger()
 	if err := godotenv.Load(); err != nil {
 		log.Println("Warning: .env file not found or error loading")
@@ -104,6 +105,15 @@ func main() {
 	}
 	go controller.AutomaticallyTestDisabledChannels(60)
 	go controller.UpdateMidjourneyTask()
+	if common.IsMasterNode {
+		go controller.StartBatchWorkers()
+		go controller.ResumeInterruptedRuns()
+		go controller.AutomaticallyExpireRuns()
+		go controller.SyncFineTuningJobs(common.FineTuningPollInterval)
+		go controller.AutomaticallySyncChannelModels()
+		go controller.AutomaticallyReconcileQuotaLedger()
+		go model.UpdateSubscriptions()
+	}
 	//go controller.UpdateMidjourneyTaskBulk()
 	if os.Getenv("BATCH_UPDATE_ENABLED") == "true" {
 		config.BatchUpdateEnabled = true
@@ -7,13 +7,16 @@ import (
 	"io/ioutil"
 	"net/http"
 	"one-api/common"
+	"one-api/common/ctxkey"
 	"one-api/common/network"
 	"one-api/model"
+	"one-api/relay/constant"
 	relaymodel "one-api/relay/model"
 	"strings"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
 func authHelper(c *gin.Context, minRole int) {
@@ -106,6 +109,8 @@ func getModelForPath(path string) string {
 		"/v1/audio/speech":         "tts-1",
 		"/v1/audio/transcriptions": "whisper-1",
 		"/v1/audio/translations":   "whisper-1",
+		"/v1/files":                "files",
+		"/v1/fine_tuning":          "fine-tuning",
 	}
 
 	if strings.HasPrefix(path, "/mj-turbo/mj") {
@@ -126,6 +131,29 @@ func getModelForPath(path string) string {
 	return ""
 }
 
+// CheckPlanLimits applies the model and rate limits of the plan the user is subscribed to, and keeps the
+// models of the plan in the context for the fallback chain. It returns the status code to answer with
+// when the request is refused.
+func CheckPlanLimits(c *gin.Context, userId int, modelName string) (int, error) {
+	plan, err := model.CacheGetActivePlan(userId)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if plan == nil {
+		return http.StatusOK, nil
+	}
+	if plan.Models != "" {
+		c.Set(ctxkey.PlanModels, plan.Models)
+		if modelName != "" && !isModelInList(modelName, plan.Models) {
+			return http.StatusForbidden, fmt.Errorf("当前订阅的套餐无权使用模型：%s", modelName)
+		}
+	}
+	if plan.RateLimit > 0 && userRateLimited(userId, plan.RateLimit, 60, "PL") {
+		return http.StatusTooManyRequests, fmt.Errorf("当前订阅的套餐每分钟最多请求 %d 次", plan.RateLimit)
+	}
+	return http.StatusOK, nil
+}
+
 func TokenAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
 		var err error
@@ -139,6 +167,24 @@ func TokenAuth() func(c *gin.Context) {
 		} else if key == "midjourney-proxy" {
 			key, parts = processAuthHeader(c.Request.Header.Get("mj-api-secret"))
 		}
+		// Gemini SDK 通过 x-goog-api-key 请求头或 key 查询参数传递密钥
+		isGeminiRequest := strings.HasPrefix(c.Request.URL.Path, "/v1beta/models/")
+		if key == "" && isGeminiRequest {
+			geminiKey := c.Request.Header.Get("x-goog-api-key")
+			if geminiKey == "" {
+				geminiKey = c.Query("key")
+			}
+			key, parts = processAuthHeader(geminiKey)
+		}
+		// 浏览器无法为 WebSocket 设置请求头，Realtime 客户端通过子协议 openai-insecure-api-key.<key> 传递密钥
+		isRealtimeRequest := strings.HasPrefix(c.Request.URL.Path, "/v1/realtime")
+		if key == "" && isRealtimeRequest {
+			for _, protocol := range websocket.Subprotocols(c.Request) {
+				if strings.HasPrefix(protocol, "openai-insecure-api-key.") {
+					key, parts = processAuthHeader(strings.TrimPrefix(protocol, "openai-insecure-api-key."))
+				}
+			}
+		}
 		c.Set("claude_original_request", false)
 		if c.Request.URL.Path == "/v1/messages" {
 			c.Set("claude_original_request", true)
@@ -151,6 +197,16 @@ func TokenAuth() func(c *gin.Context) {
 				return
 			}
 		}
+		if isGeminiRequest {
+			modelRequest.Model, _ = constant.GeminiModelAction(c.Request.URL.Path)
+		}
+		if isRealtimeRequest {
+			modelRequest.Model = c.Query("model")
+			modelRequest.Stream = true
+		}
+		if isGeminiRequest && strings.HasSuffix(c.Request.URL.Path, ":streamGenerateContent") {
+			modelRequest.Stream = true
+		}
 		if strings.HasSuffix(c.Request.URL.Path, "embeddings") {
 			if modelRequest.Model == "" {
 				modelRequest.Model = c.Param("model")
@@ -174,9 +230,21 @@ func TokenAuth() func(c *gin.Context) {
 			abortWithMessage(c, http.StatusForbidden, "用户已被封禁")
 			return
 		}
+		if statusCode, err := CheckPlanLimits(c, token.UserId, modelRequest.Model); err != nil {
+			abortWithMessage(c, statusCode, err.Error())
+			return
+		}
+		if strings.HasPrefix(modelRequest.Model, "ft:") {
+			err = model.CheckFineTunedModelOwner(modelRequest.Model, token.UserId)
+			if err != nil {
+				abortWithMessage(c, http.StatusForbidden, err.Error())
+				return
+			}
+		}
 		c.Set("relayIp", c.ClientIP())
+		c.Set("is_stream", modelRequest.Stream)
 		c.Set("is_tools", false)
-		if strings.HasPrefix(c.Request.URL.Path, "/v1/chat/completions") || strings.HasPrefix(c.Request.URL.Path, "/v1/completions") {
+		if strings.HasPrefix(c.Request.URL.Path, "/v1/chat/completions") || strings.HasPrefix(c.Request.URL.Path, "/v1/completions") || strings.HasPrefix(c.Request.URL.Path, "/v1/responses") || isGeminiRequest {
 			var reqBody relaymodel.GeneralOpenAIRequest
 			body, err := ioutil.ReadAll(c.Request.Body)
 			if err != nil {
@@ -212,6 +280,10 @@ func TokenAuth() func(c *gin.Context) {
 		c.Set("token_id", token.Id)
 		c.Set("token_name", token.Name)
 		c.Set("billing_enabled", token.BillingEnabled)
+		c.Set("token_cache_enabled", token.CacheEnabled)
+		c.Set("token_cache_ttl", token.CacheTTL)
+		c.Set("token_hedge_delay", token.HedgeDelay)
+		c.Set("token_budget_enabled", token.HasBudget())
 		if token.Group == "" {
 			userGroup, err := model.GetUserGroup(token.UserId)
 			if err != nil {
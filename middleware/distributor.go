@@ -1,19 +1,25 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"one-api/common"
+	"one-api/common/config"
 	"one-api/common/ctxkey"
 	"one-api/model"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type ModelRequest struct {
-	Model string `json:"model" form:"model"`
+	Model  string `json:"model" form:"model"`
+	Stream bool   `json:"stream" form:"stream"`
 }
 
 func Distribute() func(c *gin.Context) {
@@ -24,6 +30,7 @@ func Distribute() func(c *gin.Context) {
 
 		var channel *model.Channel
 		var err error
+		servedModel := modelName.(string)
 
 		if ok {
 			channel, err = getChannelById(channelId.(string), tokenGroup.(string), modelName.(string))
@@ -33,14 +40,58 @@ func Distribute() func(c *gin.Context) {
 			}
 		} else {
 			channel, err = selectChannelForUser(c, tokenGroup.(string), modelName.(string))
+			if err != nil && !errors.Is(err, ErrChannelsSaturated) {
+				// 请求的模型没有可用渠道时按回退链换用其他模型
+				if fallbackChannel, fallbackModel, ok := selectFallbackChannel(c, tokenGroup.(string), modelName.(string)); ok {
+					channel, servedModel, err = fallbackChannel, fallbackModel, nil
+				}
+			}
 			if err != nil {
-				abortWithMessage(c, http.StatusServiceUnavailable, err.Error())
+				status := http.StatusServiceUnavailable
+				if errors.Is(err, ErrChannelsSaturated) {
+					status = http.StatusTooManyRequests
+				}
+				abortWithMessage(c, status, err.Error())
 				return
 			}
 		}
-		SetupContextForSelectedChannel(c, channel, modelName.(string), "")
+		SetupContextForSelectedChannel(c, channel, servedModel, "")
 		c.Next()
+		ReleaseChannelLimit(c)
+	}
+}
+
+// SetupChannelForModel selects a channel for modelName the same way Distribute does,
+// for handlers that only need an upstream channel on some of their code paths. The caller releases the
+// channel limits taken here with ReleaseChannelLimit once the request is done.
+func SetupChannelForModel(c *gin.Context, modelName string) error {
+	tokenGroup := c.GetString("group")
+	var channel *model.Channel
+	var err error
+	if channelId, ok := c.Get("channelId"); ok {
+		channel, err = getChannelById(channelId.(string), tokenGroup, modelName)
+	} else {
+		channel, err = selectChannelForUser(c, tokenGroup, modelName)
 	}
+	if err != nil {
+		return err
+	}
+	SetupContextForSelectedChannel(c, channel, modelName, "")
+	return nil
+}
+
+// SetupContextForBoundChannel routes the request to a channel recorded earlier,
+// e.g. the channel an uploaded file or a fine-tuning job lives on.
+func SetupContextForBoundChannel(c *gin.Context, channelId int, modelName string) error {
+	channel, err := model.GetChannelById(channelId, true)
+	if err != nil {
+		return fmt.Errorf("渠道 #%d 已不存在", channelId)
+	}
+	if channel.Status != common.ChannelStatusEnabled {
+		return fmt.Errorf("渠道 #%d 已被禁用", channelId)
+	}
+	SetupContextForSelectedChannel(c, channel, modelName, "")
+	return nil
 }
 
 func getChannelById(channelId string, tokenGroup string, modelName string) (*model.Channel, error) {
@@ -73,15 +124,12 @@ func getChannelById(channelId string, tokenGroup string, modelName string) (*mod
 }
 
 func selectChannelForUser(c *gin.Context, tokenGroup string, modelName string) (*model.Channel, error) {
-	value, _ := c.Get("is_tools")
-	isTools, ok := value.(bool)
-	if !ok {
-		return nil, fmt.Errorf("is_tools value is not of type bool")
-	}
-	claudeoriginalrequest := c.GetBool("claude_original_request")
 	failedChannelIds := []int{}
-	channel, err := model.CacheGetRandomSatisfiedChannel(tokenGroup, modelName, false, isTools, claudeoriginalrequest, failedChannelIds, 0)
+	channel, err := SelectChannel(c, tokenGroup, modelName, false, failedChannelIds, 0)
 	if err != nil {
+		if errors.Is(err, ErrChannelsSaturated) {
+			return nil, err
+		}
 		message := fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道", tokenGroup, modelName)
 		if channel != nil {
 			common.SysError(fmt.Sprintf("渠道不存在：%d", channel.Id))
@@ -93,6 +141,116 @@ func selectChannelForUser(c *gin.Context, tokenGroup string, modelName string) (
 	return channel, nil
 }
 
+var ErrChannelsSaturated = errors.New("当前分组上游负载已饱和，请稍后再试")
+
+// 正在排队等待渠道的请求数
+var channelQueueWaiting atomic.Int64
+
+const channelQueuePollInterval = 200 * time.Millisecond
+
+// SelectChannel picks a channel like model.CacheGetRandomSatisfiedChannel, skipping the channels whose
+// RPM/TPM/concurrency limits are reached. When every channel is saturated the request waits in a bounded
+// queue for ChannelQueueTimeout seconds before giving up with ErrChannelsSaturated.
+func SelectChannel(c *gin.Context, group string, modelName string, ignoreFirstPriority bool, excludedChannelIds []int, i int) (*model.Channel, error) {
+	value, _ := c.Get("is_tools")
+	isTools, ok := value.(bool)
+	if !ok {
+		return nil, fmt.Errorf("is_tools value is not of type bool")
+	}
+	claudeoriginalrequest := c.GetBool("claude_original_request")
+	stream := c.GetBool("is_stream")
+	// 重试时先归还上一个渠道占用的并发数
+	ReleaseChannelLimit(c)
+	// 会话绑定在选好密钥后由 SetupContextForSelectedChannel 保存
+	c.Set(ctxkey.StickyRoutingKey, "")
+	c.Set(ctxkey.StickyKeyId, 0)
+	stickyKey := getStickyRoutingKey(c, group, modelName)
+	if stickyKey != "" {
+		if channel, ok := selectStickyChannel(c, stickyKey, group, modelName, excludedChannelIds, stream); ok {
+			c.Set(ctxkey.StickyRoutingKey, stickyKey)
+			return channel, nil
+		}
+	}
+
+	var deadline time.Time
+	queued := false
+	defer func() {
+		if queued {
+			channelQueueWaiting.Add(-1)
+		}
+	}()
+	for {
+		excluded := append([]int{}, excludedChannelIds...)
+		saturated := make(map[int]bool)
+		for {
+			channel, err := model.CacheGetRandomSatisfiedChannel(group, modelName, ignoreFirstPriority, isTools, claudeoriginalrequest, excluded, i)
+			if err != nil || saturated[channel.Id] {
+				if len(saturated) == 0 {
+					return channel, err
+				}
+				break
+			}
+			lease, ok := model.AcquireChannelLimit(channel, modelName, stream, estimateRequestTokens(c))
+			if ok && !model.AcquireChannelBreaker(channel.Id, modelName) {
+				// 熔断探测已被其他请求占用
+				lease.Release()
+				excluded = append(excluded, channel.Id)
+				continue
+			}
+			if ok {
+				c.Set("channel_limit_lease", lease)
+				c.Set(ctxkey.StickyRoutingKey, stickyKey)
+				return channel, nil
+			}
+			saturated[channel.Id] = true
+			excluded = append(excluded, channel.Id)
+		}
+
+		// 所有可用渠道都已达到限制，排队等待
+		if !queued {
+			if config.ChannelQueueTimeout <= 0 {
+				return nil, ErrChannelsSaturated
+			}
+			if channelQueueWaiting.Add(1) > int64(config.ChannelQueueSize) {
+				channelQueueWaiting.Add(-1)
+				return nil, ErrChannelsSaturated
+			}
+			queued = true
+			deadline = time.Now().Add(time.Duration(config.ChannelQueueTimeout) * time.Second)
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrChannelsSaturated
+		}
+		select {
+		case <-c.Request.Context().Done():
+			return nil, ErrChannelsSaturated
+		case <-time.After(channelQueuePollInterval):
+		}
+	}
+}
+
+// estimateRequestTokens roughly estimates the tokens of the request from the size of its body, it's
+// reserved against the TPM limits of the channel until the actual usage is recorded.
+func estimateRequestTokens(c *gin.Context) int {
+	if body, ok := c.Get(common.KeyRequestBody); ok {
+		if body, ok := body.([]byte); ok {
+			return len(body) / 4
+		}
+	}
+	return int(c.Request.ContentLength / 4)
+}
+
+// ReleaseChannelLimit gives back the concurrency and the unused token reservation taken when the current
+// channel was selected.
+func ReleaseChannelLimit(c *gin.Context) {
+	if value, ok := c.Get("channel_limit_lease"); ok {
+		if lease, ok := value.(*model.ChannelLimitLease); ok {
+			lease.Release()
+		}
+		c.Set("channel_limit_lease", nil)
+	}
+}
+
 func isGroupMatched(channelGroups string, tokenGroup string) bool {
 	groups := strings.Split(channelGroups, ",")
 	for _, group := range groups {
@@ -104,19 +262,14 @@ func isGroupMatched(channelGroups string, tokenGroup string) bool {
 }
 
 func isModelSupported(channelModels string, modelName string) bool {
-	models := strings.Split(channelModels, ",")
-	for _, m := range models {
-		if m == modelName {
-			return true
-		}
-	}
-	return false
+	return common.MatchModelAny(strings.Split(channelModels, ","), modelName)
 }
 
 func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, modelName string, attemptsLog string) {
 	c.Set("channel", channel.Type)
 	c.Set("channel_id", channel.Id)
 	c.Set("channel_name", channel.Name)
+	c.Set(ctxkey.ClaudeNative, channel.ClaudeOriginalRequest != nil && *channel.ClaudeOriginalRequest)
 	if channel.ProxyURL != nil {
 		c.Set("proxy_url", *channel.ProxyURL)
 	}
@@ -132,7 +285,19 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	c.Set("model_mapping", channel.GetModelMapping())
 	c.Set("status_code_mapping", channel.GetStatusCodeMapping())
 	c.Set("original_model", modelName)
-	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
+	// 粘性会话沿用上次的密钥
+	key, keyId := model.SelectPreferredChannelKey(channel, c.GetInt(ctxkey.StickyKeyId))
+	c.Set(ctxkey.StickyKeyId, 0)
+	c.Set("channel_key_id", keyId)
+	if stickyKey := c.GetString(ctxkey.StickyRoutingKey); stickyKey != "" {
+		model.SetStickyChannel(stickyKey, channel.Id, keyId)
+		c.Set(ctxkey.StickyRoutingKey, "")
+	}
+	if keyId != 0 {
+		ctx := context.WithValue(c.Request.Context(), common.ChannelKeyIdKey, model.ChannelKeyRef{ChannelId: channel.Id, KeyId: keyId})
+		c.Request = c.Request.WithContext(ctx)
+	}
+	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", key))
 	c.Set("base_url", channel.GetBaseURL())
 	cfg, _ := channel.LoadConfig()
 	// 兼容旧版本
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"one-api/common"
+	"one-api/common/ctxkey"
+	"one-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetFallbackModels returns the models of the fallback chain of the requested model that come after
+// currentModel, leaving out those the token or the subscribed plan may not use.
+func GetFallbackModels(c *gin.Context, currentModel string) []string {
+	requestedModel := c.GetString("model")
+	chain := common.GetModelFallbacks(c.GetString("group"), requestedModel)
+	start := 0
+	for i, fallbackModel := range chain {
+		if fallbackModel == currentModel {
+			start = i + 1
+		}
+	}
+	availableModels := c.GetString("available_models")
+	planModels := c.GetString(ctxkey.PlanModels)
+	var models []string
+	for _, fallbackModel := range chain[start:] {
+		if fallbackModel == requestedModel || fallbackModel == currentModel {
+			continue
+		}
+		if availableModels != "" && !isModelInList(fallbackModel, availableModels) {
+			continue
+		}
+		if planModels != "" && !isModelInList(fallbackModel, planModels) {
+			continue
+		}
+		models = append(models, fallbackModel)
+	}
+	return models
+}
+
+// SetRequestModel rewrites the model of the buffered JSON request body, the model requested first is kept
+// in the request context for the consume log. Requests without a model in the JSON body can't fall back.
+func SetRequestModel(c *gin.Context, modelName string) error {
+	value, ok := c.Get(common.KeyRequestBody)
+	if !ok {
+		return errors.New("request body is not buffered")
+	}
+	body, _ := value.([]byte)
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(body, &fields)
+	if err != nil {
+		return err
+	}
+	if _, ok := fields["model"]; !ok {
+		return errors.New("request body has no model")
+	}
+	fields["model"], _ = json.Marshal(modelName)
+	body, err = json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	c.Set(common.KeyRequestBody, body)
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+	c.Request.ContentLength = int64(len(body))
+	if _, ok := c.Request.Context().Value(common.RequestedModelKey).(string); !ok {
+		ctx := context.WithValue(c.Request.Context(), common.RequestedModelKey, c.GetString("model"))
+		c.Request = c.Request.WithContext(ctx)
+	}
+	return nil
+}
+
+// selectFallbackChannel picks a channel of the first fallback model that has one, for requests
+// whose model has no channel at all.
+func selectFallbackChannel(c *gin.Context, group string, modelName string) (*model.Channel, string, bool) {
+	for _, fallbackModel := range GetFallbackModels(c, modelName) {
+		channel, err := SelectChannel(c, group, fallbackModel, false, []int{}, 0)
+		if err != nil {
+			continue
+		}
+		if SetRequestModel(c, fallbackModel) != nil {
+			ReleaseChannelLimit(c)
+			return nil, "", false
+		}
+		return channel, fallbackModel, true
+	}
+	return nil, "", false
+}
@@ -101,3 +101,23 @@ func DownloadRateLimit() func(c *gin.Context) {
 func UploadRateLimit() func(c *gin.Context) {
 	return rateLimitFactory(common.UploadRateLimitNum, common.UploadRateLimitDuration, "UP")
 }
+
+// userRateLimited counts a request of the user and reports whether the user went over maxRequestNum requests
+// in duration seconds. Unlike the limiters above it is keyed by user rather than by ip.
+func userRateLimited(userId int, maxRequestNum int, duration int64, mark string) bool {
+	if common.RedisEnabled {
+		ctx := context.Background()
+		key := fmt.Sprintf("rateLimit:%s%d:%d", mark, userId, time.Now().Unix()/duration)
+		count, err := common.RDB.Incr(ctx, key).Result()
+		if err != nil {
+			common.SysError("failed to count user requests: " + err.Error())
+			return false
+		}
+		if count == 1 {
+			common.RDB.Expire(ctx, key, time.Duration(duration)*time.Second)
+		}
+		return count > int64(maxRequestNum)
+	}
+	inMemoryRateLimiter.Init(common.RateLimitKeyExpirationDuration)
+	return !inMemoryRateLimiter.Request(fmt.Sprintf("%s%d", mark, userId), maxRequestNum, duration)
+}
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"one-api/common/config"
+	"one-api/common/ctxkey"
+	"one-api/model"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+const StickySessionHeader = "X-Session-Id"
+
+// stickyPrompt holds the request fields a conversation keeps across turns, for the chat completions,
+// Claude messages, responses and Gemini formats.
+type stickyPrompt struct {
+	System            json.RawMessage   `json:"system"`
+	Instructions      json.RawMessage   `json:"instructions"`
+	SystemInstruction json.RawMessage   `json:"systemInstruction"`
+	Messages          []json.RawMessage `json:"messages"`
+	Contents          []json.RawMessage `json:"contents"`
+	Input             json.RawMessage   `json:"input"`
+}
+
+// getStickyRoutingKey returns the key the conversation is bound to a channel under, empty when the
+// request can't be told apart from others. An explicit X-Session-Id wins over the prompt hash.
+func getStickyRoutingKey(c *gin.Context, group string, modelName string) string {
+	if !config.StickyRoutingEnabled {
+		return ""
+	}
+	hash := sha256.New()
+	hash.Write([]byte(fmt.Sprintf("%d:", c.GetInt("token_id"))))
+	if sessionId := c.GetHeader(StickySessionHeader); sessionId != "" {
+		hash.Write([]byte("session:" + sessionId))
+	} else if !writeStickyPrompt(c, hash.Write) {
+		return ""
+	}
+	return fmt.Sprintf("sticky_channel:%s:%s:%s", group, modelName, hex.EncodeToString(hash.Sum(nil)))
+}
+
+// writeStickyPrompt writes the system prompt and the leading messages of the request,
+// only bodies buffered already are looked at, multipart uploads are left alone.
+func writeStickyPrompt(c *gin.Context, write func([]byte) (int, error)) bool {
+	value, ok := c.Get(common.KeyRequestBody)
+	if !ok {
+		return false
+	}
+	body, ok := value.([]byte)
+	if !ok {
+		return false
+	}
+	var prompt stickyPrompt
+	if json.Unmarshal(body, &prompt) != nil {
+		return false
+	}
+	messages := prompt.Messages
+	if len(messages) == 0 {
+		messages = prompt.Contents
+	}
+	if len(messages) == 0 && len(prompt.Input) > 0 && prompt.Input[0] == '[' {
+		_ = json.Unmarshal(prompt.Input, &messages)
+	}
+	leading := config.StickyRoutingMessages
+	if leading <= 0 {
+		leading = 1
+	}
+	written := false
+	for _, part := range []json.RawMessage{prompt.System, prompt.Instructions, prompt.SystemInstruction} {
+		if len(part) > 0 {
+			_, _ = write(part)
+			written = true
+		}
+	}
+	for _, message := range messages {
+		// 系统消息不计入开头的消息数
+		var role struct {
+			Role string `json:"role"`
+		}
+		_ = json.Unmarshal(message, &role)
+		if role.Role != "system" && role.Role != "developer" {
+			if leading == 0 {
+				break
+			}
+			leading--
+		}
+		_, _ = write(message)
+		written = true
+	}
+	return written
+}
+
+// selectStickyChannel returns the channel the conversation was routed to before while it is still usable.
+func selectStickyChannel(c *gin.Context, key string, group string, modelName string, excludedChannelIds []int, stream bool) (*model.Channel, bool) {
+	channelId, keyId, ok := model.GetStickyChannel(key)
+	if !ok || slices.Contains(excludedChannelIds, channelId) {
+		return nil, false
+	}
+	channel, err := model.CacheGetChannel(channelId)
+	if err != nil || channel.Status != common.ChannelStatusEnabled {
+		return nil, false
+	}
+	if !isGroupMatched(channel.Group, group) || !isModelSupported(channel.Models, modelName) {
+		return nil, false
+	}
+	if c.GetBool("is_tools") && channel.IsTools != nil && !*channel.IsTools {
+		return nil, false
+	}
+	if !model.ChannelBreakerAvailable(channel.Id, modelName) {
+		return nil, false
+	}
+	lease, ok := model.AcquireChannelLimit(channel, modelName, stream, estimateRequestTokens(c))
+	if !ok {
+		return nil, false
+	}
+	if !model.AcquireChannelBreaker(channel.Id, modelName) {
+		lease.Release()
+		return nil, false
+	}
+	c.Set("channel_limit_lease", lease)
+	c.Set(ctxkey.StickyKeyId, keyId)
+	return channel, true
+}
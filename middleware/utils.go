@@ -20,11 +20,5 @@ func abortWithMessage(c *gin.Context, statusCode int, message string) {
 	common.LogError(c.Request.Context(), message)
 }
 func isModelInList(modelName string, models string) bool {
-	modelList := strings.Split(models, ",")
-	for _, model := range modelList {
-		if modelName == model {
-			return true
-		}
-	}
-	return false
+	return common.MatchModelAny(strings.Split(models, ","), modelName)
 }
@@ -9,7 +9,6 @@ import (
 	"one-api/common/config"
 	"sort"
 	"strings"
-	"sync"
 	"time"
 
 	"gorm.io/gorm"
@@ -27,11 +26,13 @@ type Ability struct {
 }
 
 type ModelBillingInfo struct {
-	Model                string  `json:"model"`
-	ModelType            string  `json:"model_type"`
-	ModelRatio           float64 `json:"model_ratio"` // ModelRatio中的值
-	ModeCompletionlRatio float64 `json:"model_completion_ratio"`
-	ModelPrice           float64 `json:"model_ratio_2"` // ModelPrice中的值（如果有的话）
+	Model                string              `json:"model"`
+	ModelType            string              `json:"model_type"`
+	ModelRatio           float64             `json:"model_ratio"` // ModelRatio中的值
+	ModeCompletionlRatio float64             `json:"model_completion_ratio"`
+	ModelPrice           float64             `json:"model_ratio_2"`          // ModelPrice中的值（如果有的话）
+	PricingRule          *common.PricingRule `json:"pricing_rule,omitempty"` // 分组适用的计价规则
+	CurrentMultiplier    float64             `json:"current_multiplier"`     // 当前时段的倍率，已计入 ModelRatio
 }
 
 type ModelRatios map[string]float64
@@ -143,11 +144,17 @@ func GetGroupModelsBilling(group string, search string) ([]ModelBillingInfo, err
 		var modelInfo ModelBillingInfo
 
 		if model != "midjourney" {
+			// 按当前时段计价，提示词长度分档见 PricingRule
+			pricing := common.GetPricing(model, group, 0, time.Now())
+			modelInfo.CurrentMultiplier = pricing.PromptMultiplier
+			if rule, ok := common.GetPricingRule(model, group); ok {
+				modelInfo.PricingRule = &rule
+			}
 			if ratio, exists := modelRatio[model]; exists {
-				modelInfo.ModelRatio = ratio * groupRatioValue
+				modelInfo.ModelRatio = ratio * groupRatioValue * pricing.PromptMultiplier
 				modelInfo.ModeCompletionlRatio = modelInfo.ModelRatio * modelCompletionRatio
 			} else {
-				modelInfo.ModelRatio = 15 * groupRatioValue
+				modelInfo.ModelRatio = 15 * groupRatioValue * pricing.PromptMultiplier
 				modelInfo.ModeCompletionlRatio = modelInfo.ModelRatio * modelCompletionRatio
 			}
 		}
@@ -168,73 +175,11 @@ func GetGroupModelsBilling(group string, search string) ([]ModelBillingInfo, err
 	return modelsBillingInfos, nil
 }
 
-var channelRateLimitStatus sync.Map // 存储每个 Channel 的频率限制状态
-var rateLimitMutex sync.Mutex
-
-type ChannelRateLimit struct {
-	Count     int64     // 使用次数
-	ResetTime time.Time // 上次重置时间
-}
-
 type ChannelModelKey struct {
 	ChannelID int
 	Model     string
 }
 
-func checkRateLimit(channelId int, model string) (*ChannelRateLimit, bool) {
-	now := time.Now()
-	key := ChannelModelKey{ChannelID: channelId, Model: model}
-
-	rateLimitMutex.Lock()
-	defer rateLimitMutex.Unlock()
-
-	val, exists := channelRateLimitStatus.Load(key)
-	if !exists { // 如果没有找到记录，则创建一个新的
-		val = &ChannelRateLimit{
-			Count:     1,                    // 初始化为1，表示这次请求被计数
-			ResetTime: now.Add(time.Minute), // 设置下一个重置时间
-		}
-		channelRateLimitStatus.Store(key, val)
-		return val.(*ChannelRateLimit), true
-	}
-	rl := val.(*ChannelRateLimit)
-	if now.After(rl.ResetTime) {
-		rl.Count = 1
-		rl.ResetTime = now.Add(time.Minute)
-		return rl, true
-	} else if rl.Count < 5 {
-		rl.Count++
-		return rl, true
-	}
-
-	return rl, false
-}
-
-func updateRateLimitStatus(channelId int, model string) {
-	now := time.Now()
-	key := ChannelModelKey{ChannelID: channelId, Model: model}
-
-	rateLimitMutex.Lock()
-	defer rateLimitMutex.Unlock()
-
-	val, _ := channelRateLimitStatus.Load(key)
-	if val == nil {
-		// 如果没有记录，则不执行任何操作
-		return
-	}
-
-	rl := val.(*ChannelRateLimit)
-	if now.After(rl.ResetTime) {
-		// 重置频率限制状态
-		rl.Count = 1
-		rl.ResetTime = now.Add(time.Minute) // 设置ResetTime为下一分钟，而非当前时间
-	} else {
-		rl.Count++
-	}
-
-	channelRateLimitStatus.Store(key, rl)
-}
-
 func GetRandomSatisfiedChannel(group string, model string, excluded map[int]struct{}, ignoreFirstPriority bool, isTools bool, claudeoriginalrequest bool, i int) (*Channel, error) {
 	// 当i等于1时，强制使用下一个优先级
 	if i == 1 {
@@ -245,6 +190,28 @@ func GetRandomSatisfiedChannel(group string, model string, excluded map[int]stru
 	if err != nil {
 		return nil, err
 	}
+	// 没有支持 Claude 原生请求的渠道时，由其他渠道转换格式后提供服务
+	if len(abilities) == 0 && claudeoriginalrequest {
+		abilities, err = getAbilitiesByPriority(group, model, ignoreFirstPriority, isTools, false, excluded)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if strategy := common.GetRoutingStrategy(group, model); strategy != common.RoutingStrategyWeighted && len(abilities) > 0 {
+		var channels []*Channel
+		for _, ability := range abilities {
+			channel, err := CacheGetChannel(ability.ChannelId)
+			if err != nil || channel.Status != common.ChannelStatusEnabled {
+				continue
+			}
+			channels = append(channels, channel)
+		}
+		if channel, ok := selectChannelByStrategy(strategy, group, model, channels); ok {
+			return channel, nil
+		}
+		return getChannelFromNextPriority(group, model)
+	}
 
 	channel := Channel{}
 	for len(abilities) > 0 {
@@ -272,7 +239,7 @@ func GetRandomSatisfiedChannel(group string, model string, excluded map[int]stru
 			continue
 		}
 
-		if isRateLimited(channel, selectedAbility.ChannelId, model) {
+		if !ChannelBreakerAvailable(selectedAbility.ChannelId, model) {
 			abilities = removeAbility(abilities, selectedIdx)
 			continue
 		}
@@ -293,9 +260,10 @@ func getAbilitiesByPriority(group string, model string, ignoreFirstPriority bool
 		trueVal = "true"
 	}
 
-	channelQuery := DB.Where("abilities."+groupCol+" = ? and abilities.model = ? and abilities.enabled = "+trueVal, group, model)
+	models := getAbilityModels(group, model)
+	channelQuery := DB.Where("abilities."+groupCol+" = ? and abilities.model IN (?) and abilities.enabled = "+trueVal, group, models)
 	if !ignoreFirstPriority {
-		maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(abilities.priority)").Where("abilities."+groupCol+" = ? and abilities.model = ? and abilities.enabled = "+trueVal, group, model)
+		maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(abilities.priority)").Where("abilities."+groupCol+" = ? and abilities.model IN (?) and abilities.enabled = "+trueVal, group, models)
 		channelQuery = channelQuery.Where("abilities.priority = (?)", maxPrioritySubQuery)
 	}
 	conditions := []string{}
@@ -327,7 +295,41 @@ func getAbilitiesByPriority(group string, model string, ignoreFirstPriority bool
 	if err != nil {
 		return nil, err
 	}
-	return abilities, nil
+	return uniqueAbilities(abilities), nil
+}
+
+// getAbilityModels returns the model and the patterns of the abilities of the group matching it.
+func getAbilityModels(group string, model string) []string {
+	groupCol := "`group`"
+	if common.UsingPostgreSQL {
+		groupCol = `"group"`
+	}
+	var patterns []string
+	err := DB.Model(&Ability{}).Distinct("model").Where(groupCol+" = ? and (model LIKE ? or model LIKE ?)", group, "%*%", "regex:%").Pluck("model", &patterns).Error
+	if err != nil {
+		common.SysError("failed to load model patterns: " + err.Error())
+	}
+	models := []string{model}
+	for _, pattern := range patterns {
+		if pattern != model && common.MatchModel(pattern, model) {
+			models = append(models, pattern)
+		}
+	}
+	return models
+}
+
+// uniqueAbilities keeps one ability per channel, a channel can serve a model both by name and by pattern.
+func uniqueAbilities(abilities []Ability) []Ability {
+	seen := make(map[int]bool, len(abilities))
+	unique := abilities[:0]
+	for _, ability := range abilities {
+		if seen[ability.ChannelId] {
+			continue
+		}
+		seen[ability.ChannelId] = true
+		unique = append(unique, ability)
+	}
+	return unique
 }
 
 
@@ -356,16 +358,6 @@ func removeAbility(abilities []Ability, index int) []Ability {
 	return append(abilities[:index], abilities[index+1:]...)
 }
 
-func isRateLimited(channel Channel, channelId int, model string) bool {
-	if channel.RateLimited != nil && *channel.RateLimited {
-		if _, ok := checkRateLimit(channelId, model); !ok {
-			return true
-		}
-		updateRateLimitStatus(channelId, model)
-	}
-	return false
-}
-
 func getChannelFromNextPriority(group string, model string) (*Channel, error) {
 	nextPriorityAbilities, err := getNextPriorityAbilities(group, model)
 	if err != nil {
@@ -394,11 +386,12 @@ func getNextPriorityAbilities(group string, model string) ([]Ability, error) {
 		trueVal = "true"
 	}
 
+	models := getAbilityModels(group, model)
 	// 首先获取当前最高优先级
 	var maxPriority int
 	err := DB.Table("abilities").
 		Select("COALESCE(MAX(priority), 0) as max_priority"). // 假定最低优先级为 0
-		Where(groupCol+" = ? AND model = ? AND enabled = "+trueVal, group, model).
+		Where(groupCol+" = ? AND model IN (?) AND enabled = "+trueVal, group, models).
 		Pluck("max_priority", &maxPriority).Error
 
 	if err != nil {
@@ -408,17 +401,17 @@ func getNextPriorityAbilities(group string, model string) ([]Ability, error) {
 	// 使用得到的最高优先级来查询次高优先级的渠道列表
 	// 我们从那些其 priority 小于当前最高优先级的记录中选择最大值
 	nextMaxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").
-		Where(groupCol+" = ? AND model = ? AND enabled = "+trueVal+" AND priority < ?", group, model, maxPriority)
+		Where(groupCol+" = ? AND model IN (?) AND enabled = "+trueVal+" AND priority < ?", group, models, maxPriority)
 
 	// 根据次高优先级查询能力列表
-	err = DB.Where(groupCol+" = ? AND model = ? AND enabled = "+trueVal+" AND priority = (?)", group, model, nextMaxPrioritySubQuery).
+	err = DB.Where(groupCol+" = ? AND model IN (?) AND enabled = "+trueVal+" AND priority = (?)", group, models, nextMaxPrioritySubQuery).
 		Order("weight DESC").
 		Find(&abilities).Error
 
 	if err != nil {
 		return nil, err
 	}
-	return abilities, nil
+	return uniqueAbilities(abilities), nil
 }
 
 func (channel *Channel) AddAbilities() error {
@@ -440,9 +433,29 @@ func (channel *Channel) AddAbilities() error {
 			abilities = append(abilities, ability)
 		}
 	}
+	// 保留通过本渠道微调出的模型，避免编辑渠道后失去路由
+	jobs, err := getFineTunedModelJobs(channel.Id)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		ability := job.ability(channel)
+		if !containsAbility(abilities, ability) {
+			abilities = append(abilities, ability)
+		}
+	}
 	return DB.Create(&abilities).Error
 }
 
+func containsAbility(abilities []Ability, ability Ability) bool {
+	for _, a := range abilities {
+		if a.Group == ability.Group && a.Model == ability.Model {
+			return true
+		}
+	}
+	return false
+}
+
 func (channel *Channel) DeleteAbilities() error {
 	return DB.Where("channel_id = ?", channel.Id).Delete(&Ability{}).Error
 }
@@ -0,0 +1,314 @@
+package model
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const (
+	RunStatusQueued         = "queued"
+	RunStatusInProgress     = "in_progress"
+	RunStatusRequiresAction = "requires_action"
+	RunStatusCancelling     = "cancelling"
+	RunStatusCancelled      = "cancelled"
+	RunStatusFailed         = "failed"
+	RunStatusCompleted      = "completed"
+	RunStatusExpired        = "expired"
+)
+
+const (
+	RunStepTypeMessageCreation = "message_creation"
+	RunStepTypeToolCalls       = "tool_calls"
+)
+
+type Assistant struct {
+	Id             int      `json:"id"`
+	AssistantId    string   `json:"assistant_id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId         int      `json:"user_id" gorm:"index"`
+	Name           string   `json:"name"`
+	Description    string   `json:"description"`
+	Model          string   `json:"model"`
+	Instructions   string   `json:"instructions" gorm:"type:text"`
+	Tools          string   `json:"tools" gorm:"type:text"`
+	Metadata       string   `json:"metadata" gorm:"type:text"`
+	Temperature    *float64 `json:"temperature"`
+	TopP           *float64 `json:"top_p"`
+	ResponseFormat string   `json:"response_format" gorm:"type:text"`
+	CreatedAt      int64    `json:"created_at" gorm:"bigint"`
+}
+
+type Thread struct {
+	Id        int    `json:"id"`
+	ThreadId  string `json:"thread_id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId    int    `json:"user_id" gorm:"index"`
+	Metadata  string `json:"metadata" gorm:"type:text"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint"`
+}
+
+type ThreadMessage struct {
+	Id          int    `json:"id"`
+	MessageId   string `json:"message_id" gorm:"type:varchar(64);uniqueIndex"`
+	ThreadId    string `json:"thread_id" gorm:"type:varchar(64);index"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	Role        string `json:"role" gorm:"type:varchar(32)"`
+	Content     string `json:"content" gorm:"type:text"`
+	AssistantId string `json:"assistant_id" gorm:"type:varchar(64)"`
+	RunId       string `json:"run_id" gorm:"type:varchar(64)"`
+	Metadata    string `json:"metadata" gorm:"type:text"`
+	CreatedAt   int64  `json:"created_at" gorm:"bigint"`
+}
+
+type Run struct {
+	Id                     int      `json:"id"`
+	RunId                  string   `json:"run_id" gorm:"type:varchar(64);uniqueIndex"`
+	ThreadId               string   `json:"thread_id" gorm:"type:varchar(64);index"`
+	AssistantId            string   `json:"assistant_id" gorm:"type:varchar(64)"`
+	UserId                 int      `json:"user_id" gorm:"index"`
+	TokenId                int      `json:"token_id"`
+	Status                 string   `json:"status" gorm:"type:varchar(32);index"`
+	Model                  string   `json:"model"`
+	Instructions           string   `json:"instructions" gorm:"type:text"`
+	Tools                  string   `json:"tools" gorm:"type:text"`
+	Metadata               string   `json:"metadata" gorm:"type:text"`
+	Temperature            *float64 `json:"temperature"`
+	TopP                   *float64 `json:"top_p"`
+	MaxCompletionTokens    int      `json:"max_completion_tokens"`
+	RequiredAction         string   `json:"required_action" gorm:"type:text"`
+	LastError              string   `json:"last_error" gorm:"type:text"`
+	PromptTokens           int      `json:"prompt_tokens"`
+	CompletionTokens       int      `json:"completion_tokens"`
+	CreatedAt              int64    `json:"created_at" gorm:"bigint"`
+	StartedAt              int64    `json:"started_at" gorm:"bigint"`
+	ExpiresAt              int64    `json:"expires_at" gorm:"bigint"`
+	CancelledAt            int64    `json:"cancelled_at" gorm:"bigint"`
+	FailedAt               int64    `json:"failed_at" gorm:"bigint"`
+	CompletedAt            int64    `json:"completed_at" gorm:"bigint"`
+	AdditionalInstructions string   `json:"additional_instructions" gorm:"type:text"`
+}
+
+type RunStep struct {
+	Id               int    `json:"id"`
+	StepId           string `json:"step_id" gorm:"type:varchar(64);uniqueIndex"`
+	RunId            string `json:"run_id" gorm:"type:varchar(64);index"`
+	ThreadId         string `json:"thread_id" gorm:"type:varchar(64)"`
+	AssistantId      string `json:"assistant_id" gorm:"type:varchar(64)"`
+	UserId           int    `json:"user_id" gorm:"index"`
+	Type             string `json:"type" gorm:"type:varchar(32)"`
+	Status           string `json:"status" gorm:"type:varchar(32)"`
+	StepDetails      string `json:"step_details" gorm:"type:text"`
+	LastError        string `json:"last_error" gorm:"type:text"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint"`
+	CompletedAt      int64  `json:"completed_at" gorm:"bigint"`
+	FailedAt         int64  `json:"failed_at" gorm:"bigint"`
+	CancelledAt      int64  `json:"cancelled_at" gorm:"bigint"`
+}
+
+// paginate applies the cursor pagination of the assistants API, afterId is the internal id of the cursor object.
+func paginate(query *gorm.DB, order string, afterId int, num int) *gorm.DB {
+	if order == "asc" {
+		if afterId > 0 {
+			query = query.Where("id > ?", afterId)
+		}
+		return query.Order("id asc").Limit(num)
+	}
+	if afterId > 0 {
+		query = query.Where("id < ?", afterId)
+	}
+	return query.Order("id desc").Limit(num)
+}
+
+func GetUserAssistants(userId int, order string, afterId int, num int) ([]*Assistant, error) {
+	var assistants []*Assistant
+	err := paginate(DB.Where("user_id = ?", userId), order, afterId, num).Find(&assistants).Error
+	return assistants, err
+}
+
+func GetUserAssistant(userId int, assistantId string) (*Assistant, error) {
+	if userId == 0 || assistantId == "" {
+		return nil, errors.New("userId 或 assistantId 为空！")
+	}
+	var assistant Assistant
+	err := DB.Where("user_id = ? and assistant_id = ?", userId, assistantId).First(&assistant).Error
+	return &assistant, err
+}
+
+func (assistant *Assistant) Insert() error {
+	return DB.Create(assistant).Error
+}
+
+func (assistant *Assistant) Update() error {
+	return DB.Save(assistant).Error
+}
+
+func (assistant *Assistant) Delete() error {
+	return DB.Delete(assistant).Error
+}
+
+func GetUserThread(userId int, threadId string) (*Thread, error) {
+	if userId == 0 || threadId == "" {
+		return nil, errors.New("userId 或 threadId 为空！")
+	}
+	var thread Thread
+	err := DB.Where("user_id = ? and thread_id = ?", userId, threadId).First(&thread).Error
+	return &thread, err
+}
+
+func (thread *Thread) Insert() error {
+	return DB.Create(thread).Error
+}
+
+func (thread *Thread) Update() error {
+	return DB.Save(thread).Error
+}
+
+// Delete removes the thread together with its messages, runs and run steps.
+func (thread *Thread) Delete() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("thread_id = ?", thread.ThreadId).Delete(&RunStep{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("thread_id = ?", thread.ThreadId).Delete(&Run{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("thread_id = ?", thread.ThreadId).Delete(&ThreadMessage{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(thread).Error
+	})
+}
+
+func GetThreadMessages(threadId string, runId string, order string, afterId int, num int) ([]*ThreadMessage, error) {
+	var messages []*ThreadMessage
+	query := DB.Where("thread_id = ?", threadId)
+	if runId != "" {
+		query = query.Where("run_id = ?", runId)
+	}
+	err := paginate(query, order, afterId, num).Find(&messages).Error
+	return messages, err
+}
+
+// GetThreadMessagesBefore returns the messages of a thread created before the given run, oldest first.
+func GetThreadMessagesBefore(threadId string, runId string) ([]*ThreadMessage, error) {
+	var messages []*ThreadMessage
+	err := DB.Where("thread_id = ? and run_id <> ?", threadId, runId).Order("id asc").Find(&messages).Error
+	return messages, err
+}
+
+func GetThreadMessage(threadId string, messageId string) (*ThreadMessage, error) {
+	var message ThreadMessage
+	err := DB.Where("thread_id = ? and message_id = ?", threadId, messageId).First(&message).Error
+	return &message, err
+}
+
+func (message *ThreadMessage) Insert() error {
+	return DB.Create(message).Error
+}
+
+func (message *ThreadMessage) Update() error {
+	return DB.Save(message).Error
+}
+
+func GetThreadRuns(threadId string, order string, afterId int, num int) ([]*Run, error) {
+	var runs []*Run
+	err := paginate(DB.Where("thread_id = ?", threadId), order, afterId, num).Find(&runs).Error
+	return runs, err
+}
+
+func GetThreadRun(threadId string, runId string) (*Run, error) {
+	var run Run
+	err := DB.Where("thread_id = ? and run_id = ?", threadId, runId).First(&run).Error
+	return &run, err
+}
+
+// expirableRunStatuses are the statuses of runs that are not finished and expire at ExpiresAt.
+var expirableRunStatuses = []string{RunStatusQueued, RunStatusInProgress, RunStatusRequiresAction}
+
+// ExpireRuns moves the unfinished runs past their expiry to expired, so an abandoned tool call doesn't keep
+// its thread locked. A run still executing fails to move on from in_progress and stops there.
+func ExpireRuns(now int64) (int64, error) {
+	result := DB.Model(&Run{}).Where("status in ? and expires_at > 0 and expires_at < ?", expirableRunStatuses, now).Updates(map[string]interface{}{
+		"status":          RunStatusExpired,
+		"required_action": "",
+	})
+	return result.RowsAffected, result.Error
+}
+
+// HasActiveRun reports whether the thread has a run that is not finished yet, a thread runs one run at a time.
+// Runs past their expiry don't count.
+func HasActiveRun(threadId string, now int64) (bool, error) {
+	var count int64
+	err := DB.Model(&Run{}).Where("thread_id = ? and status in ?", threadId, []string{RunStatusQueued, RunStatusInProgress, RunStatusRequiresAction, RunStatusCancelling}).
+		Where("status = ? or expires_at = 0 or expires_at >= ?", RunStatusCancelling, now).Count(&count).Error
+	return count > 0, err
+}
+
+func GetRunStatus(runId string) (string, error) {
+	var status string
+	err := DB.Model(&Run{}).Where("run_id = ?", runId).Select("status").Scan(&status).Error
+	return status, err
+}
+
+// UpdateRunStatus moves a run from one of the from statuses to status, it returns false when the run is in another status.
+func UpdateRunStatus(runId string, from []string, status string, fields map[string]interface{}) (bool, error) {
+	if fields == nil {
+		fields = make(map[string]interface{})
+	}
+	fields["status"] = status
+	result := DB.Model(&Run{}).Where("run_id = ? and status in ?", runId, from).Updates(fields)
+	return result.RowsAffected == 1, result.Error
+}
+
+// RequeueInterruptedRuns puts runs that were executing when the process stopped back in the queue and returns
+// every queued run so it can be executed again. Runs past their expiry are expired and runs being cancelled are
+// cancelled instead.
+func RequeueInterruptedRuns(now int64) ([]*Run, error) {
+	_, err := ExpireRuns(now)
+	if err != nil {
+		return nil, err
+	}
+	err = DB.Model(&Run{}).Where("status = ?", RunStatusCancelling).Updates(map[string]interface{}{
+		"status":       RunStatusCancelled,
+		"cancelled_at": now,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	err = DB.Model(&Run{}).Where("status = ?", RunStatusInProgress).Update("status", RunStatusQueued).Error
+	if err != nil {
+		return nil, err
+	}
+	var runs []*Run
+	err = DB.Where("status = ?", RunStatusQueued).Order("id asc").Find(&runs).Error
+	return runs, err
+}
+
+func (run *Run) Insert() error {
+	return DB.Create(run).Error
+}
+
+func (run *Run) Update() error {
+	return DB.Save(run).Error
+}
+
+func GetRunSteps(runId string, order string, afterId int, num int) ([]*RunStep, error) {
+	var steps []*RunStep
+	err := paginate(DB.Where("run_id = ?", runId), order, afterId, num).Find(&steps).Error
+	return steps, err
+}
+
+func GetRunStep(runId string, stepId string) (*RunStep, error) {
+	var step RunStep
+	err := DB.Where("run_id = ? and step_id = ?", runId, stepId).First(&step).Error
+	return &step, err
+}
+
+func (step *RunStep) Insert() error {
+	return DB.Create(step).Error
+}
+
+func (step *RunStep) Update() error {
+	return DB.Save(step).Error
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpireRuns(t *testing.T) {
+	setupTestDB(t, &Run{})
+	runs := []*Run{
+		{RunId: "run_waiting", ThreadId: "thread_1", Status: RunStatusRequiresAction, RequiredAction: "{}", ExpiresAt: 100},
+		{RunId: "run_queued", ThreadId: "thread_2", Status: RunStatusQueued, ExpiresAt: 100},
+		{RunId: "run_fresh", ThreadId: "thread_3", Status: RunStatusRequiresAction, ExpiresAt: 300},
+		{RunId: "run_done", ThreadId: "thread_4", Status: RunStatusCompleted, ExpiresAt: 100},
+	}
+	for _, run := range runs {
+		assert.NoError(t, run.Insert())
+	}
+
+	active, err := HasActiveRun("thread_1", 200)
+	assert.NoError(t, err)
+	assert.False(t, active, "a run past its expiry doesn't lock the thread")
+	active, err = HasActiveRun("thread_3", 200)
+	assert.NoError(t, err)
+	assert.True(t, active)
+
+	count, err := ExpireRuns(200)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+	for runId, status := range map[string]string{
+		"run_waiting": RunStatusExpired,
+		"run_queued":  RunStatusExpired,
+		"run_fresh":   RunStatusRequiresAction,
+		"run_done":    RunStatusCompleted,
+	} {
+		got, err := GetRunStatus(runId)
+		assert.NoError(t, err)
+		assert.Equal(t, status, got, runId)
+	}
+	run, err := GetThreadRun("thread_1", "run_waiting")
+	assert.NoError(t, err)
+	assert.Empty(t, run.RequiredAction)
+}
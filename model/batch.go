@@ -0,0 +1,158 @@
+package model
+
+import (
+	"errors"
+	"one-api/common"
+)
+
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+type Batch struct {
+	Id                int    `json:"id"`
+	BatchId           string `json:"batch_id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId            int    `json:"user_id" gorm:"index"`
+	TokenId           int    `json:"token_id" gorm:"index"`
+	Endpoint          string `json:"endpoint"`
+	InputFileId       string `json:"input_file_id" gorm:"type:varchar(64)"`
+	OutputFileId      string `json:"output_file_id" gorm:"type:varchar(64)"`
+	ErrorFileId       string `json:"error_file_id" gorm:"type:varchar(64)"`
+	CompletionWindow  string `json:"completion_window"`
+	Status            string `json:"status" gorm:"type:varchar(32);index"`
+	Errors            string `json:"errors" gorm:"type:text"`
+	Metadata          string `json:"metadata" gorm:"type:text"`
+	TotalRequests     int    `json:"total_requests"`
+	CompletedRequests int    `json:"completed_requests"`
+	FailedRequests    int    `json:"failed_requests"`
+	CreatedAt         int64  `json:"created_at" gorm:"bigint"`
+	InProgressAt      int64  `json:"in_progress_at" gorm:"bigint"`
+	ExpiresAt         int64  `json:"expires_at" gorm:"bigint"`
+	FinalizingAt      int64  `json:"finalizing_at" gorm:"bigint"`
+	CompletedAt       int64  `json:"completed_at" gorm:"bigint"`
+	FailedAt          int64  `json:"failed_at" gorm:"bigint"`
+	ExpiredAt         int64  `json:"expired_at" gorm:"bigint"`
+	CancellingAt      int64  `json:"cancelling_at" gorm:"bigint"`
+	CancelledAt       int64  `json:"cancelled_at" gorm:"bigint"`
+}
+
+// GetUserBatches lists batches newest first; afterId is the internal id of the last batch of the previous page.
+func GetUserBatches(userId int, afterId int, num int) ([]*Batch, error) {
+	var batches []*Batch
+	query := DB.Where("user_id = ?", userId)
+	if afterId > 0 {
+		query = query.Where("id < ?", afterId)
+	}
+	err := query.Order("id desc").Limit(num).Find(&batches).Error
+	return batches, err
+}
+
+func GetUserBatch(userId int, batchId string) (*Batch, error) {
+	if userId == 0 || batchId == "" {
+		return nil, errors.New("userId 或 batchId 为空！")
+	}
+	var batch Batch
+	err := DB.Where("user_id = ? and batch_id = ?", userId, batchId).First(&batch).Error
+	return &batch, err
+}
+
+func GetBatchById(id int) (*Batch, error) {
+	var batch Batch
+	err := DB.First(&batch, "id = ?", id).Error
+	return &batch, err
+}
+
+func GetBatchesByStatus(status string, num int) ([]*Batch, error) {
+	var batches []*Batch
+	err := DB.Where("status = ?", status).Order("id asc").Limit(num).Find(&batches).Error
+	return batches, err
+}
+
+// ClaimBatch moves a batch from validating to in_progress. It returns false when another
+// node has already picked the batch up.
+func ClaimBatch(id int) (bool, error) {
+	result := DB.Model(&Batch{}).Where("id = ? and status = ?", id, BatchStatusValidating).Updates(map[string]interface{}{
+		"status":         BatchStatusInProgress,
+		"in_progress_at": common.GetTimestamp(),
+	})
+	return result.RowsAffected == 1, result.Error
+}
+
+// ExpireBatches marks batches that were not started within their completion window as expired.
+func ExpireBatches(now int64) error {
+	return DB.Model(&Batch{}).Where("status = ? and expires_at > 0 and expires_at < ?", BatchStatusValidating, now).Updates(map[string]interface{}{
+		"status":     BatchStatusExpired,
+		"expired_at": now,
+	}).Error
+}
+
+// CancelBatch cancels a batch that has not started yet right away, a running batch is
+// moved to cancelling and the worker finishes the cancellation.
+func CancelBatch(id int) (bool, error) {
+	now := common.GetTimestamp()
+	result := DB.Model(&Batch{}).Where("id = ? and status = ?", id, BatchStatusValidating).Updates(map[string]interface{}{
+		"status":        BatchStatusCancelled,
+		"cancelling_at": now,
+		"cancelled_at":  now,
+	})
+	if result.Error != nil || result.RowsAffected == 1 {
+		return result.RowsAffected == 1, result.Error
+	}
+	result = DB.Model(&Batch{}).Where("id = ? and status = ?", id, BatchStatusInProgress).Updates(map[string]interface{}{
+		"status":        BatchStatusCancelling,
+		"cancelling_at": now,
+	})
+	return result.RowsAffected == 1, result.Error
+}
+
+// FailInterruptedBatches fails batches that were running when the process stopped.
+func FailInterruptedBatches() error {
+	now := common.GetTimestamp()
+	err := DB.Model(&Batch{}).Where("status in ?", []string{BatchStatusInProgress, BatchStatusFinalizing}).Updates(map[string]interface{}{
+		"status":    BatchStatusFailed,
+		"failed_at": now,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return DB.Model(&Batch{}).Where("status = ?", BatchStatusCancelling).Updates(map[string]interface{}{
+		"status":       BatchStatusCancelled,
+		"cancelled_at": now,
+	}).Error
+}
+
+func GetBatchStatus(id int) (string, error) {
+	var status string
+	err := DB.Model(&Batch{}).Where("id = ?", id).Select("status").Scan(&status).Error
+	return status, err
+}
+
+func UpdateBatchTotalRequests(id int, total int) error {
+	return DB.Model(&Batch{}).Where("id = ?", id).Update("total_requests", total).Error
+}
+
+func UpdateBatchProgress(id int, completed int, failed int) error {
+	return DB.Model(&Batch{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"completed_requests": completed,
+		"failed_requests":    failed,
+	}).Error
+}
+
+func (batch *Batch) Insert() error {
+	return DB.Create(batch).Error
+}
+
+// Transition writes the status of the batch and the given columns only while the batch is still in one of
+// fromStatuses, so a cancel that happened in the meantime is not overwritten. It returns false when the batch
+// has moved on.
+func (batch *Batch) Transition(fromStatuses []string, columns ...string) (bool, error) {
+	result := DB.Model(batch).Where("status in ?", fromStatuses).Select(append([]string{"status"}, columns...)).Updates(batch)
+	return result.RowsAffected == 1, result.Error
+}
@@ -170,6 +170,7 @@ func CacheIsUserEnabled(userId int) (bool, error) {
 }
 
 var group2model2channels map[string]map[string][]*Channel
+var group2patterns map[string][]string // 各分组渠道中的模型规则
 var channelsIDM map[int]*Channel
 var channelSyncLock sync.RWMutex
 
@@ -205,9 +206,32 @@ func InitChannelCache() {
 		}
 	}
 
+	// fine-tuned models are routed to the channel that trained them
+	jobs, err := getFineTunedModelJobs(0)
+	if err != nil {
+		common.SysError("failed to load fine-tuned models: " + err.Error())
+	}
+	for _, job := range jobs {
+		channel, ok := newChannelsIDM[job.ChannelId]
+		if !ok {
+			continue
+		}
+		if _, ok := newGroup2model2channels[job.Group]; !ok {
+			newGroup2model2channels[job.Group] = make(map[string][]*Channel)
+		}
+		channels := newGroup2model2channels[job.Group][job.FineTunedModel]
+		if !containsChannel(channels, channel.Id) {
+			newGroup2model2channels[job.Group][job.FineTunedModel] = append(channels, channel)
+		}
+	}
+
+	newGroup2patterns := make(map[string][]string)
 	// sort by priority
 	for group, model2channels := range newGroup2model2channels {
 		for model, channels := range model2channels {
+			if common.IsModelPattern(model) {
+				newGroup2patterns[group] = append(newGroup2patterns[group], model)
+			}
 			sort.Slice(channels, func(i, j int) bool {
 				return channels[i].GetPriority() > channels[j].GetPriority()
 			})
@@ -217,11 +241,45 @@ func InitChannelCache() {
 
 	channelSyncLock.Lock()
 	group2model2channels = newGroup2model2channels
+	group2patterns = newGroup2patterns
 	channelsIDM = newChannelsIDM
 	channelSyncLock.Unlock()
 	common.SysLog("channels synced from database")
 }
 
+// getGroupModelChannels returns the channels of the group serving the model by name or by pattern,
+// the caller holds channelSyncLock.
+func getGroupModelChannels(group string, model string) []*Channel {
+	channels := group2model2channels[group][model]
+	var merged []*Channel
+	for _, pattern := range group2patterns[group] {
+		if pattern == model || !common.MatchModel(pattern, model) {
+			continue
+		}
+		if merged == nil {
+			merged = append([]*Channel{}, channels...)
+		}
+		for _, channel := range group2model2channels[group][pattern] {
+			if !containsChannel(merged, channel.Id) {
+				merged = append(merged, channel)
+			}
+		}
+	}
+	if merged == nil {
+		return channels
+	}
+	return merged
+}
+
+func containsChannel(channels []*Channel, id int) bool {
+	for _, channel := range channels {
+		if channel.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
 func SyncChannelCache(frequency int) {
 	for {
 		time.Sleep(time.Duration(frequency) * time.Second)
@@ -230,10 +288,20 @@ func SyncChannelCache(frequency int) {
 	}
 }
 
-func filterByTools(channels []*Channel, claudeoriginalrequest bool) []*Channel {
+func filterByTools(channels []*Channel) []*Channel {
 	var filteredChannels []*Channel
 	for _, ch := range channels {
-		if (ch.IsTools != nil && *ch.IsTools) || (claudeoriginalrequest && *ch.ClaudeOriginalRequest) {
+		if ch.IsTools != nil && *ch.IsTools {
+			filteredChannels = append(filteredChannels, ch)
+		}
+	}
+	return filteredChannels
+}
+
+func filterByClaudeOriginalRequest(channels []*Channel) []*Channel {
+	var filteredChannels []*Channel
+	for _, ch := range channels {
+		if ch.ClaudeOriginalRequest != nil && *ch.ClaudeOriginalRequest {
 			filteredChannels = append(filteredChannels, ch)
 		}
 	}
@@ -254,10 +322,6 @@ func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPrior
 		excludedMap[id] = struct{}{}
 	}
 
-	if strings.HasPrefix(model, "gpt-4-gizmo") {
-		model = "gpt-4-gizmo-*"
-	}
-
 	// 检查缓存
 	if !common.MemoryCacheEnabled {
 		return GetRandomSatisfiedChannel(group, model, excludedMap, ignoreFirstPriority, isTools, claudeoriginalrequest, i)
@@ -265,7 +329,7 @@ func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPrior
 
 	// 使用更细粒度的锁
 	channelSyncLock.RLock()
-	allChannels := group2model2channels[group][model]
+	allChannels := getGroupModelChannels(group, model)
 	channelSyncLock.RUnlock()
 
 	if len(allChannels) == 0 {
@@ -273,12 +337,19 @@ func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPrior
 	}
 
 	sortChannels(allChannels) // 封装排序逻辑到一个函数
+	strategy := common.GetRoutingStrategy(group, model)
 
-	if isTools || claudeoriginalrequest {
-		allChannels = filterByTools(allChannels, claudeoriginalrequest)
+	if isTools {
+		allChannels = filterByTools(allChannels)
+	}
+	// Claude 原生请求优先使用支持原生请求的渠道，没有可用的再由其他渠道转换格式后提供服务
+	if claudeoriginalrequest {
+		if channel, err := selectChannel(filterByClaudeOriginalRequest(allChannels), excludedMap, ignoreFirstPriority, i, group, model, strategy); err == nil {
+			return channel, nil
+		}
 	}
 
-	return selectChannel(allChannels, excludedMap, ignoreFirstPriority, i, model)
+	return selectChannel(allChannels, excludedMap, ignoreFirstPriority, i, group, model, strategy)
 }
 
 // 封装排序逻辑
@@ -292,7 +363,7 @@ func sortChannels(channels []*Channel) {
 }
 
 // selectChannel 根据给定条件选择合适的频道
-func selectChannel(channels []*Channel, excluded map[int]struct{}, ignoreFirstPriority bool, i int, model string) (*Channel, error) {
+func selectChannel(channels []*Channel, excluded map[int]struct{}, ignoreFirstPriority bool, i int, group string, model string, strategy string) (*Channel, error) {
 	if len(channels) == 0 {
 		return nil, errors.New("频道列表为空")
 	}
@@ -303,7 +374,7 @@ func selectChannel(channels []*Channel, excluded map[int]struct{}, ignoreFirstPr
 	}
 
 	for {
-		filteredChannels, totalWeight := filterAndWeightChannels(channels, currentPriority, excluded)
+		filteredChannels, totalWeight := filterAndWeightChannels(channels, currentPriority, excluded, model)
 		if len(filteredChannels) == 0 {
 			if nextPriority, exists := getNextLowerPriority(channels, currentPriority); exists {
 				currentPriority = nextPriority
@@ -312,7 +383,7 @@ func selectChannel(channels []*Channel, excluded map[int]struct{}, ignoreFirstPr
 			return nil, errors.New("没有可用的更低优先级频道")
 		}
 
-		if selectedChannel, err := trySelectChannel(filteredChannels, totalWeight, model); err == nil {
+		if selectedChannel, err := trySelectChannel(filteredChannels, totalWeight, group, model, strategy); err == nil {
 			return selectedChannel, nil
 		}
 
@@ -336,16 +407,23 @@ func resolveInitialPriority(channels []*Channel, ignoreFirstPriority bool, i int
 	return channels[0].GetPriority(), nil
 }
 
-func trySelectChannel(channels []*Channel, totalWeight int, model string) (*Channel, error) {
+// trySelectChannel 按渠道选择策略在同一优先级的频道中选择
+func trySelectChannel(channels []*Channel, totalWeight int, group string, model string, strategy string) (*Channel, error) {
+	if strategy != common.RoutingStrategyWeighted {
+		if channel, ok := selectChannelByStrategy(strategy, group, model, channels); ok {
+			return channel, nil
+		}
+		return nil, errors.New("没有未熔断的频道可用")
+	}
 	return weightedRandomSelection(channels, totalWeight, model)
 }
 
-// filterAndWeightChannels 过滤出同一优先级的频道，并计算总权重
-func filterAndWeightChannels(channels []*Channel, priority int64, excluded map[int]struct{}) ([]*Channel, int) {
+// filterAndWeightChannels 过滤出同一优先级且未熔断的频道，并计算总权重
+func filterAndWeightChannels(channels []*Channel, priority int64, excluded map[int]struct{}, model string) ([]*Channel, int) {
 	var priorityChannels []*Channel
 	totalWeight := 0
 	for _, ch := range channels {
-		if ch.GetPriority() == priority && !contains(excluded, ch.Id) {
+		if ch.GetPriority() == priority && !contains(excluded, ch.Id) && !isChannelBreakerOpen(ch.Id, model) {
 			priorityChannels = append(priorityChannels, ch)
 			totalWeight += ch.GetWeight()
 		}
@@ -354,18 +432,12 @@ func filterAndWeightChannels(channels []*Channel, priority int64, excluded map[i
 }
 
 func randomSelection(channels []*Channel, model string) (*Channel, error) {
-	filteredChannels := make([]*Channel, 0)
-	for _, channel := range channels {
-		if channel.RateLimited == nil || (channel.RateLimited != nil && !*channel.RateLimited) {
-			filteredChannels = append(filteredChannels, channel)
+	for _, i := range rand.Perm(len(channels)) {
+		if ChannelBreakerAvailable(channels[i].Id, model) {
+			return channels[i], nil
 		}
 	}
-	if len(filteredChannels) == 0 {
-		return nil, errors.New("没有未受限的频道可用")
-	}
-	selected := filteredChannels[rand.Intn(len(filteredChannels))]
-	updateRateLimitStatus(selected.Id, model)
-	return selected, nil
+	return nil, errors.New("没有未熔断的频道可用")
 }
 
 // weightedRandomSelection 根据权重随机选择一个频道
@@ -379,14 +451,10 @@ func weightedRandomSelection(channels []*Channel, totalWeight int, model string)
 	for _, channel := range channels {
 		randomWeight -= channel.GetWeight()
 		if randomWeight < 0 {
-			if channel.RateLimited != nil && *channel.RateLimited {
-				_, ok := checkRateLimit(channel.Id, model)
-				if !ok {
-					log.Println("频道频率限制超出", channel.Id)
-					continue
-				}
+			if !ChannelBreakerAvailable(channel.Id, model) {
+				log.Println("频道已熔断", channel.Id)
+				continue
 			}
-			updateRateLimitStatus(channel.Id, model)
 			return channel, nil
 		}
 	}
@@ -40,7 +40,7 @@ type Channel struct {
 	ClaudeOriginalRequest *bool   `json:"claude_original_request" gorm:"default:false"`
 	TestedTime            *int    `json:"tested_time" gorm:"bigint"`
 	ModelTest             string  `json:"model_test"`
-	RateLimited           *bool   `json:"rate_limited" gorm:"default:false"`
+	RateLimited           *bool   `json:"rate_limited" gorm:"default:false"` // 已弃用，固定频率限制已由渠道熔断取代
 	IsImageURLEnabled     *int    `json:"is_image_url_enabled" gorm:"default:0"`
 	StatusCodeMapping     *string `json:"status_code_mapping" gorm:"type:varchar(1024);default:''"`
 	Config                string  `json:"config"`
@@ -60,6 +60,18 @@ type ChannelConfig struct {
 	ClientId     string `json:"client_id,omitempty"`
 	ClientSecret string `json:"client_secret,omitempty"`
 	RefreshToken string `json:"refresh_token,omitempty"`
+	ChannelLimit
+	ModelLimits map[string]ChannelLimit `json:"model_limits,omitempty"` // 按模型设置的限制，与渠道整体限制同时生效
+	CostRatio   float64                 `json:"cost_ratio,omitempty"`   // 上游相对官方价格的成本倍率，用于按成本选择渠道，0 视为 1
+	KeyRotation string                  `json:"key_rotation,omitempty"` // 多密钥轮换方式 round_robin 或 random，为空时 Key 是单个密钥
+	NoModelSync bool                    `json:"no_model_sync,omitempty"` // 不参与上游模型的定时同步
+}
+
+// ChannelLimit 上游账号的速率限制，0 表示不限制
+type ChannelLimit struct {
+	RPM            int `json:"rpm,omitempty"`             // 每分钟请求数
+	TPM            int `json:"tpm,omitempty"`             // 每分钟 token 数
+	MaxConcurrency int `json:"max_concurrency,omitempty"` // 同时进行的流式请求数
 }
 
 func GetAllChannels(startIdx int, num int, selectAll bool, idSort bool) ([]*Channel, error) {
@@ -178,6 +190,10 @@ func BatchInsertChannels(channels []Channel) error {
 		if err != nil {
 			return err
 		}
+		err = SyncChannelKeys(&channel_)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -267,6 +283,10 @@ func (channel *Channel) Insert() error {
 	if err != nil {
 		return err
 	}
+	err = SyncChannelKeys(channel)
+	if err != nil {
+		return err
+	}
 	return channel.checkAndGetAccessToken()
 }
 
@@ -281,6 +301,10 @@ func (channel *Channel) Update() error {
 	if err != nil {
 		return err
 	}
+	err = SyncChannelKeys(channel)
+	if err != nil {
+		return err
+	}
 	return channel.checkAndGetAccessToken()
 }
 
@@ -351,7 +375,10 @@ func (channel *Channel) Delete() error {
 		return err
 	}
 	err = channel.DeleteAbilities()
-	return err
+	if err != nil {
+		return err
+	}
+	return DeleteChannelKeys(channel.Id)
 }
 
 func UpdateChannelStatusById(id int, status int) {
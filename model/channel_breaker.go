@@ -0,0 +1,470 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"one-api/common"
+	"one-api/common/config"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 熔断器按渠道和模型统计，状态为关闭（正常）、打开（暂停选用）和半开（放行一个探测请求）
+const (
+	BreakerStateClosed   = "closed"
+	BreakerStateOpen     = "open"
+	BreakerStateHalfOpen = "half_open"
+)
+
+// 统计窗口划分的桶数
+const breakerBucketCount = 6
+
+type ChannelBreakerStatus struct {
+	ChannelId  int     `json:"channel_id"`
+	Model      string  `json:"model"`
+	State      string  `json:"state"`
+	Requests   int64   `json:"requests"`
+	Failures   int64   `json:"failures"`
+	ErrorRate  float64 `json:"error_rate"`
+	AvgLatency int64   `json:"avg_latency"` // 毫秒
+	OpenedAt   int64   `json:"opened_at"`
+}
+
+type breakerBucket struct {
+	Start    int64
+	Requests int64
+	Failures int64
+	Latency  int64
+}
+
+type channelBreaker struct {
+	mutex    sync.Mutex
+	buckets  [breakerBucketCount]breakerBucket
+	state    string
+	openedAt int64
+	probeAt  int64
+}
+
+var channelBreakers sync.Map // ChannelModelKey -> *channelBreaker
+
+func breakerBucketSize() int64 {
+	size := int64(config.CircuitBreakerWindow / breakerBucketCount)
+	if size <= 0 {
+		size = 1
+	}
+	return size
+}
+
+func breakerOpenDuration() int64 {
+	if config.CircuitBreakerOpenDuration <= 0 {
+		return 1
+	}
+	return int64(config.CircuitBreakerOpenDuration)
+}
+
+// shouldTrip 判断窗口内的统计是否达到熔断条件
+func shouldTrip(requests int64, failures int64, latency int64) bool {
+	if requests <= 0 || requests < int64(config.CircuitBreakerMinRequests) {
+		return false
+	}
+	if float64(failures)/float64(requests) >= config.CircuitBreakerErrorRate {
+		return true
+	}
+	return config.CircuitBreakerLatencyThreshold > 0 && latency/requests >= int64(config.CircuitBreakerLatencyThreshold)
+}
+
+func getChannelBreaker(channelId int, model string) *channelBreaker {
+	key := ChannelModelKey{ChannelID: channelId, Model: model}
+	val, _ := channelBreakers.LoadOrStore(key, &channelBreaker{state: BreakerStateClosed})
+	return val.(*channelBreaker)
+}
+
+// ChannelBreakerAvailable reports whether the channel may be selected for the model, without taking the probe.
+// It is used while filtering candidates, AcquireChannelBreaker is called for the channel finally chosen.
+func ChannelBreakerAvailable(channelId int, model string) bool {
+	return !isChannelBreakerOpen(channelId, model)
+}
+
+// isChannelBreakerOpen reports whether the channel is paused for the model, without taking the probe.
+func isChannelBreakerOpen(channelId int, model string) bool {
+	if !config.CircuitBreakerEnabled {
+		return false
+	}
+	now := time.Now().Unix()
+	if common.RedisEnabled {
+		state, openedAt, probeAt := redisGetBreakerState(channelId, model)
+		return breakerBlocked(state, openedAt, probeAt, now)
+	}
+	breaker := getChannelBreaker(channelId, model)
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	return breakerBlocked(breaker.state, breaker.openedAt, breaker.probeAt, now)
+}
+
+func breakerBlocked(state string, openedAt int64, probeAt int64, now int64) bool {
+	switch state {
+	case BreakerStateOpen:
+		return now-openedAt < breakerOpenDuration()
+	case BreakerStateHalfOpen:
+		// 探测请求未返回前不再放行，探测超时后允许重新探测
+		return now-probeAt < breakerOpenDuration()
+	}
+	return false
+}
+
+// AcquireChannelBreaker lets the request chosen to use the channel for the model through the breaker,
+// an open breaker past its cooldown turns half-open and this request becomes the probe. It returns false
+// when another request took the probe first.
+func AcquireChannelBreaker(channelId int, model string) bool {
+	if !config.CircuitBreakerEnabled {
+		return true
+	}
+	now := time.Now().Unix()
+	if common.RedisEnabled {
+		return redisAcquireBreaker(channelId, model, now)
+	}
+	breaker := getChannelBreaker(channelId, model)
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	if breaker.state == BreakerStateClosed {
+		return true
+	}
+	if breakerBlocked(breaker.state, breaker.openedAt, breaker.probeAt, now) {
+		return false
+	}
+	breaker.state = BreakerStateHalfOpen
+	breaker.probeAt = now
+	return true
+}
+
+// RecordChannelResult feeds the outcome of a relay attempt to the breaker of the channel and model.
+func RecordChannelResult(channelId int, model string, success bool, latency time.Duration) {
+	if !config.CircuitBreakerEnabled || channelId == 0 {
+		return
+	}
+	now := time.Now().Unix()
+	failures := int64(0)
+	if !success {
+		failures = 1
+	}
+	if common.RedisEnabled {
+		redisRecordChannelResult(channelId, model, failures, latency.Milliseconds(), now)
+		return
+	}
+	breaker := getChannelBreaker(channelId, model)
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	bucketStart := now - now%breakerBucketSize()
+	bucket := &breaker.buckets[(bucketStart/breakerBucketSize())%breakerBucketCount]
+	if bucket.Start != bucketStart {
+		*bucket = breakerBucket{Start: bucketStart}
+	}
+	bucket.Requests++
+	bucket.Failures += failures
+	bucket.Latency += latency.Milliseconds()
+
+	switch breaker.state {
+	case BreakerStateHalfOpen:
+		if success {
+			breaker.state = BreakerStateClosed
+			breaker.buckets = [breakerBucketCount]breakerBucket{}
+		} else {
+			breaker.state = BreakerStateOpen
+			breaker.openedAt = now
+		}
+	case BreakerStateClosed:
+		requests, failures, latency := breaker.windowStats(now)
+		if shouldTrip(requests, failures, latency) {
+			breaker.state = BreakerStateOpen
+			breaker.openedAt = now
+			common.SysLog(fmt.Sprintf("渠道 #%d 模型 %s 熔断，窗口内请求 %d 次，失败 %d 次", channelId, model, requests, failures))
+		}
+	}
+}
+
+func (b *channelBreaker) windowStats(now int64) (requests int64, failures int64, latency int64) {
+	windowStart := now - int64(config.CircuitBreakerWindow)
+	for _, bucket := range b.buckets {
+		if bucket.Start > windowStart {
+			requests += bucket.Requests
+			failures += bucket.Failures
+			latency += bucket.Latency
+		}
+	}
+	return requests, failures, latency
+}
+
+// GetChannelBreakerStatuses lists the breakers with traffic in the window or not closed.
+func GetChannelBreakerStatuses() []*ChannelBreakerStatus {
+	now := time.Now().Unix()
+	var statuses []*ChannelBreakerStatus
+	if common.RedisEnabled {
+		statuses = redisGetChannelBreakerStatuses(now)
+	} else {
+		channelBreakers.Range(func(key, value any) bool {
+			k := key.(ChannelModelKey)
+			breaker := value.(*channelBreaker)
+			breaker.mutex.Lock()
+			requests, failures, latency := breaker.windowStats(now)
+			status := newChannelBreakerStatus(k.ChannelID, k.Model, breaker.state, breaker.openedAt, requests, failures, latency)
+			breaker.mutex.Unlock()
+			if status.Requests > 0 || status.State != BreakerStateClosed {
+				statuses = append(statuses, status)
+			}
+			return true
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].ChannelId == statuses[j].ChannelId {
+			return statuses[i].Model < statuses[j].Model
+		}
+		return statuses[i].ChannelId < statuses[j].ChannelId
+	})
+	return statuses
+}
+
+func newChannelBreakerStatus(channelId int, model string, state string, openedAt int64, requests int64, failures int64, latency int64) *ChannelBreakerStatus {
+	if state == "" {
+		state = BreakerStateClosed
+	}
+	status := &ChannelBreakerStatus{
+		ChannelId: channelId,
+		Model:     model,
+		State:     state,
+		Requests:  requests,
+		Failures:  failures,
+		OpenedAt:  openedAt,
+	}
+	if requests > 0 {
+		status.ErrorRate = float64(failures) / float64(requests)
+		status.AvgLatency = latency / requests
+	}
+	return status
+}
+
+// ResetChannelBreaker closes the breakers of the channel, of all models when model is empty.
+func ResetChannelBreaker(channelId int, model string) error {
+	if common.RedisEnabled {
+		return redisResetChannelBreaker(channelId, model)
+	}
+	channelBreakers.Range(func(key, value any) bool {
+		k := key.(ChannelModelKey)
+		if k.ChannelID == channelId && (model == "" || k.Model == model) {
+			channelBreakers.Delete(key)
+		}
+		return true
+	})
+	return nil
+}
+
+// Redis 中的熔断状态，多个实例共享
+// channel_breaker:{channelId}:{model} 保存状态，channel_breaker_stats:{channelId}:{bucket}:{model} 保存统计桶
+
+func breakerStateKey(channelId int, model string) string {
+	return fmt.Sprintf("channel_breaker:%d:%s", channelId, model)
+}
+
+func breakerProbeKey(channelId int, model string) string {
+	return fmt.Sprintf("channel_breaker_probe:%d:%s", channelId, model)
+}
+
+func breakerStatsKey(channelId int, model string, bucketStart int64) string {
+	return fmt.Sprintf("channel_breaker_stats:%d:%d:%s", channelId, bucketStart, model)
+}
+
+func redisGetBreakerState(channelId int, model string) (state string, openedAt int64, probeAt int64) {
+	values, err := common.RDB.HGetAll(context.Background(), breakerStateKey(channelId, model)).Result()
+	if err != nil || len(values) == 0 {
+		return BreakerStateClosed, 0, 0
+	}
+	openedAt, _ = strconv.ParseInt(values["opened_at"], 10, 64)
+	probeAt, _ = strconv.ParseInt(values["probe_at"], 10, 64)
+	return values["state"], openedAt, probeAt
+}
+
+// 多个实例同时到达冷却时间，只有抢到探测锁的请求被放行，状态判断和抢锁在一个脚本里完成
+// KEYS: 状态、探测锁；ARGV: 当前时间、熔断时长
+var breakerAcquireScript = redis.NewScript(`
+local state = redis.call('HGET', KEYS[1], 'state')
+if not state or state == 'closed' then
+	return 1
+end
+local now = tonumber(ARGV[1])
+local duration = tonumber(ARGV[2])
+if state == 'open' and now - tonumber(redis.call('HGET', KEYS[1], 'opened_at') or 0) < duration then
+	return 0
+end
+if state == 'half_open' and now - tonumber(redis.call('HGET', KEYS[1], 'probe_at') or 0) < duration then
+	return 0
+end
+if not redis.call('SET', KEYS[2], ARGV[1], 'NX', 'EX', duration) then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'state', 'half_open', 'probe_at', ARGV[1])
+return 1
+`)
+
+// 计入统计并按统计切换状态，在一个脚本里完成，避免并发的结果互相覆盖
+// KEYS: 状态、探测锁、窗口内的统计桶（第一个为当前桶）
+// ARGV: 失败数、延迟、统计桶有效期、当前时间、最少请求数、错误率阈值、延迟阈值
+// 返回切换后的状态及窗口内的请求数和失败数
+var breakerRecordScript = redis.NewScript(`
+local statsKey = KEYS[3]
+redis.call('HINCRBY', statsKey, 'requests', 1)
+redis.call('HINCRBY', statsKey, 'failures', ARGV[1])
+redis.call('HINCRBY', statsKey, 'latency', ARGV[2])
+redis.call('EXPIRE', statsKey, ARGV[3])
+local state = redis.call('HGET', KEYS[1], 'state')
+if state == 'half_open' then
+	redis.call('DEL', KEYS[2])
+	if tonumber(ARGV[1]) == 0 then
+		redis.call('DEL', KEYS[1])
+		for i = 3, #KEYS do
+			redis.call('DEL', KEYS[i])
+		end
+		return {'closed', 0, 0}
+	end
+	redis.call('HSET', KEYS[1], 'state', 'open', 'opened_at', ARGV[4])
+	return {'open', 0, 0}
+end
+if state and state ~= 'closed' then
+	return {state, 0, 0}
+end
+local requests, failures, latency = 0, 0, 0
+for i = 3, #KEYS do
+	local values = redis.call('HMGET', KEYS[i], 'requests', 'failures', 'latency')
+	requests = requests + (tonumber(values[1]) or 0)
+	failures = failures + (tonumber(values[2]) or 0)
+	latency = latency + (tonumber(values[3]) or 0)
+end
+if requests <= 0 or requests < tonumber(ARGV[5]) then
+	return {'closed', requests, failures}
+end
+local latencyThreshold = tonumber(ARGV[7])
+if failures / requests >= tonumber(ARGV[6]) or (latencyThreshold > 0 and math.floor(latency / requests) >= latencyThreshold) then
+	redis.call('HSET', KEYS[1], 'state', 'open', 'opened_at', ARGV[4])
+	return {'tripped', requests, failures}
+end
+return {'closed', requests, failures}
+`)
+
+func redisAcquireBreaker(channelId int, model string, now int64) bool {
+	keys := []string{breakerStateKey(channelId, model), breakerProbeKey(channelId, model)}
+	allowed, err := breakerAcquireScript.Run(context.Background(), common.RDB, keys, now, breakerOpenDuration()).Int()
+	if err != nil {
+		common.SysError("failed to acquire channel breaker: " + err.Error())
+		return false
+	}
+	return allowed == 1
+}
+
+func redisRecordChannelResult(channelId int, model string, failures int64, latency int64, now int64) {
+	bucketSize := breakerBucketSize()
+	windowStart := now - int64(config.CircuitBreakerWindow)
+	keys := []string{breakerStateKey(channelId, model), breakerProbeKey(channelId, model)}
+	for start := now - now%bucketSize; start > windowStart || len(keys) == 2; start -= bucketSize {
+		keys = append(keys, breakerStatsKey(channelId, model, start))
+	}
+	result, err := breakerRecordScript.Run(context.Background(), common.RDB, keys, failures, latency,
+		config.CircuitBreakerWindow+int(bucketSize), now, config.CircuitBreakerMinRequests,
+		config.CircuitBreakerErrorRate, config.CircuitBreakerLatencyThreshold).Slice()
+	if err != nil {
+		common.SysError("failed to record channel breaker result: " + err.Error())
+		return
+	}
+	if len(result) == 3 && result[0] == "tripped" {
+		common.SysLog(fmt.Sprintf("渠道 #%d 模型 %s 熔断，窗口内请求 %d 次，失败 %d 次", channelId, model, result[1], result[2]))
+	}
+}
+
+func redisWindowStats(channelId int, model string, now int64) (requests int64, failures int64, latency int64) {
+	ctx := context.Background()
+	bucketSize := breakerBucketSize()
+	windowStart := now - int64(config.CircuitBreakerWindow)
+	pipe := common.RDB.Pipeline()
+	var results []*redis.StringStringMapCmd
+	for start := now - now%bucketSize; start > windowStart; start -= bucketSize {
+		results = append(results, pipe.HGetAll(ctx, breakerStatsKey(channelId, model, start)))
+	}
+	_, _ = pipe.Exec(ctx)
+	for _, result := range results {
+		values := result.Val()
+		r, _ := strconv.ParseInt(values["requests"], 10, 64)
+		f, _ := strconv.ParseInt(values["failures"], 10, 64)
+		l, _ := strconv.ParseInt(values["latency"], 10, 64)
+		requests += r
+		failures += f
+		latency += l
+	}
+	return requests, failures, latency
+}
+
+func redisScanKeys(pattern string) []string {
+	ctx := context.Background()
+	var keys []string
+	iter := common.RDB.Scan(ctx, 0, pattern, 1000).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys
+}
+
+func redisDeleteBreakerStats(channelId int, model string) {
+	keys := redisScanKeys(fmt.Sprintf("channel_breaker_stats:%d:*", channelId))
+	for _, key := range keys {
+		parts := strings.SplitN(key, ":", 4)
+		if len(parts) == 4 && (model == "" || parts[3] == model) {
+			common.RDB.Del(context.Background(), key)
+		}
+	}
+}
+
+func redisGetChannelBreakerStatuses(now int64) []*ChannelBreakerStatus {
+	seen := make(map[ChannelModelKey]struct{})
+	for _, key := range redisScanKeys("channel_breaker:*") {
+		parts := strings.SplitN(key, ":", 3)
+		if len(parts) == 3 {
+			channelId, _ := strconv.Atoi(parts[1])
+			seen[ChannelModelKey{ChannelID: channelId, Model: parts[2]}] = struct{}{}
+		}
+	}
+	for _, key := range redisScanKeys("channel_breaker_stats:*") {
+		parts := strings.SplitN(key, ":", 4)
+		if len(parts) == 4 {
+			channelId, _ := strconv.Atoi(parts[1])
+			seen[ChannelModelKey{ChannelID: channelId, Model: parts[3]}] = struct{}{}
+		}
+	}
+	var statuses []*ChannelBreakerStatus
+	for key := range seen {
+		state, openedAt, _ := redisGetBreakerState(key.ChannelID, key.Model)
+		requests, failures, latency := redisWindowStats(key.ChannelID, key.Model, now)
+		status := newChannelBreakerStatus(key.ChannelID, key.Model, state, openedAt, requests, failures, latency)
+		if status.Requests > 0 || status.State != BreakerStateClosed {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+func redisResetChannelBreaker(channelId int, model string) error {
+	ctx := context.Background()
+	pattern := fmt.Sprintf("%d:*", channelId)
+	if model != "" {
+		pattern = fmt.Sprintf("%d:%s", channelId, model)
+	}
+	for _, prefix := range []string{"channel_breaker:", "channel_breaker_probe:"} {
+		for _, key := range redisScanKeys(prefix + pattern) {
+			if err := common.RDB.Del(ctx, key).Err(); err != nil {
+				return err
+			}
+		}
+	}
+	redisDeleteBreakerStats(channelId, model)
+	return nil
+}
@@ -0,0 +1,132 @@
+package model
+
+import (
+	"one-api/common/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupBreakerTest(t *testing.T) {
+	setupTestState(t)
+	setGlobal(t, &config.CircuitBreakerEnabled, true)
+	setGlobal(t, &config.CircuitBreakerWindow, 60)
+	setGlobal(t, &config.CircuitBreakerMinRequests, 4)
+	setGlobal(t, &config.CircuitBreakerErrorRate, 0.5)
+	setGlobal(t, &config.CircuitBreakerOpenDuration, 30)
+	setGlobal(t, &config.CircuitBreakerLatencyThreshold, 0)
+}
+
+// expireBreakerCooldown moves the breaker back in time as if the open duration had passed.
+func expireBreakerCooldown(channelId int, model string) {
+	breaker := getChannelBreaker(channelId, model)
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	breaker.openedAt -= int64(config.CircuitBreakerOpenDuration)
+	breaker.probeAt -= int64(config.CircuitBreakerOpenDuration)
+}
+
+func breakerState(channelId int, model string) string {
+	breaker := getChannelBreaker(channelId, model)
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	return breaker.state
+}
+
+func tripBreaker(channelId int, model string) {
+	for i := 0; i < config.CircuitBreakerMinRequests; i++ {
+		RecordChannelResult(channelId, model, false, time.Millisecond)
+	}
+}
+
+func TestChannelBreakerTrips(t *testing.T) {
+	setupBreakerTest(t)
+	RecordChannelResult(1, "gpt-4o", false, time.Millisecond)
+	RecordChannelResult(1, "gpt-4o", false, time.Millisecond)
+	RecordChannelResult(1, "gpt-4o", true, time.Millisecond)
+	assert.Equal(t, BreakerStateClosed, breakerState(1, "gpt-4o"), "below the minimum requests")
+	RecordChannelResult(1, "gpt-4o", true, time.Millisecond)
+	assert.Equal(t, BreakerStateOpen, breakerState(1, "gpt-4o"), "half of the requests failed")
+	assert.False(t, ChannelBreakerAvailable(1, "gpt-4o"))
+	assert.False(t, AcquireChannelBreaker(1, "gpt-4o"))
+	assert.True(t, ChannelBreakerAvailable(1, "gpt-4o-mini"), "breakers are kept per model")
+	assert.True(t, ChannelBreakerAvailable(2, "gpt-4o"), "breakers are kept per channel")
+}
+
+func TestChannelBreakerTripsOnLatency(t *testing.T) {
+	setupBreakerTest(t)
+	config.CircuitBreakerLatencyThreshold = 1000
+	for i := 0; i < 4; i++ {
+		RecordChannelResult(1, "gpt-4o", true, 2*time.Second)
+	}
+	assert.Equal(t, BreakerStateOpen, breakerState(1, "gpt-4o"))
+}
+
+func TestChannelBreakerAvailableDoesNotTakeProbe(t *testing.T) {
+	setupBreakerTest(t)
+	tripBreaker(1, "gpt-4o")
+	expireBreakerCooldown(1, "gpt-4o")
+	for i := 0; i < 3; i++ {
+		assert.True(t, ChannelBreakerAvailable(1, "gpt-4o"))
+	}
+	assert.Equal(t, BreakerStateOpen, breakerState(1, "gpt-4o"), "filtering must not change the state")
+
+	assert.True(t, AcquireChannelBreaker(1, "gpt-4o"), "the chosen request becomes the probe")
+	assert.Equal(t, BreakerStateHalfOpen, breakerState(1, "gpt-4o"))
+	assert.False(t, ChannelBreakerAvailable(1, "gpt-4o"), "only one probe at a time")
+	assert.False(t, AcquireChannelBreaker(1, "gpt-4o"))
+}
+
+func TestChannelBreakerProbeSucceeds(t *testing.T) {
+	setupBreakerTest(t)
+	tripBreaker(1, "gpt-4o")
+	expireBreakerCooldown(1, "gpt-4o")
+	assert.True(t, AcquireChannelBreaker(1, "gpt-4o"))
+	RecordChannelResult(1, "gpt-4o", true, time.Millisecond)
+	assert.Equal(t, BreakerStateClosed, breakerState(1, "gpt-4o"))
+	assert.True(t, ChannelBreakerAvailable(1, "gpt-4o"))
+	// 关闭时清空了窗口内的统计，一次失败不会再次熔断
+	RecordChannelResult(1, "gpt-4o", false, time.Millisecond)
+	assert.Equal(t, BreakerStateClosed, breakerState(1, "gpt-4o"))
+}
+
+func TestChannelBreakerProbeFails(t *testing.T) {
+	setupBreakerTest(t)
+	tripBreaker(1, "gpt-4o")
+	expireBreakerCooldown(1, "gpt-4o")
+	assert.True(t, AcquireChannelBreaker(1, "gpt-4o"))
+	RecordChannelResult(1, "gpt-4o", false, time.Millisecond)
+	assert.Equal(t, BreakerStateOpen, breakerState(1, "gpt-4o"))
+	assert.False(t, ChannelBreakerAvailable(1, "gpt-4o"), "the cooldown starts over")
+}
+
+func TestChannelBreakerProbeTimesOut(t *testing.T) {
+	setupBreakerTest(t)
+	tripBreaker(1, "gpt-4o")
+	expireBreakerCooldown(1, "gpt-4o")
+	assert.True(t, AcquireChannelBreaker(1, "gpt-4o"))
+	// 探测请求一直没有结果，超时后允许重新探测
+	expireBreakerCooldown(1, "gpt-4o")
+	assert.True(t, ChannelBreakerAvailable(1, "gpt-4o"))
+	assert.True(t, AcquireChannelBreaker(1, "gpt-4o"))
+}
+
+func TestChannelBreakerDisabled(t *testing.T) {
+	setupBreakerTest(t)
+	tripBreaker(1, "gpt-4o")
+	config.CircuitBreakerEnabled = false
+	assert.True(t, ChannelBreakerAvailable(1, "gpt-4o"))
+	assert.True(t, AcquireChannelBreaker(1, "gpt-4o"))
+}
+
+func TestResetChannelBreaker(t *testing.T) {
+	setupBreakerTest(t)
+	tripBreaker(1, "gpt-4o")
+	tripBreaker(1, "gpt-4o-mini")
+	assert.NoError(t, ResetChannelBreaker(1, "gpt-4o"))
+	assert.True(t, ChannelBreakerAvailable(1, "gpt-4o"))
+	assert.False(t, ChannelBreakerAvailable(1, "gpt-4o-mini"))
+	assert.NoError(t, ResetChannelBreaker(1, ""))
+	assert.True(t, ChannelBreakerAvailable(1, "gpt-4o-mini"))
+}
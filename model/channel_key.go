@@ -0,0 +1,327 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"one-api/common"
+	"one-api/common/config"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 多密钥渠道的密钥轮换方式
+const (
+	KeyRotationRoundRobin = "round_robin"
+	KeyRotationRandom     = "random"
+)
+
+// 密钥状态缓存的有效期，其他实例禁用的密钥最迟在该时间后生效
+const channelKeyCacheTTL = 10 * time.Second
+
+// ChannelKey 记录多密钥渠道中每个密钥的状态和用量，按密钥哈希对应，调整密钥顺序不影响记录
+type ChannelKey struct {
+	Id             int    `json:"id"`
+	ChannelId      int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_key"`
+	KeyHash        string `json:"key_hash" gorm:"type:varchar(64);uniqueIndex:idx_channel_key"`
+	Status         int    `json:"status" gorm:"default:1"`
+	DisabledReason string `json:"disabled_reason" gorm:"type:text"`
+	DisabledTime   int64  `json:"disabled_time" gorm:"bigint"`
+	UsedQuota      int64  `json:"used_quota" gorm:"bigint;default:0"`
+	UsedCount      int    `json:"used_count" gorm:"default:0"`
+	LastUsedTime   int64  `json:"last_used_time" gorm:"bigint"`
+}
+
+// ChannelKeyInfo is what the admin API shows of a key, the key itself is masked.
+type ChannelKeyInfo struct {
+	ChannelKey
+	Index int    `json:"index"`
+	Key   string `json:"key"`
+}
+
+// ChannelKeyRef identifies the key a request was sent with, it travels in the request context.
+type ChannelKeyRef struct {
+	ChannelId int
+	KeyId     int
+}
+
+type channelKeyCacheEntry struct {
+	loadedAt time.Time
+	keys     map[string]*ChannelKey // key hash -> state
+}
+
+var channelKeyCache sync.Map    // channel id -> *channelKeyCacheEntry
+var channelKeyCounters sync.Map // channel id -> *atomic.Uint64
+
+func HashChannelKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func MaskChannelKey(key string) string {
+	if len(key) <= 12 {
+		return strings.Repeat("*", len(key))
+	}
+	return key[:6] + strings.Repeat("*", 6) + key[len(key)-4:]
+}
+
+func (channel *Channel) IsMultiKey() bool {
+	cfg, err := channel.LoadConfig()
+	return err == nil && cfg.KeyRotation != ""
+}
+
+// GetKeys returns the key pool of a multi-key channel, one key per line.
+func (channel *Channel) GetKeys() []string {
+	if !channel.IsMultiKey() {
+		return []string{channel.Key}
+	}
+	var keys []string
+	for _, key := range strings.Split(channel.Key, "\n") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// SyncChannelKeys creates the state records of new keys and removes those of keys no longer in the pool.
+func SyncChannelKeys(channel *Channel) error {
+	channelKeyCache.Delete(channel.Id)
+	if !channel.IsMultiKey() {
+		return DeleteChannelKeys(channel.Id)
+	}
+	var existing []ChannelKey
+	err := DB.Where("channel_id = ?", channel.Id).Find(&existing).Error
+	if err != nil {
+		return err
+	}
+	hashes := make(map[string]bool)
+	for _, key := range channel.GetKeys() {
+		hashes[HashChannelKey(key)] = true
+	}
+	for _, key := range existing {
+		if !hashes[key.KeyHash] {
+			err = DB.Delete(&ChannelKey{}, key.Id).Error
+			if err != nil {
+				return err
+			}
+		}
+		delete(hashes, key.KeyHash)
+	}
+	for hash := range hashes {
+		err = DB.Create(&ChannelKey{ChannelId: channel.Id, KeyHash: hash, Status: common.ChannelStatusEnabled}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func DeleteChannelKeys(channelId int) error {
+	channelKeyCache.Delete(channelId)
+	return DB.Where("channel_id = ?", channelId).Delete(&ChannelKey{}).Error
+}
+
+func getChannelKeyStates(channelId int) map[string]*ChannelKey {
+	if value, ok := channelKeyCache.Load(channelId); ok {
+		entry := value.(*channelKeyCacheEntry)
+		if time.Since(entry.loadedAt) < channelKeyCacheTTL {
+			return entry.keys
+		}
+	}
+	var keys []*ChannelKey
+	err := DB.Where("channel_id = ?", channelId).Find(&keys).Error
+	if err != nil {
+		common.SysError("failed to load channel keys: " + err.Error())
+	}
+	states := make(map[string]*ChannelKey, len(keys))
+	for _, key := range keys {
+		states[key.KeyHash] = key
+	}
+	channelKeyCache.Store(channelId, &channelKeyCacheEntry{loadedAt: time.Now(), keys: states})
+	return states
+}
+
+// SelectChannelKey picks the key for the next request on the channel, skipping disabled keys.
+// The returned id is 0 for single key channels.
+func SelectChannelKey(channel *Channel) (key string, keyId int) {
+	if !channel.IsMultiKey() {
+		return channel.Key, 0
+	}
+	keys := channel.GetKeys()
+	if len(keys) == 0 {
+		return "", 0
+	}
+	states := getChannelKeyStates(channel.Id)
+	var enabled []string
+	for _, key := range keys {
+		state, ok := states[HashChannelKey(key)]
+		if !ok || state.Status == common.ChannelStatusEnabled {
+			enabled = append(enabled, key)
+		}
+	}
+	// 所有密钥都已禁用时渠道也会被禁用，这里仍然返回一个密钥，不让请求因此失败
+	if len(enabled) == 0 {
+		enabled = keys
+	}
+	cfg, _ := channel.LoadConfig()
+	if cfg.KeyRotation == KeyRotationRandom {
+		key = enabled[rand.Intn(len(enabled))]
+	} else {
+		value, _ := channelKeyCounters.LoadOrStore(channel.Id, &atomic.Uint64{})
+		key = enabled[value.(*atomic.Uint64).Add(1)%uint64(len(enabled))]
+	}
+	if state, ok := states[HashChannelKey(key)]; ok {
+		keyId = state.Id
+	}
+	return key, keyId
+}
+
+// SelectPreferredChannelKey returns the key keyId while it's still in the pool and enabled, e.g. the key a
+// sticky session used before so the upstream prompt cache of the account is hit, and SelectChannelKey otherwise.
+func SelectPreferredChannelKey(channel *Channel, keyId int) (string, int) {
+	if keyId != 0 && channel.IsMultiKey() {
+		states := getChannelKeyStates(channel.Id)
+		for _, key := range channel.GetKeys() {
+			if state, ok := states[HashChannelKey(key)]; ok && state.Id == keyId && state.Status == common.ChannelStatusEnabled {
+				return key, keyId
+			}
+		}
+	}
+	return SelectChannelKey(channel)
+}
+
+// WithSelectedKey returns a copy of a multi-key channel carrying the key the next request would use, so
+// requests sent to the upstream outside the relay (tests, model lists, balances) use one key instead of the pool.
+func (channel *Channel) WithSelectedKey() *Channel {
+	if !channel.IsMultiKey() {
+		return channel
+	}
+	keyChannel := *channel
+	keyChannel.Key, _ = SelectChannelKey(channel)
+	return &keyChannel
+}
+
+// GetChannelKeyId returns the id of the state record of key, 0 when it has none.
+func GetChannelKeyId(channelId int, key string) int {
+	if state, ok := getChannelKeyStates(channelId)[HashChannelKey(key)]; ok {
+		return state.Id
+	}
+	return 0
+}
+
+// DisableChannelKey disables one key of a multi-key channel, allDisabled tells whether the channel has no usable key left.
+func DisableChannelKey(channelId int, keyId int, reason string) (allDisabled bool, err error) {
+	err = DB.Model(&ChannelKey{}).Where("id = ? and channel_id = ?", keyId, channelId).Updates(map[string]interface{}{
+		"status":          common.ChannelStatusAutoDisabled,
+		"disabled_reason": reason,
+		"disabled_time":   common.GetTimestamp(),
+	}).Error
+	if err != nil {
+		return false, err
+	}
+	channelKeyCache.Delete(channelId)
+	var enabledCount int64
+	err = DB.Model(&ChannelKey{}).Where("channel_id = ? and status = ?", channelId, common.ChannelStatusEnabled).Count(&enabledCount).Error
+	return enabledCount == 0, err
+}
+
+// UpdateChannelKeyStatus enables or disables a key by hand from the admin API.
+func UpdateChannelKeyStatus(channelId int, keyId int, status int) error {
+	updates := map[string]interface{}{"status": status}
+	if status == common.ChannelStatusEnabled {
+		updates["disabled_reason"] = ""
+		updates["disabled_time"] = 0
+	} else {
+		updates["disabled_reason"] = "手动禁用"
+		updates["disabled_time"] = common.GetTimestamp()
+	}
+	result := DB.Model(&ChannelKey{}).Where("id = ? and channel_id = ?", keyId, channelId).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("密钥不存在")
+	}
+	channelKeyCache.Delete(channelId)
+	return nil
+}
+
+// EnableChannelKey re-enables a key that was disabled automatically, e.g. after a successful channel test.
+func EnableChannelKey(channelId int, keyId int) {
+	err := DB.Model(&ChannelKey{}).Where("id = ? and channel_id = ? and status = ?", keyId, channelId, common.ChannelStatusAutoDisabled).
+		Updates(map[string]interface{}{"status": common.ChannelStatusEnabled, "disabled_reason": "", "disabled_time": 0}).Error
+	if err != nil {
+		common.SysError("failed to enable channel key: " + err.Error())
+	}
+	channelKeyCache.Delete(channelId)
+}
+
+// GetChannelKeyInfos lists the keys of a channel in pool order with their state and usage.
+func GetChannelKeyInfos(channel *Channel) ([]ChannelKeyInfo, error) {
+	if !channel.IsMultiKey() {
+		return nil, errors.New("该渠道未启用多密钥")
+	}
+	err := SyncChannelKeys(channel)
+	if err != nil {
+		return nil, err
+	}
+	states := getChannelKeyStates(channel.Id)
+	var infos []ChannelKeyInfo
+	for i, key := range channel.GetKeys() {
+		info := ChannelKeyInfo{Index: i, Key: MaskChannelKey(key)}
+		if state, ok := states[HashChannelKey(key)]; ok {
+			info.ChannelKey = *state
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func UpdateChannelKeyUsedQuota(keyId int, quota int) {
+	if keyId == 0 {
+		return
+	}
+	if config.BatchUpdateEnabled {
+		addNewRecord(BatchUpdateTypeChannelKeyUsedQuota, keyId, quota)
+		addNewRecord(BatchUpdateTypeChannelKeyRequestCount, keyId, 1)
+		return
+	}
+	updateChannelKeyUsedQuotaAndCount(keyId, quota, 1)
+}
+
+func updateChannelKeyUsedQuotaAndCount(keyId int, quota int, count int) {
+	err := DB.Model(&ChannelKey{}).Where("id = ?", keyId).
+		Updates(map[string]interface{}{
+			"used_quota":     gorm.Expr("used_quota + ?", quota),
+			"used_count":     gorm.Expr("used_count + ?", count),
+			"last_used_time": common.GetTimestamp(),
+		}).Error
+	if err != nil {
+		common.SysError("failed to update channel key used quota and count: " + err.Error())
+	}
+}
+
+func updateChannelKeyUsedQuota(keyId int, quota int) {
+	err := DB.Model(&ChannelKey{}).Where("id = ?", keyId).
+		Updates(map[string]interface{}{
+			"used_quota":     gorm.Expr("used_quota + ?", quota),
+			"last_used_time": common.GetTimestamp(),
+		}).Error
+	if err != nil {
+		common.SysError("failed to update channel key used quota: " + err.Error())
+	}
+}
+
+func updateChannelKeyRequestCount(keyId int, count int) {
+	err := DB.Model(&ChannelKey{}).Where("id = ?", keyId).Update("used_count", gorm.Expr("used_count + ?", count)).Error
+	if err != nil {
+		common.SysError("failed to update channel key request count: " + err.Error())
+	}
+}
@@ -0,0 +1,117 @@
+package model
+
+import (
+	"one-api/common"
+	"one-api/common/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupChannelKeyTest(t *testing.T, rotation string, keys string) *Channel {
+	setupTestDB(t, &ChannelKey{})
+	channel := &Channel{Id: 1, Key: keys, Config: `{"key_rotation":"` + rotation + `"}`}
+	if !assert.NoError(t, SyncChannelKeys(channel)) {
+		t.FailNow()
+	}
+	return channel
+}
+
+func TestGetKeys(t *testing.T) {
+	single := &Channel{Key: "sk-a\nsk-b"}
+	assert.Equal(t, []string{"sk-a\nsk-b"}, single.GetKeys(), "a single key channel keeps its key as is")
+	multi := &Channel{Key: " sk-a \n\nsk-b\n", Config: `{"key_rotation":"round_robin"}`}
+	assert.Equal(t, []string{"sk-a", "sk-b"}, multi.GetKeys())
+}
+
+func TestSelectChannelKeyRoundRobin(t *testing.T) {
+	channel := setupChannelKeyTest(t, KeyRotationRoundRobin, "sk-a\nsk-b\nsk-c")
+	var selected []string
+	for i := 0; i < 6; i++ {
+		key, keyId := SelectChannelKey(channel)
+		assert.NotZero(t, keyId)
+		assert.Equal(t, GetChannelKeyId(channel.Id, key), keyId)
+		selected = append(selected, key)
+	}
+	assert.Equal(t, []string{"sk-b", "sk-c", "sk-a", "sk-b", "sk-c", "sk-a"}, selected)
+}
+
+func TestSelectChannelKeySkipsDisabledKeys(t *testing.T) {
+	channel := setupChannelKeyTest(t, KeyRotationRandom, "sk-a\nsk-b\nsk-c")
+	allDisabled, err := DisableChannelKey(channel.Id, GetChannelKeyId(channel.Id, "sk-a"), "invalid key")
+	assert.NoError(t, err)
+	assert.False(t, allDisabled)
+	assert.NoError(t, UpdateChannelKeyStatus(channel.Id, GetChannelKeyId(channel.Id, "sk-b"), common.ChannelStatusManuallyDisabled))
+	for i := 0; i < 10; i++ {
+		key, _ := SelectChannelKey(channel)
+		assert.Equal(t, "sk-c", key)
+	}
+
+	allDisabled, err = DisableChannelKey(channel.Id, GetChannelKeyId(channel.Id, "sk-c"), "invalid key")
+	assert.NoError(t, err)
+	assert.True(t, allDisabled)
+	key, _ := SelectChannelKey(channel)
+	assert.NotEmpty(t, key, "a key is still returned when all are disabled")
+
+	EnableChannelKey(channel.Id, GetChannelKeyId(channel.Id, "sk-a"))
+	EnableChannelKey(channel.Id, GetChannelKeyId(channel.Id, "sk-b"))
+	for i := 0; i < 10; i++ {
+		key, _ := SelectChannelKey(channel)
+		assert.NotEqual(t, "sk-b", key, "a key disabled by hand is not enabled by tests")
+	}
+}
+
+func TestSyncChannelKeys(t *testing.T) {
+	channel := setupChannelKeyTest(t, KeyRotationRoundRobin, "sk-a\nsk-b")
+	keyId := GetChannelKeyId(channel.Id, "sk-b")
+	channel.Key = "sk-b\nsk-c"
+	assert.NoError(t, SyncChannelKeys(channel))
+	assert.Zero(t, GetChannelKeyId(channel.Id, "sk-a"))
+	assert.Equal(t, keyId, GetChannelKeyId(channel.Id, "sk-b"), "kept keys keep their records")
+	assert.NotZero(t, GetChannelKeyId(channel.Id, "sk-c"))
+}
+
+func TestWithSelectedKey(t *testing.T) {
+	channel := setupChannelKeyTest(t, KeyRotationRoundRobin, "sk-a\nsk-b")
+	keyChannel := channel.WithSelectedKey()
+	assert.Contains(t, []string{"sk-a", "sk-b"}, keyChannel.Key)
+	assert.Equal(t, "sk-a\nsk-b", channel.Key, "the channel itself is left unchanged")
+	single := &Channel{Key: "sk-a"}
+	assert.Same(t, single, single.WithSelectedKey())
+}
+
+func TestChannelKeyUsageBatchUpdate(t *testing.T) {
+	channel := setupChannelKeyTest(t, KeyRotationRoundRobin, "sk-a")
+	keyId := GetChannelKeyId(channel.Id, "sk-a")
+
+	UpdateChannelKeyUsedQuota(keyId, 100)
+	config.BatchUpdateEnabled = true
+	for i := 0; i < 3; i++ {
+		UpdateChannelKeyUsedQuota(keyId, 10)
+	}
+	batchUpdate()
+
+	var key ChannelKey
+	assert.NoError(t, DB.First(&key, keyId).Error)
+	assert.EqualValues(t, 130, key.UsedQuota)
+	assert.Equal(t, 4, key.UsedCount, "every request is counted, not every flush")
+}
+
+func TestSelectPreferredChannelKey(t *testing.T) {
+	channel := setupChannelKeyTest(t, KeyRotationRoundRobin, "sk-a\nsk-b\nsk-c")
+	keyId := GetChannelKeyId(channel.Id, "sk-c")
+	for i := 0; i < 3; i++ {
+		key, id := SelectPreferredChannelKey(channel, keyId)
+		assert.Equal(t, "sk-c", key)
+		assert.Equal(t, keyId, id)
+	}
+
+	_, err := DisableChannelKey(channel.Id, keyId, "invalid key")
+	assert.NoError(t, err)
+	key, id := SelectPreferredChannelKey(channel, keyId)
+	assert.NotEqual(t, "sk-c", key, "a disabled key is not reused")
+	assert.Equal(t, GetChannelKeyId(channel.Id, key), id)
+
+	key, _ = SelectPreferredChannelKey(channel, 12345)
+	assert.NotEqual(t, "sk-c", key, "a key no longer in the pool falls back to rotation")
+}
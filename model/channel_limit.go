@@ -0,0 +1,213 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"one-api/common"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 渠道限流计数，启用 Redis 时多个实例共享
+// RPM、TPM 按自然分钟计数，并发数在请求结束时释放
+
+const (
+	channelLimitWindowTTL      = 2 * time.Minute
+	channelLimitConcurrencyTTL = 10 * time.Minute // 实例异常退出时未释放的并发数最终过期
+)
+
+type limitCounter struct {
+	value    int64
+	expireAt time.Time
+}
+
+var channelLimitCounters = struct {
+	sync.Mutex
+	items map[string]*limitCounter
+}{items: make(map[string]*limitCounter)}
+
+func channelLimitMinute() int64 {
+	return time.Now().Unix() / 60
+}
+
+func channelRPMKey(channelId int, model string) string {
+	return fmt.Sprintf("channel_limit_rpm:%d:%d:%s", channelId, channelLimitMinute(), model)
+}
+
+func channelTPMKey(channelId int, model string) string {
+	return fmt.Sprintf("channel_limit_tpm:%d:%d:%s", channelId, channelLimitMinute(), model)
+}
+
+func channelConcurrencyKey(channelId int, model string) string {
+	return fmt.Sprintf("channel_limit_concurrency:%d:%s", channelId, model)
+}
+
+// limitCounterAdd adds delta to the counter, when limit > 0 the counter is left unchanged
+// and false returned if it would go over the limit.
+func limitCounterAdd(key string, delta int64, limit int64, ttl time.Duration) bool {
+	if common.RedisEnabled {
+		ctx := context.Background()
+		value, err := common.RDB.IncrBy(ctx, key, delta).Result()
+		if err != nil {
+			common.SysError("failed to update channel limit counter: " + err.Error())
+			return true
+		}
+		common.RDB.Expire(ctx, key, ttl)
+		if limit > 0 && delta > 0 && value > limit {
+			common.RDB.DecrBy(ctx, key, delta)
+			return false
+		}
+		return true
+	}
+	channelLimitCounters.Lock()
+	defer channelLimitCounters.Unlock()
+	now := time.Now()
+	counter, ok := channelLimitCounters.items[key]
+	if !ok || now.After(counter.expireAt) {
+		// 顺带清理过期的计数
+		for k, item := range channelLimitCounters.items {
+			if now.After(item.expireAt) {
+				delete(channelLimitCounters.items, k)
+			}
+		}
+		counter = &limitCounter{}
+		channelLimitCounters.items[key] = counter
+	}
+	if limit > 0 && delta > 0 && counter.value+delta > limit {
+		return false
+	}
+	counter.value += delta
+	if counter.value < 0 {
+		counter.value = 0
+	}
+	counter.expireAt = now.Add(ttl)
+	return true
+}
+
+type channelLimitScope struct {
+	model string
+	limit ChannelLimit
+}
+
+func getChannelLimitScopes(channel *Channel, model string) []channelLimitScope {
+	cfg, err := channel.LoadConfig()
+	if err != nil {
+		return nil
+	}
+	var scopes []channelLimitScope
+	if cfg.ChannelLimit != (ChannelLimit{}) {
+		scopes = append(scopes, channelLimitScope{limit: cfg.ChannelLimit})
+	}
+	if limit, ok := cfg.ModelLimits[model]; ok && limit != (ChannelLimit{}) {
+		scopes = append(scopes, channelLimitScope{model: model, limit: limit})
+	}
+	return scopes
+}
+
+type tpmReservation struct {
+	key    string
+	tokens int64
+}
+
+// ChannelLimitLease is what a request took from the limits of its channel: a concurrent stream, and an
+// estimate of its tokens reserved against the TPM limits until the tokens it used are known.
+type ChannelLimitLease struct {
+	releases     []func()
+	reservations []tpmReservation
+	settled      atomic.Bool
+	once         sync.Once
+}
+
+// Release gives the concurrent stream back once the request is done, and the reserved tokens when the
+// request ended without recording its tokens.
+func (lease *ChannelLimitLease) Release() {
+	if lease == nil {
+		return
+	}
+	lease.once.Do(func() {
+		for _, f := range lease.releases {
+			f()
+		}
+		lease.settle(0)
+	})
+}
+
+// settle replaces the reserved estimate with the tokens the request used, it returns false when the lease
+// was settled before.
+func (lease *ChannelLimitLease) settle(tokens int64) bool {
+	if lease == nil || !lease.settled.CompareAndSwap(false, true) {
+		return false
+	}
+	for _, reservation := range lease.reservations {
+		limitCounterAdd(reservation.key, tokens-reservation.tokens, 0, channelLimitWindowTTL)
+	}
+	return true
+}
+
+// AcquireChannelLimit takes one request, and one concurrent stream for stream requests, from the
+// limits of the channel, and reserves estimatedTokens against its TPM limits in the same step. It fails
+// when the channel is saturated, the returned lease is released once the request is done.
+func AcquireChannelLimit(channel *Channel, model string, stream bool, estimatedTokens int) (*ChannelLimitLease, bool) {
+	var rollbacks []func()
+	rollback := func() {
+		for _, f := range rollbacks {
+			f()
+		}
+	}
+	lease := &ChannelLimitLease{}
+	for _, scope := range getChannelLimitScopes(channel, model) {
+		limit := scope.limit
+		if limit.TPM > 0 {
+			// 预占的 token 数不超过限额，单个大请求在空闲时仍可通过
+			key := channelTPMKey(channel.Id, scope.model)
+			tokens := int64(min(max(estimatedTokens, 1), limit.TPM))
+			if !limitCounterAdd(key, tokens, int64(limit.TPM), channelLimitWindowTTL) {
+				rollback()
+				return nil, false
+			}
+			rollbacks = append(rollbacks, func() { limitCounterAdd(key, -tokens, 0, channelLimitWindowTTL) })
+			lease.reservations = append(lease.reservations, tpmReservation{key: key, tokens: tokens})
+		}
+		if limit.RPM > 0 {
+			key := channelRPMKey(channel.Id, scope.model)
+			if !limitCounterAdd(key, 1, int64(limit.RPM), channelLimitWindowTTL) {
+				rollback()
+				return nil, false
+			}
+			rollbacks = append(rollbacks, func() { limitCounterAdd(key, -1, 0, channelLimitWindowTTL) })
+		}
+		if stream && limit.MaxConcurrency > 0 {
+			key := channelConcurrencyKey(channel.Id, scope.model)
+			if !limitCounterAdd(key, 1, int64(limit.MaxConcurrency), channelLimitConcurrencyTTL) {
+				rollback()
+				return nil, false
+			}
+			decrease := func() { limitCounterAdd(key, -1, 0, channelLimitConcurrencyTTL) }
+			rollbacks = append(rollbacks, decrease)
+			lease.releases = append(lease.releases, decrease)
+		}
+	}
+	return lease, true
+}
+
+// RecordChannelTokens counts the tokens a request used against the TPM limits of the channel. The first
+// record of a request replaces the tokens reserved by its lease, later ones (e.g. the next response of a
+// realtime session) are added.
+func RecordChannelTokens(channelId int, model string, tokens int, lease *ChannelLimitLease) {
+	if lease.settle(int64(tokens)) {
+		return
+	}
+	if channelId == 0 || tokens <= 0 {
+		return
+	}
+	channel, err := CacheGetChannel(channelId)
+	if err != nil {
+		return
+	}
+	for _, scope := range getChannelLimitScopes(channel, model) {
+		if scope.limit.TPM > 0 {
+			limitCounterAdd(channelTPMKey(channelId, scope.model), int64(tokens), 0, channelLimitWindowTTL)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func limitCounterValue(key string) int64 {
+	channelLimitCounters.Lock()
+	defer channelLimitCounters.Unlock()
+	if counter, ok := channelLimitCounters.items[key]; ok {
+		return counter.value
+	}
+	return 0
+}
+
+func TestChannelLimitReservesTokens(t *testing.T) {
+	setupTestState(t)
+	channel := &Channel{Id: 1, Config: `{"tpm":1000}`}
+
+	first, ok := AcquireChannelLimit(channel, "gpt-4o", false, 600)
+	assert.True(t, ok)
+	key := first.reservations[0].key
+	assert.EqualValues(t, 600, limitCounterValue(key))
+
+	_, ok = AcquireChannelLimit(channel, "gpt-4o", false, 500)
+	assert.False(t, ok, "the reservation would go over the limit")
+	assert.EqualValues(t, 600, limitCounterValue(key), "a refused request reserves nothing")
+
+	second, ok := AcquireChannelLimit(channel, "gpt-4o", false, 400)
+	assert.True(t, ok)
+	assert.EqualValues(t, 1000, limitCounterValue(key))
+
+	RecordChannelTokens(channel.Id, "gpt-4o", 100, first)
+	assert.EqualValues(t, 500, limitCounterValue(key), "the estimate is replaced with the used tokens")
+	first.Release()
+	assert.EqualValues(t, 500, limitCounterValue(key), "a settled lease keeps its tokens")
+
+	second.Release()
+	assert.EqualValues(t, 100, limitCounterValue(key), "an unsettled reservation is returned")
+	second.Release()
+	assert.EqualValues(t, 100, limitCounterValue(key))
+}
+
+func TestChannelLimitReservationCappedAtLimit(t *testing.T) {
+	setupTestState(t)
+	channel := &Channel{Id: 1, Config: `{"tpm":1000}`}
+
+	lease, ok := AcquireChannelLimit(channel, "gpt-4o", false, 5000)
+	assert.True(t, ok, "a large request still passes an idle channel")
+	assert.EqualValues(t, 1000, limitCounterValue(lease.reservations[0].key))
+	_, ok = AcquireChannelLimit(channel, "gpt-4o", false, 0)
+	assert.False(t, ok)
+}
+
+func TestChannelLimitRollsBackScopes(t *testing.T) {
+	setupTestState(t)
+	channel := &Channel{Id: 1, Config: `{"tpm":1000,"model_limits":{"gpt-4o":{"max_concurrency":1}}}`}
+
+	lease, ok := AcquireChannelLimit(channel, "gpt-4o", true, 100)
+	assert.True(t, ok)
+	key := lease.reservations[0].key
+	_, ok = AcquireChannelLimit(channel, "gpt-4o", true, 100)
+	assert.False(t, ok, "the model allows one concurrent stream")
+	assert.EqualValues(t, 100, limitCounterValue(key), "the channel reservation is rolled back")
+
+	lease.Release()
+	assert.EqualValues(t, 0, limitCounterValue(key))
+	_, ok = AcquireChannelLimit(channel, "gpt-4o", true, 100)
+	assert.True(t, ok)
+}
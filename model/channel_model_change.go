@@ -0,0 +1,43 @@
+package model
+
+import (
+	"one-api/common"
+	"strings"
+)
+
+// ChannelModelChange records a difference found between the models of a channel and those listed by its upstream.
+type ChannelModelChange struct {
+	Id          int    `json:"id"`
+	ChannelId   int    `json:"channel_id" gorm:"index"`
+	ChannelName string `json:"channel_name"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint;index"`
+	Added       string `json:"added" gorm:"type:text"`   // 上游新增的模型，逗号分隔
+	Removed     string `json:"removed" gorm:"type:text"` // 上游已下架的模型，逗号分隔
+	Applied     bool   `json:"applied"`                  // 是否已更新到渠道的模型列表
+}
+
+func (change *ChannelModelChange) Insert() error {
+	change.CreatedTime = common.GetTimestamp()
+	return DB.Create(change).Error
+}
+
+// GetChannelModelChanges lists the recorded changes, of one channel when channelId isn't 0, newest first.
+func GetChannelModelChanges(channelId int, startIdx int, num int) ([]*ChannelModelChange, error) {
+	var changes []*ChannelModelChange
+	query := DB.Order("id desc")
+	if channelId != 0 {
+		query = query.Where("channel_id = ?", channelId)
+	}
+	err := query.Limit(num).Offset(startIdx).Find(&changes).Error
+	return changes, err
+}
+
+// UpdateChannelModels replaces the model list of the channel and rebuilds its abilities.
+func UpdateChannelModels(channel *Channel, models []string) error {
+	channel.Models = strings.Join(models, ",")
+	err := DB.Model(channel).Update("models", channel.Models).Error
+	if err != nil {
+		return err
+	}
+	return channel.UpdateAbilities()
+}
@@ -0,0 +1,152 @@
+package model
+
+import (
+	"fmt"
+	"math/rand"
+	"one-api/common"
+	"one-api/common/config"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 渠道选择策略用到的运行时统计，只在本实例内存中维护
+
+type channelLatency struct {
+	mutex sync.Mutex
+	ewma  float64 // 毫秒
+}
+
+const (
+	// 没有统计数据也没有测速结果的渠道按此延迟参与排序，避免总是被优先选中
+	channelLatencyUnsampled = 3 * time.Second
+	// 失败按此延迟计入统计，连续失败的渠道排到后面
+	channelLatencyFailurePenalty = 30 * time.Second
+)
+
+var channelLatencies sync.Map   // ChannelModelKey -> *channelLatency
+var channelInflights sync.Map   // channel id -> *atomic.Int64
+var roundRobinCounters sync.Map // group:model:priority -> *atomic.Uint64
+
+// RecordChannelLatency folds the time to first token of a successful relay into the latency EWMA of the channel and model.
+func RecordChannelLatency(channelId int, model string, latency time.Duration) {
+	if channelId == 0 {
+		return
+	}
+	value, _ := channelLatencies.LoadOrStore(ChannelModelKey{ChannelID: channelId, Model: model}, &channelLatency{})
+	stat := value.(*channelLatency)
+	alpha := config.RoutingLatencyEWMAAlpha
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.3
+	}
+	stat.mutex.Lock()
+	defer stat.mutex.Unlock()
+	milliseconds := float64(latency.Milliseconds())
+	if stat.ewma == 0 {
+		stat.ewma = milliseconds
+	} else {
+		stat.ewma = alpha*milliseconds + (1-alpha)*stat.ewma
+	}
+}
+
+// RecordChannelLatencyFailure folds a failed relay into the latency EWMA as a penalty.
+func RecordChannelLatencyFailure(channelId int, model string) {
+	RecordChannelLatency(channelId, model, channelLatencyFailurePenalty)
+}
+
+// getChannelLatency returns the latency EWMA, falling back to the response time of the last channel test
+// and then to channelLatencyUnsampled.
+func getChannelLatency(channel *Channel, model string) float64 {
+	if value, ok := channelLatencies.Load(ChannelModelKey{ChannelID: channel.Id, Model: model}); ok {
+		stat := value.(*channelLatency)
+		stat.mutex.Lock()
+		defer stat.mutex.Unlock()
+		return stat.ewma
+	}
+	if channel.ResponseTime > 0 {
+		return float64(channel.ResponseTime)
+	}
+	return float64(channelLatencyUnsampled.Milliseconds())
+}
+
+// StartChannelRequest counts a request in flight on the channel until the returned func is called.
+func StartChannelRequest(channelId int) (done func()) {
+	if channelId == 0 {
+		return func() {}
+	}
+	value, _ := channelInflights.LoadOrStore(channelId, &atomic.Int64{})
+	counter := value.(*atomic.Int64)
+	counter.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() { counter.Add(-1) })
+	}
+}
+
+func getChannelInflight(channelId int) int64 {
+	if value, ok := channelInflights.Load(channelId); ok {
+		return value.(*atomic.Int64).Load()
+	}
+	return 0
+}
+
+// getChannelCost estimates what serving model costs on the channel, from the ratios of the mapped model.
+func getChannelCost(channel *Channel, model string) float64 {
+	actualModel := model
+	if mapped, ok := channel.GetModelMapping()[model]; ok && mapped != "" {
+		actualModel = mapped
+	}
+	modelRatio := common.GetModelRatio(actualModel)
+	cost := modelRatio + modelRatio*common.GetCompletionRatio(actualModel)
+	cfg, _ := channel.LoadConfig()
+	if cfg.CostRatio > 0 {
+		cost *= cfg.CostRatio
+	}
+	return cost
+}
+
+// orderChannelsByStrategy returns the channels of one priority in the order the strategy prefers,
+// nil for the weighted random strategy.
+func orderChannelsByStrategy(strategy string, group string, model string, channels []*Channel) []*Channel {
+	if len(channels) == 0 || strategy == common.RoutingStrategyWeighted {
+		return nil
+	}
+	ordered := make([]*Channel, len(channels))
+	copy(ordered, channels)
+	switch strategy {
+	case common.RoutingStrategyRoundRobin:
+		sort.Slice(ordered, func(i, j int) bool { return ordered[i].Id < ordered[j].Id })
+		key := fmt.Sprintf("%s:%s:%d", group, model, ordered[0].GetPriority())
+		value, _ := roundRobinCounters.LoadOrStore(key, &atomic.Uint64{})
+		start := int(value.(*atomic.Uint64).Add(1) % uint64(len(ordered)))
+		return append(ordered[start:], ordered[:start]...)
+	case common.RoutingStrategyLatency, common.RoutingStrategyCost, common.RoutingStrategyLeastInflight:
+		scores := make(map[int]float64, len(ordered))
+		for _, channel := range ordered {
+			switch strategy {
+			case common.RoutingStrategyLatency:
+				scores[channel.Id] = getChannelLatency(channel, model)
+			case common.RoutingStrategyCost:
+				scores[channel.Id] = getChannelCost(channel, model)
+			default:
+				scores[channel.Id] = float64(getChannelInflight(channel.Id))
+			}
+		}
+		// 打乱后稳定排序，分数相同的渠道随机分担请求
+		rand.Shuffle(len(ordered), func(i, j int) { ordered[i], ordered[j] = ordered[j], ordered[i] })
+		sort.SliceStable(ordered, func(i, j int) bool { return scores[ordered[i].Id] < scores[ordered[j].Id] })
+		return ordered
+	}
+	return nil
+}
+
+// selectChannelByStrategy returns the first channel in strategy order whose circuit breaker is not open.
+func selectChannelByStrategy(strategy string, group string, model string, channels []*Channel) (*Channel, bool) {
+	for _, channel := range orderChannelsByStrategy(strategy, group, model, channels) {
+		if ChannelBreakerAvailable(channel.Id, model) {
+			return channel, true
+		}
+	}
+	return nil, false
+}
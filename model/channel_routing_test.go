@@ -0,0 +1,97 @@
+package model
+
+import (
+	"one-api/common"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func channelIds(channels []*Channel) []int {
+	var ids []int
+	for _, channel := range channels {
+		ids = append(ids, channel.Id)
+	}
+	return ids
+}
+
+func TestOrderChannelsByLatency(t *testing.T) {
+	setupTestState(t)
+	channels := []*Channel{{Id: 1}, {Id: 2}, {Id: 3, ResponseTime: 2000}}
+	RecordChannelLatency(1, "gpt-4o", 4*time.Second)
+	RecordChannelLatency(2, "gpt-4o", time.Second)
+	RecordChannelLatency(2, "gpt-4o-mini", 10*time.Second)
+
+	// 渠道 3 没有统计数据，按测速结果排序
+	assert.Equal(t, []int{2, 3, 1}, channelIds(orderChannelsByStrategy(common.RoutingStrategyLatency, "default", "gpt-4o", channels)))
+
+	// 没有统计数据也没有测速结果的渠道不会总是排在最前
+	channels = append(channels, &Channel{Id: 4})
+	assert.Equal(t, []int{2, 3, 4, 1}, channelIds(orderChannelsByStrategy(common.RoutingStrategyLatency, "default", "gpt-4o", channels)))
+}
+
+func TestOrderChannelsByLatencyPenalizesFailures(t *testing.T) {
+	setupTestState(t)
+	channels := []*Channel{{Id: 1}, {Id: 2}}
+	RecordChannelLatency(1, "gpt-4o", 500*time.Millisecond)
+	RecordChannelLatency(2, "gpt-4o", time.Second)
+	assert.Equal(t, []int{1, 2}, channelIds(orderChannelsByStrategy(common.RoutingStrategyLatency, "default", "gpt-4o", channels)))
+
+	RecordChannelLatencyFailure(1, "gpt-4o")
+	assert.Equal(t, []int{2, 1}, channelIds(orderChannelsByStrategy(common.RoutingStrategyLatency, "default", "gpt-4o", channels)))
+}
+
+func TestOrderChannelsByCost(t *testing.T) {
+	setupTestState(t)
+	mapping := `{"gpt-4o":"gpt-4o-mini"}`
+	channels := []*Channel{
+		{Id: 1, Config: `{"cost_ratio":2}`},
+		{Id: 2, ModelMapping: &mapping},
+		{Id: 3},
+	}
+	assert.Equal(t, []int{2, 3, 1}, channelIds(orderChannelsByStrategy(common.RoutingStrategyCost, "default", "gpt-4o", channels)))
+}
+
+func TestOrderChannelsByLeastInflight(t *testing.T) {
+	setupTestState(t)
+	channels := []*Channel{{Id: 1}, {Id: 2}}
+	done := StartChannelRequest(1)
+	assert.Equal(t, []int{2, 1}, channelIds(orderChannelsByStrategy(common.RoutingStrategyLeastInflight, "default", "gpt-4o", channels)))
+	done()
+	done()
+	assert.EqualValues(t, 0, getChannelInflight(1), "done is counted once")
+	StartChannelRequest(2)
+	assert.Equal(t, []int{1, 2}, channelIds(orderChannelsByStrategy(common.RoutingStrategyLeastInflight, "default", "gpt-4o", channels)))
+}
+
+func TestOrderChannelsByRoundRobin(t *testing.T) {
+	setupTestState(t)
+	channels := []*Channel{{Id: 3}, {Id: 1}, {Id: 2}}
+	assert.Equal(t, []int{2, 3, 1}, channelIds(orderChannelsByStrategy(common.RoutingStrategyRoundRobin, "default", "gpt-4o", channels)))
+	assert.Equal(t, []int{3, 1, 2}, channelIds(orderChannelsByStrategy(common.RoutingStrategyRoundRobin, "default", "gpt-4o", channels)))
+	assert.Equal(t, []int{1, 2, 3}, channelIds(orderChannelsByStrategy(common.RoutingStrategyRoundRobin, "default", "gpt-4o", channels)))
+	assert.Equal(t, []int{2, 3, 1}, channelIds(orderChannelsByStrategy(common.RoutingStrategyRoundRobin, "default", "gpt-4o-mini", channels)),
+		"each model keeps its own counter")
+	assert.Equal(t, []int{3, 1, 2}, channelIds(channels), "the input is left unchanged")
+}
+
+func TestOrderChannelsWeighted(t *testing.T) {
+	assert.Nil(t, orderChannelsByStrategy(common.RoutingStrategyWeighted, "default", "gpt-4o", []*Channel{{Id: 1}}))
+}
+
+func TestSelectChannelByStrategySkipsOpenBreakers(t *testing.T) {
+	setupTestState(t)
+	setupBreakerTest(t)
+	channels := []*Channel{{Id: 1}, {Id: 2}}
+	RecordChannelLatency(1, "gpt-4o", 500*time.Millisecond)
+	RecordChannelLatency(2, "gpt-4o", time.Second)
+	tripBreaker(1, "gpt-4o")
+	channel, ok := selectChannelByStrategy(common.RoutingStrategyLatency, "default", "gpt-4o", channels)
+	assert.True(t, ok)
+	assert.Equal(t, 2, channel.Id)
+
+	tripBreaker(2, "gpt-4o")
+	_, ok = selectChannelByStrategy(common.RoutingStrategyLatency, "default", "gpt-4o", channels)
+	assert.False(t, ok)
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"one-api/common"
+	"one-api/common/config"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 会话粘性路由：同一会话的请求尽量发往同一个渠道，以命中上游的提示词缓存
+
+const stickyChannelMaxItems = 100000
+
+type stickyChannelItem struct {
+	channelId int
+	keyId     int
+	expireAt  time.Time
+}
+
+var stickyChannels = struct {
+	sync.Mutex
+	items map[string]stickyChannelItem
+}{items: make(map[string]stickyChannelItem)}
+
+func stickyRoutingTTL() time.Duration {
+	if config.StickyRoutingTTL <= 0 {
+		return time.Hour
+	}
+	return time.Duration(config.StickyRoutingTTL) * time.Second
+}
+
+// GetStickyChannel returns the channel the session was last routed to, and the key of a multi-key
+// channel it used (0 for single key channels).
+func GetStickyChannel(key string) (channelId int, keyId int, ok bool) {
+	if common.RedisEnabled {
+		value, err := common.RedisGet(key)
+		if err != nil {
+			return 0, 0, false
+		}
+		// 值为 "渠道 id:密钥 id"，旧版本只保存渠道 id
+		channelValue, keyValue, _ := strings.Cut(value, ":")
+		channelId, err = strconv.Atoi(channelValue)
+		if err != nil {
+			return 0, 0, false
+		}
+		keyId, _ = strconv.Atoi(keyValue)
+		return channelId, keyId, true
+	}
+	stickyChannels.Lock()
+	defer stickyChannels.Unlock()
+	item, ok := stickyChannels.items[key]
+	if !ok || time.Now().After(item.expireAt) {
+		return 0, 0, false
+	}
+	return item.channelId, item.keyId, true
+}
+
+// SetStickyChannel binds the session to the channel and the key it used, every request of the session
+// renews the TTL.
+func SetStickyChannel(key string, channelId int, keyId int) {
+	if common.RedisEnabled {
+		err := common.RDB.Set(context.Background(), key, fmt.Sprintf("%d:%d", channelId, keyId), stickyRoutingTTL()).Err()
+		if err != nil {
+			common.SysError("failed to save sticky channel: " + err.Error())
+		}
+		return
+	}
+	stickyChannels.Lock()
+	defer stickyChannels.Unlock()
+	now := time.Now()
+	if len(stickyChannels.items) >= stickyChannelMaxItems {
+		for k, item := range stickyChannels.items {
+			if now.After(item.expireAt) {
+				delete(stickyChannels.items, k)
+			}
+		}
+		// 仍然已满时放弃新的绑定，已有会话不受影响
+		if _, ok := stickyChannels.items[key]; !ok && len(stickyChannels.items) >= stickyChannelMaxItems {
+			return
+		}
+	}
+	stickyChannels.items[key] = stickyChannelItem{channelId: channelId, keyId: keyId, expireAt: now.Add(stickyRoutingTTL())}
+}
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStickyChannel(t *testing.T) {
+	setupTestState(t)
+
+	_, _, ok := GetStickyChannel("sticky_channel:default:gpt-4o:a")
+	assert.False(t, ok)
+
+	SetStickyChannel("sticky_channel:default:gpt-4o:a", 3, 7)
+	channelId, keyId, ok := GetStickyChannel("sticky_channel:default:gpt-4o:a")
+	assert.True(t, ok)
+	assert.Equal(t, 3, channelId)
+	assert.Equal(t, 7, keyId)
+
+	SetStickyChannel("sticky_channel:default:gpt-4o:a", 4, 0)
+	channelId, keyId, _ = GetStickyChannel("sticky_channel:default:gpt-4o:a")
+	assert.Equal(t, 4, channelId, "the session follows the channel it was last routed to")
+	assert.Equal(t, 0, keyId)
+}
@@ -0,0 +1,60 @@
+package model
+
+import (
+	"errors"
+)
+
+const (
+	FileStatusUploaded  = "uploaded"
+	FileStatusProcessed = "processed"
+	FileStatusError     = "error"
+)
+
+// File records an uploaded file and where it lives.
+// ChannelId is 0 when the file is kept in local storage, otherwise it is the
+// upstream channel the file was uploaded to, so later requests referencing the
+// file can be sent to the same upstream.
+type File struct {
+	Id         int    `json:"id"`
+	FileId     string `json:"file_id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId     int    `json:"user_id" gorm:"index"`
+	TokenId    int    `json:"token_id" gorm:"index"`
+	ChannelId  int    `json:"channel_id" gorm:"index;default:0"`
+	Filename   string `json:"filename"`
+	Purpose    string `json:"purpose" gorm:"type:varchar(32);index"`
+	Bytes      int64  `json:"bytes"`
+	Status     string `json:"status" gorm:"type:varchar(32)"`
+	StorageKey string `json:"storage_key"`
+	CreatedAt  int64  `json:"created_at" gorm:"bigint"`
+}
+
+func GetUserFiles(userId int, purpose string, order string, afterId int, num int) ([]*File, error) {
+	var files []*File
+	query := DB.Where("user_id = ?", userId)
+	if purpose != "" {
+		query = query.Where("purpose = ?", purpose)
+	}
+	err := paginate(query, order, afterId, num).Find(&files).Error
+	return files, err
+}
+
+func GetUserFile(userId int, fileId string) (*File, error) {
+	if userId == 0 || fileId == "" {
+		return nil, errors.New("userId 或 fileId 为空！")
+	}
+	var file File
+	err := DB.Where("user_id = ? and file_id = ?", userId, fileId).First(&file).Error
+	return &file, err
+}
+
+func (file *File) Insert() error {
+	return DB.Create(file).Error
+}
+
+func (file *File) Update() error {
+	return DB.Save(file).Error
+}
+
+func (file *File) Delete() error {
+	return DB.Delete(file).Error
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fileIds(files []*File) []string {
+	var ids []string
+	for _, file := range files {
+		ids = append(ids, file.FileId)
+	}
+	return ids
+}
+
+func TestGetUserFilesPaginates(t *testing.T) {
+	setupTestDB(t, &File{})
+	for i := 1; i <= 5; i++ {
+		purpose := "batch"
+		if i == 3 {
+			purpose = "assistants"
+		}
+		assert.NoError(t, (&File{FileId: fmt.Sprintf("file-%d", i), UserId: 1, Purpose: purpose}).Insert())
+	}
+	assert.NoError(t, (&File{FileId: "file-other", UserId: 2, Purpose: "batch"}).Insert())
+
+	files, err := GetUserFiles(1, "", "desc", 0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"file-5", "file-4"}, fileIds(files))
+	files, err = GetUserFiles(1, "", "desc", files[1].Id, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"file-3", "file-2"}, fileIds(files), "the next page starts after the cursor")
+
+	files, err = GetUserFiles(1, "batch", "asc", 2, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"file-4", "file-5"}, fileIds(files))
+}
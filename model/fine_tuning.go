@@ -0,0 +1,129 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+
+	"gorm.io/gorm/clause"
+)
+
+const (
+	FineTuningStatusValidatingFiles = "validating_files"
+	FineTuningStatusQueued          = "queued"
+	FineTuningStatusRunning         = "running"
+	FineTuningStatusSucceeded       = "succeeded"
+	FineTuningStatusFailed          = "failed"
+	FineTuningStatusCancelled       = "cancelled"
+)
+
+// FineTuningJob records which user created an upstream fine-tuning job and on which channel,
+// so that later requests for the job and its fine-tuned model go back to the same channel.
+// Object keeps the last job object returned by the upstream.
+type FineTuningJob struct {
+	Id             int    `json:"id"`
+	JobId          string `json:"job_id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId         int    `json:"user_id" gorm:"index"`
+	TokenId        int    `json:"token_id"`
+	ChannelId      int    `json:"channel_id" gorm:"index"`
+	Group          string `json:"group" gorm:"type:varchar(32)"`
+	Model          string `json:"model"`
+	FineTunedModel string `json:"fine_tuned_model" gorm:"type:varchar(191);index"`
+	Status         string `json:"status" gorm:"type:varchar(32);index"`
+	Object         string `json:"object" gorm:"type:text"`
+	CreatedAt      int64  `json:"created_at" gorm:"bigint"`
+}
+
+func GetUserFineTuningJobs(userId int, afterId int, num int) ([]*FineTuningJob, error) {
+	var jobs []*FineTuningJob
+	query := DB.Where("user_id = ?", userId)
+	if afterId > 0 {
+		query = query.Where("id < ?", afterId)
+	}
+	err := query.Order("id desc").Limit(num).Find(&jobs).Error
+	return jobs, err
+}
+
+func GetUserFineTuningJob(userId int, jobId string) (*FineTuningJob, error) {
+	if userId == 0 || jobId == "" {
+		return nil, errors.New("userId 或 jobId 为空！")
+	}
+	var job FineTuningJob
+	err := DB.Where("user_id = ? and job_id = ?", userId, jobId).First(&job).Error
+	return &job, err
+}
+
+// GetUnfinishedFineTuningJobs returns the jobs whose status may still change upstream.
+func GetUnfinishedFineTuningJobs() ([]*FineTuningJob, error) {
+	var jobs []*FineTuningJob
+	err := DB.Where("status in ?", []string{FineTuningStatusValidatingFiles, FineTuningStatusQueued, FineTuningStatusRunning}).Find(&jobs).Error
+	return jobs, err
+}
+
+func getFineTunedModelJobs(channelId int) ([]*FineTuningJob, error) {
+	var jobs []*FineTuningJob
+	query := DB.Where("fine_tuned_model <> ''")
+	if channelId != 0 {
+		query = query.Where("channel_id = ?", channelId)
+	}
+	err := query.Find(&jobs).Error
+	return jobs, err
+}
+
+// CheckFineTunedModelOwner rejects fine-tuned models created through the gateway by another user.
+// Models that were not fine-tuned through the gateway are not restricted here.
+func CheckFineTunedModelOwner(modelName string, userId int) error {
+	if modelName == "" {
+		return nil
+	}
+	var job FineTuningJob
+	err := DB.Where("fine_tuned_model = ?", modelName).First(&job).Error
+	if err != nil {
+		return nil
+	}
+	if job.UserId != userId {
+		return fmt.Errorf("无权使用模型：%s", modelName)
+	}
+	return nil
+}
+
+func (job *FineTuningJob) Insert() error {
+	return DB.Create(job).Error
+}
+
+func (job *FineTuningJob) Update() error {
+	return DB.Save(job).Error
+}
+
+func (job *FineTuningJob) ability(channel *Channel) Ability {
+	return Ability{
+		Group:                 job.Group,
+		Model:                 job.FineTunedModel,
+		ChannelId:             channel.Id,
+		Enabled:               channel.Status == common.ChannelStatusEnabled,
+		Priority:              channel.Priority,
+		Weight:                uint(channel.GetWeight()),
+		IsTools:               channel.IsTools,
+		ClaudeOriginalRequest: channel.ClaudeOriginalRequest,
+	}
+}
+
+// AddFineTunedModelAbility makes the fine-tuned model of the job routable to the channel that trained it.
+func (job *FineTuningJob) AddFineTunedModelAbility() error {
+	if job.FineTunedModel == "" {
+		return nil
+	}
+	channel, err := GetChannelById(job.ChannelId, true)
+	if err != nil {
+		return err
+	}
+	ability := job.ability(channel)
+	err = DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&ability).Error
+	if err != nil {
+		return err
+	}
+	if common.MemoryCacheEnabled {
+		InitChannelCache()
+	}
+	return nil
+}
@@ -32,6 +32,20 @@ type Log struct {
 	UserQuota        int    `json:"userQuota"`
 	AttemptsLog      string `json:"attempts_log"`
 	Ip               string `json:"ip"`
+	BatchId          string `json:"batch_id" gorm:"index;default:''"`
+	IsCached         bool   `json:"is_cached" gorm:"default:false"`
+	RequestedModel   string `json:"requested_model" gorm:"default:''"`  // 模型回退时用户请求的模型，ModelName 为实际提供服务的模型
+	RequestId        string `json:"request_id" gorm:"index;default:''"` // 额度账本以此关联消费记录
+	TokenDetails
+}
+
+// TokenDetails 为按不同倍率计费的 token 数，均已计入 PromptTokens 或 CompletionTokens
+type TokenDetails struct {
+	CachedTokens          int `json:"cached_tokens" gorm:"default:0"`
+	CacheCreationTokens   int `json:"cache_creation_tokens" gorm:"default:0"`
+	ReasoningTokens       int `json:"reasoning_tokens" gorm:"default:0"`
+	AudioPromptTokens     int `json:"audio_prompt_tokens" gorm:"default:0"`
+	AudioCompletionTokens int `json:"audio_completion_tokens" gorm:"default:0"`
 }
 
 type LogStatistic struct {
@@ -60,6 +74,10 @@ type UserLogResponse struct {
 	Multiplier       string `json:"multiplier"`
 	UserQuota        int    `json:"userQuota"`
 	Ip               string `json:"ip"`
+	BatchId          string `json:"batch_id"`
+	IsCached         bool   `json:"is_cached"`
+	RequestedModel   string `json:"requested_model"`
+	TokenDetails
 }
 
 const (
@@ -169,6 +187,10 @@ func RecordLog(userId int, logType int, quota int, multiplier string) {
 
 func RecordConsumeLog(ctx context.Context, userId int, channelId int, channelName string, promptTokens int, completionTokens int, modelName string, tokenName string, quota int, content string, tokenId int, multiplier string, userQuota int, useTimeSeconds int, isStream bool, AttemptsLog string, Ip string) {
 	common.LogInfo(ctx, fmt.Sprintf("record consume log: userId=%d, 用户调用前余额=%d, channelId=%d, promptTokens=%d, completionTokens=%d, modelName=%s, tokenName=%s, quota=%d,multiplier=%s", userId, userQuota, channelId, promptTokens, completionTokens, modelName, tokenName, quota, multiplier))
+	// 多密钥渠道按密钥统计用量，与是否记录日志无关
+	if key, ok := ctx.Value(common.ChannelKeyIdKey).(ChannelKeyRef); ok && key.ChannelId == channelId {
+		UpdateChannelKeyUsedQuota(key.KeyId, quota)
+	}
 	if !config.LogConsumeEnabled {
 		return
 	}
@@ -194,6 +216,21 @@ func RecordConsumeLog(ctx context.Context, userId int, channelId int, channelNam
 		AttemptsLog:      AttemptsLog,
 		Ip:               Ip,
 	}
+	if batchId, ok := ctx.Value(common.BatchIdKey).(string); ok {
+		log.BatchId = batchId
+	}
+	if cached, ok := ctx.Value(common.ResponseCacheKey).(bool); ok {
+		log.IsCached = cached
+	}
+	if requestedModel, ok := ctx.Value(common.RequestedModelKey).(string); ok && requestedModel != modelName {
+		log.RequestedModel = requestedModel
+	}
+	if details, ok := ctx.Value(common.TokenDetailsKey).(TokenDetails); ok {
+		log.TokenDetails = details
+	}
+	if requestId, ok := ctx.Value(common.RequestIdKey).(string); ok {
+		log.RequestId = requestId
+	}
 	err := DB.Create(log).Error
 	if err != nil {
 		common.LogError(ctx, "failed to record log: "+err.Error())
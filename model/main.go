@@ -152,9 +152,44 @@ func InitDB() (err error) {
 		if err != nil {
 			return err
 		}
+		err = db.AutoMigrate(&File{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&Batch{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&Assistant{}, &Thread{}, &ThreadMessage{}, &Run{}, &RunStep{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&FineTuningJob{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&ChannelKey{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&ChannelModelChange{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&QuotaLedger{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&Plan{}, &Subscription{})
+		if err != nil {
+			return err
+		}
 		common.SysLog("database migrated")
 		err = createRootAccountIfNeed()
-		return err
+		if err != nil {
+			return err
+		}
+		return openQuotaLedger()
 	} else {
 		common.FatalLog(err)
 	}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/common/config"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setGlobal sets the variable p points to for the duration of the test.
+func setGlobal[T any](t *testing.T, p *T, value T) {
+	previous := *p
+	*p = value
+	t.Cleanup(func() {
+		*p = previous
+	})
+}
+
+// setupTestState runs the test without Redis and batch updates, the in-memory state of channels, tokens and
+// sessions is cleared before and after it.
+func setupTestState(t *testing.T) {
+	setGlobal(t, &common.RedisEnabled, false)
+	setGlobal(t, &config.BatchUpdateEnabled, false)
+	resetMemoryState()
+	t.Cleanup(resetMemoryState)
+}
+
+func resetMemoryState() {
+	for _, m := range []*sync.Map{&channelBreakers, &channelLatencies, &channelInflights, &roundRobinCounters, &channelKeyCache, &channelKeyCounters} {
+		m.Range(func(key, value any) bool {
+			m.Delete(key)
+			return true
+		})
+	}
+	channelLimitCounters.Lock()
+	channelLimitCounters.items = make(map[string]*limitCounter)
+	channelLimitCounters.Unlock()
+	stickyChannels.Lock()
+	stickyChannels.items = make(map[string]stickyChannelItem)
+	stickyChannels.Unlock()
+	tokenBudgetLock.Lock()
+	tokenBudgetCounters = make(map[string]*tokenBudgetCounter)
+	tokenBudgetLock.Unlock()
+}
+
+// setupTestDB points DB at a fresh in-memory SQLite database with the tables of models, see setupTestState.
+func setupTestDB(t *testing.T, models ...any) {
+	setupTestState(t)
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if !assert.NoError(t, db.AutoMigrate(models...)) {
+		t.FailNow()
+	}
+	previous := DB
+	DB = db
+	t.Cleanup(func() {
+		DB = previous
+		sqlDB.Close()
+	})
+}
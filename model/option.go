@@ -88,7 +88,16 @@ func InitOptionMap() {
 	config.OptionMap["ModelRatio"] = common.ModelRatioJSONString()
 	config.OptionMap["ModelPrice"] = common.ModelRatio2JSONString()
 	config.OptionMap["GroupRatio"] = common.GroupRatio2JSONString()
+	config.OptionMap["PricingRules"] = common.PricingRules2JSONString()
+	config.OptionMap["PricingTimezone"] = common.PricingTimezone
+	config.OptionMap["BatchRatio"] = strconv.FormatFloat(config.BatchRatio, 'f', -1, 64)
 	config.OptionMap["CompletionRatio"] = common.CompletionRatio2JSONString()
+	config.OptionMap["RerankRatio"] = common.RerankRatioJSONString()
+	config.OptionMap["AudioRatio"] = common.AudioRatioJSONString()
+	config.OptionMap["AudioCompletionRatio"] = common.AudioCompletionRatioJSONString()
+	config.OptionMap["CacheRatio"] = common.CacheRatioJSONString()
+	config.OptionMap["CacheCreationRatio"] = common.CacheCreationRatioJSONString()
+	config.OptionMap["ReasoningRatio"] = common.ReasoningRatioJSONString()
 	config.OptionMap["TopUpLink"] = config.TopUpLink
 	config.OptionMap["ChatLink"] = config.ChatLink
 	config.OptionMap["QuotaPerUnit"] = strconv.FormatFloat(config.QuotaPerUnit, 'f', -1, 64)
@@ -113,6 +122,38 @@ func InitOptionMap() {
 	config.OptionMap["ProporTions"] = strconv.Itoa(config.ProporTions)
 	config.OptionMap["RedempTionCount"] = strconv.Itoa(config.RedempTionCount)
 	config.OptionMap["OutProxyUrl"] = ""
+	config.OptionMap["FileUpstreamEnabled"] = strconv.FormatBool(config.FileUpstreamEnabled)
+	config.OptionMap["ResponseCacheEnabled"] = strconv.FormatBool(config.ResponseCacheEnabled)
+	config.OptionMap["CircuitBreakerEnabled"] = strconv.FormatBool(config.CircuitBreakerEnabled)
+	config.OptionMap["CircuitBreakerWindow"] = strconv.Itoa(config.CircuitBreakerWindow)
+	config.OptionMap["CircuitBreakerMinRequests"] = strconv.Itoa(config.CircuitBreakerMinRequests)
+	config.OptionMap["CircuitBreakerErrorRate"] = strconv.FormatFloat(config.CircuitBreakerErrorRate, 'f', -1, 64)
+	config.OptionMap["CircuitBreakerLatencyThreshold"] = strconv.Itoa(config.CircuitBreakerLatencyThreshold)
+	config.OptionMap["CircuitBreakerOpenDuration"] = strconv.Itoa(config.CircuitBreakerOpenDuration)
+	config.OptionMap["ChannelQueueSize"] = strconv.Itoa(config.ChannelQueueSize)
+	config.OptionMap["ChannelQueueTimeout"] = strconv.Itoa(config.ChannelQueueTimeout)
+	config.OptionMap["RoutingStrategy"] = config.RoutingStrategy
+	config.OptionMap["StickyRoutingEnabled"] = strconv.FormatBool(config.StickyRoutingEnabled)
+	config.OptionMap["StickyRoutingTTL"] = strconv.Itoa(config.StickyRoutingTTL)
+	config.OptionMap["StickyRoutingMessages"] = strconv.Itoa(config.StickyRoutingMessages)
+	config.OptionMap["StreamFailoverEnabled"] = strconv.FormatBool(config.StreamFailoverEnabled)
+	config.OptionMap["StreamFailoverBufferEvents"] = strconv.Itoa(config.StreamFailoverBufferEvents)
+	config.OptionMap["ModelSyncEnabled"] = strconv.FormatBool(config.ModelSyncEnabled)
+	config.OptionMap["ModelSyncInterval"] = strconv.Itoa(config.ModelSyncInterval)
+	config.OptionMap["ModelSyncAutoAddEnabled"] = strconv.FormatBool(config.ModelSyncAutoAddEnabled)
+	config.OptionMap["ModelSyncAutoRemoveEnabled"] = strconv.FormatBool(config.ModelSyncAutoRemoveEnabled)
+	config.OptionMap["QuotaReconcileEnabled"] = strconv.FormatBool(config.QuotaReconcileEnabled)
+	config.OptionMap["QuotaReconcileInterval"] = strconv.Itoa(config.QuotaReconcileInterval)
+	config.OptionMap["SubscriptionRemindDays"] = strconv.Itoa(config.SubscriptionRemindDays)
+	config.OptionMap["RoutingLatencyEWMAAlpha"] = strconv.FormatFloat(config.RoutingLatencyEWMAAlpha, 'f', -1, 64)
+	config.OptionMap["GroupRoutingStrategy"] = common.GroupRoutingStrategy2JSONString()
+	config.OptionMap["ModelFallback"] = common.ModelFallback2JSONString()
+	config.OptionMap["GroupModelFallback"] = common.GroupModelFallback2JSONString()
+	config.OptionMap["ModelRoutingStrategy"] = common.ModelRoutingStrategy2JSONString()
+	config.OptionMap["ResponseCacheTTL"] = strconv.Itoa(config.ResponseCacheTTL)
+	config.OptionMap["ResponseCacheHitRatio"] = strconv.FormatFloat(config.ResponseCacheHitRatio, 'f', -1, 64)
+	config.OptionMap["GroupResponseCacheTTL"] = common.GroupResponseCacheTTLJSONString()
+	config.OptionMap["GroupHedgeDelay"] = common.GroupHedgeDelayJSONString()
 	config.OptionMapRWMutex.Unlock()
 	loadOptionsFromDatabase()
 }
@@ -230,6 +271,24 @@ func updateOptionMap(key string, value string) (err error) {
 			config.BlankReplyRetryEnabled = boolValue
 		case "UserGroupEnabled":
 			config.UserGroupEnabled = boolValue
+		case "FileUpstreamEnabled":
+			config.FileUpstreamEnabled = boolValue
+		case "ResponseCacheEnabled":
+			config.ResponseCacheEnabled = boolValue
+		case "StickyRoutingEnabled":
+			config.StickyRoutingEnabled = boolValue
+		case "StreamFailoverEnabled":
+			config.StreamFailoverEnabled = boolValue
+		case "ModelSyncEnabled":
+			config.ModelSyncEnabled = boolValue
+		case "ModelSyncAutoAddEnabled":
+			config.ModelSyncAutoAddEnabled = boolValue
+		case "ModelSyncAutoRemoveEnabled":
+			config.ModelSyncAutoRemoveEnabled = boolValue
+		case "QuotaReconcileEnabled":
+			config.QuotaReconcileEnabled = boolValue
+		case "CircuitBreakerEnabled":
+			config.CircuitBreakerEnabled = boolValue
 
 		}
 	}
@@ -299,6 +358,32 @@ func updateOptionMap(key string, value string) (err error) {
 		config.PreConsumedQuota, _ = strconv.Atoi(value)
 	case "RetryTimes":
 		config.RetryTimes, _ = strconv.Atoi(value)
+	case "ResponseCacheTTL":
+		config.ResponseCacheTTL, _ = strconv.Atoi(value)
+	case "CircuitBreakerWindow":
+		config.CircuitBreakerWindow, _ = strconv.Atoi(value)
+	case "CircuitBreakerMinRequests":
+		config.CircuitBreakerMinRequests, _ = strconv.Atoi(value)
+	case "CircuitBreakerLatencyThreshold":
+		config.CircuitBreakerLatencyThreshold, _ = strconv.Atoi(value)
+	case "CircuitBreakerOpenDuration":
+		config.CircuitBreakerOpenDuration, _ = strconv.Atoi(value)
+	case "ChannelQueueSize":
+		config.ChannelQueueSize, _ = strconv.Atoi(value)
+	case "ChannelQueueTimeout":
+		config.ChannelQueueTimeout, _ = strconv.Atoi(value)
+	case "StickyRoutingTTL":
+		config.StickyRoutingTTL, _ = strconv.Atoi(value)
+	case "StickyRoutingMessages":
+		config.StickyRoutingMessages, _ = strconv.Atoi(value)
+	case "StreamFailoverBufferEvents":
+		config.StreamFailoverBufferEvents, _ = strconv.Atoi(value)
+	case "ModelSyncInterval":
+		config.ModelSyncInterval, _ = strconv.Atoi(value)
+	case "QuotaReconcileInterval":
+		config.QuotaReconcileInterval, _ = strconv.Atoi(value)
+	case "SubscriptionRemindDays":
+		config.SubscriptionRemindDays, _ = strconv.Atoi(value)
 	case "DataExportInterval":
 		config.DataExportInterval, _ = strconv.Atoi(value)
 	case "ProporTions":
@@ -311,8 +396,46 @@ func updateOptionMap(key string, value string) (err error) {
 		err = common.UpdateModelRatio2ByJSONString(value)
 	case "GroupRatio":
 		err = common.UpdateGroupRatioByJSONString(value)
+	case "PricingRules":
+		err = common.UpdatePricingRulesByJSONString(value)
+	case "PricingTimezone":
+		err = common.UpdatePricingTimezone(value)
+	case "BatchRatio":
+		config.BatchRatio, _ = strconv.ParseFloat(value, 64)
+	case "ResponseCacheHitRatio":
+		config.ResponseCacheHitRatio, _ = strconv.ParseFloat(value, 64)
+	case "RoutingLatencyEWMAAlpha":
+		config.RoutingLatencyEWMAAlpha, _ = strconv.ParseFloat(value, 64)
+	case "CircuitBreakerErrorRate":
+		config.CircuitBreakerErrorRate, _ = strconv.ParseFloat(value, 64)
+	case "GroupResponseCacheTTL":
+		err = common.UpdateGroupResponseCacheTTLByJSONString(value)
+	case "GroupHedgeDelay":
+		err = common.UpdateGroupHedgeDelayByJSONString(value)
+	case "GroupRoutingStrategy":
+		err = common.UpdateGroupRoutingStrategyByJSONString(value)
+	case "ModelFallback":
+		err = common.UpdateModelFallbackByJSONString(value)
+	case "GroupModelFallback":
+		err = common.UpdateGroupModelFallbackByJSONString(value)
+	case "ModelRoutingStrategy":
+		err = common.UpdateModelRoutingStrategyByJSONString(value)
+	case "RoutingStrategy":
+		config.RoutingStrategy = value
 	case "CompletionRatio":
 		err = common.UpdateCompletionRatioByJSONString(value)
+	case "RerankRatio":
+		err = common.UpdateRerankRatioByJSONString(value)
+	case "AudioRatio":
+		err = common.UpdateAudioRatioByJSONString(value)
+	case "AudioCompletionRatio":
+		err = common.UpdateAudioCompletionRatioByJSONString(value)
+	case "CacheRatio":
+		err = common.UpdateCacheRatioByJSONString(value)
+	case "CacheCreationRatio":
+		err = common.UpdateCacheCreationRatioByJSONString(value)
+	case "ReasoningRatio":
+		err = common.UpdateReasoningRatioByJSONString(value)
 	case "GroupUserRatio":
 		err = common.UpdateGroupUserRatioByJSONString(value)
 	case "TopUpLink":
@@ -0,0 +1,295 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"one-api/common"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 额度账本：users.quota 与 tokens.remain_quota 的每一次变动都按复式记账追加一对记录，记录只增不改。
+// 一条记在 Account 上，另一条以相反的金额记在 Counterparty（system:consume、system:topup、aff:1 等）上，
+// 两条在同一事务中写入，因此整个账本之和恒为零。
+// 用户与令牌账户的记录之和应当等于其当前余额，对账时以此找出绕过账本的改动；账本之和不为零说明有记录缺失了对方。
+
+const (
+	LedgerReasonOpening      = "opening"      // 启用账本时的期初余额
+	LedgerReasonConsume      = "consume"      // 请求的预扣、结算与退还
+	LedgerReasonRefund       = "refund"       // 任务失败补偿
+	LedgerReasonTopup        = "topup"        // 在线充值
+	LedgerReasonRedemption   = "redemption"   // 兑换码
+	LedgerReasonGift         = "gift"         // 注册、邀请赠送
+	LedgerReasonAffTransfer  = "aff_transfer" // 邀请额度划转
+	LedgerReasonAdjust       = "adjust"       // 管理员修改用户额度、修改令牌额度
+	LedgerReasonExpire       = "expire"       // 充值额度过期
+	LedgerReasonSubscription = "subscription" // 订阅套餐按月发放
+	LedgerReasonWithdrawal   = "withdrawal"   // 邀请额度提现
+)
+
+type QuotaLedger struct {
+	Id           int    `json:"id"`
+	CreatedAt    int64  `json:"created_at" gorm:"bigint;index"`
+	Account      string `json:"account" gorm:"type:varchar(32);index"` // user:1、token:1
+	Counterparty string `json:"counterparty" gorm:"type:varchar(32)"`  // 对方账户，如 system:consume、aff:1
+	Amount       int    `json:"amount"`
+	Balance      int    `json:"balance"` // 变动后 Account 的余额，系统账户与邀请账户不记余额，为 0
+	Reason       string `json:"reason" gorm:"type:varchar(32);index"`
+	RefId        string `json:"ref_id" gorm:"type:varchar(64);index;default:''"` // 请求 id、订单号、兑换码 id 等
+}
+
+// LedgerRef tells why quota changes and what it refers to.
+type LedgerRef struct {
+	Reason       string
+	RefId        string
+	Counterparty string // 为空时为原因对应的系统账户
+}
+
+// LedgerTotalAccount names the drift of the whole ledger, which sums to zero unless an entry lacks its other half.
+const LedgerTotalAccount = "total"
+
+type QuotaDrift struct {
+	Account       string `json:"account"`
+	Balance       int    `json:"balance"`        // 数据库中的余额
+	LedgerBalance int    `json:"ledger_balance"` // 账本记录之和
+	Drift         int    `json:"drift"`
+}
+
+func UserLedgerAccount(id int) string {
+	return fmt.Sprintf("user:%d", id)
+}
+
+func TokenLedgerAccount(id int) string {
+	return fmt.Sprintf("token:%d", id)
+}
+
+// ConsumeLedgerRef refers quota consumed by a relay request to the request id, which is kept in the consume log.
+func ConsumeLedgerRef(ctx context.Context) LedgerRef {
+	requestId, _ := ctx.Value(common.RequestIdKey).(string)
+	return LedgerRef{Reason: LedgerReasonConsume, RefId: requestId}
+}
+
+func newLedgerEntry(amount int, ref LedgerRef) *QuotaLedger {
+	counterparty := ref.Counterparty
+	if counterparty == "" {
+		switch ref.Reason {
+		case LedgerReasonConsume, LedgerReasonRefund:
+			counterparty = "system:consume"
+		default:
+			counterparty = "system:" + ref.Reason
+		}
+	}
+	return &QuotaLedger{
+		CreatedAt:    common.GetTimestamp(),
+		Counterparty: counterparty,
+		Amount:       amount,
+		Reason:       ref.Reason,
+		RefId:        ref.RefId,
+	}
+}
+
+func recordUserLedger(tx *gorm.DB, id int, entries ...*QuotaLedger) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	var balance int
+	err := tx.Model(&User{}).Unscoped().Where("id = ?", id).Select("quota").Find(&balance).Error
+	if err != nil {
+		return err
+	}
+	return recordLedger(tx, UserLedgerAccount(id), balance, entries)
+}
+
+func recordTokenLedger(tx *gorm.DB, id int, entries ...*QuotaLedger) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	var balance int
+	err := tx.Model(&Token{}).Where("id = ?", id).Select("remain_quota").Find(&balance).Error
+	if err != nil {
+		return err
+	}
+	return recordLedger(tx, TokenLedgerAccount(id), balance, entries)
+}
+
+// recordLedger writes the entries that brought the account to balance, the balance after each entry is
+// worked out backwards from the last one.
+func recordLedger(tx *gorm.DB, account string, balance int, entries []*QuotaLedger) error {
+	for i := len(entries) - 1; i >= 0; i-- {
+		entries[i].Account = account
+		entries[i].Balance = balance
+		balance -= entries[i].Amount
+	}
+	return createLedgerEntries(tx, entries)
+}
+
+// contraEntries returns the other half of each entry, booked on the counterparty with the opposite amount.
+func contraEntries(entries []*QuotaLedger) []*QuotaLedger {
+	contras := make([]*QuotaLedger, 0, len(entries))
+	for _, entry := range entries {
+		contras = append(contras, &QuotaLedger{
+			CreatedAt:    entry.CreatedAt,
+			Account:      entry.Counterparty,
+			Counterparty: entry.Account,
+			Amount:       -entry.Amount,
+			Reason:       entry.Reason,
+			RefId:        entry.RefId,
+		})
+	}
+	return contras
+}
+
+// createLedgerEntries writes the entries together with their other halves.
+func createLedgerEntries(tx *gorm.DB, entries []*QuotaLedger) error {
+	return tx.CreateInBatches(append(entries, contraEntries(entries)...), 100).Error
+}
+
+// openQuotaLedger records the opening balance of every account when the ledger is empty.
+func openQuotaLedger() error {
+	var count int64
+	err := DB.Model(&QuotaLedger{}).Count(&count).Error
+	if err != nil || count > 0 {
+		return err
+	}
+	common.SysLog("opening quota ledger")
+	var entries []*QuotaLedger
+	var users []User
+	err = DB.Unscoped().Select("id", "quota").Where("quota <> 0").Find(&users).Error
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		entry := newLedgerEntry(user.Quota, LedgerRef{Reason: LedgerReasonOpening})
+		entry.Account = UserLedgerAccount(user.Id)
+		entry.Balance = user.Quota
+		entries = append(entries, entry)
+	}
+	var tokens []Token
+	err = DB.Select("id", "remain_quota").Where("remain_quota <> 0").Find(&tokens).Error
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		entry := newLedgerEntry(token.RemainQuota, LedgerRef{Reason: LedgerReasonOpening})
+		entry.Account = TokenLedgerAccount(token.Id)
+		entry.Balance = token.RemainQuota
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return createLedgerEntries(DB, entries)
+}
+
+func GetQuotaLedgers(account string, reason string, refId string, startIdx int, num int) (ledgers []*QuotaLedger, total int64, err error) {
+	tx := DB.Model(&QuotaLedger{})
+	if account != "" {
+		tx = tx.Where("account = ?", account)
+	}
+	if reason != "" {
+		tx = tx.Where("reason = ?", reason)
+	}
+	if refId != "" {
+		tx = tx.Where("ref_id = ?", refId)
+	}
+	err = tx.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&ledgers).Error
+	return ledgers, total, err
+}
+
+func sumQuotaLedgers(tx *gorm.DB, prefix string) (map[string]int, error) {
+	var sums []struct {
+		Account string
+		Total   int
+	}
+	err := tx.Model(&QuotaLedger{}).Select("account, sum(amount) as total").
+		Where("account LIKE ?", prefix+"%").Group("account").Scan(&sums).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int, len(sums))
+	for _, sum := range sums {
+		result[sum.Account] = sum.Total
+	}
+	return result, nil
+}
+
+// ReconcileQuotaLedger checks that the whole ledger sums to zero, then compares it with users.quota and
+// tokens.remain_quota and returns the accounts that don't match. Pending batch updates are written to the ledger
+// with the balances, so they never drift.
+func ReconcileQuotaLedger() ([]QuotaDrift, error) {
+	var drifts []QuotaDrift
+	var total int
+	err := DB.Model(&QuotaLedger{}).Select("coalesce(sum(amount), 0)").Scan(&total).Error
+	if err != nil {
+		return nil, err
+	}
+	if total != 0 {
+		drifts = append(drifts, QuotaDrift{Account: LedgerTotalAccount, LedgerBalance: total, Drift: -total})
+	}
+	var candidates []string
+	userSums, err := sumQuotaLedgers(DB, "user:")
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	err = DB.Unscoped().Select("id", "quota").Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		account := UserLedgerAccount(user.Id)
+		if userSums[account] != user.Quota {
+			candidates = append(candidates, account)
+		}
+	}
+	tokenSums, err := sumQuotaLedgers(DB, "token:")
+	if err != nil {
+		return nil, err
+	}
+	var tokens []Token
+	err = DB.Select("id", "remain_quota").Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		account := TokenLedgerAccount(token.Id)
+		if tokenSums[account] != token.RemainQuota {
+			candidates = append(candidates, account)
+		}
+	}
+	// 上面的汇总与余额不是同一时刻读取的，逐个用一条语句复核，排除对账期间正常扣费造成的差异
+	for _, candidate := range candidates {
+		drift, err := reconcileAccount(candidate)
+		if err != nil {
+			return nil, err
+		}
+		if drift.Drift != 0 {
+			drifts = append(drifts, drift)
+		}
+	}
+	return drifts, nil
+}
+
+// reconcileAccount reads the balance and the ledger sum of account in one statement, so both come from the
+// same snapshot even under READ COMMITTED.
+func reconcileAccount(account string) (drift QuotaDrift, err error) {
+	kind, idStr, _ := strings.Cut(account, ":")
+	id, _ := strconv.Atoi(idStr)
+	ledgerSum := DB.Model(&QuotaLedger{}).Select("coalesce(sum(amount), 0)").Where("account = ?", account)
+	var tx *gorm.DB
+	if kind == "user" {
+		tx = DB.Model(&User{}).Unscoped().Select("quota as balance, (?) as ledger_balance", ledgerSum)
+	} else {
+		tx = DB.Model(&Token{}).Select("remain_quota as balance, (?) as ledger_balance", ledgerSum)
+	}
+	err = tx.Where("id = ?", id).Scan(&drift).Error
+	drift.Account = account
+	drift.Drift = drift.Balance - drift.LedgerBalance
+	return drift, err
+}
@@ -0,0 +1,113 @@
+package model
+
+import (
+	"one-api/common/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupLedgerTest(t *testing.T) (user *User, token *Token) {
+	setupTestDB(t, &User{}, &Token{}, &QuotaLedger{}, &RechargeRecord{})
+	user = &User{Username: "ledger", AffCode: "ledger", Quota: 1000}
+	assert.NoError(t, DB.Create(user).Error)
+	token = &Token{UserId: user.Id, Key: "ledger", RemainQuota: 500}
+	assert.NoError(t, DB.Create(token).Error)
+	if !assert.NoError(t, openQuotaLedger()) {
+		t.FailNow()
+	}
+	return user, token
+}
+
+func TestQuotaLedgerRecordsBalances(t *testing.T) {
+	user, token := setupLedgerTest(t)
+	assert.NoError(t, IncreaseUserQuota(user.Id, 200, LedgerRef{Reason: LedgerReasonTopup, RefId: "order"}))
+	assert.NoError(t, DecreaseUserQuota(user.Id, 300, LedgerRef{Reason: LedgerReasonConsume, RefId: "request"}))
+	assert.NoError(t, DecreaseTokenQuota(token.Id, 100, LedgerRef{Reason: LedgerReasonConsume, RefId: "request"}))
+
+	ledgers, total, err := GetQuotaLedgers(UserLedgerAccount(user.Id), "", "", 0, 10)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, total)
+	if assert.Len(t, ledgers, 3) {
+		assert.Equal(t, -300, ledgers[0].Amount)
+		assert.Equal(t, 900, ledgers[0].Balance)
+		assert.Equal(t, "system:consume", ledgers[0].Counterparty)
+		assert.Equal(t, 1200, ledgers[1].Balance)
+		assert.Equal(t, "system:topup", ledgers[1].Counterparty)
+		assert.Equal(t, LedgerReasonOpening, ledgers[2].Reason)
+	}
+	ledgers, _, err = GetQuotaLedgers(TokenLedgerAccount(token.Id), LedgerReasonConsume, "request", 0, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, ledgers, 1) {
+		assert.Equal(t, 400, ledgers[0].Balance)
+	}
+	// 每条记录都在对方账户上有一条金额相反的记录
+	ledgers, _, err = GetQuotaLedgers("system:consume", "", "request", 0, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, ledgers, 2) {
+		assert.Equal(t, 100, ledgers[0].Amount)
+		assert.Equal(t, TokenLedgerAccount(token.Id), ledgers[0].Counterparty)
+		assert.Equal(t, 300, ledgers[1].Amount)
+		assert.Equal(t, UserLedgerAccount(user.Id), ledgers[1].Counterparty)
+	}
+	var sum int
+	assert.NoError(t, DB.Model(&QuotaLedger{}).Select("sum(amount)").Scan(&sum).Error)
+	assert.Zero(t, sum)
+
+	drifts, err := ReconcileQuotaLedger()
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+}
+
+func TestReconcileQuotaLedgerFindsDrift(t *testing.T) {
+	user, token := setupLedgerTest(t)
+	// 绕过账本直接修改余额
+	assert.NoError(t, DB.Model(&User{}).Where("id = ?", user.Id).Update("quota", 1500).Error)
+	assert.NoError(t, DB.Model(&Token{}).Where("id = ?", token.Id).Update("remain_quota", 450).Error)
+
+	drifts, err := ReconcileQuotaLedger()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []QuotaDrift{
+		{Account: UserLedgerAccount(user.Id), Balance: 1500, LedgerBalance: 1000, Drift: 500},
+		{Account: TokenLedgerAccount(token.Id), Balance: 450, LedgerBalance: 500, Drift: -50},
+	}, drifts)
+}
+
+func TestReconcileQuotaLedgerFindsUnbalancedEntry(t *testing.T) {
+	user, _ := setupLedgerTest(t)
+	// 只有一半的记录：用户余额与账本相符，但账本之和不为零
+	entry := newLedgerEntry(200, LedgerRef{Reason: LedgerReasonAdjust})
+	entry.Account = UserLedgerAccount(user.Id)
+	assert.NoError(t, DB.Create(entry).Error)
+	assert.NoError(t, DB.Model(&User{}).Where("id = ?", user.Id).Update("quota", 1200).Error)
+
+	drifts, err := ReconcileQuotaLedger()
+	assert.NoError(t, err)
+	assert.Equal(t, []QuotaDrift{{Account: LedgerTotalAccount, LedgerBalance: 200, Drift: -200}}, drifts)
+}
+
+func TestReconcileQuotaLedgerWithBatchUpdate(t *testing.T) {
+	user, token := setupLedgerTest(t)
+	config.BatchUpdateEnabled = true
+	assert.NoError(t, IncreaseUserQuota(user.Id, 200, LedgerRef{Reason: LedgerReasonTopup}))
+	assert.NoError(t, DecreaseUserQuota(user.Id, 50, LedgerRef{Reason: LedgerReasonConsume}))
+	assert.NoError(t, DecreaseTokenQuota(token.Id, 50, LedgerRef{Reason: LedgerReasonConsume}))
+
+	// 未写入的批量更新既不在余额里也不在账本里
+	drifts, err := ReconcileQuotaLedger()
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+
+	batchUpdate()
+	assert.NoError(t, DB.First(user, user.Id).Error)
+	assert.Equal(t, 1150, user.Quota)
+	ledgers, _, err := GetQuotaLedgers(UserLedgerAccount(user.Id), "", "", 0, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, ledgers, 3) {
+		assert.Equal(t, 1150, ledgers[0].Balance)
+		assert.Equal(t, 1200, ledgers[1].Balance, "the balance after each entry is worked out backwards")
+	}
+	drifts, err = ReconcileQuotaLedger()
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+}
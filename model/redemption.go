@@ -75,6 +75,11 @@ func Redeem(key string, userId int) (quota int, err error) {
 		if err != nil {
 			return err
 		}
+		entry := newLedgerEntry(redemption.Quota, LedgerRef{Reason: LedgerReasonRedemption, RefId: strconv.Itoa(redemption.Id)})
+		err = recordUserLedger(tx, userId, entry)
+		if err != nil {
+			return err
+		}
 
 		err = redemptionIncreaseRechargeQuota(tx, userId, RedempTionCount, redemption.Quota)
 		if err != nil {
@@ -0,0 +1,453 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/common/config"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 订阅套餐：用户按月付费，每个计费周期发放一次额度，订阅期间切换到套餐的分组，并受套餐的模型和频率限制。
+// 不结转的额度以充值记录的形式发放，周期结束时由 UpdateUserQuotaData 回收未用完的部分。
+
+const (
+	SubscriptionStatusActive    = "active"
+	SubscriptionStatusExpired   = "expired"
+	SubscriptionStatusCancelled = "cancelled"
+)
+
+type Plan struct {
+	Id           int     `json:"id"`
+	Name         string  `json:"name" gorm:"index"`
+	Description  string  `json:"description"`
+	Price        float64 `json:"price"` // 每月价格
+	MonthlyQuota int     `json:"monthly_quota"`
+	Rollover     bool    `json:"rollover"`                      // 周期结束时未用完的额度是否保留
+	Group        string  `json:"group" gorm:"type:varchar(32)"` // 订阅期间用户所在的分组，为空时不切换
+	Models       string  `json:"models"`                        // 允许使用的模型，逗号分隔，支持模型规则，为空时不限制
+	RateLimit    int     `json:"rate_limit"`                    // 每分钟请求数上限，0 为不限制
+	Status       int     `json:"status" gorm:"default:1"`
+	CreatedTime  int64   `json:"created_time" gorm:"bigint"`
+}
+
+type Subscription struct {
+	Id            int    `json:"id"`
+	UserId        int    `json:"user_id" gorm:"index"`
+	PlanId        int    `json:"plan_id" gorm:"index"`
+	PlanName      string `json:"plan_name"`
+	Status        string `json:"status" gorm:"type:varchar(16);index"`
+	StartTime     int64  `json:"start_time" gorm:"bigint"`
+	ExpireTime    int64  `json:"expire_time" gorm:"bigint"` // 已付费的截止时间，续费时顺延
+	PeriodStart   int64  `json:"period_start" gorm:"bigint"`
+	PeriodEnd     int64  `json:"period_end" gorm:"bigint;index"`
+	PreviousGroup string `json:"previous_group" gorm:"type:varchar(32)"` // 订阅结束后恢复的分组
+	Reminded      bool   `json:"reminded"`                               // 是否已发送续费提醒
+	TradeNo       string `json:"trade_no"`                               // 最近一次付款的订单号，管理员开通时为空
+	CreatedTime   int64  `json:"created_time" gorm:"bigint"`
+}
+
+func GetAllPlans(enabledOnly bool) (plans []*Plan, err error) {
+	tx := DB.Order("price asc, id asc")
+	if enabledOnly {
+		tx = tx.Where("status = ?", common.PlanStatusEnabled)
+	}
+	err = tx.Find(&plans).Error
+	return plans, err
+}
+
+func GetPlanById(id int) (*Plan, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	plan := Plan{Id: id}
+	err := DB.First(&plan, "id = ?", id).Error
+	return &plan, err
+}
+
+func (plan *Plan) Insert() error {
+	plan.CreatedTime = common.GetTimestamp()
+	return DB.Create(plan).Error
+}
+
+func (plan *Plan) Update() error {
+	return DB.Model(plan).Select("name", "description", "price", "monthly_quota", "rollover", "group", "models", "rate_limit", "status").Updates(plan).Error
+}
+
+func DeletePlanById(id int) error {
+	if id == 0 {
+		return errors.New("id 为空！")
+	}
+	return DB.Delete(&Plan{}, "id = ?", id).Error
+}
+
+func GetAllSubscriptions(userId int, startIdx int, num int) (subscriptions []*Subscription, err error) {
+	tx := DB.Order("id desc")
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	err = tx.Limit(num).Offset(startIdx).Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+func GetSubscriptionById(id int) (*Subscription, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	subscription := Subscription{Id: id}
+	err := DB.First(&subscription, "id = ?", id).Error
+	return &subscription, err
+}
+
+// GetActiveSubscription returns the active subscription of the user, nil when there is none.
+func GetActiveSubscription(userId int) (*Subscription, error) {
+	var subscriptions []*Subscription
+	err := DB.Where("user_id = ? AND status = ?", userId, SubscriptionStatusActive).Limit(1).Find(&subscriptions).Error
+	if err != nil || len(subscriptions) == 0 {
+		return nil, err
+	}
+	return subscriptions[0], nil
+}
+
+func getActivePlan(userId int) (*Plan, error) {
+	subscription, err := GetActiveSubscription(userId)
+	if err != nil || subscription == nil {
+		return nil, err
+	}
+	plan, err := GetPlanById(subscription.PlanId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// 套餐已删除，订阅按无限制处理
+		return nil, nil
+	}
+	return plan, err
+}
+
+// CacheGetActivePlan returns the plan the user is subscribed to, nil when the user has no subscription.
+func CacheGetActivePlan(userId int) (*Plan, error) {
+	if !common.RedisEnabled {
+		return getActivePlan(userId)
+	}
+	key := fmt.Sprintf("user_plan:%d", userId)
+	if value, err := common.RedisGet(key); err == nil {
+		var plan *Plan
+		if err := json.Unmarshal([]byte(value), &plan); err == nil {
+			return plan, nil
+		}
+	}
+	plan, err := getActivePlan(userId)
+	if err != nil {
+		return nil, err
+	}
+	value, _ := json.Marshal(plan)
+	err = common.RedisSet(key, string(value), time.Duration(UserId2GroupCacheSeconds)*time.Second)
+	if err != nil {
+		common.SysError("Redis set user plan error: " + err.Error())
+	}
+	return plan, nil
+}
+
+func invalidateSubscriptionCache(userId int) {
+	if !common.RedisEnabled {
+		return
+	}
+	_ = common.RedisDel(fmt.Sprintf("user_plan:%d", userId))
+}
+
+func setUserGroup(tx *gorm.DB, userId int, group string) error {
+	err := tx.Model(&User{}).Where("id = ?", userId).Update("group", group).Error
+	if err != nil {
+		return err
+	}
+	if common.RedisEnabled {
+		_ = common.RedisSet(fmt.Sprintf("user_group:%d", userId), group, time.Duration(UserId2GroupCacheSeconds)*time.Second)
+	}
+	return nil
+}
+
+// addMonths adds months to t and clamps the day to the last day of the month, a month after Jan 31 is
+// the end of February rather than early March as with AddDate.
+func addMonths(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	first := time.Date(year, month+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	lastDay := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(day, lastDay)-1)
+}
+
+// nextPeriodEnd is the first monthly anniversary of the subscription start after periodStart, capped at the
+// paid expire time. Periods follow the start day, so one cut short by a shorter month doesn't move later ones.
+func nextPeriodEnd(startTime int64, periodStart int64, expireTime int64) int64 {
+	start := time.Unix(startTime, 0)
+	for months := 1; ; months++ {
+		end := addMonths(start, months).Unix()
+		if end > periodStart {
+			return min(end, expireTime)
+		}
+	}
+}
+
+// Subscribe subscribes the user to the plan for months months. Subscribing to the current plan again extends it,
+// another plan replaces the current subscription. tradeNo is the order paying for it, the order claimed by
+// ClaimTopUp is marked successful in the same transaction that subscribes and grants the quota.
+func Subscribe(userId int, planId int, months int, tradeNo string) (*Subscription, error) {
+	if months <= 0 {
+		return nil, errors.New("订阅月数必须大于 0")
+	}
+	plan, err := GetPlanById(planId)
+	if err != nil {
+		return nil, err
+	}
+	var subscription *Subscription
+	var logs []string
+	granted := false
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var subscriptions []*Subscription
+		err := tx.Where("user_id = ? AND status = ?", userId, SubscriptionStatusActive).Limit(1).Find(&subscriptions).Error
+		if err != nil {
+			return err
+		}
+		var current *Subscription
+		if len(subscriptions) > 0 {
+			current = subscriptions[0]
+		}
+		switch {
+		case current != nil && tradeNo != "" && current.TradeNo == tradeNo:
+			// 同一订单重复回调时不再重复开通
+			subscription = current
+		case current != nil && current.PlanId == plan.Id:
+			current.ExpireTime = addMonths(time.Unix(current.ExpireTime, 0), months).Unix()
+			current.Reminded = false
+			current.TradeNo = tradeNo
+			err = tx.Model(current).Select("expire_time", "reminded", "trade_no").Updates(current).Error
+			if err != nil {
+				return err
+			}
+			subscription = current
+			logs = append(logs, fmt.Sprintf("续订套餐「%s」%d 个月，到期时间顺延至 %s", plan.Name, months, time.Unix(current.ExpireTime, 0).Format("2006-01-02 15:04:05")))
+		default:
+			if current != nil {
+				err = endSubscriptionTx(tx, current, SubscriptionStatusCancelled)
+				if err != nil {
+					return err
+				}
+			}
+			group, err := getUserGroup(tx, userId)
+			if err != nil {
+				return err
+			}
+			now := time.Now()
+			subscription = &Subscription{
+				UserId:        userId,
+				PlanId:        plan.Id,
+				PlanName:      plan.Name,
+				Status:        SubscriptionStatusActive,
+				StartTime:     now.Unix(),
+				ExpireTime:    addMonths(now, months).Unix(),
+				PeriodStart:   now.Unix(),
+				PreviousGroup: group,
+				TradeNo:       tradeNo,
+				CreatedTime:   now.Unix(),
+			}
+			subscription.PeriodEnd = nextPeriodEnd(subscription.StartTime, subscription.PeriodStart, subscription.ExpireTime)
+			err = tx.Create(subscription).Error
+			if err != nil {
+				return err
+			}
+			if plan.Group != "" && plan.Group != group {
+				err = setUserGroup(tx, userId, plan.Group)
+				if err != nil {
+					return err
+				}
+			}
+			err = grantPeriodQuota(tx, subscription, plan)
+			if err != nil {
+				return err
+			}
+			granted = true
+			logs = append(logs, fmt.Sprintf("订阅套餐「%s」%d 个月", plan.Name, months))
+		}
+		if tradeNo == "" {
+			return nil
+		}
+		return tx.Model(&TopUp{}).Where("trade_no = ? AND status = ?", tradeNo, "processing").Update("status", "success").Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	invalidateSubscriptionCache(userId)
+	for _, content := range logs {
+		RecordLog(userId, LogTypeSystem, 0, content)
+	}
+	if granted {
+		recordPeriodQuotaLog(subscription, plan)
+	}
+	return subscription, nil
+}
+
+// grantPeriodQuota gives the user the quota of the current period. Quota that doesn't roll over is
+// recorded as a recharge record ending with the period, so the unused part is taken back.
+func grantPeriodQuota(tx *gorm.DB, subscription *Subscription, plan *Plan) error {
+	if plan.MonthlyQuota <= 0 {
+		return nil
+	}
+	err := tx.Model(&User{}).Where("id = ?", subscription.UserId).Update("quota", gorm.Expr("quota + ?", plan.MonthlyQuota)).Error
+	if err != nil {
+		return err
+	}
+	ref := LedgerRef{Reason: LedgerReasonSubscription, RefId: strconv.Itoa(subscription.Id)}
+	err = recordUserLedger(tx, subscription.UserId, newLedgerEntry(plan.MonthlyQuota, ref))
+	if err != nil {
+		return err
+	}
+	if plan.Rollover {
+		return nil
+	}
+	return tx.Create(&RechargeRecord{
+		UserID:    uint(subscription.UserId),
+		Amount:    plan.MonthlyQuota,
+		StartDate: subscription.PeriodStart,
+		EndDate:   subscription.PeriodEnd,
+	}).Error
+}
+
+func recordPeriodQuotaLog(subscription *Subscription, plan *Plan) {
+	if plan.MonthlyQuota <= 0 {
+		return
+	}
+	RecordLog(subscription.UserId, LogTypeTopup, plan.MonthlyQuota, fmt.Sprintf("套餐「%s」发放本月额度 %s", plan.Name, common.LogQuota(plan.MonthlyQuota)))
+}
+
+// endSubscription ends the subscription and moves the user back to the group before it,
+// unless the group was changed since.
+func endSubscription(subscription *Subscription, status string) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		return endSubscriptionTx(tx, subscription, status)
+	})
+	if err != nil {
+		return err
+	}
+	subscription.Status = status
+	invalidateSubscriptionCache(subscription.UserId)
+	return nil
+}
+
+func endSubscriptionTx(tx *gorm.DB, subscription *Subscription, status string) error {
+	err := tx.Model(subscription).Select("status", "period_end").Updates(Subscription{Status: status, PeriodEnd: common.GetTimestamp()}).Error
+	if err != nil {
+		return err
+	}
+	var plans []*Plan
+	err = tx.Where("id = ?", subscription.PlanId).Limit(1).Find(&plans).Error
+	if err != nil || len(plans) == 0 || plans[0].Group == "" || subscription.PreviousGroup == "" {
+		return err
+	}
+	group, err := getUserGroup(tx, subscription.UserId)
+	if err != nil || group != plans[0].Group {
+		return err
+	}
+	return setUserGroup(tx, subscription.UserId, subscription.PreviousGroup)
+}
+
+func CancelSubscription(id int) error {
+	subscription, err := GetSubscriptionById(id)
+	if err != nil {
+		return err
+	}
+	if subscription.Status != SubscriptionStatusActive {
+		return errors.New("该订阅未生效")
+	}
+	err = endSubscription(subscription, SubscriptionStatusCancelled)
+	if err != nil {
+		return err
+	}
+	RecordLog(subscription.UserId, LogTypeManage, 0, fmt.Sprintf("管理员取消了套餐「%s」的订阅", subscription.PlanName))
+	return nil
+}
+
+// renewSubscriptions starts the next period of subscriptions whose period is over and ends the expired ones.
+func renewSubscriptions(now int64) {
+	var subscriptions []*Subscription
+	err := DB.Where("status = ? AND period_end <= ?", SubscriptionStatusActive, now).Find(&subscriptions).Error
+	if err != nil {
+		common.SysError("failed to fetch subscriptions: " + err.Error())
+		return
+	}
+	for _, subscription := range subscriptions {
+		plan, err := GetPlanById(subscription.PlanId)
+		if err != nil {
+			plan = nil
+		}
+		for subscription.Status == SubscriptionStatusActive && subscription.PeriodEnd <= now {
+			if subscription.PeriodEnd >= subscription.ExpireTime {
+				err = endSubscription(subscription, SubscriptionStatusExpired)
+				if err == nil {
+					RecordLog(subscription.UserId, LogTypeSystem, 0, fmt.Sprintf("套餐「%s」的订阅已到期", subscription.PlanName))
+				}
+				break
+			}
+			subscription.PeriodStart = subscription.PeriodEnd
+			subscription.PeriodEnd = nextPeriodEnd(subscription.StartTime, subscription.PeriodStart, subscription.ExpireTime)
+			// 进入新周期与发放额度在同一事务中，失败时下次重试整个周期
+			err = DB.Transaction(func(tx *gorm.DB) error {
+				err := tx.Model(subscription).Select("period_start", "period_end").Updates(subscription).Error
+				if err != nil || plan == nil {
+					return err
+				}
+				return grantPeriodQuota(tx, subscription, plan)
+			})
+			if err != nil {
+				break
+			}
+			if plan != nil {
+				recordPeriodQuotaLog(subscription, plan)
+			}
+		}
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to renew subscription #%d: %s", subscription.Id, err.Error()))
+		}
+	}
+}
+
+// remindSubscriptions emails the users whose subscription expires within SubscriptionRemindDays days.
+func remindSubscriptions(now int64) {
+	if config.SubscriptionRemindDays <= 0 {
+		return
+	}
+	deadline := now + int64(config.SubscriptionRemindDays)*24*60*60
+	var subscriptions []*Subscription
+	err := DB.Where("status = ? AND expire_time <= ? AND reminded = ?", SubscriptionStatusActive, deadline, false).Find(&subscriptions).Error
+	if err != nil {
+		common.SysError("failed to fetch subscriptions: " + err.Error())
+		return
+	}
+	for _, subscription := range subscriptions {
+		email, err := GetUserEmail(subscription.UserId)
+		if err == nil && email != "" {
+			subject := fmt.Sprintf("您订阅的套餐「%s」即将到期", subscription.PlanName)
+			link := fmt.Sprintf("%s/topup", config.ServerAddress)
+			content := fmt.Sprintf("您订阅的套餐「%s」将于 %s 到期，到期后将不再发放额度，为了不影响您的使用，请及时续费。<br/>续费链接：<a href='%s'>%s</a>",
+				subscription.PlanName, time.Unix(subscription.ExpireTime, 0).Format("2006-01-02 15:04:05"), link, link)
+			err = common.SendEmail(subject, email, content)
+			if err != nil {
+				common.SysError("failed to send email: " + err.Error())
+				continue
+			}
+		}
+		err = DB.Model(subscription).Update("reminded", true).Error
+		if err != nil {
+			common.SysError("failed to update subscription: " + err.Error())
+		}
+	}
+}
+
+func UpdateSubscriptions() {
+	for {
+		now := common.GetTimestamp()
+		renewSubscriptions(now)
+		remindSubscriptions(now)
+		time.Sleep(10 * time.Minute)
+	}
+}
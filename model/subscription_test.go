@@ -0,0 +1,141 @@
+package model
+
+import (
+	"one-api/common"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddMonths(t *testing.T) {
+	tests := []struct {
+		time   time.Time
+		months int
+		want   time.Time
+	}{
+		{time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC), 1, time.Date(2024, 2, 15, 10, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC), 1, time.Date(2024, 2, 29, 10, 0, 0, 0, time.UTC)}, // 闰年
+		{time.Date(2023, 1, 31, 10, 0, 0, 0, time.UTC), 1, time.Date(2023, 2, 28, 10, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 3, 31, 10, 0, 0, 0, time.UTC), 1, time.Date(2024, 4, 30, 10, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC), 3, time.Date(2024, 4, 30, 10, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 12, 31, 10, 0, 0, 0, time.UTC), 2, time.Date(2025, 2, 28, 10, 0, 0, 0, time.UTC)}, // 跨年
+		{time.Date(2024, 2, 29, 10, 0, 0, 0, time.UTC), 12, time.Date(2025, 2, 28, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, addMonths(test.time, test.months), "%s + %d months", test.time, test.months)
+	}
+}
+
+func TestNextPeriodEnd(t *testing.T) {
+	start := time.Date(2024, 1, 31, 10, 0, 0, 0, time.Local)
+	expire := addMonths(start, 4).Unix()
+	var ends []time.Time
+	periodStart := start.Unix()
+	for periodStart < expire {
+		periodStart = nextPeriodEnd(start.Unix(), periodStart, expire)
+		ends = append(ends, time.Unix(periodStart, 0))
+	}
+	// 二月的周期缩短后，之后的周期仍在月底结束
+	assert.Equal(t, []time.Time{
+		time.Date(2024, 2, 29, 10, 0, 0, 0, time.Local),
+		time.Date(2024, 3, 31, 10, 0, 0, 0, time.Local),
+		time.Date(2024, 4, 30, 10, 0, 0, 0, time.Local),
+		time.Date(2024, 5, 31, 10, 0, 0, 0, time.Local),
+	}, ends)
+
+	assert.Equal(t, start.Unix()+60, nextPeriodEnd(start.Unix(), start.Unix(), start.Unix()+60), "capped at the expire time")
+}
+
+func setupSubscriptionTest(t *testing.T, plan *Plan) *User {
+	setupTestDB(t, &User{}, &Plan{}, &Subscription{}, &QuotaLedger{}, &RechargeRecord{}, &Log{}, &TopUp{})
+	user := &User{Username: "subscriber", AffCode: "subscriber", Group: "default"}
+	assert.NoError(t, DB.Create(user).Error)
+	if !assert.NoError(t, plan.Insert()) {
+		t.FailNow()
+	}
+	return user
+}
+
+func TestRenewSubscriptionsAtMonthEnd(t *testing.T) {
+	plan := &Plan{Name: "monthly", MonthlyQuota: 100, Rollover: true, Status: common.PlanStatusEnabled}
+	user := setupSubscriptionTest(t, plan)
+	start := time.Date(2024, 1, 31, 10, 0, 0, 0, time.Local)
+	subscription := &Subscription{
+		UserId:      user.Id,
+		PlanId:      plan.Id,
+		PlanName:    plan.Name,
+		Status:      SubscriptionStatusActive,
+		StartTime:   start.Unix(),
+		ExpireTime:  addMonths(start, 3).Unix(),
+		PeriodStart: start.Unix(),
+	}
+	subscription.PeriodEnd = nextPeriodEnd(subscription.StartTime, subscription.PeriodStart, subscription.ExpireTime)
+	assert.NoError(t, DB.Create(subscription).Error)
+
+	renewSubscriptions(time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local).Unix())
+	assert.NoError(t, DB.First(subscription, subscription.Id).Error)
+	assert.Equal(t, time.Date(2024, 2, 29, 10, 0, 0, 0, time.Local).Unix(), subscription.PeriodStart)
+	assert.Equal(t, time.Date(2024, 3, 31, 10, 0, 0, 0, time.Local).Unix(), subscription.PeriodEnd)
+	assert.NoError(t, DB.First(user, user.Id).Error)
+	assert.Equal(t, 100, user.Quota)
+
+	// 错过了多个周期时逐个发放，到期后结束订阅
+	renewSubscriptions(time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local).Unix())
+	assert.NoError(t, DB.First(subscription, subscription.Id).Error)
+	assert.Equal(t, SubscriptionStatusExpired, subscription.Status)
+	assert.NoError(t, DB.First(user, user.Id).Error)
+	assert.Equal(t, 200, user.Quota)
+}
+
+func TestSubscribeIgnoresRepeatedTrade(t *testing.T) {
+	plan := &Plan{Name: "monthly", MonthlyQuota: 100, Rollover: true, Status: common.PlanStatusEnabled}
+	user := setupSubscriptionTest(t, plan)
+
+	first, err := Subscribe(user.Id, plan.Id, 1, "trade-1")
+	assert.NoError(t, err)
+	again, err := Subscribe(user.Id, plan.Id, 1, "trade-1")
+	assert.NoError(t, err)
+	assert.Equal(t, first.Id, again.Id)
+	assert.Equal(t, first.ExpireTime, again.ExpireTime, "a repeated notification doesn't extend the subscription")
+
+	extended, err := Subscribe(user.Id, plan.Id, 2, "trade-2")
+	assert.NoError(t, err)
+	assert.Equal(t, addMonths(time.Unix(first.ExpireTime, 0), 2).Unix(), extended.ExpireTime)
+	assert.NoError(t, DB.First(user, user.Id).Error)
+	assert.Equal(t, 100, user.Quota, "the quota of the period is granted once")
+}
+
+func TestSubscribeGrantsQuotaWithTheOrder(t *testing.T) {
+	plan := &Plan{Name: "monthly", MonthlyQuota: 100, Status: common.PlanStatusEnabled}
+	user := setupSubscriptionTest(t, plan)
+	topUp := &TopUp{UserId: user.Id, Amount: 1, TradeNo: "trade-1", Status: "pending", PlanId: plan.Id}
+	assert.NoError(t, topUp.Insert())
+
+	claimed, err := ClaimTopUp(topUp.TradeNo)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	claimed, err = ClaimTopUp(topUp.TradeNo)
+	assert.NoError(t, err)
+	assert.False(t, claimed, "a concurrent notification can't claim the order again")
+
+	// 发放额度失败时订阅一并回滚，订单退回待处理后重试仍能发放
+	assert.NoError(t, DB.Migrator().DropTable(&RechargeRecord{}))
+	_, err = Subscribe(user.Id, plan.Id, 1, topUp.TradeNo)
+	assert.Error(t, err)
+	subscription, err := GetActiveSubscription(user.Id)
+	assert.NoError(t, err)
+	assert.Nil(t, subscription)
+	assert.NoError(t, ReleaseTopUp(topUp.TradeNo))
+
+	assert.NoError(t, DB.AutoMigrate(&RechargeRecord{}))
+	claimed, err = ClaimTopUp(topUp.TradeNo)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	subscription, err = Subscribe(user.Id, plan.Id, 1, topUp.TradeNo)
+	assert.NoError(t, err)
+	assert.Equal(t, topUp.TradeNo, subscription.TradeNo)
+	assert.NoError(t, DB.First(user, user.Id).Error)
+	assert.Equal(t, 100, user.Quota)
+	assert.Equal(t, "success", GetTopUpByTradeNo(topUp.TradeNo).Status)
+}
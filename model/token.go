@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"one-api/common"
@@ -34,6 +35,12 @@ type Token struct {
 	ExpiryMode     string  `json:"expiry_mode"`
 	Duration       int64   `json:"duration"`
 	FirstUsedTime  int64   `json:"first_used_time"`
+	CacheEnabled   bool    `json:"cache_enabled" gorm:"default:false"` // 缓存相同请求的响应
+	CacheTTL       int     `json:"cache_ttl" gorm:"default:0"`         // 缓存有效期（秒），0 使用分组或全局设置
+	HedgeDelay     int     `json:"hedge_delay" gorm:"default:0"`       // 首字节超时后对冲请求的等待时间（毫秒），0 使用分组设置
+	DailyBudget    int     `json:"daily_budget" gorm:"default:0"`      // 每日、每周、每月最多消耗的额度，0 表示不限制
+	WeeklyBudget   int     `json:"weekly_budget" gorm:"default:0"`
+	MonthlyBudget  int     `json:"monthly_budget" gorm:"default:0"`
 }
 
 func GetAllUserTokens(userId int, startIdx int, num int) ([]*Token, error) {
@@ -101,17 +108,7 @@ func ValidateUserToken(key string, model string) (token *Token, err error) {
 		}
 		if token.Models != "" {
 			models := strings.Split(token.Models, ",")
-			if strings.HasPrefix(model, "gpt-4-gizmo") {
-				model = "gpt-4-gizmo-*"
-			}
-			modelFound := false
-			for _, m := range models {
-				if m == model {
-					modelFound = true
-					break
-				}
-			}
-			if !modelFound && model != "" {
+			if !common.MatchModelAny(models, model) && model != "" {
 				return token, errors.New("该令牌不支持指定的模型")
 			}
 		}
@@ -141,16 +138,29 @@ func GetTokenById(id int) (*Token, error) {
 }
 
 func (token *Token) Insert() error {
-	var err error
-	err = DB.Create(token).Error
-	return err
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(token).Error
+		if err != nil || token.RemainQuota == 0 {
+			return err
+		}
+		return recordTokenLedger(tx, token.Id, newLedgerEntry(token.RemainQuota, LedgerRef{Reason: LedgerReasonAdjust}))
+	})
 }
 
 // Update Make sure your token's fields is completed, because this will update non-zero values
 func (token *Token) Update() error {
-	var err error
-	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "group", "billing_enabled", "models", "fixed_content", "subnet", "expiry_mode", "duration").Updates(token).Error
-	return err
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var oldQuota int
+		err := tx.Model(&Token{}).Where("id = ?", token.Id).Select("remain_quota").Find(&oldQuota).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "group", "billing_enabled", "models", "fixed_content", "subnet", "expiry_mode", "duration", "cache_enabled", "cache_ttl", "hedge_delay", "daily_budget", "weekly_budget", "monthly_budget").Updates(token).Error
+		if err != nil || token.RemainQuota == oldQuota {
+			return err
+		}
+		return recordTokenLedger(tx, token.Id, newLedgerEntry(token.RemainQuota-oldQuota, LedgerRef{Reason: LedgerReasonAdjust}))
+	})
 }
 
 func (token *Token) UpdateTokenBilling() error {
@@ -183,41 +193,48 @@ func DeleteTokenById(id int, userId int) (err error) {
 	return token.Delete()
 }
 
-func IncreaseTokenQuota(id int, quota int) (err error) {
+func IncreaseTokenQuota(id int, quota int, ref LedgerRef) (err error) {
 
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
+	entry := newLedgerEntry(quota, ref)
 	if config.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeTokenQuota, id, quota)
+		addNewLedgerRecord(BatchUpdateTypeTokenQuota, id, quota, entry)
 		return nil
 	}
-	return increaseTokenQuota(id, quota)
+	return increaseTokenQuota(id, quota, entry)
 }
 
-func increaseTokenQuota(id int, quota int) (err error) {
-	err = DB.Model(&Token{}).Where("id = ?", id).Updates(
-		map[string]interface{}{
-			"remain_quota":  gorm.Expr("remain_quota + ?", quota),
-			"used_quota":    gorm.Expr("used_quota - ?", quota),
-			"accessed_time": common.GetTimestamp(),
-		},
-	).Error
-	return err
+func increaseTokenQuota(id int, quota int, entries ...*QuotaLedger) (err error) {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Token{}).Where("id = ?", id).Updates(
+			map[string]interface{}{
+				"remain_quota":  gorm.Expr("remain_quota + ?", quota),
+				"used_quota":    gorm.Expr("used_quota - ?", quota),
+				"accessed_time": common.GetTimestamp(),
+			},
+		).Error
+		if err != nil {
+			return err
+		}
+		return recordTokenLedger(tx, id, entries...)
+	})
 }
 
-func DecreaseTokenQuota(id int, quota int) (err error) {
+func DecreaseTokenQuota(id int, quota int, ref LedgerRef) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
+	entry := newLedgerEntry(-quota, ref)
 	if config.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeTokenQuota, id, -quota)
+		addNewLedgerRecord(BatchUpdateTypeTokenQuota, id, -quota, entry)
 		return nil
 	}
-	return decreaseTokenQuota(id, quota)
+	return decreaseTokenQuota(id, quota, entry)
 }
 
-func decreaseTokenQuota(id int, quota int) error {
+func decreaseTokenQuota(id int, quota int, entry *QuotaLedger) error {
 	maxRetries := 2
 	for retries := 0; retries < maxRetries; retries++ {
 		var token Token
@@ -229,21 +246,29 @@ func decreaseTokenQuota(id int, quota int) error {
 
 		newVersion := time.Now().UnixNano() / int64(time.Millisecond)
 
-		// 使用乐观锁更新 Token
-		result := DB.Model(&Token{}).
-			Where("id = ? AND version = ?", id, token.Version).
-			Updates(map[string]interface{}{
-				"remain_quota":  gorm.Expr("remain_quota - ?", quota),
-				"used_quota":    gorm.Expr("used_quota + ?", quota),
-				"accessed_time": newVersion, // 使用新版本号作为访问时间
-				"version":       newVersion, // 更新版本号
-			})
-
-		if result.Error != nil {
-			return result.Error
+		// 使用乐观锁更新 Token，账本记录在同一事务中写入
+		var rowsAffected int64
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&Token{}).
+				Where("id = ? AND version = ?", id, token.Version).
+				Updates(map[string]interface{}{
+					"remain_quota":  gorm.Expr("remain_quota - ?", quota),
+					"used_quota":    gorm.Expr("used_quota + ?", quota),
+					"accessed_time": newVersion, // 使用新版本号作为访问时间
+					"version":       newVersion, // 更新版本号
+				})
+			if result.Error != nil || result.RowsAffected != 1 {
+				return result.Error
+			}
+			rowsAffected = result.RowsAffected
+			return recordTokenLedger(tx, id, entry)
+		})
+
+		if err != nil {
+			return err
 		}
 
-		if result.RowsAffected == 1 {
+		if rowsAffected == 1 {
 			// 更新成功，退出循环
 			return nil
 		}
@@ -255,30 +280,54 @@ func decreaseTokenQuota(id int, quota int) error {
 	return errors.New("failed to update token quota after max retries")
 }
 
-func PostConsumeTokenQuota(tokenId int, quota int) (err error) {
+func PostConsumeTokenQuota(ctx context.Context, tokenId int, quota int) (err error) {
+	return PostConsumeTokenQuotaWithBudget(ctx, tokenId, quota, 0)
+}
+
+// PostConsumeTokenQuotaWithBudget settles quota like PostConsumeTokenQuota, budgetReserved counted against the
+// budgets by ReserveTokenBudget is taken off, so only the difference is counted.
+func PostConsumeTokenQuotaWithBudget(ctx context.Context, tokenId int, quota int, budgetReserved int) (err error) {
 	token, err := GetTokenById(tokenId)
-	if quota > 0 {
-		err = DecreaseUserQuota(token.UserId, quota)
-	} else {
-		err = IncreaseUserQuota(token.UserId, -quota)
-	}
 	if err != nil {
 		return err
 	}
-	if !token.UnlimitedQuota {
+	if quota != 0 {
+		ref := ConsumeLedgerRef(ctx)
 		if quota > 0 {
-			err = DecreaseTokenQuota(tokenId, quota)
+			err = DecreaseUserQuota(token.UserId, quota, ref)
 		} else {
-			err = IncreaseTokenQuota(tokenId, -quota)
+			err = IncreaseUserQuota(token.UserId, -quota, ref)
 		}
 		if err != nil {
 			return err
 		}
+		if !token.UnlimitedQuota {
+			if quota > 0 {
+				err = DecreaseTokenQuota(tokenId, quota, ref)
+			} else {
+				err = IncreaseTokenQuota(tokenId, -quota, ref)
+			}
+			if err != nil {
+				return err
+			}
+		}
 	}
+	recordTokenBudgetSpent(token, quota-budgetReserved, time.Now())
 	return nil
 }
 
-func PreConsumeTokenQuota(tokenId int, quota int) (err error) {
+// ReserveTokenBudget counts quota against the budgets of the token for a request whose quota isn't pre-consumed,
+// it returns a TokenBudgetError when that would take the token over one of them. The reservation is given back
+// with PostConsumeTokenQuotaWithBudget when the request is settled or fails.
+func ReserveTokenBudget(tokenId int, quota int) error {
+	token, err := GetTokenById(tokenId)
+	if err != nil {
+		return err
+	}
+	return reserveTokenBudget(token, quota, time.Now())
+}
+
+func PreConsumeTokenQuota(ctx context.Context, tokenId int, quota int) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -286,6 +335,17 @@ func PreConsumeTokenQuota(tokenId int, quota int) (err error) {
 	if err != nil {
 		return err
 	}
+	now := time.Now()
+	// 检查预算的同时计入本次消耗，之后失败时退还
+	err = reserveTokenBudget(token, quota, now)
+	if err != nil || quota == 0 {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			recordTokenBudgetSpent(token, -quota, now)
+		}
+	}()
 	if !token.UnlimitedQuota && token.RemainQuota < quota {
 		return errors.New("令牌额度不足")
 	}
@@ -318,14 +378,14 @@ func PreConsumeTokenQuota(tokenId int, quota int) (err error) {
 			}
 		}()
 	}
+	ref := ConsumeLedgerRef(ctx)
 	if !token.UnlimitedQuota {
-		err = DecreaseTokenQuota(tokenId, quota)
+		err = DecreaseTokenQuota(tokenId, quota, ref)
 		if err != nil {
 			return err
 		}
 	}
-	err = DecreaseUserQuota(token.UserId, quota)
-	return err
+	return DecreaseUserQuota(token.UserId, quota, ref)
 }
 func (token *Token) UpdateFirstUsedTime() error {
 	return DB.Model(token).Select("FirstUsedTime").Updates(map[string]interface{}{
@@ -0,0 +1,320 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 令牌的周期预算：按自然日、自然周（周一开始）、自然月累计令牌的消耗，超出预算时拒绝请求，下个周期自动重置。
+// 消耗计数保存在 Redis 中，未启用 Redis 时保存在内存中，此时只对当前节点有效。
+// 只有设置了预算的令牌才计数，预算在周期中途设置时从设置后开始累计。
+
+const (
+	TokenBudgetDaily   = "daily"
+	TokenBudgetWeekly  = "weekly"
+	TokenBudgetMonthly = "monthly"
+)
+
+var tokenBudgetPeriodNames = map[string]string{
+	TokenBudgetDaily:   "每日",
+	TokenBudgetWeekly:  "每周",
+	TokenBudgetMonthly: "每月",
+}
+
+// TokenBudgetError is returned when a request would take a token over one of its budgets.
+type TokenBudgetError struct {
+	Period    string
+	Budget    int
+	Spent     int
+	ResetTime int64
+}
+
+func (e *TokenBudgetError) Error() string {
+	return fmt.Sprintf("令牌%s预算 %s 已用尽（已消耗 %s），将于 %s 重置", tokenBudgetPeriodNames[e.Period],
+		common.LogQuota(e.Budget), common.LogQuota(e.Spent), time.Unix(e.ResetTime, 0).Format("2006-01-02 15:04:05"))
+}
+
+type TokenBudgetUsage struct {
+	Period    string `json:"period"`
+	Budget    int    `json:"budget"`
+	Spent     int    `json:"spent"`
+	ResetTime int64  `json:"reset_time"`
+}
+
+type tokenBudgetPeriod struct {
+	name  string
+	start time.Time
+	end   time.Time
+}
+
+type tokenBudgetCounter struct {
+	spent    int
+	expireAt time.Time
+}
+
+var tokenBudgetCounters = make(map[string]*tokenBudgetCounter)
+var tokenBudgetLock sync.Mutex
+
+func tokenBudgetPeriods(now time.Time) []tokenBudgetPeriod {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	week := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	return []tokenBudgetPeriod{
+		{name: TokenBudgetDaily, start: day, end: day.AddDate(0, 0, 1)},
+		{name: TokenBudgetWeekly, start: week, end: week.AddDate(0, 0, 7)},
+		{name: TokenBudgetMonthly, start: month, end: month.AddDate(0, 1, 0)},
+	}
+}
+
+func (token *Token) budget(period string) int {
+	switch period {
+	case TokenBudgetDaily:
+		return token.DailyBudget
+	case TokenBudgetWeekly:
+		return token.WeeklyBudget
+	case TokenBudgetMonthly:
+		return token.MonthlyBudget
+	}
+	return 0
+}
+
+func (token *Token) HasBudget() bool {
+	return token.DailyBudget > 0 || token.WeeklyBudget > 0 || token.MonthlyBudget > 0
+}
+
+func tokenBudgetKey(tokenId int, period tokenBudgetPeriod) string {
+	return fmt.Sprintf("token_budget:%d:%s:%d", tokenId, period.name, period.start.Unix())
+}
+
+// tokenBudgetExpireAt keeps a counter an hour past its period, so requests at the end of a period still find it
+// when they settle.
+func tokenBudgetExpireAt(period tokenBudgetPeriod) time.Time {
+	return period.end.Add(time.Hour)
+}
+
+// getTokenBudgetCounter returns the in-memory counter of key, must be called with tokenBudgetLock held.
+// With create a missing or expired counter is replaced with a new one, otherwise nil is returned.
+func getTokenBudgetCounter(key string, expireAt time.Time, now time.Time, create bool) *tokenBudgetCounter {
+	counter, ok := tokenBudgetCounters[key]
+	if ok && !now.After(counter.expireAt) {
+		return counter
+	}
+	if !create {
+		return nil
+	}
+	// 顺便清理已过期的计数
+	for k, c := range tokenBudgetCounters {
+		if now.After(c.expireAt) {
+			delete(tokenBudgetCounters, k)
+		}
+	}
+	counter = &tokenBudgetCounter{expireAt: expireAt}
+	tokenBudgetCounters[key] = counter
+	return counter
+}
+
+func getTokenBudgetSpent(key string) (int, error) {
+	if common.RedisEnabled {
+		spent, err := common.RDB.Get(context.Background(), key).Result()
+		if errors.Is(err, redis.Nil) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		return strconv.Atoi(spent)
+	}
+	tokenBudgetLock.Lock()
+	defer tokenBudgetLock.Unlock()
+	counter := getTokenBudgetCounter(key, time.Time{}, time.Now(), false)
+	if counter == nil {
+		return 0, nil
+	}
+	return counter.spent, nil
+}
+
+func addTokenBudgetSpent(key string, quota int, expireAt time.Time) error {
+	if common.RedisEnabled {
+		ctx := context.Background()
+		_, err := common.RDB.IncrBy(ctx, key, int64(quota)).Result()
+		if err != nil {
+			return err
+		}
+		return common.RDB.ExpireAt(ctx, key, expireAt).Err()
+	}
+	tokenBudgetLock.Lock()
+	defer tokenBudgetLock.Unlock()
+	getTokenBudgetCounter(key, expireAt, time.Now(), true).spent += quota
+	return nil
+}
+
+// 检查所有周期的预算并计入本次消耗，在一个脚本里完成，并发的请求不会一起超出预算
+// KEYS: 各周期的计数；ARGV: 本次消耗，之后每个周期依次为预算、计数的过期时间
+// 返回超出的周期序号（从 1 开始，0 为未超出）及其已消耗的额度
+var tokenBudgetReserveScript = redis.NewScript(`
+local quota = tonumber(ARGV[1])
+for i = 1, #KEYS do
+	local spent = tonumber(redis.call('GET', KEYS[i]) or '0')
+	local budget = tonumber(ARGV[i * 2])
+	if spent >= budget or spent + quota > budget then
+		return {i, spent}
+	end
+end
+if quota ~= 0 then
+	for i = 1, #KEYS do
+		redis.call('INCRBY', KEYS[i], quota)
+		redis.call('EXPIREAT', KEYS[i], ARGV[i * 2 + 1])
+	end
+end
+return {0, 0}
+`)
+
+// reserveTokenBudgetSpent adds quota to the counters when none of them would go over its budget, otherwise it
+// returns the index of the period that would and how much it has spent, the index is -1 when all fit.
+func reserveTokenBudgetSpent(keys []string, budgets []int, expireAts []time.Time, quota int) (int, int, error) {
+	if common.RedisEnabled {
+		args := []interface{}{quota}
+		for i := range keys {
+			args = append(args, budgets[i], expireAts[i].Unix())
+		}
+		result, err := tokenBudgetReserveScript.Run(context.Background(), common.RDB, keys, args...).Int64Slice()
+		if err != nil {
+			return 0, 0, err
+		}
+		return int(result[0]) - 1, int(result[1]), nil
+	}
+	tokenBudgetLock.Lock()
+	defer tokenBudgetLock.Unlock()
+	now := time.Now()
+	counters := make([]*tokenBudgetCounter, len(keys))
+	for i, key := range keys {
+		counters[i] = getTokenBudgetCounter(key, expireAts[i], now, true)
+		if spent := counters[i].spent; spent >= budgets[i] || spent+quota > budgets[i] {
+			return i, spent, nil
+		}
+	}
+	for _, counter := range counters {
+		counter.spent += quota
+	}
+	return -1, 0, nil
+}
+
+// reserveTokenBudget checks the budgets of the token and counts quota against them in one step, it returns a
+// TokenBudgetError when spending quota more would take the token over one of its budgets. A token that has used
+// up a budget is refused even when quota is 0.
+func reserveTokenBudget(token *Token, quota int, now time.Time) error {
+	if !token.HasBudget() {
+		return nil
+	}
+	var periods []tokenBudgetPeriod
+	var keys []string
+	var budgets []int
+	var expireAts []time.Time
+	for _, period := range tokenBudgetPeriods(now) {
+		budget := token.budget(period.name)
+		if budget <= 0 {
+			continue
+		}
+		periods = append(periods, period)
+		keys = append(keys, tokenBudgetKey(token.Id, period))
+		budgets = append(budgets, budget)
+		expireAts = append(expireAts, tokenBudgetExpireAt(period))
+	}
+	exceeded, spent, err := reserveTokenBudgetSpent(keys, budgets, expireAts, quota)
+	if err != nil {
+		// 计数不可用时放行，避免 Redis 故障导致全部请求失败
+		common.SysError("failed to reserve token budget: " + err.Error())
+		return nil
+	}
+	if exceeded < 0 {
+		return nil
+	}
+	return &TokenBudgetError{
+		Period:    periods[exceeded].name,
+		Budget:    budgets[exceeded],
+		Spent:     spent,
+		ResetTime: periods[exceeded].end.Unix(),
+	}
+}
+
+// CheckTokenBudget returns a TokenBudgetError when spending quota more would take the token over one of its
+// budgets, without counting it. It's for requests that are only billed once they are done.
+func CheckTokenBudget(tokenId int, quota int) error {
+	token, err := GetTokenById(tokenId)
+	if err != nil {
+		return err
+	}
+	return checkTokenBudget(token, quota, time.Now())
+}
+
+func checkTokenBudget(token *Token, quota int, now time.Time) error {
+	if !token.HasBudget() {
+		return nil
+	}
+	for _, period := range tokenBudgetPeriods(now) {
+		budget := token.budget(period.name)
+		if budget <= 0 {
+			continue
+		}
+		spent, err := getTokenBudgetSpent(tokenBudgetKey(token.Id, period))
+		if err != nil {
+			// 计数不可用时放行，避免 Redis 故障导致全部请求失败
+			common.SysError("failed to get token budget spent: " + err.Error())
+			continue
+		}
+		if spent >= budget || spent+quota > budget {
+			return &TokenBudgetError{
+				Period:    period.name,
+				Budget:    budget,
+				Spent:     spent,
+				ResetTime: period.end.Unix(),
+			}
+		}
+	}
+	return nil
+}
+
+// recordTokenBudgetSpent counts quota against the budgets of the token, quota is negative when it's returned.
+func recordTokenBudgetSpent(token *Token, quota int, now time.Time) {
+	if quota == 0 || !token.HasBudget() {
+		return
+	}
+	for _, period := range tokenBudgetPeriods(now) {
+		if token.budget(period.name) <= 0 {
+			continue
+		}
+		err := addTokenBudgetSpent(tokenBudgetKey(token.Id, period), quota, tokenBudgetExpireAt(period))
+		if err != nil {
+			common.SysError("failed to record token budget spent: " + err.Error())
+		}
+	}
+}
+
+// GetTokenBudgetUsage returns how much of each budget the token has spent in the current periods.
+func GetTokenBudgetUsage(token *Token) ([]TokenBudgetUsage, error) {
+	var usages []TokenBudgetUsage
+	for _, period := range tokenBudgetPeriods(time.Now()) {
+		budget := token.budget(period.name)
+		if budget <= 0 {
+			continue
+		}
+		spent, err := getTokenBudgetSpent(tokenBudgetKey(token.Id, period))
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, TokenBudgetUsage{
+			Period:    period.name,
+			Budget:    budget,
+			Spent:     spent,
+			ResetTime: period.end.Unix(),
+		})
+	}
+	return usages, nil
+}
@@ -0,0 +1,139 @@
+package model
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBudgetPeriods(t *testing.T) {
+	tests := []struct {
+		now        time.Time
+		weekStart  time.Time
+		monthStart time.Time
+		monthEnd   time.Time
+	}{
+		// 周一零点属于新的一周
+		{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		// 周日属于周一开始的一周
+		{time.Date(2024, 1, 7, 23, 59, 59, 0, time.UTC), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		// 跨月的一周从上个月开始
+		{time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), time.Date(2024, 2, 26, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		// 闰年二月
+		{time.Date(2024, 2, 29, 23, 59, 59, 0, time.UTC), time.Date(2024, 2, 26, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		// 跨年
+		{time.Date(2024, 12, 31, 8, 0, 0, 0, time.UTC), time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC), time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		periods := tokenBudgetPeriods(test.now)
+		name := test.now.Format(time.RFC1123)
+		day := time.Date(test.now.Year(), test.now.Month(), test.now.Day(), 0, 0, 0, 0, time.UTC)
+		assert.Equal(t, tokenBudgetPeriod{name: TokenBudgetDaily, start: day, end: day.AddDate(0, 0, 1)}, periods[0], name)
+		assert.Equal(t, tokenBudgetPeriod{name: TokenBudgetWeekly, start: test.weekStart, end: test.weekStart.AddDate(0, 0, 7)}, periods[1], name)
+		assert.Equal(t, tokenBudgetPeriod{name: TokenBudgetMonthly, start: test.monthStart, end: test.monthEnd}, periods[2], name)
+	}
+}
+
+func TestReserveTokenBudget(t *testing.T) {
+	setupTestState(t)
+	token := &Token{Id: 1, DailyBudget: 100, MonthlyBudget: 150}
+	now := time.Now()
+
+	assert.NoError(t, reserveTokenBudget(token, 60, now))
+	err := reserveTokenBudget(token, 50, now)
+	var budgetErr *TokenBudgetError
+	if assert.ErrorAs(t, err, &budgetErr) {
+		assert.Equal(t, TokenBudgetDaily, budgetErr.Period)
+		assert.Equal(t, 60, budgetErr.Spent)
+	}
+	assert.NoError(t, reserveTokenBudget(token, 40, now))
+	assert.Error(t, reserveTokenBudget(token, 0, now), "a used up budget refuses requests without pre-consumed quota")
+
+	// 每日预算放宽后仍受每月预算限制
+	token.DailyBudget = 1000
+	err = reserveTokenBudget(token, 60, now)
+	if assert.ErrorAs(t, err, &budgetErr) {
+		assert.Equal(t, TokenBudgetMonthly, budgetErr.Period)
+	}
+	usages, err := GetTokenBudgetUsage(token)
+	assert.NoError(t, err)
+	for _, usage := range usages {
+		assert.Equal(t, 100, usage.Spent, "a refused request counts against no budget, %s", usage.Period)
+	}
+}
+
+func TestReserveTokenBudgetConcurrently(t *testing.T) {
+	setupTestState(t)
+	token := &Token{Id: 1, WeeklyBudget: 100}
+	now := time.Now()
+	var wg sync.WaitGroup
+	var reserved atomic.Int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if reserveTokenBudget(token, 10, now) == nil {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 10, reserved.Load())
+	assert.NoError(t, checkTokenBudget(&Token{Id: 1, WeeklyBudget: 101}, 1, now))
+	assert.Error(t, checkTokenBudget(token, 0, now))
+}
+
+func TestPreConsumeTokenQuotaReturnsBudget(t *testing.T) {
+	setupTestDB(t, &User{}, &Token{}, &QuotaLedger{}, &RechargeRecord{})
+	user := &User{Username: "budget", AffCode: "budget", Quota: 50}
+	assert.NoError(t, DB.Create(user).Error)
+	token := &Token{UserId: user.Id, Key: "budget", UnlimitedQuota: true, DailyBudget: 100}
+	assert.NoError(t, DB.Create(token).Error)
+
+	assert.Error(t, PreConsumeTokenQuota(context.Background(), token.Id, 80), "the user doesn't have enough quota")
+	assert.NoError(t, CheckTokenBudget(token.Id, 100), "the budget of a failed request is returned")
+
+	assert.NoError(t, PreConsumeTokenQuota(context.Background(), token.Id, 40))
+	err := CheckTokenBudget(token.Id, 61)
+	var budgetErr *TokenBudgetError
+	assert.ErrorAs(t, err, &budgetErr)
+}
+
+func TestReservedTokenBudgetIsSettled(t *testing.T) {
+	setupTestDB(t, &User{}, &Token{}, &QuotaLedger{}, &RechargeRecord{})
+	user := &User{Username: "budget", AffCode: "budget", Quota: 1000}
+	assert.NoError(t, DB.Create(user).Error)
+	token := &Token{UserId: user.Id, Key: "budget", UnlimitedQuota: true, DailyBudget: 100}
+	assert.NoError(t, DB.Create(token).Error)
+
+	// 未预扣费的请求也占用预算，并发的请求不能一起超出
+	assert.NoError(t, ReserveTokenBudget(token.Id, 60))
+	var budgetErr *TokenBudgetError
+	assert.ErrorAs(t, ReserveTokenBudget(token.Id, 60), &budgetErr)
+
+	// 结算时只计入实际消耗与占用的差额
+	assert.NoError(t, PostConsumeTokenQuotaWithBudget(context.Background(), token.Id, 30, 60))
+	usages, err := GetTokenBudgetUsage(token)
+	assert.NoError(t, err)
+	if assert.Len(t, usages, 1) {
+		assert.Equal(t, 30, usages[0].Spent)
+	}
+	assert.NoError(t, DB.First(user, user.Id).Error)
+	assert.Equal(t, 970, user.Quota)
+
+	// 失败的请求退还占用的预算，不改动额度
+	assert.NoError(t, ReserveTokenBudget(token.Id, 50))
+	assert.NoError(t, PostConsumeTokenQuotaWithBudget(context.Background(), token.Id, 0, 50))
+	usages, err = GetTokenBudgetUsage(token)
+	assert.NoError(t, err)
+	if assert.Len(t, usages, 1) {
+		assert.Equal(t, 30, usages[0].Spent)
+	}
+	assert.NoError(t, DB.First(user, user.Id).Error)
+	assert.Equal(t, 970, user.Quota)
+}
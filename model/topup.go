@@ -11,6 +11,7 @@ type TopUp struct {
 	TradeNo    string  `json:"trade_no"`
 	CreateTime int64   `json:"create_time"`
 	Status     string  `json:"status"`
+	PlanId     int     `json:"plan_id" gorm:"default:0"` // 订阅套餐的订单，Amount 为订阅月数
 }
 
 type TopUpQueryParams struct {
@@ -32,6 +33,18 @@ func (topUp *TopUp) Update() error {
 	return err
 }
 
+// ClaimTopUp moves the pending order to processing. Of concurrent payment notifications of the order only one
+// claims it, the others get false.
+func ClaimTopUp(tradeNo string) (bool, error) {
+	result := DB.Model(&TopUp{}).Where("trade_no = ? AND status = ?", tradeNo, "pending").Update("status", "processing")
+	return result.RowsAffected > 0, result.Error
+}
+
+// ReleaseTopUp puts the order claimed but not fulfilled back to pending, so the next notification retries it.
+func ReleaseTopUp(tradeNo string) error {
+	return DB.Model(&TopUp{}).Where("trade_no = ? AND status = ?", tradeNo, "processing").Update("status", "pending").Error
+}
+
 func GetTopUpById(id int) *TopUp {
 	var topUp *TopUp
 	var err error
@@ -207,6 +207,10 @@ func (user *User) TransferAffQuotaToQuota(quota int) error {
 	if err := tx.Save(user).Error; err != nil {
 		return err
 	}
+	entry := newLedgerEntry(transferAmount, LedgerRef{Reason: LedgerReasonAffTransfer, Counterparty: fmt.Sprintf("aff:%d", user.Id)})
+	if err := recordUserLedger(tx, user.Id, entry); err != nil {
+		return err
+	}
 
 	// 提交事务
 	return tx.Commit().Error
@@ -262,6 +266,12 @@ func (user *User) AffQuotaToQuota(quota int, alipayAccount string) error {
 	if err := tx.Save(user).Error; err != nil {
 		return err
 	}
+	// 提现不经过用户额度，从邀请账户直接转出
+	entry := newLedgerEntry(-transferAmount, LedgerRef{Reason: LedgerReasonWithdrawal, RefId: order.OrderNumber})
+	entry.Account = fmt.Sprintf("aff:%d", user.Id)
+	if err := createLedgerEntries(tx, []*QuotaLedger{entry}); err != nil {
+		return err
+	}
 
 	// 提交事务
 	if err := tx.Commit().Error; err != nil {
@@ -298,11 +308,15 @@ func (user *User) Insert(inviterId int) error {
 		return result.Error
 	}
 	if config.QuotaForNewUser > 0 {
+		err = recordUserLedger(DB, user.Id, newLedgerEntry(user.Quota, LedgerRef{Reason: LedgerReasonGift}))
+		if err != nil {
+			common.SysError("failed to record quota ledger: " + err.Error())
+		}
 		RecordLog(user.Id, LogTypeSystem, 0, fmt.Sprintf("新用户注册赠送 %s", common.LogQuota(config.QuotaForNewUser)))
 	}
 	if inviterId != 0 {
 		if config.QuotaForInvitee > 0 {
-			_ = IncreaseUserQuota(user.Id, config.QuotaForInvitee)
+			_ = IncreaseUserQuota(user.Id, config.QuotaForInvitee, LedgerRef{Reason: LedgerReasonGift, RefId: UserLedgerAccount(inviterId)})
 			RecordLog(user.Id, LogTypeSystem, 0, fmt.Sprintf("使用邀请码赠送 %s", common.LogQuota(config.QuotaForInvitee)))
 		}
 		if config.QuotaForInviter > 0 {
@@ -364,8 +378,19 @@ func (user *User) Update(updatePassword bool) error {
 		}
 	}
 	newUser := *user
-	DB.First(&user, user.Id)
-	err = DB.Model(user).Updates(newUser).Error
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		tx.Set("gorm:query_option", "FOR UPDATE").First(&user, user.Id)
+		oldQuota := user.Quota
+		err := tx.Model(user).Updates(newUser).Error
+		if err != nil {
+			return err
+		}
+		// Updates 不更新零值，额度为 0 时不会被修改
+		if newUser.Quota != 0 && newUser.Quota != oldQuota {
+			return recordUserLedger(tx, user.Id, newLedgerEntry(newUser.Quota-oldQuota, LedgerRef{Reason: LedgerReasonAdjust}))
+		}
+		return nil
+	})
 	if err == nil {
 		if common.RedisEnabled {
 			_ = common.RedisSet(fmt.Sprintf("user_group:%d", user.Id), user.Group, time.Duration(UserId2GroupCacheSeconds)*time.Second)
@@ -532,24 +557,29 @@ func GetUserEmail(id int) (email string, err error) {
 }
 
 func GetUserGroup(id int) (group string, err error) {
+	return getUserGroup(DB, id)
+}
+
+func getUserGroup(tx *gorm.DB, id int) (group string, err error) {
 	groupCol := "`group`"
 	if common.UsingPostgreSQL {
 		groupCol = `"group"`
 	}
 
-	err = DB.Model(&User{}).Where("id = ?", id).Select(groupCol).Find(&group).Error
+	err = tx.Model(&User{}).Where("id = ?", id).Select(groupCol).Find(&group).Error
 	return group, err
 }
 
-func IncreaseUserQuota(id int, quota int) (err error) {
+func IncreaseUserQuota(id int, quota int, ref LedgerRef) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
+	entry := newLedgerEntry(quota, ref)
 	if config.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeUserQuota, id, quota)
+		addNewLedgerRecord(BatchUpdateTypeUserQuota, id, quota, entry)
 		return nil
 	}
-	return increaseUserQuota(id, quota)
+	return increaseUserQuota(id, quota, entry)
 }
 
 func VipUserQuota(id int) (err error) {
@@ -563,14 +593,14 @@ func VipUserQuota(id int) (err error) {
 	return nil
 }
 
-func increaseUserQuota(id int, quota int) (err error) {
+func increaseUserQuota(id int, quota int, entries ...*QuotaLedger) (err error) {
 	// 启动一个事务处理增加配额
 	err = DB.Transaction(func(tx *gorm.DB) error {
 		// 更新用户配额
 		if err := tx.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota + ?", quota)).Error; err != nil {
 			return err
 		}
-		return nil
+		return recordUserLedger(tx, id, entries...)
 	})
 
 	return err
@@ -613,18 +643,19 @@ func IncreaseRechargeQuota(id int, topupratio string, quota int) (err error) {
 	return err
 }
 
-func DecreaseUserQuota(id int, quota int) (err error) {
+func DecreaseUserQuota(id int, quota int, ref LedgerRef) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
+	entry := newLedgerEntry(-quota, ref)
 	if config.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeUserQuota, id, -quota)
+		addNewLedgerRecord(BatchUpdateTypeUserQuota, id, -quota, entry)
 		return nil
 	}
-	return decreaseUserQuota(id, quota)
+	return decreaseUserQuota(id, quota, entry)
 }
 
-func decreaseUserQuota(userID int, quotaToDecrease int) (err error) {
+func decreaseUserQuota(userID int, quotaToDecrease int, entry *QuotaLedger) (err error) {
 	maxRetries := 2
 	for retries := 0; retries < maxRetries; retries++ {
 		err = DB.Transaction(func(tx *gorm.DB) error {
@@ -651,6 +682,11 @@ func decreaseUserQuota(userID int, quotaToDecrease int) (err error) {
 			if result.RowsAffected == 0 {
 				return errors.New("version mismatch")
 			}
+			// 重试时事务已回滚，每次写入新的记录
+			ledger := *entry
+			if err := recordUserLedger(tx, userID, &ledger); err != nil {
+				return err
+			}
 
 			// 4. 获取充值记录
 			var records []RechargeRecord
@@ -803,6 +839,11 @@ func UpdateUserQuotaData() {
 				if err != nil {
 					return err
 				}
+				entry := newLedgerEntry(-record.Amount, LedgerRef{Reason: LedgerReasonExpire, RefId: strconv.Itoa(int(record.ID))})
+				err = recordUserLedger(tx, int(record.UserID), entry)
+				if err != nil {
+					return err
+				}
 
 				// 设置过期记录的金额为0，表示已经从用户余额中扣除
 				record.Amount = 0
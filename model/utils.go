@@ -12,15 +12,19 @@ const (
 	BatchUpdateTypeUsedQuota
 	BatchUpdateTypeChannelUsedQuota
 	BatchUpdateTypeRequestCount
+	BatchUpdateTypeChannelKeyUsedQuota
+	BatchUpdateTypeChannelKeyRequestCount
 	BatchUpdateTypeCount // if you add a new type, you need to add a new map and a new lock
 )
 
 var batchUpdateStores []map[int]int
+var batchUpdateLedgers []map[int][]*QuotaLedger // 与额度一起写入的账本记录
 var batchUpdateLocks []sync.Mutex
 
 func init() {
 	for i := 0; i < BatchUpdateTypeCount; i++ {
 		batchUpdateStores = append(batchUpdateStores, make(map[int]int))
+		batchUpdateLedgers = append(batchUpdateLedgers, make(map[int][]*QuotaLedger))
 		batchUpdateLocks = append(batchUpdateLocks, sync.Mutex{})
 	}
 }
@@ -44,23 +48,32 @@ func addNewRecord(type_ int, id int, value int) {
 	}
 }
 
+func addNewLedgerRecord(type_ int, id int, value int, entry *QuotaLedger) {
+	batchUpdateLocks[type_].Lock()
+	defer batchUpdateLocks[type_].Unlock()
+	batchUpdateStores[type_][id] += value
+	batchUpdateLedgers[type_][id] = append(batchUpdateLedgers[type_][id], entry)
+}
+
 func batchUpdate() {
 	common.SysLog("batch update started")
 	for i := 0; i < BatchUpdateTypeCount; i++ {
 		batchUpdateLocks[i].Lock()
 		store := batchUpdateStores[i]
 		batchUpdateStores[i] = make(map[int]int)
+		ledgers := batchUpdateLedgers[i]
+		batchUpdateLedgers[i] = make(map[int][]*QuotaLedger)
 		batchUpdateLocks[i].Unlock()
 		// TODO: maybe we can combine updates with same key?
 		for key, value := range store {
 			switch i {
 			case BatchUpdateTypeUserQuota:
-				err := increaseUserQuota(key, value)
+				err := increaseUserQuota(key, value, ledgers[key]...)
 				if err != nil {
 					common.SysError("failed to batch update user quota: " + err.Error())
 				}
 			case BatchUpdateTypeTokenQuota:
-				err := increaseTokenQuota(key, value)
+				err := increaseTokenQuota(key, value, ledgers[key]...)
 				if err != nil {
 					common.SysError("failed to batch update token quota: " + err.Error())
 				}
@@ -70,6 +83,10 @@ func batchUpdate() {
 				updateUserRequestCount(key, value)
 			case BatchUpdateTypeChannelUsedQuota:
 				updateChannelUsedQuota(key, value)
+			case BatchUpdateTypeChannelKeyUsedQuota:
+				updateChannelKeyUsedQuota(key, value)
+			case BatchUpdateTypeChannelKeyRequestCount:
+				updateChannelKeyRequestCount(key, value)
 			}
 		}
 	}
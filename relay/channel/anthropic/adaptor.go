@@ -58,8 +58,10 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *util.Rel
 	if !meta.IsClaude {
 		if meta.IsStream {
 			var responseText string
-			err, _, responseText = StreamHandler(c, resp)
-			usage = openai.ResponseText2Usage(responseText, meta.ActualModelName, meta.PromptTokens)
+			err, usage, responseText = StreamHandler(c, resp)
+			if usage == nil || usage.TotalTokens == 0 {
+				usage = openai.ResponseText2Usage(responseText, meta.ActualModelName, meta.PromptTokens)
+			}
 
 			if usage.CompletionTokens == 0 {
 				if config.BlankReplyRetryEnabled {
@@ -81,8 +83,10 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *util.Rel
 	} else {
 		if meta.IsStream {
 			var responseText string
-			err, _, responseText = ClaudeStreamHandler(c, resp)
-			usage = openai.ResponseText2Usage(responseText, meta.ActualModelName, meta.PromptTokens)
+			err, usage, responseText = ClaudeStreamHandler(c, resp)
+			if usage == nil || usage.TotalTokens == 0 {
+				usage = openai.ResponseText2Usage(responseText, meta.ActualModelName, meta.PromptTokens)
+			}
 			if usage.CompletionTokens == 0 {
 				if config.BlankReplyRetryEnabled {
 					return "", nil, &model.ErrorWithStatusCode{
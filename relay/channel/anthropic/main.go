@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/http"
 	"one-api/common"
+	"one-api/common/ctxkey"
 	"one-api/common/helper"
 	"one-api/common/image"
 	"one-api/common/logger"
@@ -507,6 +508,7 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 	stopChan := make(chan bool)
 	errorChan := make(chan *model.ErrorWithStatusCode, 1)
 	go func() {
+		stopped := false
 		for scanner.Scan() {
 			data := scanner.Text()
 			if strings.HasPrefix(data, "event: error") {
@@ -520,12 +522,18 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 				continue
 			}
 			data = strings.TrimPrefix(data, "data: ")
+			if strings.Contains(data, `"message_stop"`) {
+				stopped = true
+			}
 			dataChan <- data
 		}
+		if scanner.Err() != nil || !stopped {
+			c.Set(ctxkey.StreamInterrupted, true)
+		}
 		stopChan <- true
 	}()
 	common.SetEventStreamHeaders(c)
-	var usage model.Usage
+	var usage Usage
 	var modelName string
 	var id string
 	var streamError *model.ErrorWithStatusCode
@@ -542,8 +550,7 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 			}
 			response, meta := StreamResponseClaude2OpenAI(&claudeResponse)
 			if meta != nil {
-				usage.PromptTokens += meta.Usage.InputTokens
-				usage.CompletionTokens += meta.Usage.OutputTokens
+				MergeStreamUsage(&usage, &meta.Usage)
 				modelName = meta.Model
 				id = fmt.Sprintf("chatcmpl-%s", meta.Id)
 				return true
@@ -574,7 +581,34 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 		}
 	})
 	_ = resp.Body.Close()
-	return streamError, &usage, responseTextBuilder.String()
+	return streamError, UsageClaude2OpenAI(&usage), responseTextBuilder.String()
+}
+
+// UsageClaude2OpenAI counts the cached prompt tokens into the prompt tokens like OpenAI does,
+// Anthropic reports them apart from input_tokens.
+func UsageClaude2OpenAI(usage *Usage) *model.Usage {
+	promptTokens := usage.InputTokens + usage.CacheReadInputTokens + usage.CacheCreationInputTokens
+	openaiUsage := &model.Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: usage.OutputTokens,
+		TotalTokens:      promptTokens + usage.OutputTokens,
+	}
+	if usage.CacheReadInputTokens > 0 || usage.CacheCreationInputTokens > 0 {
+		openaiUsage.PromptTokensDetails = &model.PromptTokensDetails{
+			CachedTokens:        usage.CacheReadInputTokens,
+			CacheCreationTokens: usage.CacheCreationInputTokens,
+		}
+	}
+	return openaiUsage
+}
+
+// MergeStreamUsage takes the usage of message_start and message_delta events,
+// the counts of message_delta are cumulative.
+func MergeStreamUsage(usage *Usage, event *Usage) {
+	usage.InputTokens = max(usage.InputTokens, event.InputTokens)
+	usage.OutputTokens = max(usage.OutputTokens, event.OutputTokens)
+	usage.CacheCreationInputTokens = max(usage.CacheCreationInputTokens, event.CacheCreationInputTokens)
+	usage.CacheReadInputTokens = max(usage.CacheReadInputTokens, event.CacheReadInputTokens)
 }
 
 func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName string) (*model.ErrorWithStatusCode, *model.Usage, string) {
@@ -609,12 +643,8 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	if len(claudeResponse.Content[0].Text) > 0 {
 		aitext = claudeResponse.Content[0].Text
 	}
-	usage := model.Usage{
-		PromptTokens:     claudeResponse.Usage.InputTokens,
-		CompletionTokens: claudeResponse.Usage.OutputTokens,
-		TotalTokens:      claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens,
-	}
-	fullTextResponse.Usage = usage
+	usage := UsageClaude2OpenAI(&claudeResponse.Usage)
+	fullTextResponse.Usage = *usage
 	jsonResponse, err := json.Marshal(fullTextResponse)
 	if err != nil {
 		return openai.ErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil, ""
@@ -622,7 +652,7 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	c.Writer.Header().Set("Content-Type", "application/json")
 	c.Writer.WriteHeader(resp.StatusCode)
 	_, _ = c.Writer.Write(jsonResponse)
-	return nil, &usage, aitext
+	return nil, usage, aitext
 }
 
 func ClaudeStreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage, string) {
@@ -631,7 +661,7 @@ func ClaudeStreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithS
 	// 设置适合流式传输的响应头
 	common.SetEventStreamHeaders(c)
 	var responseTextBuilder strings.Builder
-	var usage model.Usage
+	var usage Usage
 	responseText := ""
 	sendStopMessage := false
 
@@ -662,7 +692,7 @@ func ClaudeStreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithS
 									Code:    529,
 								},
 								StatusCode: 529,
-							}, &usage, responseText
+							}, UsageClaude2OpenAI(&usage), responseText
 						// 可以在这里添加其他错误类型的处理
 						default:
 							return &model.ErrorWithStatusCode{
@@ -672,7 +702,7 @@ func ClaudeStreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithS
 									Code:    http.StatusInternalServerError,
 								},
 								StatusCode: http.StatusInternalServerError,
-							}, &usage, responseText
+							}, UsageClaude2OpenAI(&usage), responseText
 						}
 					}
 				}
@@ -701,8 +731,7 @@ func ClaudeStreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithS
 
 			response, meta := StreamResponseClaude2OpenAI(&claudeResponse)
 			if meta != nil {
-				usage.PromptTokens += meta.Usage.InputTokens
-				usage.CompletionTokens += meta.Usage.OutputTokens
+				MergeStreamUsage(&usage, &meta.Usage)
 			}
 			if response != nil {
 				responsePart := response.Choices[0].Delta.Content.(string)
@@ -716,7 +745,7 @@ func ClaudeStreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithS
 		sendStreamStopMessage(c)
 	}
 
-	return nil, &usage, responseTextBuilder.String()
+	return nil, UsageClaude2OpenAI(&usage), responseTextBuilder.String()
 }
 
 // 修改 sendStreamStopMessage 函数
@@ -753,11 +782,7 @@ func ClaudeHandler(c *gin.Context, resp *http.Response, promptTokens int, modelN
 	}
 
 	aitext = claudeResponse.Content[0].Text
-	usage := model.Usage{
-		PromptTokens:     claudeResponse.Usage.InputTokens,
-		CompletionTokens: claudeResponse.Usage.OutputTokens,
-		TotalTokens:      claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens,
-	}
+	usage := UsageClaude2OpenAI(&claudeResponse.Usage)
 
 	if err != nil {
 		return openai.ErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil, ""
@@ -765,5 +790,5 @@ func ClaudeHandler(c *gin.Context, resp *http.Response, promptTokens int, modelN
 	c.Writer.Header().Set("Content-Type", "application/json")
 	c.Writer.WriteHeader(resp.StatusCode)
 	_, err = c.Writer.Write(responseBody)
-	return nil, &usage, aitext
+	return nil, usage, aitext
 }
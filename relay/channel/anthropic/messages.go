@@ -0,0 +1,531 @@
+package anthropic
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/relay/channel/openai"
+	"one-api/relay/model"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MessagesRequest is a /v1/messages request as sent by the Claude clients, it is translated
+// into a chat completions request when the selected channel does not speak Claude natively.
+type MessagesRequest struct {
+	Model         string            `json:"model"`
+	Messages      []MessagesMessage `json:"messages"`
+	System        json.RawMessage   `json:"system,omitempty"`
+	MaxTokens     uint              `json:"max_tokens,omitempty"`
+	StopSequences []string          `json:"stop_sequences,omitempty"`
+	Stream        bool              `json:"stream,omitempty"`
+	Temperature   *float64          `json:"temperature,omitempty"`
+	TopP          *float64          `json:"top_p,omitempty"`
+	TopK          int               `json:"top_k,omitempty"`
+	Tools         []MessagesTool    `json:"tools,omitempty"`
+	ToolChoice    *ToolChoice       `json:"tool_choice,omitempty"`
+	Metadata      *Metadata         `json:"metadata,omitempty"`
+}
+
+type MessagesMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// MessagesContent is a content block, the content of a tool_result is either a string or a list of blocks
+type MessagesContent struct {
+	Type      string               `json:"type"`
+	Text      string               `json:"text,omitempty"`
+	Source    *MessagesImageSource `json:"source,omitempty"`
+	Id        string               `json:"id,omitempty"`
+	Name      string               `json:"name,omitempty"`
+	Input     any                  `json:"input,omitempty"`
+	ToolUseId string               `json:"tool_use_id,omitempty"`
+	Content   json.RawMessage      `json:"content,omitempty"`
+	IsError   bool                 `json:"is_error,omitempty"`
+}
+
+type MessagesImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	Url       string `json:"url,omitempty"`
+}
+
+type MessagesTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema any    `json:"input_schema"`
+}
+
+type ToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+type MessagesResponse struct {
+	Id           string           `json:"id"`
+	Type         string           `json:"type"`
+	Role         string           `json:"role"`
+	Model        string           `json:"model"`
+	Content      []map[string]any `json:"content"`
+	StopReason   *string          `json:"stop_reason"`
+	StopSequence *string          `json:"stop_sequence"`
+	Usage        Usage            `json:"usage"`
+}
+
+type MessagesStreamEvent struct {
+	Type         string            `json:"type"`
+	Message      *MessagesResponse `json:"message,omitempty"`
+	Index        *int              `json:"index,omitempty"`
+	ContentBlock map[string]any    `json:"content_block,omitempty"`
+	Delta        map[string]any    `json:"delta,omitempty"`
+	Usage        *Usage            `json:"usage,omitempty"`
+}
+
+func stopReasonOpenAI2Claude(reason string) string {
+	switch reason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	default:
+		return "end_turn"
+	}
+}
+
+func parseMessagesContent(content json.RawMessage) ([]MessagesContent, error) {
+	if len(content) == 0 || string(content) == "null" {
+		return nil, nil
+	}
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return []MessagesContent{{Type: "text", Text: text}}, nil
+	}
+	var blocks []MessagesContent
+	err := json.Unmarshal(content, &blocks)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message content: %s", err.Error())
+	}
+	return blocks, nil
+}
+
+func messagesContentText(blocks []MessagesContent) string {
+	var text strings.Builder
+	for _, block := range blocks {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	return text.String()
+}
+
+func messagesImageUrl(source *MessagesImageSource) (string, error) {
+	if source == nil {
+		return "", errors.New("image source is required")
+	}
+	switch source.Type {
+	case "base64":
+		return fmt.Sprintf("data:%s;base64,%s", source.MediaType, source.Data), nil
+	case "url":
+		return source.Url, nil
+	}
+	return "", fmt.Errorf("image source type %s is not supported", source.Type)
+}
+
+// messagesContent2MessageContent converts the text and image blocks of a user message.
+func messagesContent2MessageContent(blocks []MessagesContent) (any, error) {
+	contentList := make([]any, 0, len(blocks))
+	hasImage := false
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			contentList = append(contentList, map[string]any{
+				"type": model.ContentTypeText,
+				"text": block.Text,
+			})
+		case "image":
+			url, err := messagesImageUrl(block.Source)
+			if err != nil {
+				return nil, err
+			}
+			hasImage = true
+			contentList = append(contentList, map[string]any{
+				"type":      model.ContentTypeImageURL,
+				"image_url": map[string]any{"url": url},
+			})
+		}
+	}
+	if !hasImage {
+		return messagesContentText(blocks), nil
+	}
+	return contentList, nil
+}
+
+func messages2ChatMessages(message MessagesMessage) ([]model.Message, error) {
+	blocks, err := parseMessagesContent(message.Content)
+	if err != nil {
+		return nil, err
+	}
+	var messages []model.Message
+	if message.Role == "assistant" {
+		chatMessage := model.Message{Role: "assistant", Content: messagesContentText(blocks)}
+		for _, block := range blocks {
+			if block.Type != "tool_use" {
+				continue
+			}
+			input := block.Input
+			if input == nil {
+				input = map[string]any{}
+			}
+			arguments, _ := json.Marshal(input)
+			chatMessage.ToolCalls = append(chatMessage.ToolCalls, model.Tool{
+				Id:   block.Id,
+				Type: "function",
+				Function: model.Function{
+					Name:      block.Name,
+					Arguments: string(arguments),
+				},
+			})
+		}
+		return append(messages, chatMessage), nil
+	}
+
+	// the results of the tool calls answer the previous assistant message, so they go first
+	var others []MessagesContent
+	for _, block := range blocks {
+		if block.Type != "tool_result" {
+			others = append(others, block)
+			continue
+		}
+		resultBlocks, err := parseMessagesContent(block.Content)
+		if err != nil {
+			return nil, err
+		}
+		result := messagesContentText(resultBlocks)
+		if block.IsError {
+			result = "Error: " + result
+		}
+		messages = append(messages, model.Message{Role: "tool", ToolCallId: block.ToolUseId, Content: result})
+	}
+	if len(others) > 0 || len(messages) == 0 {
+		content, err := messagesContent2MessageContent(others)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, model.Message{Role: "user", Content: content})
+	}
+	return messages, nil
+}
+
+// MessagesRequest2ChatRequest translates a Claude messages request into a chat completions request.
+func MessagesRequest2ChatRequest(request *MessagesRequest) (*model.GeneralOpenAIRequest, error) {
+	chatRequest := &model.GeneralOpenAIRequest{
+		Model:     request.Model,
+		Stream:    request.Stream,
+		MaxTokens: request.MaxTokens,
+		TopK:      request.TopK,
+	}
+	if request.Temperature != nil {
+		chatRequest.Temperature = *request.Temperature
+	}
+	if request.TopP != nil {
+		chatRequest.TopP = *request.TopP
+	}
+	if len(request.StopSequences) > 0 {
+		chatRequest.Stop = request.StopSequences
+	}
+	if request.Metadata != nil {
+		chatRequest.User = request.Metadata.UserId
+	}
+	systemBlocks, err := parseMessagesContent(request.System)
+	if err != nil {
+		return nil, err
+	}
+	if system := messagesContentText(systemBlocks); system != "" {
+		chatRequest.Messages = append(chatRequest.Messages, model.Message{Role: "system", Content: system})
+	}
+	for _, message := range request.Messages {
+		messages, err := messages2ChatMessages(message)
+		if err != nil {
+			return nil, err
+		}
+		chatRequest.Messages = append(chatRequest.Messages, messages...)
+	}
+	if len(request.Messages) == 0 {
+		return nil, errors.New("messages is required")
+	}
+
+	for _, tool := range request.Tools {
+		chatRequest.Tools = append(chatRequest.Tools, model.Tool{
+			Type: "function",
+			Function: model.Function{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+	if request.ToolChoice != nil {
+		switch request.ToolChoice.Type {
+		case "auto", "none":
+			chatRequest.ToolChoice = request.ToolChoice.Type
+		case "any":
+			chatRequest.ToolChoice = "required"
+		case "tool":
+			chatRequest.ToolChoice = map[string]any{
+				"type":     "function",
+				"function": map[string]any{"name": request.ToolChoice.Name},
+			}
+		}
+	}
+	return chatRequest, nil
+}
+
+func newMessagesResponse(modelName string) *MessagesResponse {
+	return &MessagesResponse{
+		Id:      "msg_" + common.GetUUID(),
+		Type:    "message",
+		Role:    "assistant",
+		Model:   modelName,
+		Content: []map[string]any{},
+	}
+}
+
+func toolCallInput(toolCall model.Tool) any {
+	input := map[string]any{}
+	switch arguments := toolCall.Function.Arguments.(type) {
+	case string:
+		_ = json.Unmarshal([]byte(arguments), &input)
+	case map[string]any:
+		input = arguments
+	}
+	return input
+}
+
+// ChatResponse2MessagesResponse translates a chat completion into a Claude message.
+func ChatResponse2MessagesResponse(textResponse *openai.TextResponse, modelName string, usage *model.Usage) *MessagesResponse {
+	response := newMessagesResponse(modelName)
+	finishReason := ""
+	if len(textResponse.Choices) > 0 {
+		choice := textResponse.Choices[0]
+		finishReason = choice.FinishReason
+		if text := choice.Message.StringContent(); text != "" {
+			response.Content = append(response.Content, map[string]any{"type": "text", "text": text})
+		}
+		for _, toolCall := range choice.Message.ToolCalls {
+			response.Content = append(response.Content, map[string]any{
+				"type":  "tool_use",
+				"id":    toolCall.Id,
+				"name":  toolCall.Function.Name,
+				"input": toolCallInput(toolCall),
+			})
+		}
+	}
+	stopReason := stopReasonOpenAI2Claude(finishReason)
+	response.StopReason = &stopReason
+	if usage != nil {
+		response.Usage = Usage{
+			InputTokens:              usage.PromptTokens - usage.CachedTokens() - usage.CacheCreationTokens(),
+			OutputTokens:             usage.CompletionTokens,
+			CacheCreationInputTokens: usage.CacheCreationTokens(),
+			CacheReadInputTokens:     usage.CachedTokens(),
+		}
+	}
+	return response
+}
+
+// MessagesWriter sits between an adaptor and the client, and rewrites the chat completion
+// the adaptor writes, plain or streamed, into Claude messages and stream events.
+type MessagesWriter struct {
+	gin.ResponseWriter
+	modelName    string
+	promptTokens int
+	stream       bool
+	status       int
+	buffer       bytes.Buffer
+	started      bool
+
+	blockIndex   int
+	blockType    string
+	finishReason string
+}
+
+func NewMessagesWriter(writer gin.ResponseWriter, modelName string, stream bool, promptTokens int) *MessagesWriter {
+	return &MessagesWriter{
+		ResponseWriter: writer,
+		modelName:      modelName,
+		promptTokens:   promptTokens,
+		stream:         stream,
+		status:         http.StatusOK,
+		blockIndex:     -1,
+	}
+}
+
+func (w *MessagesWriter) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *MessagesWriter) WriteHeaderNow() {}
+
+func (w *MessagesWriter) Write(data []byte) (int, error) {
+	w.buffer.Write(data)
+	if w.stream {
+		w.convertStream()
+	}
+	return len(data), nil
+}
+
+func (w *MessagesWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *MessagesWriter) Flush() {
+	if w.stream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *MessagesWriter) writeEvent(event MessagesStreamEvent) {
+	jsonBytes, err := json.Marshal(event)
+	if err != nil {
+		common.SysError("error marshalling message event: " + err.Error())
+		return
+	}
+	_, _ = w.ResponseWriter.WriteString(fmt.Sprintf("event: %s\ndata: %s\n\n", event.Type, jsonBytes))
+}
+
+// convertStream handles the complete chat completion chunks in the buffer.
+func (w *MessagesWriter) convertStream() {
+	for {
+		line, err := w.buffer.ReadString('\n')
+		if err != nil {
+			// keep the incomplete line for the next write
+			remaining := []byte(line)
+			w.buffer.Reset()
+			w.buffer.Write(remaining)
+			return
+		}
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			continue
+		}
+		var chunk openai.ChatCompletionsStreamResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+		w.handleChunk(&chunk)
+	}
+}
+
+func (w *MessagesWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	message := newMessagesResponse(w.modelName)
+	message.Usage.InputTokens = w.promptTokens
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.ResponseWriter.WriteHeader(http.StatusOK)
+	w.writeEvent(MessagesStreamEvent{Type: "message_start", Message: message})
+}
+
+func (w *MessagesWriter) startBlock(block map[string]any) {
+	w.stopBlock()
+	w.blockIndex++
+	w.blockType = block["type"].(string)
+	index := w.blockIndex
+	w.writeEvent(MessagesStreamEvent{Type: "content_block_start", Index: &index, ContentBlock: block})
+}
+
+func (w *MessagesWriter) stopBlock() {
+	if w.blockType == "" {
+		return
+	}
+	index := w.blockIndex
+	w.writeEvent(MessagesStreamEvent{Type: "content_block_stop", Index: &index})
+	w.blockType = ""
+}
+
+func (w *MessagesWriter) handleChunk(chunk *openai.ChatCompletionsStreamResponse) {
+	w.start()
+	// Claude messages have a single content list, only the first choice is kept
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		if text := common.AsString(choice.Delta.Content); text != "" {
+			if w.blockType != "text" {
+				w.startBlock(map[string]any{"type": "text", "text": ""})
+			}
+			index := w.blockIndex
+			w.writeEvent(MessagesStreamEvent{Type: "content_block_delta", Index: &index, Delta: map[string]any{"type": "text_delta", "text": text}})
+		}
+		for _, toolCall := range choice.Delta.ToolCalls {
+			// only the first chunk of a call carries its id, later chunks continue the current call
+			if toolCall.Id != "" || w.blockType != "tool_use" {
+				w.startBlock(map[string]any{"type": "tool_use", "id": toolCall.Id, "name": toolCall.Function.Name, "input": map[string]any{}})
+			}
+			arguments, _ := toolCall.Function.Arguments.(string)
+			if arguments != "" {
+				index := w.blockIndex
+				w.writeEvent(MessagesStreamEvent{Type: "content_block_delta", Index: &index, Delta: map[string]any{"type": "input_json_delta", "partial_json": arguments}})
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			w.finishReason = *choice.FinishReason
+		}
+	}
+}
+
+// Finish writes what is left once the adaptor returned: the converted message, or the closing events of the stream.
+func (w *MessagesWriter) Finish(usage *model.Usage) error {
+	if !w.stream {
+		w.Header().Del("Content-Length")
+		if w.status != http.StatusOK {
+			w.ResponseWriter.WriteHeader(w.status)
+			_, err := w.ResponseWriter.Write(w.buffer.Bytes())
+			return err
+		}
+		var textResponse openai.TextResponse
+		err := json.Unmarshal(w.buffer.Bytes(), &textResponse)
+		if err != nil {
+			return err
+		}
+		jsonBytes, err := json.Marshal(ChatResponse2MessagesResponse(&textResponse, w.modelName, usage))
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.ResponseWriter.WriteHeader(http.StatusOK)
+		_, err = w.ResponseWriter.Write(jsonBytes)
+		return err
+	}
+
+	w.buffer.WriteString("\n")
+	w.convertStream()
+	w.start()
+	w.stopBlock()
+	outputUsage := &Usage{}
+	if usage != nil {
+		outputUsage.OutputTokens = usage.CompletionTokens
+	}
+	w.writeEvent(MessagesStreamEvent{
+		Type:  "message_delta",
+		Delta: map[string]any{"stop_reason": stopReasonOpenAI2Claude(w.finishReason), "stop_sequence": nil},
+		Usage: outputUsage,
+	})
+	w.writeEvent(MessagesStreamEvent{Type: "message_stop"})
+	w.ResponseWriter.Flush()
+	return nil
+}
@@ -74,8 +74,10 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *util.Rel
 	if !meta.IsClaude {
 		if meta.IsStream {
 			var responseText string
-			err, _, responseText = StreamHandler(c, a.awsClient)
-			usage = openai.ResponseText2Usage(responseText, meta.ActualModelName, meta.PromptTokens)
+			err, usage, responseText = StreamHandler(c, a.awsClient)
+			if usage == nil || usage.TotalTokens == 0 {
+				usage = openai.ResponseText2Usage(responseText, meta.ActualModelName, meta.PromptTokens)
+			}
 
 			if usage.CompletionTokens == 0 {
 				if config.BlankReplyRetryEnabled {
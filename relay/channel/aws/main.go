@@ -125,15 +125,11 @@ func Handler(c *gin.Context, awsCli *bedrockruntime.Client, modelName string) (*
 		responseText = claudeResponse.Content[0].Text
 	}
 
-	usage := relaymodel.Usage{
-		PromptTokens:     claudeResponse.Usage.InputTokens,
-		CompletionTokens: claudeResponse.Usage.OutputTokens,
-		TotalTokens:      claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens,
-	}
-	openaiResp.Usage = usage
+	usage := anthropic.UsageClaude2OpenAI(&claudeResponse.Usage)
+	openaiResp.Usage = *usage
 
 	c.JSON(http.StatusOK, openaiResp)
-	return nil, &usage, responseText
+	return nil, usage, responseText
 }
 
 func StreamHandler(c *gin.Context, awsCli *bedrockruntime.Client) (*relaymodel.ErrorWithStatusCode, *relaymodel.Usage, string) {
@@ -182,7 +178,7 @@ func StreamHandler(c *gin.Context, awsCli *bedrockruntime.Client) (*relaymodel.E
 	defer stream.Close()
 
 	c.Writer.Header().Set("Content-Type", "text/event-stream")
-	var usage relaymodel.Usage
+	var usage anthropic.Usage
 	var id string
 	var lastToolCallChoice openai.ChatCompletionsStreamResponseChoice
 
@@ -204,8 +200,7 @@ func StreamHandler(c *gin.Context, awsCli *bedrockruntime.Client) (*relaymodel.E
 
 			response, meta := anthropic.StreamResponseClaude2OpenAI(claudeResp)
 			if meta != nil {
-				usage.PromptTokens += meta.Usage.InputTokens
-				usage.CompletionTokens += meta.Usage.OutputTokens
+				anthropic.MergeStreamUsage(&usage, &meta.Usage)
 				if len(meta.Id) > 0 { // only message_start has an id, otherwise it's a finish_reason event.
 					id = fmt.Sprintf("chatcmpl-%s", meta.Id)
 					return true
@@ -248,7 +243,7 @@ func StreamHandler(c *gin.Context, awsCli *bedrockruntime.Client) (*relaymodel.E
 			return false
 		}
 	})
-	return nil, &usage, responseText
+	return nil, anthropic.UsageClaude2OpenAI(&usage), responseText
 }
 
 func ClaudeHandler(c *gin.Context, awsCli *bedrockruntime.Client, modelName string) (*relaymodel.ErrorWithStatusCode, *relaymodel.Usage, string) {
@@ -299,14 +294,10 @@ func ClaudeHandler(c *gin.Context, awsCli *bedrockruntime.Client, modelName stri
 	}
 
 	responseText = claudeResponse.Content[0].Text
-	usage := relaymodel.Usage{
-		PromptTokens:     claudeResponse.Usage.InputTokens,
-		CompletionTokens: claudeResponse.Usage.OutputTokens,
-		TotalTokens:      claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens,
-	}
+	usage := anthropic.UsageClaude2OpenAI(&claudeResponse.Usage)
 
 	c.JSON(http.StatusOK, claudeResponse)
-	return nil, &usage, responseText
+	return nil, usage, responseText
 }
 
 func StreamClaudeHandler(c *gin.Context, awsCli *bedrockruntime.Client) (*relaymodel.ErrorWithStatusCode, *relaymodel.Usage, string) {
@@ -7,6 +7,8 @@ import (
 	"net/http"
 
 	"one-api/relay/channel"
+	"one-api/relay/channel/openai"
+	"one-api/relay/constant"
 	"one-api/relay/model"
 	"one-api/relay/util"
 
@@ -27,6 +29,9 @@ func (a *Adaptor) Init(meta *util.RelayMeta) {
 }
 
 func (a *Adaptor) GetRequestURL(meta *util.RelayMeta) (string, error) {
+	if meta.Mode == constant.RelayModeRerank {
+		return fmt.Sprintf("%s/v1/rerank", meta.BaseURL), nil
+	}
 	return fmt.Sprintf("%s/v1/chat", meta.BaseURL), nil
 }
 
@@ -43,6 +48,18 @@ func (a *Adaptor) ConvertRequest(c *gin.Context, meta *util.RelayMeta, request *
 	return ConvertRequest(*request), nil
 }
 
+func (a *Adaptor) ConvertRerankRequest(request *model.RerankRequest) (any, error) {
+	if request == nil {
+		return nil, errors.New("request is nil")
+	}
+	return request, nil
+}
+
+// DoRerankResponse returns the Cohere response as is, its shape is the one of /v1/rerank.
+func (a *Adaptor) DoRerankResponse(c *gin.Context, resp *http.Response, meta *util.RelayMeta) (*model.RerankResponse, *model.ErrorWithStatusCode) {
+	return openai.RerankHandler(c, resp)
+}
+
 func (a *Adaptor) DoRequest(c *gin.Context, meta *util.RelayMeta, requestBody io.Reader) (*http.Response, error) {
 	return channel.DoRequestHelper(a, c, meta, requestBody)
 }
@@ -57,7 +74,7 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *util.Rel
 }
 
 func (a *Adaptor) GetModelList() []string {
-	return ModelList
+	return append(ModelList, RerankModelList...)
 }
 
 func (a *Adaptor) GetChannelName() string {
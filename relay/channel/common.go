@@ -1,6 +1,7 @@
 package channel
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -51,6 +52,10 @@ func DoRequestHelper(a Adaptor, c *gin.Context, meta *util.RelayMeta, requestBod
 }
 
 func DoRequest(c *gin.Context, req *http.Request, client *http.Client) (*http.Response, error) {
+	// 对冲请求中落后的一方需要能被取消
+	if hedgeCtx, ok := c.Get(ctxkey.HedgeContext); ok {
+		req = req.WithContext(hedgeCtx.(context.Context))
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -108,8 +108,10 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *util.Rel
 		if meta.IsStream {
 
 			var responseText string
-			err, _, responseText = anthropic.StreamHandler(c, resp)
-			usage = openai.ResponseText2Usage(responseText, meta.ActualModelName, meta.PromptTokens)
+			err, usage, responseText = anthropic.StreamHandler(c, resp)
+			if usage == nil || usage.TotalTokens == 0 {
+				usage = openai.ResponseText2Usage(responseText, meta.ActualModelName, meta.PromptTokens)
+			}
 
 			if usage.CompletionTokens == 0 {
 				if config.BlankReplyRetryEnabled {
@@ -133,8 +135,10 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *util.Rel
 		if meta.IsStream {
 
 			var responseText string
-			err, _, responseText = anthropic.ClaudeStreamHandler(c, resp)
-			usage = openai.ResponseText2Usage(responseText, meta.ActualModelName, meta.PromptTokens)
+			err, usage, responseText = anthropic.ClaudeStreamHandler(c, resp)
+			if usage == nil || usage.TotalTokens == 0 {
+				usage = openai.ResponseText2Usage(responseText, meta.ActualModelName, meta.PromptTokens)
+			}
 			if usage.CompletionTokens == 0 {
 				if config.BlankReplyRetryEnabled {
 					return "", nil, &model.ErrorWithStatusCode{
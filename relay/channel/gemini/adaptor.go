@@ -44,9 +44,15 @@ func (a *Adaptor) GetRequestURL(meta *util.RelayMeta) (string, error) {
 	switch meta.Mode {
 	case constant.RelayModeEmbeddings:
 		action = "batchEmbedContents"
+	case constant.RelayModeGeminiCountTokens:
+		action = "countTokens"
 	default:
 		action = "generateContent"
 	}
+	// requests of the Gemini SDKs are relayed as is and may use fields of the beta API
+	if meta.Mode == constant.RelayModeGeminiGenerateContent || meta.Mode == constant.RelayModeGeminiCountTokens {
+		version = "v1beta"
+	}
 	if meta.IsStream {
 		action = "streamGenerateContent?alt=sse"
 	}
@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/http"
 	"one-api/common"
+	"one-api/common/ctxkey"
 	"one-api/common/helper"
 	"one-api/common/image"
 	"one-api/common/logger"
@@ -142,6 +143,7 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *ChatRequest {
 type ChatResponse struct {
 	Candidates     []ChatCandidate    `json:"candidates"`
 	PromptFeedback ChatPromptFeedback `json:"promptFeedback"`
+	UsageMetadata  *UsageMetadata     `json:"usageMetadata,omitempty"`
 }
 
 func (g *ChatResponse) GetResponseText() string {
@@ -272,6 +274,7 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 	dataChan := make(chan string)
 	stopChan := make(chan bool)
 	go func() {
+		finished := false
 		for scanner.Scan() {
 			data := scanner.Text()
 			data = strings.TrimSpace(data)
@@ -280,8 +283,14 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 			}
 			data = strings.TrimPrefix(data, "data: ")
 			data = strings.TrimSuffix(data, "\"")
+			if strings.Contains(data, `"finishReason"`) {
+				finished = true
+			}
 			dataChan <- data
 		}
+		if scanner.Err() != nil || !finished {
+			c.Set(ctxkey.StreamInterrupted, true)
+		}
 		stopChan <- true
 	}()
 	common.SetEventStreamHeaders(c)
@@ -348,12 +357,17 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	}
 	fullTextResponse := responseGeminiChat2OpenAI(&geminiResponse)
 	fullTextResponse.Model = modelName
-	completionTokens := openai.CountTokenText(geminiResponse.GetResponseText(), modelName)
 	responseText = geminiResponse.GetResponseText()
-	usage := model.Usage{
-		PromptTokens:     promptTokens,
-		CompletionTokens: completionTokens,
-		TotalTokens:      promptTokens + completionTokens,
+	var usage model.Usage
+	if geminiResponse.UsageMetadata != nil && geminiResponse.UsageMetadata.TotalTokenCount > 0 {
+		usage = *usageMetadata2Usage(geminiResponse.UsageMetadata)
+	} else {
+		completionTokens := openai.CountTokenText(responseText, modelName)
+		usage = model.Usage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		}
 	}
 	fullTextResponse.Usage = usage
 	jsonResponse, err := json.Marshal(fullTextResponse)
@@ -0,0 +1,610 @@
+package gemini
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/relay/channel/openai"
+	"one-api/relay/model"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// https://ai.google.dev/api/generate-content
+
+// GenerateContentRequest is a request of the Gemini SDKs, relayed as is to Gemini channels
+// and translated into a chat completions request for the others.
+type GenerateContentRequest struct {
+	Contents          []NativeContent         `json:"contents"`
+	SystemInstruction *NativeContent          `json:"systemInstruction,omitempty"`
+	Tools             []NativeTool            `json:"tools,omitempty"`
+	ToolConfig        *NativeToolConfig       `json:"toolConfig,omitempty"`
+	GenerationConfig  *NativeGenerationConfig `json:"generationConfig,omitempty"`
+	SafetySettings    []ChatSafetySettings    `json:"safetySettings,omitempty"`
+}
+
+type NativeContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []NativePart `json:"parts"`
+}
+
+type NativePart struct {
+	Text             string                  `json:"text,omitempty"`
+	InlineData       *InlineData             `json:"inlineData,omitempty"`
+	FileData         *NativeFileData         `json:"fileData,omitempty"`
+	FunctionCall     *NativeFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *NativeFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type NativeFileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileUri  string `json:"fileUri"`
+}
+
+type NativeFunctionCall struct {
+	Name string `json:"name"`
+	Args any    `json:"args"`
+}
+
+type NativeFunctionResponse struct {
+	Name     string `json:"name"`
+	Response any    `json:"response"`
+}
+
+type NativeTool struct {
+	FunctionDeclarations []NativeFunctionDeclaration `json:"functionDeclarations,omitempty"`
+}
+
+type NativeFunctionDeclaration struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+}
+
+type NativeToolConfig struct {
+	FunctionCallingConfig *struct {
+		Mode                 string   `json:"mode,omitempty"`
+		AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+	} `json:"functionCallingConfig,omitempty"`
+}
+
+type NativeGenerationConfig struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"topP,omitempty"`
+	TopK             float64  `json:"topK,omitempty"`
+	MaxOutputTokens  uint     `json:"maxOutputTokens,omitempty"`
+	CandidateCount   int      `json:"candidateCount,omitempty"`
+	StopSequences    []string `json:"stopSequences,omitempty"`
+	ResponseMimeType string   `json:"responseMimeType,omitempty"`
+	ResponseSchema   any      `json:"responseSchema,omitempty"`
+}
+
+type NativeCandidate struct {
+	Content      NativeContent `json:"content"`
+	FinishReason string        `json:"finishReason,omitempty"`
+	Index        int           `json:"index"`
+}
+
+type UsageMetadata struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	TotalTokenCount         int `json:"totalTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount,omitempty"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount,omitempty"`
+}
+
+type GenerateContentResponse struct {
+	Candidates    []NativeCandidate `json:"candidates"`
+	UsageMetadata *UsageMetadata    `json:"usageMetadata,omitempty"`
+	ModelVersion  string            `json:"modelVersion,omitempty"`
+}
+
+type CountTokensResponse struct {
+	TotalTokens int `json:"totalTokens"`
+}
+
+func nativeContentText(content *NativeContent) string {
+	var text strings.Builder
+	for _, part := range content.Parts {
+		text.WriteString(part.Text)
+	}
+	return text.String()
+}
+
+// GenerateContentRequest2ChatRequest translates a Gemini request into a chat completions request.
+func GenerateContentRequest2ChatRequest(request *GenerateContentRequest, modelName string, stream bool) (*model.GeneralOpenAIRequest, error) {
+	chatRequest := &model.GeneralOpenAIRequest{
+		Model:  modelName,
+		Stream: stream,
+	}
+	if request.SystemInstruction != nil {
+		if system := nativeContentText(request.SystemInstruction); system != "" {
+			chatRequest.Messages = append(chatRequest.Messages, model.Message{Role: "system", Content: system})
+		}
+	}
+	// Gemini has no call ids, the responses are matched to the pending calls by function name
+	pendingCalls := make(map[string][]string)
+	callNum := 0
+	for _, content := range request.Contents {
+		if content.Role == "model" {
+			message := model.Message{Role: "assistant", Content: nativeContentText(&content)}
+			for _, part := range content.Parts {
+				if part.FunctionCall == nil {
+					continue
+				}
+				callNum++
+				callId := fmt.Sprintf("call_%d", callNum)
+				pendingCalls[part.FunctionCall.Name] = append(pendingCalls[part.FunctionCall.Name], callId)
+				args := part.FunctionCall.Args
+				if args == nil {
+					args = map[string]any{}
+				}
+				arguments, _ := json.Marshal(args)
+				message.ToolCalls = append(message.ToolCalls, model.Tool{
+					Id:       callId,
+					Type:     "function",
+					Function: model.Function{Name: part.FunctionCall.Name, Arguments: string(arguments)},
+				})
+			}
+			chatRequest.Messages = append(chatRequest.Messages, message)
+			continue
+		}
+
+		contentList := make([]any, 0, len(content.Parts))
+		hasImage := false
+		for _, part := range content.Parts {
+			switch {
+			case part.FunctionResponse != nil:
+				callId := ""
+				if ids := pendingCalls[part.FunctionResponse.Name]; len(ids) > 0 {
+					callId = ids[0]
+					pendingCalls[part.FunctionResponse.Name] = ids[1:]
+				}
+				response, _ := json.Marshal(part.FunctionResponse.Response)
+				chatRequest.Messages = append(chatRequest.Messages, model.Message{Role: "tool", ToolCallId: callId, Content: string(response)})
+			case part.InlineData != nil:
+				hasImage = true
+				contentList = append(contentList, map[string]any{
+					"type":      model.ContentTypeImageURL,
+					"image_url": map[string]any{"url": fmt.Sprintf("data:%s;base64,%s", part.InlineData.MimeType, part.InlineData.Data)},
+				})
+			case part.FileData != nil:
+				hasImage = true
+				contentList = append(contentList, map[string]any{
+					"type":      model.ContentTypeImageURL,
+					"image_url": map[string]any{"url": part.FileData.FileUri},
+				})
+			case part.Text != "":
+				contentList = append(contentList, map[string]any{
+					"type": model.ContentTypeText,
+					"text": part.Text,
+				})
+			}
+		}
+		if hasImage {
+			chatRequest.Messages = append(chatRequest.Messages, model.Message{Role: "user", Content: contentList})
+		} else if text := nativeContentText(&content); text != "" {
+			chatRequest.Messages = append(chatRequest.Messages, model.Message{Role: "user", Content: text})
+		}
+	}
+	if len(chatRequest.Messages) == 0 {
+		return nil, fmt.Errorf("contents is required")
+	}
+
+	for _, tool := range request.Tools {
+		for _, declaration := range tool.FunctionDeclarations {
+			chatRequest.Tools = append(chatRequest.Tools, model.Tool{
+				Type: "function",
+				Function: model.Function{
+					Name:        declaration.Name,
+					Description: declaration.Description,
+					Parameters:  declaration.Parameters,
+				},
+			})
+		}
+	}
+	if request.ToolConfig != nil && request.ToolConfig.FunctionCallingConfig != nil {
+		config := request.ToolConfig.FunctionCallingConfig
+		switch config.Mode {
+		case "AUTO":
+			chatRequest.ToolChoice = "auto"
+		case "NONE":
+			chatRequest.ToolChoice = "none"
+		case "ANY":
+			chatRequest.ToolChoice = "required"
+			if len(config.AllowedFunctionNames) == 1 {
+				chatRequest.ToolChoice = map[string]any{
+					"type":     "function",
+					"function": map[string]any{"name": config.AllowedFunctionNames[0]},
+				}
+			}
+		}
+	}
+
+	if config := request.GenerationConfig; config != nil {
+		if config.Temperature != nil {
+			chatRequest.Temperature = *config.Temperature
+		}
+		if config.TopP != nil {
+			chatRequest.TopP = *config.TopP
+		}
+		chatRequest.TopK = int(config.TopK)
+		chatRequest.MaxTokens = config.MaxOutputTokens
+		chatRequest.N = config.CandidateCount
+		if len(config.StopSequences) > 0 {
+			chatRequest.Stop = config.StopSequences
+		}
+		if config.ResponseMimeType == "application/json" {
+			chatRequest.ResponseFormat = map[string]any{"type": "json_object"}
+			if config.ResponseSchema != nil {
+				chatRequest.ResponseFormat = map[string]any{
+					"type":        "json_schema",
+					"json_schema": map[string]any{"name": "response", "schema": config.ResponseSchema},
+				}
+			}
+		}
+	}
+	return chatRequest, nil
+}
+
+func finishReasonOpenAI2Gemini(reason string) string {
+	switch reason {
+	case "length":
+		return "MAX_TOKENS"
+	case "content_filter":
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
+
+func nativeUsage(usage *model.Usage) *UsageMetadata {
+	if usage == nil {
+		return nil
+	}
+	return &UsageMetadata{
+		PromptTokenCount:        usage.PromptTokens,
+		CandidatesTokenCount:    usage.CompletionTokens - usage.ReasoningTokens(),
+		TotalTokenCount:         usage.TotalTokens,
+		CachedContentTokenCount: usage.CachedTokens(),
+		ThoughtsTokenCount:      usage.ReasoningTokens(),
+	}
+}
+
+func toolCallPart(toolCall model.Tool) NativePart {
+	args := map[string]any{}
+	if arguments, ok := toolCall.Function.Arguments.(string); ok {
+		_ = json.Unmarshal([]byte(arguments), &args)
+	}
+	return NativePart{FunctionCall: &NativeFunctionCall{Name: toolCall.Function.Name, Args: args}}
+}
+
+// ChatResponse2GenerateContentResponse translates a chat completion into a Gemini response.
+func ChatResponse2GenerateContentResponse(textResponse *openai.TextResponse, modelName string, usage *model.Usage) *GenerateContentResponse {
+	response := &GenerateContentResponse{
+		Candidates:    make([]NativeCandidate, 0, len(textResponse.Choices)),
+		UsageMetadata: nativeUsage(usage),
+		ModelVersion:  modelName,
+	}
+	for _, choice := range textResponse.Choices {
+		candidate := NativeCandidate{
+			Content:      NativeContent{Role: "model", Parts: []NativePart{}},
+			FinishReason: finishReasonOpenAI2Gemini(choice.FinishReason),
+			Index:        choice.Index,
+		}
+		if text := choice.Message.StringContent(); text != "" {
+			candidate.Content.Parts = append(candidate.Content.Parts, NativePart{Text: text})
+		}
+		for _, toolCall := range choice.Message.ToolCalls {
+			candidate.Content.Parts = append(candidate.Content.Parts, toolCallPart(toolCall))
+		}
+		response.Candidates = append(response.Candidates, candidate)
+	}
+	return response
+}
+
+// nativeStreamWriter writes stream chunks the way the client asked for them,
+// server-sent events with alt=sse, otherwise a JSON array written piece by piece.
+type nativeStreamWriter struct {
+	writer  io.Writer
+	sse     bool
+	started bool
+}
+
+func (w *nativeStreamWriter) writeChunk(data []byte) {
+	if w.sse {
+		_, _ = w.writer.Write([]byte("data: " + string(data) + "\r\n\r\n"))
+		return
+	}
+	prefix := ",\r\n"
+	if !w.started {
+		prefix = "["
+		w.started = true
+	}
+	_, _ = w.writer.Write(append([]byte(prefix), data...))
+}
+
+func (w *nativeStreamWriter) close() {
+	if w.sse {
+		return
+	}
+	if !w.started {
+		_, _ = w.writer.Write([]byte("["))
+	}
+	_, _ = w.writer.Write([]byte("]"))
+}
+
+func setNativeStreamHeaders(c *gin.Context, sse bool) {
+	if sse {
+		common.SetEventStreamHeaders(c)
+		return
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+}
+
+// NativeWriter sits between an adaptor and the client, and rewrites the chat completion
+// the adaptor writes, plain or streamed, into Gemini responses.
+type NativeWriter struct {
+	gin.ResponseWriter
+	modelName string
+	stream    bool
+	status    int
+	buffer    bytes.Buffer
+	chunks    *nativeStreamWriter
+	started   bool
+
+	toolCalls    []model.Tool
+	finishReason string
+}
+
+func NewNativeWriter(writer gin.ResponseWriter, modelName string, stream bool, sse bool) *NativeWriter {
+	return &NativeWriter{
+		ResponseWriter: writer,
+		modelName:      modelName,
+		stream:         stream,
+		status:         http.StatusOK,
+		chunks:         &nativeStreamWriter{writer: writer, sse: sse},
+	}
+}
+
+func (w *NativeWriter) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *NativeWriter) WriteHeaderNow() {}
+
+func (w *NativeWriter) Write(data []byte) (int, error) {
+	w.buffer.Write(data)
+	if w.stream {
+		w.convertStream()
+	}
+	return len(data), nil
+}
+
+func (w *NativeWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *NativeWriter) Flush() {
+	if w.stream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *NativeWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	if w.chunks.sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.ResponseWriter.WriteHeader(http.StatusOK)
+}
+
+func (w *NativeWriter) writeResponse(response *GenerateContentResponse) {
+	w.start()
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		common.SysError("error marshalling gemini response: " + err.Error())
+		return
+	}
+	w.chunks.writeChunk(jsonBytes)
+}
+
+// convertStream handles the complete chat completion chunks in the buffer.
+func (w *NativeWriter) convertStream() {
+	for {
+		line, err := w.buffer.ReadString('\n')
+		if err != nil {
+			// keep the incomplete line for the next write
+			remaining := []byte(line)
+			w.buffer.Reset()
+			w.buffer.Write(remaining)
+			return
+		}
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			continue
+		}
+		var chunk openai.ChatCompletionsStreamResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+		w.handleChunk(&chunk)
+	}
+}
+
+func (w *NativeWriter) handleChunk(chunk *openai.ChatCompletionsStreamResponse) {
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		if text := common.AsString(choice.Delta.Content); text != "" {
+			w.writeResponse(&GenerateContentResponse{
+				Candidates: []NativeCandidate{{
+					Content: NativeContent{Role: "model", Parts: []NativePart{{Text: text}}},
+				}},
+				ModelVersion: w.modelName,
+			})
+		}
+		// Gemini sends a function call at once, the arguments are collected until the end
+		for _, toolCall := range choice.Delta.ToolCalls {
+			if toolCall.Id != "" || len(w.toolCalls) == 0 {
+				w.toolCalls = append(w.toolCalls, model.Tool{Id: toolCall.Id, Function: model.Function{Name: toolCall.Function.Name, Arguments: ""}})
+			}
+			last := &w.toolCalls[len(w.toolCalls)-1]
+			arguments, _ := toolCall.Function.Arguments.(string)
+			last.Function.Arguments = last.Function.Arguments.(string) + arguments
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			w.finishReason = *choice.FinishReason
+		}
+	}
+}
+
+// Finish writes what is left once the adaptor returned: the converted response, or the last chunk of the stream.
+func (w *NativeWriter) Finish(usage *model.Usage) error {
+	if !w.stream {
+		w.Header().Del("Content-Length")
+		if w.status != http.StatusOK {
+			w.ResponseWriter.WriteHeader(w.status)
+			_, err := w.ResponseWriter.Write(w.buffer.Bytes())
+			return err
+		}
+		var textResponse openai.TextResponse
+		err := json.Unmarshal(w.buffer.Bytes(), &textResponse)
+		if err != nil {
+			return err
+		}
+		jsonBytes, err := json.Marshal(ChatResponse2GenerateContentResponse(&textResponse, w.modelName, usage))
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.ResponseWriter.WriteHeader(http.StatusOK)
+		_, err = w.ResponseWriter.Write(jsonBytes)
+		return err
+	}
+
+	w.buffer.WriteString("\n")
+	w.convertStream()
+	last := NativeCandidate{
+		Content:      NativeContent{Role: "model", Parts: []NativePart{}},
+		FinishReason: finishReasonOpenAI2Gemini(w.finishReason),
+	}
+	for _, toolCall := range w.toolCalls {
+		last.Content.Parts = append(last.Content.Parts, toolCallPart(toolCall))
+	}
+	w.writeResponse(&GenerateContentResponse{
+		Candidates:    []NativeCandidate{last},
+		UsageMetadata: nativeUsage(usage),
+		ModelVersion:  w.modelName,
+	})
+	w.chunks.close()
+	w.ResponseWriter.Flush()
+	return nil
+}
+
+func generateContentUsage(response *GenerateContentResponse) *model.Usage {
+	if response.UsageMetadata == nil {
+		return nil
+	}
+	return usageMetadata2Usage(response.UsageMetadata)
+}
+
+// usageMetadata2Usage counts the thinking tokens into the completion tokens, Gemini reports them apart.
+func usageMetadata2Usage(metadata *UsageMetadata) *model.Usage {
+	completionTokens := metadata.CandidatesTokenCount + metadata.ThoughtsTokenCount
+	usage := &model.Usage{
+		PromptTokens:     metadata.PromptTokenCount,
+		CompletionTokens: completionTokens,
+		TotalTokens:      metadata.PromptTokenCount + completionTokens,
+	}
+	if metadata.CachedContentTokenCount > 0 {
+		usage.PromptTokensDetails = &model.PromptTokensDetails{CachedTokens: metadata.CachedContentTokenCount}
+	}
+	if metadata.ThoughtsTokenCount > 0 {
+		usage.CompletionTokensDetails = &model.CompletionTokensDetails{ReasoningTokens: metadata.ThoughtsTokenCount}
+	}
+	return usage
+}
+
+func generateContentText(response *GenerateContentResponse) string {
+	var text strings.Builder
+	for _, candidate := range response.Candidates {
+		text.WriteString(nativeContentText(&candidate.Content))
+	}
+	return text.String()
+}
+
+// NativeHandler passes a Gemini response through and returns its usage.
+func NativeHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage, string) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return openai.ErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil, ""
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil, ""
+	}
+	var response GenerateContentResponse
+	err = json.Unmarshal(responseBody, &response)
+	if err != nil {
+		return openai.ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil, ""
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, _ = c.Writer.Write(responseBody)
+	return nil, generateContentUsage(&response), generateContentText(&response)
+}
+
+// NativeStreamHandler passes the server-sent events of a Gemini stream through, in the format
+// the client asked for, and returns the usage of the last event.
+func NativeStreamHandler(c *gin.Context, resp *http.Response, sse bool) (*model.ErrorWithStatusCode, *model.Usage, string) {
+	var usage *model.Usage
+	var responseText strings.Builder
+	chunks := &nativeStreamWriter{writer: c.Writer, sse: sse}
+	setNativeStreamHeaders(c, sse)
+	c.Writer.WriteHeader(http.StatusOK)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		var response GenerateContentResponse
+		if err := json.Unmarshal([]byte(data), &response); err != nil {
+			common.SysError("error unmarshalling stream response: " + err.Error())
+			continue
+		}
+		if chunkUsage := generateContentUsage(&response); chunkUsage != nil {
+			usage = chunkUsage
+		}
+		responseText.WriteString(generateContentText(&response))
+		chunks.writeChunk([]byte(data))
+		c.Writer.Flush()
+	}
+	chunks.close()
+	c.Writer.Flush()
+	err := resp.Body.Close()
+	if err != nil {
+		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil, ""
+	}
+	return nil, usage, responseText.String()
+}
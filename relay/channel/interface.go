@@ -20,3 +20,10 @@ type Adaptor interface {
 	GetModelList() []string
 	GetChannelName() string
 }
+
+// RerankAdaptor is implemented by the adaptors that serve /v1/rerank.
+type RerankAdaptor interface {
+	Adaptor
+	ConvertRerankRequest(request *model.RerankRequest) (any, error)
+	DoRerankResponse(c *gin.Context, resp *http.Response, meta *util.RelayMeta) (*model.RerankResponse, *model.ErrorWithStatusCode)
+}
@@ -569,7 +569,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *MidjourneyResponse {
 	defer func(ctx context.Context) {
 
 		if consumeQuota && !excludedActions[mjAction] {
-			err := model.PostConsumeTokenQuota(tokenId, quota)
+			err := model.PostConsumeTokenQuota(ctx, tokenId, quota)
 			if err != nil {
 				common.SysError("error consuming token remain quota: " + err.Error())
 			}
@@ -120,16 +120,16 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *util.Rel
         // 流处理逻辑
         var responseText string
         var toolCount int
-        err, responseText, toolCount = StreamHandler(c, resp, meta.Mode, meta.ActualModelName, meta.FixedContent)
+        err, responseText, toolCount, usage = StreamHandler(c, resp, meta.Mode, meta.ActualModelName, meta.FixedContent)
         aitext = responseText
         if usage == nil || usage.TotalTokens == 0 {
             usage = ResponseText2Usage(responseText, meta.ActualModelName, meta.PromptTokens)
+            usage.CompletionTokens += toolCount * 7
         }
         if usage.TotalTokens != 0 && usage.CompletionTokens == 0 {
             usage.PromptTokens = meta.PromptTokens
             usage.CompletionTokens = usage.TotalTokens - meta.PromptTokens
         }
-        usage.CompletionTokens += toolCount * 7
         if usage.CompletionTokens == 0 {
             if config.BlankReplyRetryEnabled {
                 return "", nil, &model.ErrorWithStatusCode{
@@ -9,6 +9,7 @@ import (
 	"log"
 	"net/http"
 	"one-api/common"
+	"one-api/common/ctxkey"
 	"one-api/relay/constant"
 	"one-api/relay/model"
 	"strconv"
@@ -17,9 +18,11 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func StreamHandler(c *gin.Context, resp *http.Response, relayMode int, modelName string, fixedContent string) (*model.ErrorWithStatusCode, string, int) {
+// StreamHandler relays the stream, the usage is nil unless the upstream reported it (stream_options.include_usage).
+func StreamHandler(c *gin.Context, resp *http.Response, relayMode int, modelName string, fixedContent string) (*model.ErrorWithStatusCode, string, int, *model.Usage) {
 	responseText := ""
 	toolCount := 0
+	var usage *model.Usage
 	scanner := bufio.NewScanner(resp.Body)
 	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		if atEOF && len(data) == 0 {
@@ -39,6 +42,8 @@ func StreamHandler(c *gin.Context, resp *http.Response, relayMode int, modelName
 	go func() {
 		var stopMessage string
 		var needInjectFixedContent = false // 标志是否需要注入固定内容
+		finished := false                  // 是否收到结束原因或结束标记
+		var trailingMessages []string      // 停止消息之后的消息，如 include_usage 的用量
 
 		for scanner.Scan() {
 			data := scanner.Text()
@@ -49,6 +54,7 @@ func StreamHandler(c *gin.Context, resp *http.Response, relayMode int, modelName
 
 			// 检查是否为结束标记
 			if data == "data: [DONE]" {
+				finished = true
 				break // 如果是结束标记，则跳出循环
 			}
 
@@ -64,7 +70,13 @@ func StreamHandler(c *gin.Context, resp *http.Response, relayMode int, modelName
 						log.Println("解析失败:", err)
 						continue
 					}
+					if streamResponse.Usage != nil {
+						usage = streamResponse.Usage
+					}
 					for _, choice := range streamResponse.Choices {
+						if choice.FinishReason != nil && *choice.FinishReason != "" {
+							finished = true
+						}
 						responseText += common.AsString(choice.Delta.Content)
 						if choice.Delta.ToolCalls != nil {
 							if len(choice.Delta.ToolCalls) > toolCount {
@@ -88,7 +100,13 @@ func StreamHandler(c *gin.Context, resp *http.Response, relayMode int, modelName
 						log.Println("解析失败:", err)
 						continue
 					}
+					if streamResponse.Usage != nil {
+						usage = streamResponse.Usage
+					}
 					for _, choice := range streamResponse.Choices {
+						if choice.FinishReason != "" {
+							finished = true
+						}
 						responseText += choice.Text
 						if choice.FinishReason == "stop" {
 							needInjectFixedContent = true // 需要注入fixedContent
@@ -96,17 +114,18 @@ func StreamHandler(c *gin.Context, resp *http.Response, relayMode int, modelName
 						}
 					}
 				}
-
-				if needInjectFixedContent {
-					break
-				}
 			}
 
 			// 如果没有标记需要注入固定内容，那么直接发送
 			if !needInjectFixedContent {
 				dataChan <- data
+			} else if data != stopMessage {
+				trailingMessages = append(trailingMessages, data)
 			}
 		}
+		if scanner.Err() != nil || !finished {
+			c.Set(ctxkey.StreamInterrupted, true)
+		}
 		if needInjectFixedContent && fixedContent != "" {
 			fixedContentMessage := GenerateFixedContentMessage(fixedContent, modelName)
 			dataChan <- fixedContentMessage
@@ -114,6 +133,9 @@ func StreamHandler(c *gin.Context, resp *http.Response, relayMode int, modelName
 			// 发送暂存的停止消息
 			dataChan <- stopMessage
 		}
+		for _, message := range trailingMessages {
+			dataChan <- message
+		}
 
 		// 最后发送结束信号
 		dataChan <- "data: [DONE]"
@@ -136,9 +158,9 @@ func StreamHandler(c *gin.Context, resp *http.Response, relayMode int, modelName
 	})
 	err := resp.Body.Close()
 	if err != nil {
-		return ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), "", 0
+		return ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), "", 0, nil
 	}
-	return nil, responseText, toolCount
+	return nil, responseText, toolCount, usage
 }
 
 func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName string) (*model.ErrorWithStatusCode, *model.Usage, string) {
@@ -151,6 +151,7 @@ type ChatCompletionsStreamResponse struct {
 	Created int64                                 `json:"created"`
 	Model   string                                `json:"model"`
 	Choices []ChatCompletionsStreamResponseChoice `json:"choices"`
+	Usage   *model.Usage                          `json:"usage,omitempty"`
 }
 
 type CompletionsStreamResponse struct {
@@ -158,6 +159,7 @@ type CompletionsStreamResponse struct {
 		Text         string `json:"text"`
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
+	Usage *model.Usage `json:"usage,omitempty"`
 }
 
 type ImageURL struct {
@@ -0,0 +1,42 @@
+package openai
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"one-api/common"
+	"one-api/relay/util"
+	"strings"
+)
+
+// GetRealtimeURL returns the websocket url of the Realtime API for the channel,
+// https://platform.openai.com/docs/guides/realtime
+func GetRealtimeURL(meta *util.RelayMeta) string {
+	baseURL := strings.TrimSuffix(meta.BaseURL, "/")
+	if strings.HasPrefix(baseURL, "https://") {
+		baseURL = "wss://" + strings.TrimPrefix(baseURL, "https://")
+	} else if strings.HasPrefix(baseURL, "http://") {
+		baseURL = "ws://" + strings.TrimPrefix(baseURL, "http://")
+	}
+	if meta.ChannelType == common.ChannelTypeAzure {
+		// https://learn.microsoft.com/en-us/azure/ai-services/openai/how-to/realtime-audio
+		// wss://{resource_name}.openai.azure.com/openai/realtime?api-version={api_version}&deployment={deployment}
+		return fmt.Sprintf("%s/openai/realtime?api-version=%s&deployment=%s", baseURL, meta.Config.APIVersion, url.QueryEscape(meta.ActualModelName))
+	}
+	return fmt.Sprintf("%s/v1/realtime?model=%s", baseURL, url.QueryEscape(meta.ActualModelName))
+}
+
+// GetRealtimeHeader returns the headers of the upstream websocket handshake.
+func GetRealtimeHeader(meta *util.RelayMeta) http.Header {
+	header := http.Header{}
+	for headerKey, headerValue := range meta.Headers {
+		header.Set(headerKey, headerValue)
+	}
+	if meta.ChannelType == common.ChannelTypeAzure {
+		header.Set("api-key", meta.APIKey)
+		return header
+	}
+	header.Set("Authorization", "Bearer "+meta.APIKey)
+	header.Set("OpenAI-Beta", "realtime=v1")
+	return header
+}
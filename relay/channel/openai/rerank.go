@@ -0,0 +1,43 @@
+package openai
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"one-api/relay/model"
+	"one-api/relay/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (a *Adaptor) ConvertRerankRequest(request *model.RerankRequest) (any, error) {
+	return request, nil
+}
+
+func (a *Adaptor) DoRerankResponse(c *gin.Context, resp *http.Response, meta *util.RelayMeta) (*model.RerankResponse, *model.ErrorWithStatusCode) {
+	return RerankHandler(c, resp)
+}
+
+// RerankHandler passes a Jina/Cohere style rerank response through and returns it for billing.
+func RerankHandler(c *gin.Context, resp *http.Response) (*model.RerankResponse, *model.ErrorWithStatusCode) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, ErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return nil, ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError)
+	}
+	var rerankResponse model.RerankResponse
+	err = json.Unmarshal(responseBody, &rerankResponse)
+	if err != nil {
+		return nil, ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError)
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, err = c.Writer.Write(responseBody)
+	if err != nil {
+		return nil, ErrorWrapper(err, "write_response_body_failed", http.StatusInternalServerError)
+	}
+	return &rerankResponse, nil
+}
@@ -0,0 +1,599 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/relay/model"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResponsesRequest2ChatRequest translates a Responses API request into a chat completions request,
+// so channels without a native Responses API can serve it through their adaptors.
+func ResponsesRequest2ChatRequest(request *model.ResponsesRequest) (*model.GeneralOpenAIRequest, error) {
+	if request.PreviousResponseId != "" {
+		return nil, errors.New("previous_response_id is only supported by OpenAI channels")
+	}
+	chatRequest := &model.GeneralOpenAIRequest{
+		Model:     request.Model,
+		Stream:    request.Stream,
+		MaxTokens: request.MaxOutputTokens,
+		User:      request.User,
+	}
+	if request.Temperature != nil {
+		chatRequest.Temperature = *request.Temperature
+	}
+	if request.TopP != nil {
+		chatRequest.TopP = *request.TopP
+	}
+	if request.Instructions != "" {
+		chatRequest.Messages = append(chatRequest.Messages, model.Message{Role: "system", Content: request.Instructions})
+	}
+	messages, err := responsesInput2Messages(request.Input)
+	if err != nil {
+		return nil, err
+	}
+	chatRequest.Messages = append(chatRequest.Messages, messages...)
+	if len(chatRequest.Messages) == 0 {
+		return nil, errors.New("input is required")
+	}
+
+	for _, tool := range request.Tools {
+		if tool.Type != "function" {
+			return nil, fmt.Errorf("tool type %s is only supported by OpenAI channels", tool.Type)
+		}
+		chatRequest.Tools = append(chatRequest.Tools, model.Tool{
+			Type: "function",
+			Function: model.Function{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	switch toolChoice := request.ToolChoice.(type) {
+	case string:
+		chatRequest.ToolChoice = toolChoice
+	case map[string]any:
+		if toolChoice["type"] == "function" {
+			chatRequest.ToolChoice = map[string]any{
+				"type":     "function",
+				"function": map[string]any{"name": toolChoice["name"]},
+			}
+		}
+	}
+
+	if request.Text != nil && request.Text.Format != nil {
+		switch request.Text.Format.Type {
+		case "json_object":
+			chatRequest.ResponseFormat = map[string]any{"type": "json_object"}
+		case "json_schema":
+			jsonSchema := map[string]any{
+				"name":   request.Text.Format.Name,
+				"schema": request.Text.Format.Schema,
+			}
+			if request.Text.Format.Strict != nil {
+				jsonSchema["strict"] = *request.Text.Format.Strict
+			}
+			chatRequest.ResponseFormat = map[string]any{"type": "json_schema", "json_schema": jsonSchema}
+		}
+	}
+	return chatRequest, nil
+}
+
+func responsesInput2Messages(input json.RawMessage) ([]model.Message, error) {
+	if len(input) == 0 {
+		return nil, nil
+	}
+	var text string
+	if json.Unmarshal(input, &text) == nil {
+		return []model.Message{{Role: "user", Content: text}}, nil
+	}
+	var items []model.ResponseInputItem
+	err := json.Unmarshal(input, &items)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input: %s", err.Error())
+	}
+	var messages []model.Message
+	for _, item := range items {
+		switch item.Type {
+		case "", "message":
+			role := item.Role
+			if role == "developer" {
+				role = "system"
+			}
+			content, err := responsesContent2MessageContent(item.Content)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, model.Message{Role: role, Content: content})
+		case "function_call":
+			toolCall := model.Tool{
+				Id:   item.CallId,
+				Type: "function",
+				Function: model.Function{
+					Name:      item.Name,
+					Arguments: item.Arguments,
+				},
+			}
+			// parallel calls are a single assistant message in chat completions
+			if len(messages) > 0 && messages[len(messages)-1].Role == "assistant" && len(messages[len(messages)-1].ToolCalls) > 0 {
+				messages[len(messages)-1].ToolCalls = append(messages[len(messages)-1].ToolCalls, toolCall)
+			} else {
+				messages = append(messages, model.Message{Role: "assistant", Content: "", ToolCalls: []model.Tool{toolCall}})
+			}
+		case "function_call_output":
+			messages = append(messages, model.Message{Role: "tool", Content: item.Output, ToolCallId: item.CallId})
+		case "reasoning":
+			// reasoning items of earlier responses are not understood by other providers
+		default:
+			return nil, fmt.Errorf("input item type %s is only supported by OpenAI channels", item.Type)
+		}
+	}
+	return messages, nil
+}
+
+func responsesContent2MessageContent(content json.RawMessage) (any, error) {
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return text, nil
+	}
+	var parts []model.ResponseContent
+	err := json.Unmarshal(content, &parts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message content: %s", err.Error())
+	}
+	contentList := make([]any, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case "input_text", "output_text":
+			contentList = append(contentList, map[string]any{
+				"type": model.ContentTypeText,
+				"text": part.Text,
+			})
+		case "input_image":
+			detail := part.Detail
+			if detail == "" {
+				detail = "auto"
+			}
+			contentList = append(contentList, map[string]any{
+				"type":      model.ContentTypeImageURL,
+				"image_url": map[string]any{"url": part.ImageUrl, "detail": detail},
+			})
+		default:
+			return nil, fmt.Errorf("content type %s is only supported by OpenAI channels", part.Type)
+		}
+	}
+	return contentList, nil
+}
+
+func newResponseObject(request *model.ResponsesRequest, modelName string) *model.ResponseObject {
+	tools := request.Tools
+	if tools == nil {
+		tools = []model.ResponseTool{}
+	}
+	return &model.ResponseObject{
+		Id:              "resp_" + common.GetUUID(),
+		Object:          "response",
+		CreatedAt:       common.GetTimestamp(),
+		Status:          "in_progress",
+		Instructions:    request.Instructions,
+		MaxOutputTokens: request.MaxOutputTokens,
+		Model:           modelName,
+		Output:          []model.ResponseOutputItem{},
+		Temperature:     request.Temperature,
+		TopP:            request.TopP,
+		Tools:           tools,
+		ToolChoice:      request.ToolChoice,
+		Metadata:        request.Metadata,
+	}
+}
+
+func completeResponseObject(response *model.ResponseObject, finishReason string, usage *model.Usage) {
+	response.Status = "completed"
+	if finishReason == "length" {
+		response.Status = "incomplete"
+		response.IncompleteDetails = &model.ResponseIncompleteDetails{Reason: "max_output_tokens"}
+	}
+	if usage != nil {
+		response.Usage = &model.ResponseUsage{
+			InputTokens:  usage.PromptTokens,
+			OutputTokens: usage.CompletionTokens,
+			TotalTokens:  usage.TotalTokens,
+		}
+		if usage.PromptTokensDetails != nil {
+			response.Usage.InputTokensDetails = &model.ResponseInputTokensDetails{CachedTokens: usage.CachedTokens()}
+		}
+		if usage.CompletionTokensDetails != nil {
+			response.Usage.OutputTokensDetails = &model.ResponseOutputTokensDetails{ReasoningTokens: usage.ReasoningTokens()}
+		}
+	}
+}
+
+func toolCallArguments(toolCall model.Tool) string {
+	switch arguments := toolCall.Function.Arguments.(type) {
+	case string:
+		return arguments
+	case nil:
+		return ""
+	default:
+		jsonBytes, _ := json.Marshal(arguments)
+		return string(jsonBytes)
+	}
+}
+
+// ChatResponse2Response translates a chat completion into a Responses API response object.
+func ChatResponse2Response(textResponse *TextResponse, request *model.ResponsesRequest, usage *model.Usage) *model.ResponseObject {
+	response := newResponseObject(request, textResponse.Model)
+	if response.Model == "" {
+		response.Model = request.Model
+	}
+	finishReason := ""
+	// the Responses API has a single output list, only the first choice is kept
+	if len(textResponse.Choices) > 0 {
+		choice := textResponse.Choices[0]
+		finishReason = choice.FinishReason
+		if text := choice.Message.StringContent(); text != "" {
+			response.Output = append(response.Output, model.ResponseOutputItem{
+				Type:    "message",
+				Id:      "msg_" + common.GetUUID(),
+				Status:  "completed",
+				Role:    "assistant",
+				Content: []model.ResponseContent{{Type: "output_text", Text: text, Annotations: []any{}}},
+			})
+		}
+		for _, toolCall := range choice.Message.ToolCalls {
+			response.Output = append(response.Output, model.ResponseOutputItem{
+				Type:      "function_call",
+				Id:        "fc_" + common.GetUUID(),
+				Status:    "completed",
+				CallId:    toolCall.Id,
+				Name:      toolCall.Function.Name,
+				Arguments: toolCallArguments(toolCall),
+			})
+		}
+	}
+	completeResponseObject(response, finishReason, usage)
+	return response
+}
+
+// ResponsesWriter sits between an adaptor and the client, and rewrites the chat completion
+// the adaptor writes, plain or streamed, into the Responses API format.
+type ResponsesWriter struct {
+	gin.ResponseWriter
+	request  *model.ResponsesRequest
+	stream   bool
+	status   int
+	buffer   bytes.Buffer
+	response *model.ResponseObject
+	sequence int
+
+	messageItem  *model.ResponseOutputItem
+	messageIndex int
+	messageText  strings.Builder
+	toolItems    []*model.ResponseOutputItem
+	toolIndexes  []int
+	toolCalls    map[int]int // 流式工具调用的 index 到 toolItems 下标
+	finishReason string
+}
+
+func NewResponsesWriter(writer gin.ResponseWriter, request *model.ResponsesRequest) *ResponsesWriter {
+	return &ResponsesWriter{
+		ResponseWriter: writer,
+		request:        request,
+		stream:         request.Stream,
+		status:         http.StatusOK,
+	}
+}
+
+func (w *ResponsesWriter) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *ResponsesWriter) WriteHeaderNow() {}
+
+func (w *ResponsesWriter) Write(data []byte) (int, error) {
+	w.buffer.Write(data)
+	if w.stream {
+		w.convertStream()
+	}
+	return len(data), nil
+}
+
+func (w *ResponsesWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *ResponsesWriter) Flush() {
+	if w.stream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *ResponsesWriter) writeEvent(event model.ResponseStreamEvent) {
+	event.SequenceNumber = w.sequence
+	w.sequence++
+	jsonBytes, err := json.Marshal(event)
+	if err != nil {
+		common.SysError("error marshalling response event: " + err.Error())
+		return
+	}
+	_, _ = w.ResponseWriter.WriteString(fmt.Sprintf("event: %s\ndata: %s\n\n", event.Type, jsonBytes))
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+// convertStream handles the complete chat completion chunks in the buffer.
+func (w *ResponsesWriter) convertStream() {
+	for {
+		line, err := w.buffer.ReadString('\n')
+		if err != nil {
+			// keep the incomplete line for the next write
+			remaining := []byte(line)
+			w.buffer.Reset()
+			w.buffer.Write(remaining)
+			return
+		}
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			continue
+		}
+		var chunk ChatCompletionsStreamResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+		w.handleChunk(&chunk)
+	}
+}
+
+func (w *ResponsesWriter) start(modelName string) {
+	if w.response != nil {
+		return
+	}
+	if modelName == "" {
+		modelName = w.request.Model
+	}
+	w.response = newResponseObject(w.request, modelName)
+	w.ResponseWriter.WriteHeader(http.StatusOK)
+	w.writeEvent(model.ResponseStreamEvent{Type: "response.created", Response: w.response})
+	w.writeEvent(model.ResponseStreamEvent{Type: "response.in_progress", Response: w.response})
+}
+
+func (w *ResponsesWriter) handleChunk(chunk *ChatCompletionsStreamResponse) {
+	w.start(chunk.Model)
+	for _, choice := range chunk.Choices {
+		if text := common.AsString(choice.Delta.Content); text != "" {
+			if w.messageItem == nil {
+				w.messageItem = &model.ResponseOutputItem{
+					Type:    "message",
+					Id:      "msg_" + common.GetUUID(),
+					Status:  "in_progress",
+					Role:    "assistant",
+					Content: []model.ResponseContent{},
+				}
+				w.messageIndex = len(w.toolItems)
+				w.writeEvent(model.ResponseStreamEvent{Type: "response.output_item.added", OutputIndex: intPtr(w.messageIndex), Item: w.messageItem})
+				w.writeEvent(model.ResponseStreamEvent{Type: "response.content_part.added", OutputIndex: intPtr(w.messageIndex), ContentIndex: intPtr(0), ItemId: w.messageItem.Id,
+					Part: &model.ResponseContent{Type: "output_text", Annotations: []any{}}})
+			}
+			w.messageText.WriteString(text)
+			w.writeEvent(model.ResponseStreamEvent{Type: "response.output_text.delta", OutputIndex: intPtr(w.messageIndex), ContentIndex: intPtr(0), ItemId: w.messageItem.Id, Delta: text})
+		}
+		for _, toolCall := range choice.Delta.ToolCalls {
+			i := w.toolCallItem(toolCall)
+			item := w.toolItems[i]
+			if arguments := toolCallArguments(toolCall); arguments != "" {
+				item.Arguments += arguments
+				w.writeEvent(model.ResponseStreamEvent{Type: "response.function_call_arguments.delta", OutputIndex: intPtr(w.toolIndexes[i]), ItemId: item.Id, Delta: arguments})
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			w.finishReason = *choice.FinishReason
+		}
+	}
+}
+
+// toolCallItem returns the position in toolItems of the call the stream delta belongs to, adding an item for a new call.
+// Deltas of parallel calls may interleave and are told apart by index, deltas without one continue the last call
+// unless they carry an id.
+func (w *ResponsesWriter) toolCallItem(toolCall model.Tool) int {
+	if toolCall.Index != nil {
+		if i, ok := w.toolCalls[*toolCall.Index]; ok && (toolCall.Id == "" || toolCall.Id == w.toolItems[i].CallId) {
+			return i
+		}
+	} else if toolCall.Id == "" && len(w.toolItems) > 0 {
+		return len(w.toolItems) - 1
+	}
+	item := &model.ResponseOutputItem{
+		Type:   "function_call",
+		Id:     "fc_" + common.GetUUID(),
+		Status: "in_progress",
+		CallId: toolCall.Id,
+		Name:   toolCall.Function.Name,
+	}
+	outputIndex := len(w.toolItems)
+	if w.messageItem != nil {
+		outputIndex++
+	}
+	w.toolItems = append(w.toolItems, item)
+	w.toolIndexes = append(w.toolIndexes, outputIndex)
+	if toolCall.Index != nil {
+		if w.toolCalls == nil {
+			w.toolCalls = make(map[int]int)
+		}
+		w.toolCalls[*toolCall.Index] = len(w.toolItems) - 1
+	}
+	w.writeEvent(model.ResponseStreamEvent{Type: "response.output_item.added", OutputIndex: intPtr(outputIndex), Item: item})
+	return len(w.toolItems) - 1
+}
+
+// Finish writes what is left once the adaptor returned: the converted response, or the closing events of the stream.
+func (w *ResponsesWriter) Finish(usage *model.Usage) error {
+	if !w.stream {
+		w.Header().Del("Content-Length")
+		if w.status != http.StatusOK {
+			w.ResponseWriter.WriteHeader(w.status)
+			_, err := w.ResponseWriter.Write(w.buffer.Bytes())
+			return err
+		}
+		var textResponse TextResponse
+		err := json.Unmarshal(w.buffer.Bytes(), &textResponse)
+		if err != nil {
+			return err
+		}
+		jsonBytes, err := json.Marshal(ChatResponse2Response(&textResponse, w.request, usage))
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.ResponseWriter.WriteHeader(http.StatusOK)
+		_, err = w.ResponseWriter.Write(jsonBytes)
+		return err
+	}
+
+	w.buffer.WriteString("\n")
+	w.convertStream()
+	w.start("")
+	output := make([]model.ResponseOutputItem, len(w.toolItems))
+	if w.messageItem != nil {
+		output = append(output, model.ResponseOutputItem{})
+		text := w.messageText.String()
+		part := model.ResponseContent{Type: "output_text", Text: text, Annotations: []any{}}
+		w.messageItem.Status = "completed"
+		w.messageItem.Content = []model.ResponseContent{part}
+		w.writeEvent(model.ResponseStreamEvent{Type: "response.output_text.done", OutputIndex: intPtr(w.messageIndex), ContentIndex: intPtr(0), ItemId: w.messageItem.Id, Text: text})
+		w.writeEvent(model.ResponseStreamEvent{Type: "response.content_part.done", OutputIndex: intPtr(w.messageIndex), ContentIndex: intPtr(0), ItemId: w.messageItem.Id, Part: &part})
+		w.writeEvent(model.ResponseStreamEvent{Type: "response.output_item.done", OutputIndex: intPtr(w.messageIndex), Item: w.messageItem})
+		output[w.messageIndex] = *w.messageItem
+	}
+	for i, item := range w.toolItems {
+		item.Status = "completed"
+		w.writeEvent(model.ResponseStreamEvent{Type: "response.function_call_arguments.done", OutputIndex: intPtr(w.toolIndexes[i]), ItemId: item.Id, Arguments: item.Arguments})
+		w.writeEvent(model.ResponseStreamEvent{Type: "response.output_item.done", OutputIndex: intPtr(w.toolIndexes[i]), Item: item})
+		output[w.toolIndexes[i]] = *item
+	}
+	w.response.Output = output
+	completeResponseObject(w.response, w.finishReason, usage)
+	eventType := "response.completed"
+	if w.response.Status == "incomplete" {
+		eventType = "response.incomplete"
+	}
+	w.writeEvent(model.ResponseStreamEvent{Type: eventType, Response: w.response})
+	w.ResponseWriter.Flush()
+	return nil
+}
+
+func responseOutputText(response *model.ResponseObject) string {
+	var text strings.Builder
+	for _, item := range response.Output {
+		for _, content := range item.Content {
+			text.WriteString(content.Text)
+		}
+		text.WriteString(item.Arguments)
+	}
+	return text.String()
+}
+
+func responseUsage(response *model.ResponseObject) *model.Usage {
+	if response.Usage == nil {
+		return nil
+	}
+	usage := &model.Usage{
+		PromptTokens:     response.Usage.InputTokens,
+		CompletionTokens: response.Usage.OutputTokens,
+		TotalTokens:      response.Usage.TotalTokens,
+	}
+	if details := response.Usage.InputTokensDetails; details != nil {
+		usage.PromptTokensDetails = &model.PromptTokensDetails{CachedTokens: details.CachedTokens}
+	}
+	if details := response.Usage.OutputTokensDetails; details != nil {
+		usage.CompletionTokensDetails = &model.CompletionTokensDetails{ReasoningTokens: details.ReasoningTokens}
+	}
+	return usage
+}
+
+// ResponsesHandler passes a native Responses API response through and returns its usage.
+func ResponsesHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage, string) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil, ""
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil, ""
+	}
+	var response model.ResponseObject
+	err = json.Unmarshal(responseBody, &response)
+	if err != nil {
+		return ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil, ""
+	}
+	if response.Error != nil && response.Error.Message != "" {
+		return &model.ErrorWithStatusCode{
+			Error:      *response.Error,
+			StatusCode: resp.StatusCode,
+		}, nil, ""
+	}
+	for k, v := range resp.Header {
+		c.Writer.Header().Set(k, v[0])
+	}
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, err = c.Writer.Write(responseBody)
+	if err != nil {
+		return ErrorWrapper(err, "copy_response_body_failed", http.StatusInternalServerError), nil, ""
+	}
+	return nil, responseUsage(&response), responseOutputText(&response)
+}
+
+// ResponsesStreamHandler passes native Responses API events through, the usage comes with the final event.
+func ResponsesStreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage, string) {
+	var usage *model.Usage
+	var responseText strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	common.SetEventStreamHeaders(c)
+	c.Writer.WriteHeader(http.StatusOK)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		_, _ = c.Writer.WriteString(line + "\n")
+		if line == "" {
+			c.Writer.Flush()
+			continue
+		}
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		var event model.ResponseStreamEvent
+		if json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &event) != nil {
+			continue
+		}
+		switch event.Type {
+		case "response.output_text.delta", "response.function_call_arguments.delta":
+			responseText.WriteString(event.Delta)
+		case "response.completed", "response.incomplete", "response.failed":
+			if event.Response != nil {
+				usage = responseUsage(event.Response)
+			}
+		}
+	}
+	c.Writer.Flush()
+	err := resp.Body.Close()
+	if err != nil {
+		return ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil, ""
+	}
+	return nil, usage, responseText.String()
+}
@@ -0,0 +1,45 @@
+package openai
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"one-api/relay/model"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponsesWriterInterleavedToolCalls(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	writer := NewResponsesWriter(c.Writer, &model.ResponsesRequest{Model: "gpt-4o", Stream: true})
+	chunks := []string{
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"a","arguments":""}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"b","arguments":""}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"x\":"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"function":{"arguments":"{\"y\":2}"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"1}"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+	}
+	for _, chunk := range chunks {
+		_, _ = writer.WriteString("data: " + chunk + "\n\n")
+	}
+	_, _ = writer.WriteString("data: [DONE]\n\n")
+	assert.NoError(t, writer.Finish(&model.Usage{}))
+
+	var completed *model.ResponseObject
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		var event model.ResponseStreamEvent
+		if json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event) == nil && event.Type == "response.completed" {
+			completed = event.Response
+		}
+	}
+	if assert.NotNil(t, completed) && assert.Len(t, completed.Output, 2) {
+		assert.Equal(t, "call_a", completed.Output[0].CallId)
+		assert.Equal(t, `{"x":1}`, completed.Output[0].Arguments)
+		assert.Equal(t, "call_b", completed.Output[1].CallId)
+		assert.Equal(t, `{"y":2}`, completed.Output[1].Arguments)
+	}
+}
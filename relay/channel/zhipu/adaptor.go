@@ -88,10 +88,12 @@ func (a *Adaptor) DoResponseV4(c *gin.Context, resp *http.Response, meta *util.R
 	if meta.IsStream {
 		var responseText string
 		var toolCount int
-		err, responseText, toolCount = openai.StreamHandler(c, resp, meta.Mode, meta.ActualModelName, meta.FixedContent)
+		err, responseText, toolCount, usage = openai.StreamHandler(c, resp, meta.Mode, meta.ActualModelName, meta.FixedContent)
 		aitext = responseText
-		usage = openai.ResponseText2Usage(responseText, meta.ActualModelName, meta.PromptTokens)
-		usage.CompletionTokens += toolCount * 7
+		if usage == nil || usage.TotalTokens == 0 {
+			usage = openai.ResponseText2Usage(responseText, meta.ActualModelName, meta.PromptTokens)
+			usage.CompletionTokens += toolCount * 7
+		}
 	} else {
 		if meta.Mode == constant.RelayModeEmbeddings {
 			err, usage = EmbeddingsHandler(c, resp)
@@ -29,6 +29,11 @@ const (
 	RelayModeMidjourneyTaskFetchByCondition
 	RelayMidjourneyImage
 	RelayModeMessages
+	RelayModeResponses
+	RelayModeRerank
+	RelayModeGeminiGenerateContent
+	RelayModeGeminiCountTokens
+	RelayModeRealtime
 )
 
 func Path2RelayMode(path string) int {
@@ -55,10 +60,32 @@ func Path2RelayMode(path string) int {
 		relayMode = RelayModeAudioTranslation
 	} else if strings.HasPrefix(path, "/v1/messages") {
 		relayMode = RelayModeMessages
+	} else if strings.HasPrefix(path, "/v1/responses") {
+		relayMode = RelayModeResponses
+	} else if strings.HasPrefix(path, "/v1/rerank") {
+		relayMode = RelayModeRerank
+	} else if strings.HasPrefix(path, "/v1/realtime") {
+		relayMode = RelayModeRealtime
+	} else if strings.HasPrefix(path, "/v1beta/models/") {
+		relayMode = RelayModeGeminiGenerateContent
+		if strings.HasSuffix(path, ":countTokens") {
+			relayMode = RelayModeGeminiCountTokens
+		}
 	}
 	return relayMode
 }
 
+// GeminiModelAction splits a Gemini API path like /v1beta/models/gemini-pro:generateContent
+// into the model and the action.
+func GeminiModelAction(path string) (string, string) {
+	path = strings.TrimPrefix(path, "/v1beta/models/")
+	i := strings.LastIndex(path, ":")
+	if i < 0 {
+		return path, ""
+	}
+	return path[:i], path[i+1:]
+}
+
 func MidjourneyRelayMode(path string) int {
 	relayMode := RelayModeUnknown
 	if strings.Contains(path, "/mj/submit/imagine") {
@@ -0,0 +1,667 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/relay/channel/openai"
+	relaymodel "one-api/relay/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func notFoundError(kind string, id string) *relaymodel.ErrorWithStatusCode {
+	return openai.ErrorWrapper(fmt.Errorf("No %s found with id '%s'.", kind, id), "not_found", http.StatusNotFound)
+}
+
+func MarshalMetadata(metadata map[string]string) string {
+	if len(metadata) == 0 {
+		return ""
+	}
+	jsonBytes, _ := json.Marshal(metadata)
+	return string(jsonBytes)
+}
+
+func unmarshalMetadata(metadata string) map[string]string {
+	result := make(map[string]string)
+	if metadata != "" {
+		_ = json.Unmarshal([]byte(metadata), &result)
+	}
+	return result
+}
+
+func MarshalTools(tools []relaymodel.Tool) string {
+	if len(tools) == 0 {
+		return ""
+	}
+	jsonBytes, _ := json.Marshal(tools)
+	return string(jsonBytes)
+}
+
+func UnmarshalTools(tools string) []relaymodel.Tool {
+	result := make([]relaymodel.Tool, 0)
+	if tools != "" {
+		_ = json.Unmarshal([]byte(tools), &result)
+	}
+	return result
+}
+
+// listParams reads the limit, order and after query parameters shared by the assistants list endpoints
+func listParams(c *gin.Context) (limit int, order string, after string) {
+	limit, _ = strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	order = c.Query("order")
+	if order != "asc" {
+		order = "desc"
+	}
+	return limit, order, c.Query("after")
+}
+
+func listResponse[T any](c *gin.Context, items []T, limit int, getId func(T) string) {
+	response := relaymodel.ListResponse{Object: "list"}
+	if len(items) > limit {
+		items = items[:limit]
+		response.HasMore = true
+	}
+	if len(items) > 0 {
+		response.FirstId = getId(items[0])
+		response.LastId = getId(items[len(items)-1])
+	}
+	response.Data = items
+	c.JSON(http.StatusOK, response)
+}
+
+func AssistantObject(assistant *model.Assistant) relaymodel.AssistantObject {
+	object := relaymodel.AssistantObject{
+		Id:           assistant.AssistantId,
+		Object:       "assistant",
+		CreatedAt:    assistant.CreatedAt,
+		Name:         assistant.Name,
+		Description:  assistant.Description,
+		Model:        assistant.Model,
+		Instructions: assistant.Instructions,
+		Tools:        UnmarshalTools(assistant.Tools),
+		Metadata:     unmarshalMetadata(assistant.Metadata),
+		Temperature:  assistant.Temperature,
+		TopP:         assistant.TopP,
+	}
+	if assistant.ResponseFormat != "" {
+		_ = json.Unmarshal([]byte(assistant.ResponseFormat), &object.ResponseFormat)
+	} else {
+		object.ResponseFormat = "auto"
+	}
+	return object
+}
+
+func applyAssistantRequest(assistant *model.Assistant, request *relaymodel.AssistantRequest) {
+	if request.Model != nil {
+		assistant.Model = *request.Model
+	}
+	if request.Name != nil {
+		assistant.Name = *request.Name
+	}
+	if request.Description != nil {
+		assistant.Description = *request.Description
+	}
+	if request.Instructions != nil {
+		assistant.Instructions = *request.Instructions
+	}
+	if request.Tools != nil {
+		assistant.Tools = MarshalTools(request.Tools)
+	}
+	if request.Metadata != nil {
+		assistant.Metadata = MarshalMetadata(request.Metadata)
+	}
+	if request.Temperature != nil {
+		assistant.Temperature = request.Temperature
+	}
+	if request.TopP != nil {
+		assistant.TopP = request.TopP
+	}
+	if request.ResponseFormat != nil {
+		jsonBytes, _ := json.Marshal(request.ResponseFormat)
+		assistant.ResponseFormat = string(jsonBytes)
+	}
+}
+
+func CreateAssistant(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	var request relaymodel.AssistantRequest
+	err := common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_assistant_request", http.StatusBadRequest)
+	}
+	if request.Model == nil || *request.Model == "" {
+		return openai.ErrorWrapper(errors.New("model is required"), "invalid_assistant_request", http.StatusBadRequest)
+	}
+	assistant := &model.Assistant{
+		AssistantId: "asst_" + common.GetUUID(),
+		UserId:      c.GetInt("id"),
+		CreatedAt:   common.GetTimestamp(),
+	}
+	applyAssistantRequest(assistant, &request)
+	err = assistant.Insert()
+	if err != nil {
+		return openai.ErrorWrapper(err, "insert_assistant_failed", http.StatusInternalServerError)
+	}
+	c.JSON(http.StatusOK, AssistantObject(assistant))
+	return nil
+}
+
+func ListAssistants(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	userId := c.GetInt("id")
+	limit, order, after := listParams(c)
+	afterId := 0
+	if after != "" {
+		cursor, err := model.GetUserAssistant(userId, after)
+		if err != nil {
+			return notFoundError("assistant", after)
+		}
+		afterId = cursor.Id
+	}
+	assistants, err := model.GetUserAssistants(userId, order, afterId, limit+1)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_assistants_failed", http.StatusInternalServerError)
+	}
+	objects := make([]relaymodel.AssistantObject, 0, len(assistants))
+	for _, assistant := range assistants {
+		objects = append(objects, AssistantObject(assistant))
+	}
+	listResponse(c, objects, limit, func(o relaymodel.AssistantObject) string { return o.Id })
+	return nil
+}
+
+func RetrieveAssistant(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	assistant, err := model.GetUserAssistant(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		return notFoundError("assistant", c.Param("id"))
+	}
+	c.JSON(http.StatusOK, AssistantObject(assistant))
+	return nil
+}
+
+func ModifyAssistant(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	assistant, err := model.GetUserAssistant(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		return notFoundError("assistant", c.Param("id"))
+	}
+	var request relaymodel.AssistantRequest
+	err = common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_assistant_request", http.StatusBadRequest)
+	}
+	applyAssistantRequest(assistant, &request)
+	err = assistant.Update()
+	if err != nil {
+		return openai.ErrorWrapper(err, "update_assistant_failed", http.StatusInternalServerError)
+	}
+	c.JSON(http.StatusOK, AssistantObject(assistant))
+	return nil
+}
+
+func DeleteAssistant(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	assistant, err := model.GetUserAssistant(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		return notFoundError("assistant", c.Param("id"))
+	}
+	err = assistant.Delete()
+	if err != nil {
+		return openai.ErrorWrapper(err, "delete_assistant_failed", http.StatusInternalServerError)
+	}
+	c.JSON(http.StatusOK, relaymodel.DeleteResponse{Id: assistant.AssistantId, Object: "assistant.deleted", Deleted: true})
+	return nil
+}
+
+func ThreadObject(thread *model.Thread) relaymodel.ThreadObject {
+	return relaymodel.ThreadObject{
+		Id:        thread.ThreadId,
+		Object:    "thread",
+		CreatedAt: thread.CreatedAt,
+		Metadata:  unmarshalMetadata(thread.Metadata),
+	}
+}
+
+// CreateThreadWithMessages creates a thread for userId together with its initial messages.
+func CreateThreadWithMessages(userId int, request *relaymodel.ThreadRequest) (*model.Thread, *relaymodel.ErrorWithStatusCode) {
+	thread := &model.Thread{
+		ThreadId:  "thread_" + common.GetUUID(),
+		UserId:    userId,
+		Metadata:  MarshalMetadata(request.Metadata),
+		CreatedAt: common.GetTimestamp(),
+	}
+	messages := make([]*model.ThreadMessage, 0, len(request.Messages))
+	for i := range request.Messages {
+		message, bizErr := NewThreadMessage(thread, &request.Messages[i])
+		if bizErr != nil {
+			return nil, bizErr
+		}
+		messages = append(messages, message)
+	}
+	err := thread.Insert()
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "insert_thread_failed", http.StatusInternalServerError)
+	}
+	for _, message := range messages {
+		err = message.Insert()
+		if err != nil {
+			return nil, openai.ErrorWrapper(err, "insert_message_failed", http.StatusInternalServerError)
+		}
+	}
+	return thread, nil
+}
+
+func CreateThread(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	var request relaymodel.ThreadRequest
+	if c.Request.ContentLength > 0 {
+		err := common.UnmarshalBodyReusable(c, &request)
+		if err != nil {
+			return openai.ErrorWrapper(err, "invalid_thread_request", http.StatusBadRequest)
+		}
+	}
+	thread, bizErr := CreateThreadWithMessages(c.GetInt("id"), &request)
+	if bizErr != nil {
+		return bizErr
+	}
+	c.JSON(http.StatusOK, ThreadObject(thread))
+	return nil
+}
+
+func RetrieveThread(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	thread, err := model.GetUserThread(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		return notFoundError("thread", c.Param("id"))
+	}
+	c.JSON(http.StatusOK, ThreadObject(thread))
+	return nil
+}
+
+func ModifyThread(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	thread, err := model.GetUserThread(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		return notFoundError("thread", c.Param("id"))
+	}
+	var request relaymodel.ThreadRequest
+	err = common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_thread_request", http.StatusBadRequest)
+	}
+	if request.Metadata != nil {
+		thread.Metadata = MarshalMetadata(request.Metadata)
+	}
+	err = thread.Update()
+	if err != nil {
+		return openai.ErrorWrapper(err, "update_thread_failed", http.StatusInternalServerError)
+	}
+	c.JSON(http.StatusOK, ThreadObject(thread))
+	return nil
+}
+
+func DeleteThread(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	thread, err := model.GetUserThread(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		return notFoundError("thread", c.Param("id"))
+	}
+	err = thread.Delete()
+	if err != nil {
+		return openai.ErrorWrapper(err, "delete_thread_failed", http.StatusInternalServerError)
+	}
+	c.JSON(http.StatusOK, relaymodel.DeleteResponse{Id: thread.ThreadId, Object: "thread.deleted", Deleted: true})
+	return nil
+}
+
+// normalizeMessageContent accepts the string or content part array forms of a message content
+func normalizeMessageContent(content any) ([]relaymodel.MessageContent, error) {
+	switch content := content.(type) {
+	case string:
+		return []relaymodel.MessageContent{{Type: "text", Text: &relaymodel.MessageText{Value: content, Annotations: []any{}}}}, nil
+	case []any:
+		parts := make([]relaymodel.MessageContent, 0, len(content))
+		for _, item := range content {
+			part, ok := item.(map[string]any)
+			if !ok {
+				return nil, errors.New("invalid content part")
+			}
+			switch part["type"] {
+			case "text":
+				text, _ := part["text"].(string)
+				parts = append(parts, relaymodel.MessageContent{Type: "text", Text: &relaymodel.MessageText{Value: text, Annotations: []any{}}})
+			case "image_url":
+				imageUrl, _ := part["image_url"].(map[string]any)
+				url, _ := imageUrl["url"].(string)
+				detail, _ := imageUrl["detail"].(string)
+				parts = append(parts, relaymodel.MessageContent{Type: "image_url", ImageUrl: &relaymodel.ImageURL{Url: url, Detail: detail}})
+			default:
+				return nil, fmt.Errorf("unsupported content part type: %v", part["type"])
+			}
+		}
+		return parts, nil
+	}
+	return nil, errors.New("content must be a string or an array of content parts")
+}
+
+func NewThreadMessage(thread *model.Thread, request *relaymodel.ThreadMessageRequest) (*model.ThreadMessage, *relaymodel.ErrorWithStatusCode) {
+	if request.Role != "user" && request.Role != "assistant" {
+		return nil, openai.ErrorWrapper(errors.New("role must be user or assistant"), "invalid_message_request", http.StatusBadRequest)
+	}
+	parts, err := normalizeMessageContent(request.Content)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "invalid_message_request", http.StatusBadRequest)
+	}
+	content, _ := json.Marshal(parts)
+	return &model.ThreadMessage{
+		MessageId: "msg_" + common.GetUUID(),
+		ThreadId:  thread.ThreadId,
+		UserId:    thread.UserId,
+		Role:      request.Role,
+		Content:   string(content),
+		Metadata:  MarshalMetadata(request.Metadata),
+		CreatedAt: common.GetTimestamp(),
+	}, nil
+}
+
+func ThreadMessageObject(message *model.ThreadMessage) relaymodel.ThreadMessageObject {
+	object := relaymodel.ThreadMessageObject{
+		Id:          message.MessageId,
+		Object:      "thread.message",
+		CreatedAt:   message.CreatedAt,
+		ThreadId:    message.ThreadId,
+		Status:      "completed",
+		Role:        message.Role,
+		Content:     make([]relaymodel.MessageContent, 0),
+		Attachments: []any{},
+		Metadata:    unmarshalMetadata(message.Metadata),
+	}
+	_ = json.Unmarshal([]byte(message.Content), &object.Content)
+	if message.AssistantId != "" {
+		object.AssistantId = &message.AssistantId
+	}
+	if message.RunId != "" {
+		object.RunId = &message.RunId
+	}
+	return object
+}
+
+func CreateThreadMessage(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	thread, err := model.GetUserThread(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		return notFoundError("thread", c.Param("id"))
+	}
+	var request relaymodel.ThreadMessageRequest
+	err = common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_message_request", http.StatusBadRequest)
+	}
+	message, bizErr := NewThreadMessage(thread, &request)
+	if bizErr != nil {
+		return bizErr
+	}
+	err = message.Insert()
+	if err != nil {
+		return openai.ErrorWrapper(err, "insert_message_failed", http.StatusInternalServerError)
+	}
+	c.JSON(http.StatusOK, ThreadMessageObject(message))
+	return nil
+}
+
+func ListThreadMessages(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	thread, err := model.GetUserThread(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		return notFoundError("thread", c.Param("id"))
+	}
+	limit, order, after := listParams(c)
+	afterId := 0
+	if after != "" {
+		cursor, err := model.GetThreadMessage(thread.ThreadId, after)
+		if err != nil {
+			return notFoundError("message", after)
+		}
+		afterId = cursor.Id
+	}
+	messages, err := model.GetThreadMessages(thread.ThreadId, c.Query("run_id"), order, afterId, limit+1)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_messages_failed", http.StatusInternalServerError)
+	}
+	objects := make([]relaymodel.ThreadMessageObject, 0, len(messages))
+	for _, message := range messages {
+		objects = append(objects, ThreadMessageObject(message))
+	}
+	listResponse(c, objects, limit, func(o relaymodel.ThreadMessageObject) string { return o.Id })
+	return nil
+}
+
+func getThreadMessage(c *gin.Context) (*model.ThreadMessage, *relaymodel.ErrorWithStatusCode) {
+	thread, err := model.GetUserThread(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		return nil, notFoundError("thread", c.Param("id"))
+	}
+	message, err := model.GetThreadMessage(thread.ThreadId, c.Param("messageId"))
+	if err != nil {
+		return nil, notFoundError("message", c.Param("messageId"))
+	}
+	return message, nil
+}
+
+func RetrieveThreadMessage(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	message, bizErr := getThreadMessage(c)
+	if bizErr != nil {
+		return bizErr
+	}
+	c.JSON(http.StatusOK, ThreadMessageObject(message))
+	return nil
+}
+
+func ModifyThreadMessage(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	message, bizErr := getThreadMessage(c)
+	if bizErr != nil {
+		return bizErr
+	}
+	var request relaymodel.ThreadMessageRequest
+	err := common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_message_request", http.StatusBadRequest)
+	}
+	if request.Metadata != nil {
+		message.Metadata = MarshalMetadata(request.Metadata)
+	}
+	err = message.Update()
+	if err != nil {
+		return openai.ErrorWrapper(err, "update_message_failed", http.StatusInternalServerError)
+	}
+	c.JSON(http.StatusOK, ThreadMessageObject(message))
+	return nil
+}
+
+func RunObject(run *model.Run) relaymodel.RunObject {
+	object := relaymodel.RunObject{
+		Id:                  run.RunId,
+		Object:              "thread.run",
+		CreatedAt:           run.CreatedAt,
+		ThreadId:            run.ThreadId,
+		AssistantId:         run.AssistantId,
+		Status:              run.Status,
+		ExpiresAt:           run.ExpiresAt,
+		StartedAt:           run.StartedAt,
+		CancelledAt:         run.CancelledAt,
+		FailedAt:            run.FailedAt,
+		CompletedAt:         run.CompletedAt,
+		Model:               run.Model,
+		Instructions:        run.Instructions,
+		Tools:               UnmarshalTools(run.Tools),
+		Metadata:            unmarshalMetadata(run.Metadata),
+		Temperature:         run.Temperature,
+		TopP:                run.TopP,
+		MaxCompletionTokens: run.MaxCompletionTokens,
+	}
+	if run.RequiredAction != "" && run.Status == model.RunStatusRequiresAction {
+		var requiredAction relaymodel.RequiredAction
+		if json.Unmarshal([]byte(run.RequiredAction), &requiredAction) == nil {
+			object.RequiredAction = &requiredAction
+		}
+	}
+	if run.LastError != "" {
+		var lastError relaymodel.RunError
+		if json.Unmarshal([]byte(run.LastError), &lastError) == nil {
+			object.LastError = &lastError
+		}
+	}
+	if run.Status == model.RunStatusCompleted || run.Status == model.RunStatusFailed || run.Status == model.RunStatusCancelled || run.Status == model.RunStatusExpired {
+		object.Usage = &relaymodel.Usage{
+			PromptTokens:     run.PromptTokens,
+			CompletionTokens: run.CompletionTokens,
+			TotalTokens:      run.PromptTokens + run.CompletionTokens,
+		}
+	}
+	return object
+}
+
+// GetUserThreadRun loads the run addressed by the :id and :runsId path parameters for the current user
+func GetUserThreadRun(c *gin.Context) (*model.Run, *relaymodel.ErrorWithStatusCode) {
+	thread, err := model.GetUserThread(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		return nil, notFoundError("thread", c.Param("id"))
+	}
+	run, err := model.GetThreadRun(thread.ThreadId, c.Param("runsId"))
+	if err != nil {
+		return nil, notFoundError("run", c.Param("runsId"))
+	}
+	// runs left unfinished past their deadline, e.g. waiting for tool outputs, expire lazily
+	active := []string{model.RunStatusQueued, model.RunStatusInProgress, model.RunStatusRequiresAction}
+	if run.ExpiresAt > 0 && common.GetTimestamp() > run.ExpiresAt && common.StringsContains(active, run.Status) {
+		ok, _ := model.UpdateRunStatus(run.RunId, active, model.RunStatusExpired, nil)
+		if ok {
+			run.Status = model.RunStatusExpired
+		}
+	}
+	return run, nil
+}
+
+func ListRuns(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	thread, err := model.GetUserThread(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		return notFoundError("thread", c.Param("id"))
+	}
+	limit, order, after := listParams(c)
+	afterId := 0
+	if after != "" {
+		cursor, err := model.GetThreadRun(thread.ThreadId, after)
+		if err != nil {
+			return notFoundError("run", after)
+		}
+		afterId = cursor.Id
+	}
+	runs, err := model.GetThreadRuns(thread.ThreadId, order, afterId, limit+1)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_runs_failed", http.StatusInternalServerError)
+	}
+	objects := make([]relaymodel.RunObject, 0, len(runs))
+	for _, run := range runs {
+		objects = append(objects, RunObject(run))
+	}
+	listResponse(c, objects, limit, func(o relaymodel.RunObject) string { return o.Id })
+	return nil
+}
+
+func RetrieveRun(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	run, bizErr := GetUserThreadRun(c)
+	if bizErr != nil {
+		return bizErr
+	}
+	c.JSON(http.StatusOK, RunObject(run))
+	return nil
+}
+
+func ModifyRun(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	run, bizErr := GetUserThreadRun(c)
+	if bizErr != nil {
+		return bizErr
+	}
+	var request struct {
+		Metadata map[string]string `json:"metadata"`
+	}
+	err := common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_run_request", http.StatusBadRequest)
+	}
+	if request.Metadata != nil {
+		run.Metadata = MarshalMetadata(request.Metadata)
+		err = model.DB.Model(run).Update("metadata", run.Metadata).Error
+		if err != nil {
+			return openai.ErrorWrapper(err, "update_run_failed", http.StatusInternalServerError)
+		}
+	}
+	c.JSON(http.StatusOK, RunObject(run))
+	return nil
+}
+
+func RunStepObject(step *model.RunStep) relaymodel.RunStepObject {
+	object := relaymodel.RunStepObject{
+		Id:          step.StepId,
+		Object:      "thread.run.step",
+		CreatedAt:   step.CreatedAt,
+		AssistantId: step.AssistantId,
+		ThreadId:    step.ThreadId,
+		RunId:       step.RunId,
+		Type:        step.Type,
+		Status:      step.Status,
+		CancelledAt: step.CancelledAt,
+		FailedAt:    step.FailedAt,
+		CompletedAt: step.CompletedAt,
+		Usage: &relaymodel.Usage{
+			PromptTokens:     step.PromptTokens,
+			CompletionTokens: step.CompletionTokens,
+			TotalTokens:      step.PromptTokens + step.CompletionTokens,
+		},
+	}
+	_ = json.Unmarshal([]byte(step.StepDetails), &object.StepDetails)
+	if step.LastError != "" {
+		var lastError relaymodel.RunError
+		if json.Unmarshal([]byte(step.LastError), &lastError) == nil {
+			object.LastError = &lastError
+		}
+	}
+	return object
+}
+
+func ListRunSteps(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	run, bizErr := GetUserThreadRun(c)
+	if bizErr != nil {
+		return bizErr
+	}
+	limit, order, after := listParams(c)
+	afterId := 0
+	if after != "" {
+		cursor, err := model.GetRunStep(run.RunId, after)
+		if err != nil {
+			return notFoundError("run step", after)
+		}
+		afterId = cursor.Id
+	}
+	steps, err := model.GetRunSteps(run.RunId, order, afterId, limit+1)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_run_steps_failed", http.StatusInternalServerError)
+	}
+	objects := make([]relaymodel.RunStepObject, 0, len(steps))
+	for _, step := range steps {
+		objects = append(objects, RunStepObject(step))
+	}
+	listResponse(c, objects, limit, func(o relaymodel.RunStepObject) string { return o.Id })
+	return nil
+}
+
+func RetrieveRunStep(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	run, bizErr := GetUserThreadRun(c)
+	if bizErr != nil {
+		return bizErr
+	}
+	step, err := model.GetRunStep(run.RunId, c.Param("stepId"))
+	if err != nil {
+		return notFoundError("run step", c.Param("stepId"))
+	}
+	c.JSON(http.StatusOK, RunStepObject(step))
+	return nil
+}
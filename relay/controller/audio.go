@@ -69,7 +69,7 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCod
 
 	BillingByRequestEnabled, _ := strconv.ParseBool(config.OptionMap["BillingByRequestEnabled"])
 	ModelRatioEnabled, _ := strconv.ParseBool(config.OptionMap["ModelRatioEnabled"])
-	preConsumedQuota = int(float64(preConsumedTokens) * ratio)
+	preConsumedQuota = int(float64(preConsumedTokens) * ratio * common.GetPricing(audioRequest.Model, meta.Group, 0, meta.StartTime).PromptMultiplier)
 	if BillingByRequestEnabled {
 		shouldUseModelRatio2 := !ModelRatioEnabled || (ModelRatioEnabled && meta.BillingEnabled)
 		if shouldUseModelRatio2 {
@@ -88,21 +88,26 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCod
 	if userQuota-preConsumedQuota < 0 {
 		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
-	err = model.CacheDecreaseUserQuota(c.Request.Context(), meta.UserId, preConsumedQuota)
-	if err != nil {
-		return openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
-	}
+	decreasedQuota := preConsumedQuota
 	if userQuota > 100*preConsumedQuota {
 		// in this case, we do not pre-consume quota
 		// because the user has enough quota
 		preConsumedQuota = 0
 	}
 
-	if preConsumedQuota > 0 {
-		err = model.PreConsumeTokenQuota(meta.TokenId, preConsumedQuota)
-		if err != nil {
-			return openai.ErrorWrapper(err, "pre_consume_token_quota_failed", http.StatusForbidden)
+	// 检查令牌的周期预算后再扣减缓存中的用户额度
+	err = reserveQuota(c.Request.Context(), meta, preConsumedQuota, decreasedQuota)
+	if err != nil {
+		return preConsumeTokenQuotaError(err)
+	}
+	err = model.CacheDecreaseUserQuota(c.Request.Context(), meta.UserId, decreasedQuota)
+	if err != nil {
+		if preConsumedQuota > 0 || meta.BudgetReserved > 0 {
+			if err := model.PostConsumeTokenQuotaWithBudget(c.Request.Context(), meta.TokenId, -preConsumedQuota, meta.BudgetReserved); err != nil {
+				logger.Error(c.Request.Context(), "error return pre-consumed quota: "+err.Error())
+			}
 		}
+		return openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
 	}
 
 	// map model name
@@ -111,6 +116,7 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCod
 		modelMap := make(map[string]string)
 		err := json.Unmarshal([]byte(modelMapping), &modelMap)
 		if err != nil {
+			util.ReturnPreConsumedQuota(c.Request.Context(), preConsumedQuota, meta)
 			return openai.ErrorWrapper(err, "unmarshal_model_mapping_failed", http.StatusInternalServerError)
 		}
 		if modelMap[audioRequest.Model] != "" {
@@ -139,6 +145,7 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCod
 
 	req, err := http.NewRequest(c.Request.Method, fullRequestURL, requestBody)
 	if err != nil {
+		util.ReturnPreConsumedQuota(c.Request.Context(), preConsumedQuota, meta)
 		return openai.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
 	}
 	if (relayMode == constant.RelayModeAudioTranscription || relayMode == constant.RelayModeAudioSpeech) && meta.ChannelType == common.ChannelTypeAzure {
@@ -155,15 +162,18 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCod
 
 	resp, err := util.HTTPClient.Do(req)
 	if err != nil {
+		util.ReturnPreConsumedQuota(c.Request.Context(), preConsumedQuota, meta)
 		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
 	}
 
 	err = req.Body.Close()
 	if err != nil {
+		util.ReturnPreConsumedQuota(c.Request.Context(), preConsumedQuota, meta)
 		return openai.ErrorWrapper(err, "close_request_body_failed", http.StatusInternalServerError)
 	}
 	err = c.Request.Body.Close()
 	if err != nil {
+		util.ReturnPreConsumedQuota(c.Request.Context(), preConsumedQuota, meta)
 		return openai.ErrorWrapper(err, "close_request_body_failed", http.StatusInternalServerError)
 	}
 
@@ -186,8 +196,9 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCod
 				promptTokens = quota
 			}
 			modelRatioString := ""
-			quota = int(float64(quota) * ratio)
-			modelRatioString = fmt.Sprintf("模型倍率 %.2f", modelRatio)
+			pricing := common.GetPricing(audioRequest.Model, meta.Group, promptTokens, meta.StartTime)
+			quota = int(float64(quota) * ratio * pricing.PromptMultiplier)
+			modelRatioString = fmt.Sprintf("模型倍率 %.2f", modelRatio) + pricing.Description
 			if BillingByRequestEnabled {
 				shouldUseModelRatio2 := !ModelRatioEnabled || (ModelRatioEnabled && meta.BillingEnabled)
 				if shouldUseModelRatio2 {
@@ -205,7 +216,7 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCod
 				quota = 1
 			}
 			quotaDelta := quota - preConsumedQuota
-			err = model.PostConsumeTokenQuota(meta.TokenId, quotaDelta)
+			err = model.PostConsumeTokenQuotaWithBudget(ctx, meta.TokenId, quotaDelta, meta.BudgetReserved)
 			if err != nil {
 				common.SysError("error consuming token remain quota: " + err.Error())
 			}
@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/common/storage"
+	"one-api/model"
+	"one-api/relay/channel/openai"
+	relaymodel "one-api/relay/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BatchEndpoints are the endpoints a batch may target, every line of the input file must use the batch endpoint
+var BatchEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+}
+
+const batchCompletionWindow = 24 * 60 * 60
+
+func BatchObject(batch *model.Batch) relaymodel.BatchObject {
+	object := relaymodel.BatchObject{
+		Id:               batch.BatchId,
+		Object:           "batch",
+		Endpoint:         batch.Endpoint,
+		InputFileId:      batch.InputFileId,
+		CompletionWindow: batch.CompletionWindow,
+		Status:           batch.Status,
+		OutputFileId:     batch.OutputFileId,
+		ErrorFileId:      batch.ErrorFileId,
+		CreatedAt:        batch.CreatedAt,
+		InProgressAt:     batch.InProgressAt,
+		ExpiresAt:        batch.ExpiresAt,
+		FinalizingAt:     batch.FinalizingAt,
+		CompletedAt:      batch.CompletedAt,
+		FailedAt:         batch.FailedAt,
+		ExpiredAt:        batch.ExpiredAt,
+		CancellingAt:     batch.CancellingAt,
+		CancelledAt:      batch.CancelledAt,
+		RequestCounts: relaymodel.BatchRequestCounts{
+			Total:     batch.TotalRequests,
+			Completed: batch.CompletedRequests,
+			Failed:    batch.FailedRequests,
+		},
+	}
+	if batch.Errors != "" {
+		var batchErrors relaymodel.BatchErrors
+		if err := json.Unmarshal([]byte(batch.Errors), &batchErrors); err == nil {
+			object.Errors = &batchErrors
+		}
+	}
+	if batch.Metadata != "" {
+		_ = json.Unmarshal([]byte(batch.Metadata), &object.Metadata)
+	}
+	return object
+}
+
+func RelayBatchCreate(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	var request relaymodel.BatchRequest
+	err := common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_batch_request", http.StatusBadRequest)
+	}
+	if !BatchEndpoints[request.Endpoint] {
+		return openai.ErrorWrapper(fmt.Errorf("unsupported endpoint: %s", request.Endpoint), "invalid_endpoint", http.StatusBadRequest)
+	}
+	if request.CompletionWindow != "24h" {
+		return openai.ErrorWrapper(errors.New("completion_window must be 24h"), "invalid_completion_window", http.StatusBadRequest)
+	}
+	userId := c.GetInt("id")
+	inputFile, err := model.GetUserFile(userId, request.InputFileId)
+	if err != nil {
+		return openai.ErrorWrapper(fmt.Errorf("No such File object: %s", request.InputFileId), "file_not_found", http.StatusNotFound)
+	}
+	if inputFile.Purpose != "batch" {
+		return openai.ErrorWrapper(errors.New("input file must be uploaded with purpose batch"), "invalid_input_file", http.StatusBadRequest)
+	}
+	if inputFile.ChannelId != 0 {
+		return openai.ErrorWrapper(errors.New("input file is stored upstream and cannot be read by the batch worker"), "invalid_input_file", http.StatusBadRequest)
+	}
+	metadata := ""
+	if len(request.Metadata) > 0 {
+		jsonBytes, err := json.Marshal(request.Metadata)
+		if err != nil {
+			return openai.ErrorWrapper(err, "marshal_metadata_failed", http.StatusInternalServerError)
+		}
+		metadata = string(jsonBytes)
+	}
+	now := common.GetTimestamp()
+	batch := &model.Batch{
+		BatchId:          "batch_" + common.GetUUID(),
+		UserId:           userId,
+		TokenId:          c.GetInt("token_id"),
+		Endpoint:         request.Endpoint,
+		InputFileId:      request.InputFileId,
+		CompletionWindow: request.CompletionWindow,
+		Status:           model.BatchStatusValidating,
+		Metadata:         metadata,
+		CreatedAt:        now,
+		ExpiresAt:        now + batchCompletionWindow,
+	}
+	err = batch.Insert()
+	if err != nil {
+		return openai.ErrorWrapper(err, "insert_batch_failed", http.StatusInternalServerError)
+	}
+	c.JSON(http.StatusOK, BatchObject(batch))
+	return nil
+}
+
+func RelayBatchRetrieve(c *gin.Context, batch *model.Batch) *relaymodel.ErrorWithStatusCode {
+	c.JSON(http.StatusOK, BatchObject(batch))
+	return nil
+}
+
+func RelayBatchList(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	userId := c.GetInt("id")
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	afterId := 0
+	if after := c.Query("after"); after != "" {
+		batch, err := model.GetUserBatch(userId, after)
+		if err != nil {
+			return openai.ErrorWrapper(fmt.Errorf("No such Batch object: %s", after), "batch_not_found", http.StatusNotFound)
+		}
+		afterId = batch.Id
+	}
+	batches, err := model.GetUserBatches(userId, afterId, limit+1)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_batches_failed", http.StatusInternalServerError)
+	}
+	response := relaymodel.BatchListResponse{
+		Object: "list",
+		Data:   make([]relaymodel.BatchObject, 0, len(batches)),
+	}
+	if len(batches) > limit {
+		batches = batches[:limit]
+		response.HasMore = true
+	}
+	for _, batch := range batches {
+		response.Data = append(response.Data, BatchObject(batch))
+	}
+	if len(response.Data) > 0 {
+		response.FirstId = response.Data[0].Id
+		response.LastId = response.Data[len(response.Data)-1].Id
+	}
+	c.JSON(http.StatusOK, response)
+	return nil
+}
+
+func RelayBatchCancel(c *gin.Context, batch *model.Batch) *relaymodel.ErrorWithStatusCode {
+	ok, err := model.CancelBatch(batch.Id)
+	if err != nil {
+		return openai.ErrorWrapper(err, "cancel_batch_failed", http.StatusInternalServerError)
+	}
+	if !ok {
+		return openai.ErrorWrapper(fmt.Errorf("cannot cancel a batch with status %s", batch.Status), "invalid_batch_status", http.StatusConflict)
+	}
+	batch, err = model.GetBatchById(batch.Id)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_batch_failed", http.StatusInternalServerError)
+	}
+	c.JSON(http.StatusOK, BatchObject(batch))
+	return nil
+}
+
+// SaveBatchResultFile stores the output or error JSONL of a batch as a downloadable file of the batch owner.
+func SaveBatchResultFile(batch *model.Batch, purpose string, content []byte) (*model.File, error) {
+	backend, err := storage.GetStorage()
+	if err != nil {
+		return nil, err
+	}
+	file := &model.File{
+		FileId:    "file-" + common.GetUUID(),
+		UserId:    batch.UserId,
+		TokenId:   batch.TokenId,
+		Filename:  fmt.Sprintf("%s_%s.jsonl", batch.BatchId, purpose),
+		Purpose:   purpose,
+		Status:    model.FileStatusProcessed,
+		CreatedAt: common.GetTimestamp(),
+	}
+	file.StorageKey = fmt.Sprintf("%d/%s", file.UserId, file.FileId)
+	file.Bytes, err = backend.Save(file.StorageKey, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	return file, file.Insert()
+}
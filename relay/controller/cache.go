@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/common/config"
+	"one-api/common/logger"
+	"one-api/relay/model"
+	"one-api/relay/util"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 超过该大小的响应不缓存
+const responseCacheMaxBodySize = 1 << 20
+
+type cachedResponse struct {
+	ContentType string      `json:"content_type"`
+	Body        string      `json:"body"`
+	Usage       model.Usage `json:"usage"`
+	AIText      string      `json:"aitext"`
+}
+
+// responseCacheWriter copies what the adaptor writes to the client so the response can be cached.
+type responseCacheWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *responseCacheWriter) Write(data []byte) (int, error) {
+	w.buffer(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseCacheWriter) WriteString(s string) (int, error) {
+	w.buffer([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *responseCacheWriter) buffer(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.body.Len()+len(data) > responseCacheMaxBodySize {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(data)
+}
+
+// getResponseCacheTTL returns how long the response of this request may be cached,
+// 0 when neither the token nor the group enables the cache.
+func getResponseCacheTTL(c *gin.Context, meta *util.RelayMeta) time.Duration {
+	if !config.ResponseCacheEnabled {
+		return 0
+	}
+	groupTTL, groupEnabled := common.GetGroupResponseCacheTTL(meta.Group)
+	if !c.GetBool("token_cache_enabled") && !groupEnabled {
+		return 0
+	}
+	ttl := c.GetInt("token_cache_ttl")
+	if ttl <= 0 {
+		ttl = groupTTL
+	}
+	if ttl <= 0 {
+		ttl = config.ResponseCacheTTL
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// getResponseCacheKey hashes the normalized request together with the token, so a response is only replayed to the
+// token that paid for it; requests of the token differing only in the end user share the response.
+func getResponseCacheKey(meta *util.RelayMeta, textRequest *model.GeneralOpenAIRequest) (string, error) {
+	request := *textRequest
+	request.User = ""
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	hash.Write([]byte(fmt.Sprintf("%d:%d:%s:%s:", meta.TokenId, meta.Mode, meta.Group, meta.FixedContent)))
+	hash.Write(jsonData)
+	return "response_cache:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func getCachedResponse(key string) (*cachedResponse, bool) {
+	value, ok := common.ResponseCacheGet(key)
+	if !ok {
+		return nil, false
+	}
+	var cached cachedResponse
+	if err := json.Unmarshal([]byte(value), &cached); err != nil {
+		return nil, false
+	}
+	return &cached, true
+}
+
+// saveCachedResponse stores the response copied by the writer, failed or truncated responses are skipped.
+func saveCachedResponse(ctx context.Context, key string, ttl time.Duration, writer *responseCacheWriter, usage *model.Usage, aitext string) {
+	if writer.overflow || writer.body.Len() == 0 || writer.Status() != http.StatusOK || usage == nil || usage.TotalTokens == 0 {
+		return
+	}
+	jsonData, err := json.Marshal(cachedResponse{
+		ContentType: writer.Header().Get("Content-Type"),
+		Body:        writer.body.String(),
+		Usage:       *usage,
+		AIText:      aitext,
+	})
+	if err != nil {
+		return
+	}
+	err = common.ResponseCacheSet(key, string(jsonData), ttl)
+	if err != nil {
+		logger.Errorf(ctx, "save response cache failed: %s", err.Error())
+	}
+}
+
+// replayCachedResponse writes a cached response back as is, as SSE events for stream requests.
+func replayCachedResponse(c *gin.Context, cached *cachedResponse) {
+	if strings.HasPrefix(cached.ContentType, "text/event-stream") {
+		common.SetEventStreamHeaders(c)
+	} else {
+		c.Writer.Header().Set("Content-Type", cached.ContentType)
+	}
+	c.Writer.Header().Set(common.ResponseCacheKey, "HIT")
+	c.Writer.WriteHeader(http.StatusOK)
+	_, _ = c.Writer.WriteString(cached.Body)
+	c.Writer.Flush()
+}
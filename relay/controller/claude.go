@@ -3,28 +3,45 @@ package controller
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"one-api/common"
-	"one-api/common/config"
 	"one-api/common/logger"
+	"one-api/relay/channel/anthropic"
 	"one-api/relay/channel/openai"
 	"one-api/relay/constant"
 	"one-api/relay/helper"
 	"one-api/relay/model"
 	"one-api/relay/util"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// isNativeClaudeChannel reports whether the channel serves /v1/messages itself: Claude channels and the channels
+// flagged to take Claude original requests, which are preferred for them when selecting. Other channels get the
+// request translated to chat completions.
+func isNativeClaudeChannel(meta *util.RelayMeta) bool {
+	if meta.ClaudeNative {
+		return true
+	}
+	switch meta.APIType {
+	case constant.APITypeAnthropic, constant.APITypeAwsClaude, constant.APITypeGCP:
+		return true
+	}
+	return false
+}
+
 func RelayClaude(c *gin.Context) *model.ErrorWithStatusCode {
 
 	ctx := c.Request.Context()
 	meta := util.GetRelayMeta(c)
+	if !isNativeClaudeChannel(meta) {
+		return relayClaudeByChat(c, meta)
+	}
 	// get & validate textRequest
 	meta.IsClaude = true
 	textRequest, err := getAndValidateTextRequest(c, meta.Mode)
@@ -41,32 +58,9 @@ func RelayClaude(c *gin.Context) *model.ErrorWithStatusCode {
 	textRequest.Model, isModelMapped = util.GetMappedModelName(textRequest.Model, meta.ModelMapping)
 	meta.ActualModelName = textRequest.Model
 	// get model ratio & group ratio
-	modelRatio := common.GetModelRatio(textRequest.Model)
-	groupRatio := common.GetGroupRatio(meta.Group)
 	promptTokens := getPromptTokens(textRequest, meta.Mode)
 	meta.PromptTokens = promptTokens
-	ratio := modelRatio * groupRatio
-	preConsumedQuota := 0
-	preConsumedTokens := promptTokens
-	if config.PreConsumedQuota <= 0 {
-		config.PreConsumedQuota = 500
-	}
-	if preConsumedTokens <= 0 {
-		preConsumedTokens = config.PreConsumedQuota
-	}
-	BillingByRequestEnabled, _ := strconv.ParseBool(config.OptionMap["BillingByRequestEnabled"])
-	ModelRatioEnabled, _ := strconv.ParseBool(config.OptionMap["ModelRatioEnabled"])
-	preConsumedQuota = int(float64(preConsumedTokens) * ratio)
-	if BillingByRequestEnabled {
-		shouldUseModelRatio2 := !ModelRatioEnabled || (ModelRatioEnabled && meta.BillingEnabled)
-		if shouldUseModelRatio2 {
-			modelRatio2, ok := common.GetModelRatio2(meta.OriginModelName)
-			if ok {
-				ratio = modelRatio2 * groupRatio
-				preConsumedQuota = int(ratio * config.QuotaPerUnit)
-			}
-		}
-	}
+	ratio, modelRatio, groupRatio, preConsumedQuota := getTextQuotaRatio(meta, textRequest.Model, promptTokens)
 
 	preConsumedQuota, bizErr := preConsumeQuota(ctx, preConsumedQuota, meta)
 	if bizErr != nil {
@@ -115,7 +109,7 @@ func RelayClaude(c *gin.Context) *model.ErrorWithStatusCode {
 		errorHappened := (resp.StatusCode != http.StatusOK) || (meta.IsStream && strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json"))
 		if errorHappened {
 			logger.Errorf(ctx, "errorHappened is not nil: %+v", errorHappened)
-			util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+			util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
 			return util.RelayErrorHandler(resp)
 		}
 	}
@@ -130,10 +124,102 @@ func RelayClaude(c *gin.Context) *model.ErrorWithStatusCode {
 	duration := int(endTime.Sub(startTime).Seconds())
 	if respErr != nil {
 		logger.Errorf(ctx, "respErr is not nil: %+v", respErr)
-		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
 		return respErr
 	}
 	// post-consume quota
 	go postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, aitext, duration)
 	return nil
 }
+
+// relayClaudeByChat serves a Claude messages request on a channel speaking chat completions,
+// the response written by the adaptor is converted back into Claude messages or stream events.
+func relayClaudeByChat(c *gin.Context, meta *util.RelayMeta) *model.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	var request anthropic.MessagesRequest
+	err := common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_text_request", http.StatusBadRequest)
+	}
+	if request.Model == "" {
+		return openai.ErrorWrapper(errors.New("model is required"), "invalid_text_request", http.StatusBadRequest)
+	}
+	textRequest, err := anthropic.MessagesRequest2ChatRequest(&request)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_text_request", http.StatusBadRequest)
+	}
+	meta.IsClaude = false
+	meta.IsStream = textRequest.Stream
+	meta.AttemptsLog = c.GetString("attemptsLog")
+	meta.OriginModelName = textRequest.Model
+	textRequest.Model, _ = util.GetMappedModelName(textRequest.Model, meta.ModelMapping)
+	meta.ActualModelName = textRequest.Model
+	promptTokens := openai.CountTokenChatRequest(textRequest, textRequest.Model)
+	meta.PromptTokens = promptTokens
+	ratio, modelRatio, groupRatio, preConsumedQuota := getTextQuotaRatio(meta, textRequest.Model, promptTokens)
+
+	preConsumedQuota, bizErr := preConsumeQuota(ctx, preConsumedQuota, meta)
+	if bizErr != nil {
+		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
+		return bizErr
+	}
+
+	adaptor := helper.GetAdaptor(meta.APIType)
+	if adaptor == nil {
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		return openai.ErrorWrapper(fmt.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+	// the adaptors only know chat completions
+	meta.Mode = constant.RelayModeChatCompletions
+	meta.RequestURLPath = "/v1/chat/completions"
+	adaptor.Init(meta)
+	convertedRequest, err := adaptor.ConvertRequest(c, meta, textRequest)
+	if err != nil {
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		return openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
+	}
+	jsonData, err := json.Marshal(convertedRequest)
+	if err != nil {
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		return openai.ErrorWrapper(err, "json_marshal_failed", http.StatusInternalServerError)
+	}
+	logger.Debugf(ctx, "converted request: \n%s", string(jsonData))
+
+	startTime := time.Now()
+	resp, err := adaptor.DoRequest(c, meta, bytes.NewBuffer(jsonData))
+	if err != nil {
+		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	statusCodeMappingStr := c.GetString("status_code_mapping")
+	if isErrorHappened(meta, resp) {
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		openaiErr := util.RelayErrorHandler(resp)
+		util.ResetStatusCode(openaiErr, statusCodeMappingStr)
+		return openaiErr
+	}
+
+	writer := anthropic.NewMessagesWriter(c.Writer, meta.OriginModelName, meta.IsStream, promptTokens)
+	c.Writer = writer
+	aitext, usage, respErr := adaptor.DoResponse(c, resp, meta)
+	c.Writer = writer.ResponseWriter
+	if respErr == nil {
+		err = writer.Finish(usage)
+		if err != nil {
+			logger.Errorf(ctx, "convert response failed: %s", err.Error())
+			if !meta.IsStream {
+				respErr = openai.ErrorWrapper(err, "convert_response_failed", http.StatusInternalServerError)
+			}
+		}
+	}
+	if respErr != nil {
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		util.ResetStatusCode(respErr, statusCodeMappingStr)
+		return respErr
+	}
+	duration := int(time.Since(startTime).Seconds())
+
+	go postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, aitext, duration)
+	return nil
+}
@@ -0,0 +1,316 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/common/config"
+	"one-api/common/logger"
+	"one-api/common/storage"
+	"one-api/model"
+	"one-api/relay/channel"
+	"one-api/relay/channel/openai"
+	"one-api/relay/constant"
+	relaymodel "one-api/relay/model"
+	"one-api/relay/util"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FileModelName is the pseudo model used for channel selection, token model limits and billing of /v1/files
+const FileModelName = "files"
+
+var filePurposes = map[string]bool{
+	"assistants": true,
+	"batch":      true,
+	"fine-tune":  true,
+	"vision":     true,
+	"user_data":  true,
+	"evals":      true,
+}
+
+func fileObject(file *model.File) relaymodel.FileObject {
+	return relaymodel.FileObject{
+		Id:        file.FileId,
+		Object:    "file",
+		Bytes:     file.Bytes,
+		CreatedAt: file.CreatedAt,
+		Filename:  file.Filename,
+		Purpose:   file.Purpose,
+		Status:    file.Status,
+	}
+}
+
+// getFileUploadQuota returns the per-upload price of the files pseudo model, 0 if it is not priced.
+func getFileUploadQuota(meta *util.RelayMeta) (int, float64) {
+	groupRatio := common.GetGroupRatio(meta.Group)
+	price, ok := common.ModelPrice[FileModelName]
+	if !ok || price <= 0 {
+		return 0, groupRatio
+	}
+	return int(price * groupRatio * config.QuotaPerUnit), groupRatio
+}
+
+func postConsumeFileQuota(c *gin.Context, meta *util.RelayMeta, quota int, groupRatio float64, userQuota int, startTime time.Time) {
+	if quota == 0 {
+		return
+	}
+	ctx := c.Request.Context()
+	err := model.PostConsumeTokenQuota(ctx, meta.TokenId, quota)
+	if err != nil {
+		common.SysError("error consuming token remain quota: " + err.Error())
+	}
+	err = model.CacheDecreaseUserQuota(ctx, meta.UserId, quota)
+	if err != nil {
+		logger.Error(ctx, "decrease_user_quota_failed"+err.Error())
+	}
+	useTimeSeconds := time.Now().Unix() - startTime.Unix()
+	multiplier := fmt.Sprintf(" 按次计费，分组倍率 %.2f", groupRatio)
+	model.RecordConsumeLog(ctx, meta.UserId, meta.ChannelId, meta.ChannelName, 0, 0, FileModelName, meta.TokenName, quota, " ", meta.TokenId, multiplier, userQuota, int(useTimeSeconds), false, meta.AttemptsLog, meta.RelayIp)
+	model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
+	model.UpdateChannelUsedQuota(meta.ChannelId, quota)
+}
+
+// RelayFileUpload stores an uploaded file locally, or forwards it to the selected channel
+// when the context already carries one (see config.FileUpstreamEnabled).
+func RelayFileUpload(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	startTime := time.Now()
+	meta := util.GetRelayMeta(c)
+	purpose := c.PostForm("purpose")
+	if !filePurposes[purpose] {
+		return openai.ErrorWrapper(fmt.Errorf("invalid purpose: %s", purpose), "invalid_purpose", http.StatusBadRequest)
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return openai.ErrorWrapper(err, "file_missing", http.StatusBadRequest)
+	}
+
+	quota, groupRatio := getFileUploadQuota(meta)
+	userQuota, err := model.CacheGetUserQuota(c, meta.UserId)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
+	}
+	if userQuota-quota < 0 {
+		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusPaymentRequired)
+	}
+
+	file := &model.File{
+		UserId:    meta.UserId,
+		TokenId:   meta.TokenId,
+		ChannelId: meta.ChannelId,
+		Filename:  fileHeader.Filename,
+		Purpose:   purpose,
+		Bytes:     fileHeader.Size,
+		Status:    model.FileStatusProcessed,
+		CreatedAt: common.GetTimestamp(),
+	}
+	if meta.ChannelId != 0 {
+		requestBody, err := common.GetRequestBody(c)
+		if err != nil {
+			return openai.ErrorWrapper(err, "read_request_body_failed", http.StatusInternalServerError)
+		}
+		upstream, bizErr := doFileUpstreamRequest(c, meta, requestBody)
+		if bizErr != nil {
+			return bizErr
+		}
+		file.FileId = upstream.Id
+		file.Status = upstream.Status
+		if upstream.Bytes != 0 {
+			file.Bytes = upstream.Bytes
+		}
+	} else {
+		backend, err := storage.GetStorage()
+		if err != nil {
+			return openai.ErrorWrapper(err, "get_file_storage_failed", http.StatusInternalServerError)
+		}
+		src, err := fileHeader.Open()
+		if err != nil {
+			return openai.ErrorWrapper(err, "open_upload_file_failed", http.StatusBadRequest)
+		}
+		defer src.Close()
+		file.FileId = "file-" + common.GetUUID()
+		file.StorageKey = fmt.Sprintf("%d/%s", meta.UserId, file.FileId)
+		file.Bytes, err = backend.Save(file.StorageKey, src)
+		if err != nil {
+			return openai.ErrorWrapper(err, "save_file_failed", http.StatusInternalServerError)
+		}
+	}
+	err = file.Insert()
+	if err != nil {
+		return openai.ErrorWrapper(err, "insert_file_failed", http.StatusInternalServerError)
+	}
+	postConsumeFileQuota(c, meta, quota, groupRatio, userQuota, startTime)
+	c.JSON(http.StatusOK, fileObject(file))
+	return nil
+}
+
+func RelayFileList(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	userId := c.GetInt("id")
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 {
+		limit = 20
+	} else if limit > 10000 {
+		limit = 10000
+	}
+	order := c.Query("order")
+	if order != "asc" {
+		order = "desc"
+	}
+	afterId := 0
+	if after := c.Query("after"); after != "" {
+		cursor, err := model.GetUserFile(userId, after)
+		if err != nil {
+			return notFoundError("file", after)
+		}
+		afterId = cursor.Id
+	}
+	files, err := model.GetUserFiles(userId, c.Query("purpose"), order, afterId, limit+1)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_files_failed", http.StatusInternalServerError)
+	}
+	objects := make([]relaymodel.FileObject, 0, len(files))
+	for _, file := range files {
+		objects = append(objects, fileObject(file))
+	}
+	listResponse(c, objects, limit, func(o relaymodel.FileObject) string { return o.Id })
+	return nil
+}
+
+// RelayFileRetrieve answers GET /v1/files/:id. Files living on an upstream channel
+// are refreshed from it so the status reflects upstream processing.
+func RelayFileRetrieve(c *gin.Context, file *model.File) *relaymodel.ErrorWithStatusCode {
+	if file.ChannelId != 0 {
+		upstream, bizErr := doFileUpstreamRequest(c, util.GetRelayMeta(c), nil)
+		if bizErr != nil {
+			return bizErr
+		}
+		if upstream.Status != "" && upstream.Status != file.Status {
+			file.Status = upstream.Status
+			if err := file.Update(); err != nil {
+				common.SysError("failed to update file status: " + err.Error())
+			}
+		}
+	}
+	c.JSON(http.StatusOK, fileObject(file))
+	return nil
+}
+
+func RelayFileContent(c *gin.Context, file *model.File) *relaymodel.ErrorWithStatusCode {
+	if file.ChannelId != 0 {
+		resp, err := channel.DoRequestHelper(&openai.Adaptor{}, c, util.GetRelayMeta(c), http.NoBody)
+		if err != nil {
+			return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return util.RelayErrorHandler(resp)
+		}
+		defer resp.Body.Close()
+		for k, v := range resp.Header {
+			c.Writer.Header().Set(k, v[0])
+		}
+		c.Writer.WriteHeader(resp.StatusCode)
+		if _, err := io.Copy(c.Writer, resp.Body); err != nil {
+			return openai.ErrorWrapper(err, "copy_response_body_failed", http.StatusInternalServerError)
+		}
+		return nil
+	}
+	reader, err := OpenFileContent(file)
+	if err != nil {
+		return openai.ErrorWrapper(err, "open_file_failed", http.StatusInternalServerError)
+	}
+	defer reader.Close()
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Filename))
+	c.DataFromReader(http.StatusOK, file.Bytes, "application/octet-stream", reader, nil)
+	return nil
+}
+
+func RelayFileDelete(c *gin.Context, file *model.File) *relaymodel.ErrorWithStatusCode {
+	if file.ChannelId != 0 {
+		resp, err := channel.DoRequestHelper(&openai.Adaptor{}, c, util.GetRelayMeta(c), http.NoBody)
+		if err != nil {
+			return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+		}
+		// the upstream copy may already be gone, the local record is removed anyway
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+			return util.RelayErrorHandler(resp)
+		}
+		_ = resp.Body.Close()
+	} else {
+		backend, err := storage.GetStorage()
+		if err != nil {
+			return openai.ErrorWrapper(err, "get_file_storage_failed", http.StatusInternalServerError)
+		}
+		err = backend.Delete(file.StorageKey)
+		if err != nil {
+			return openai.ErrorWrapper(err, "delete_file_failed", http.StatusInternalServerError)
+		}
+	}
+	err := file.Delete()
+	if err != nil {
+		return openai.ErrorWrapper(err, "delete_file_failed", http.StatusInternalServerError)
+	}
+	c.JSON(http.StatusOK, relaymodel.FileDeleteResponse{
+		Id:      file.FileId,
+		Object:  "file",
+		Deleted: true,
+	})
+	return nil
+}
+
+// OpenFileContent opens a locally stored file.
+func OpenFileContent(file *model.File) (io.ReadCloser, error) {
+	if file.ChannelId != 0 {
+		return nil, fmt.Errorf("file %s is stored on channel #%d", file.FileId, file.ChannelId)
+	}
+	backend, err := storage.GetStorage()
+	if err != nil {
+		return nil, err
+	}
+	return backend.Open(file.StorageKey)
+}
+
+func channelSupportsFiles(meta *util.RelayMeta) bool {
+	if meta.APIType != constant.APITypeOpenAI {
+		return false
+	}
+	switch meta.ChannelType {
+	case common.ChannelTypeAzure, common.ChannelTypeCustom, common.ChannelTypeMinimax, common.ChannelTypeDouBao:
+		return false
+	}
+	return true
+}
+
+// doFileUpstreamRequest sends the current files request to the selected channel and
+// decodes the returned file object. Only OpenAI compatible channels host files.
+func doFileUpstreamRequest(c *gin.Context, meta *util.RelayMeta, requestBody []byte) (*relaymodel.FileObject, *relaymodel.ErrorWithStatusCode) {
+	if !channelSupportsFiles(meta) {
+		return nil, openai.ErrorWrapper(fmt.Errorf("channel #%d does not support files", meta.ChannelId), "channel_not_support_files", http.StatusBadRequest)
+	}
+	var body io.Reader = http.NoBody
+	if requestBody != nil {
+		body = bytes.NewReader(requestBody)
+	}
+	resp, err := channel.DoRequestHelper(&openai.Adaptor{}, c, meta, body)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, util.RelayErrorHandler(resp)
+	}
+	defer resp.Body.Close()
+	var upstream relaymodel.FileObject
+	err = json.NewDecoder(resp.Body).Decode(&upstream)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError)
+	}
+	if upstream.Id == "" {
+		return nil, openai.ErrorWrapper(errors.New("upstream returned an empty file id"), "bad_response", http.StatusBadGateway)
+	}
+	return &upstream, nil
+}
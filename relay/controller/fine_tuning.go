@@ -0,0 +1,201 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/relay/channel"
+	"one-api/relay/channel/openai"
+	"one-api/relay/constant"
+	relaymodel "one-api/relay/model"
+	"one-api/relay/util"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FineTuningModelName is the pseudo model used for token model limits of the fine-tuning requests without a model
+const FineTuningModelName = "fine-tuning"
+
+// channelSupportsFineTuning reports whether jobs can be created on the channel. The training files must live on the
+// same upstream, so only the channels that host files qualify, Azure is not supported.
+func channelSupportsFineTuning(meta *util.RelayMeta) bool {
+	if meta.APIType != constant.APITypeOpenAI {
+		return false
+	}
+	switch meta.ChannelType {
+	case common.ChannelTypeAzure, common.ChannelTypeCustom, common.ChannelTypeMinimax, common.ChannelTypeDouBao:
+		return false
+	}
+	return true
+}
+
+// doFineTuningRequest sends the current fine-tuning request to the channel in the context and returns the response body.
+func doFineTuningRequest(c *gin.Context, requestBody []byte) ([]byte, *relaymodel.ErrorWithStatusCode) {
+	meta := util.GetRelayMeta(c)
+	if !channelSupportsFineTuning(meta) {
+		return nil, openai.ErrorWrapper(fmt.Errorf("channel #%d does not support fine-tuning", meta.ChannelId), "channel_not_support_fine_tuning", http.StatusBadRequest)
+	}
+	var body io.Reader = http.NoBody
+	if requestBody != nil {
+		body = bytes.NewReader(requestBody)
+	}
+	resp, err := channel.DoRequestHelper(&openai.Adaptor{}, c, meta, body)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, util.RelayErrorHandler(resp)
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+	}
+	return responseBody, nil
+}
+
+// updateFineTuningJob stores the job object returned by the upstream, and registers the
+// fine-tuned model once the job produced one.
+func updateFineTuningJob(job *model.FineTuningJob, responseBody []byte) *relaymodel.ErrorWithStatusCode {
+	var upstream relaymodel.FineTuningJob
+	err := json.Unmarshal(responseBody, &upstream)
+	if err != nil {
+		return openai.ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError)
+	}
+	if upstream.Id == "" {
+		return openai.ErrorWrapper(errors.New("upstream returned an empty job id"), "bad_response", http.StatusBadGateway)
+	}
+	newModel := upstream.FineTunedModel != nil && *upstream.FineTunedModel != "" && *upstream.FineTunedModel != job.FineTunedModel
+	job.Status = upstream.Status
+	if upstream.FineTunedModel != nil {
+		job.FineTunedModel = *upstream.FineTunedModel
+	}
+	job.Object = string(responseBody)
+	if job.Id == 0 {
+		job.JobId = upstream.Id
+		err = job.Insert()
+	} else {
+		err = job.Update()
+	}
+	if err != nil {
+		return openai.ErrorWrapper(err, "save_fine_tuning_job_failed", http.StatusInternalServerError)
+	}
+	if newModel {
+		err = job.AddFineTunedModelAbility()
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to add ability for fine-tuned model %s: %s", job.FineTunedModel, err.Error()))
+		} else {
+			common.SysLog(fmt.Sprintf("fine-tuned model %s of user %d is available on channel #%d", job.FineTunedModel, job.UserId, job.ChannelId))
+		}
+	}
+	return nil
+}
+
+// RelayFineTuningJobCreate forwards POST /v1/fine_tuning/jobs to the channel of its files and records the job owner.
+func RelayFineTuningJobCreate(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	meta := util.GetRelayMeta(c)
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return openai.ErrorWrapper(err, "read_request_body_failed", http.StatusInternalServerError)
+	}
+	responseBody, bizErr := doFineTuningRequest(c, requestBody)
+	if bizErr != nil {
+		return bizErr
+	}
+	job := &model.FineTuningJob{
+		UserId:    meta.UserId,
+		TokenId:   meta.TokenId,
+		ChannelId: meta.ChannelId,
+		Group:     meta.Group,
+		Model:     c.GetString("original_model"),
+		CreatedAt: common.GetTimestamp(),
+	}
+	bizErr = updateFineTuningJob(job, responseBody)
+	if bizErr != nil {
+		return bizErr
+	}
+	c.Data(http.StatusOK, "application/json", responseBody)
+	return nil
+}
+
+// RelayFineTuningJobList answers GET /v1/fine_tuning/jobs from the recorded jobs of the user,
+// the upstream list would contain the jobs of every user sharing the channel.
+func RelayFineTuningJobList(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	userId := c.GetInt("id")
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	afterId := 0
+	if after := c.Query("after"); after != "" {
+		job, err := model.GetUserFineTuningJob(userId, after)
+		if err != nil {
+			return openai.ErrorWrapper(fmt.Errorf("No such fine-tuning job: %s", after), "fine_tuning_job_not_found", http.StatusNotFound)
+		}
+		afterId = job.Id
+	}
+	jobs, err := model.GetUserFineTuningJobs(userId, afterId, limit+1)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_fine_tuning_jobs_failed", http.StatusInternalServerError)
+	}
+	response := relaymodel.FineTuningJobListResponse{
+		Object: "list",
+		Data:   make([]json.RawMessage, 0, len(jobs)),
+	}
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+		response.HasMore = true
+	}
+	for _, job := range jobs {
+		response.Data = append(response.Data, json.RawMessage(job.Object))
+	}
+	c.JSON(http.StatusOK, response)
+	return nil
+}
+
+// RefreshFineTuningJob fetches the job from the channel in the context and stores it.
+func RefreshFineTuningJob(c *gin.Context, job *model.FineTuningJob) *relaymodel.ErrorWithStatusCode {
+	responseBody, bizErr := doFineTuningRequest(c, nil)
+	if bizErr != nil {
+		return bizErr
+	}
+	return updateFineTuningJob(job, responseBody)
+}
+
+func RelayFineTuningJobRetrieve(c *gin.Context, job *model.FineTuningJob) *relaymodel.ErrorWithStatusCode {
+	bizErr := RefreshFineTuningJob(c, job)
+	if bizErr != nil {
+		return bizErr
+	}
+	c.Data(http.StatusOK, "application/json", []byte(job.Object))
+	return nil
+}
+
+func RelayFineTuningJobCancel(c *gin.Context, job *model.FineTuningJob) *relaymodel.ErrorWithStatusCode {
+	responseBody, bizErr := doFineTuningRequest(c, nil)
+	if bizErr != nil {
+		return bizErr
+	}
+	bizErr = updateFineTuningJob(job, responseBody)
+	if bizErr != nil {
+		return bizErr
+	}
+	c.Data(http.StatusOK, "application/json", responseBody)
+	return nil
+}
+
+// RelayFineTuningJobEvents passes the events of the job through, pagination parameters included.
+func RelayFineTuningJobEvents(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	responseBody, bizErr := doFineTuningRequest(c, nil)
+	if bizErr != nil {
+		return bizErr
+	}
+	c.Data(http.StatusOK, "application/json", responseBody)
+	return nil
+}
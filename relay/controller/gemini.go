@@ -0,0 +1,180 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/common/logger"
+	"one-api/relay/channel/gemini"
+	"one-api/relay/channel/openai"
+	"one-api/relay/constant"
+	"one-api/relay/helper"
+	"one-api/relay/model"
+	"one-api/relay/util"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getGeminiRequest parses a request of the Gemini SDKs, the model and the action come from the path.
+func getGeminiRequest(c *gin.Context) (*model.GeneralOpenAIRequest, error) {
+	modelName, action := constant.GeminiModelAction(c.Request.URL.Path)
+	var request gemini.GenerateContentRequest
+	err := common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		return nil, err
+	}
+	return gemini.GenerateContentRequest2ChatRequest(&request, modelName, action == "streamGenerateContent")
+}
+
+// RelayGeminiCountTokens answers countTokens, from the channel for Gemini channels, with the local tokenizer otherwise.
+func RelayGeminiCountTokens(c *gin.Context) *model.ErrorWithStatusCode {
+	meta := util.GetRelayMeta(c)
+	textRequest, err := getGeminiRequest(c)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_gemini_request", http.StatusBadRequest)
+	}
+	textRequest.Model, _ = util.GetMappedModelName(textRequest.Model, meta.ModelMapping)
+	if meta.APIType != constant.APITypeGemini {
+		c.JSON(http.StatusOK, gemini.CountTokensResponse{TotalTokens: openai.CountTokenChatRequest(textRequest, textRequest.Model)})
+		return nil
+	}
+	meta.ActualModelName = textRequest.Model
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return openai.ErrorWrapper(err, "read_request_body_failed", http.StatusInternalServerError)
+	}
+	adaptor := &gemini.Adaptor{}
+	adaptor.Init(meta)
+	resp, err := adaptor.DoRequest(c, meta, bytes.NewReader(requestBody))
+	if err != nil {
+		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return util.RelayErrorHandler(resp)
+	}
+	defer resp.Body.Close()
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(http.StatusOK)
+	_, err = io.Copy(c.Writer, resp.Body)
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "copy count tokens response failed: %s", err.Error())
+	}
+	return nil
+}
+
+// RelayGeminiHelper serves generateContent and streamGenerateContent, relayed as is to Gemini
+// channels and translated to chat completions for the other adaptors, billed the same way as RelayTextHelper.
+func RelayGeminiHelper(c *gin.Context) *model.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := util.GetRelayMeta(c)
+	textRequest, err := getGeminiRequest(c)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_gemini_request", http.StatusBadRequest)
+	}
+	native := meta.APIType == constant.APITypeGemini
+	sse := c.Query("alt") == "sse"
+	meta.IsClaude = false
+	meta.IsStream = textRequest.Stream
+	meta.OriginModelName = textRequest.Model
+	textRequest.Model, _ = util.GetMappedModelName(textRequest.Model, meta.ModelMapping)
+	meta.ActualModelName = textRequest.Model
+	promptTokens := openai.CountTokenChatRequest(textRequest, textRequest.Model)
+	meta.PromptTokens = promptTokens
+	ratio, modelRatio, groupRatio, preConsumedQuota := getTextQuotaRatio(meta, textRequest.Model, promptTokens)
+
+	preConsumedQuota, bizErr := preConsumeQuota(ctx, preConsumedQuota, meta)
+	if bizErr != nil {
+		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
+		return bizErr
+	}
+
+	adaptor := helper.GetAdaptor(meta.APIType)
+	if adaptor == nil {
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		return openai.ErrorWrapper(fmt.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+	if !native {
+		// the adaptors only know chat completions
+		meta.Mode = constant.RelayModeChatCompletions
+		meta.RequestURLPath = "/v1/chat/completions"
+	}
+	adaptor.Init(meta)
+	var requestBody io.Reader
+	if native {
+		body, err := common.GetRequestBody(c)
+		if err != nil {
+			util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+			return openai.ErrorWrapper(err, "read_request_body_failed", http.StatusInternalServerError)
+		}
+		requestBody = bytes.NewReader(body)
+	} else {
+		convertedRequest, err := adaptor.ConvertRequest(c, meta, textRequest)
+		if err != nil {
+			util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+			return openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
+		}
+		jsonData, err := json.Marshal(convertedRequest)
+		if err != nil {
+			util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+			return openai.ErrorWrapper(err, "json_marshal_failed", http.StatusInternalServerError)
+		}
+		logger.Debugf(ctx, "converted request: \n%s", string(jsonData))
+		requestBody = bytes.NewBuffer(jsonData)
+	}
+
+	startTime := time.Now()
+	resp, err := adaptor.DoRequest(c, meta, requestBody)
+	if err != nil {
+		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	statusCodeMappingStr := c.GetString("status_code_mapping")
+	if isErrorHappened(meta, resp) {
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		openaiErr := util.RelayErrorHandler(resp)
+		util.ResetStatusCode(openaiErr, statusCodeMappingStr)
+		return openaiErr
+	}
+
+	var aitext string
+	var usage *model.Usage
+	var respErr *model.ErrorWithStatusCode
+	if native {
+		if meta.IsStream {
+			respErr, usage, aitext = gemini.NativeStreamHandler(c, resp, sse)
+		} else {
+			respErr, usage, aitext = gemini.NativeHandler(c, resp)
+		}
+		if respErr == nil && (usage == nil || usage.TotalTokens == 0) {
+			usage = openai.ResponseText2Usage(aitext, meta.ActualModelName, meta.PromptTokens)
+		}
+	} else {
+		writer := gemini.NewNativeWriter(c.Writer, meta.OriginModelName, meta.IsStream, sse)
+		c.Writer = writer
+		aitext, usage, respErr = adaptor.DoResponse(c, resp, meta)
+		c.Writer = writer.ResponseWriter
+		if respErr == nil {
+			err = writer.Finish(usage)
+			if err != nil {
+				logger.Errorf(ctx, "convert response failed: %s", err.Error())
+				if !meta.IsStream {
+					respErr = openai.ErrorWrapper(err, "convert_response_failed", http.StatusInternalServerError)
+				}
+			}
+		}
+	}
+	if respErr != nil {
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		util.ResetStatusCode(respErr, statusCodeMappingStr)
+		return respErr
+	}
+	duration := int(time.Since(startTime).Seconds())
+
+	go postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, aitext, duration)
+	return nil
+}
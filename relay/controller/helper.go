@@ -152,6 +152,45 @@ func getPromptTokens(textRequest *relaymodel.GeneralOpenAIRequest, relayMode int
 	return 0
 }
 
+// getBatchRatio returns the discount applied to requests replayed from a batch.
+func getBatchRatio(meta *util.RelayMeta) float64 {
+	if meta.BatchId == "" {
+		return 1
+	}
+	return config.BatchRatio
+}
+
+// getTextQuotaRatio returns the ratio a text request is billed with, the model and group ratios
+// it is made of, and the quota to pre-consume for the request.
+func getTextQuotaRatio(meta *util.RelayMeta, modelName string, promptTokens int) (ratio float64, modelRatio float64, groupRatio float64, preConsumedQuota int) {
+	modelRatio = common.GetModelRatio(modelName)
+	groupRatio = common.GetGroupRatio(meta.Group)
+	batchRatio := getBatchRatio(meta)
+	ratio = modelRatio * groupRatio * batchRatio
+	preConsumedTokens := promptTokens
+	if config.PreConsumedQuota <= 0 {
+		config.PreConsumedQuota = 500
+	}
+	if preConsumedTokens <= 0 {
+		preConsumedTokens = config.PreConsumedQuota
+	}
+	BillingByRequestEnabled, _ := strconv.ParseBool(config.OptionMap["BillingByRequestEnabled"])
+	ModelRatioEnabled, _ := strconv.ParseBool(config.OptionMap["ModelRatioEnabled"])
+	pricing := common.GetPricing(modelName, meta.Group, promptTokens, meta.StartTime)
+	preConsumedQuota = int(float64(preConsumedTokens) * ratio * pricing.PromptMultiplier)
+	if BillingByRequestEnabled {
+		shouldUseModelRatio2 := !ModelRatioEnabled || (ModelRatioEnabled && meta.BillingEnabled)
+		if shouldUseModelRatio2 {
+			modelRatio2, ok := common.GetModelRatio2(meta.OriginModelName)
+			if ok {
+				ratio = modelRatio2 * groupRatio * batchRatio
+				preConsumedQuota = int(ratio * config.QuotaPerUnit)
+			}
+		}
+	}
+	return ratio, modelRatio, groupRatio, preConsumedQuota
+}
+
 func preConsumeQuota(ctx context.Context, preConsumedQuota int, meta *util.RelayMeta) (int, *relaymodel.ErrorWithStatusCode) {
 	userQuota, err := model.CacheGetUserQuota(ctx, meta.UserId)
 	if err != nil {
@@ -160,10 +199,7 @@ func preConsumeQuota(ctx context.Context, preConsumedQuota int, meta *util.Relay
 	if userQuota-preConsumedQuota < 0 {
 		return preConsumedQuota, openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusPaymentRequired)
 	}
-	err = model.CacheDecreaseUserQuota(ctx, meta.UserId, preConsumedQuota)
-	if err != nil {
-		return preConsumedQuota, openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
-	}
+	decreasedQuota := preConsumedQuota
 	if userQuota > 100*preConsumedQuota {
 		// in this case, we do not pre-consume quota
 		// because the user has enough quota
@@ -172,14 +208,95 @@ func preConsumeQuota(ctx context.Context, preConsumedQuota int, meta *util.Relay
 	}
 	if preConsumedQuota > 0 {
 		logger.Info(ctx, fmt.Sprintf("用户%d 额度 %d，预扣费 %d", meta.UserId, userQuota, preConsumedQuota))
-		err := model.PreConsumeTokenQuota(meta.TokenId, preConsumedQuota)
-		if err != nil {
-			return preConsumedQuota, openai.ErrorWrapper(err, "pre_consume_token_quota_failed", http.StatusForbidden)
+	}
+	// 检查令牌的周期预算后才扣减缓存中的用户额度
+	err = reserveQuota(ctx, meta, preConsumedQuota, decreasedQuota)
+	if err != nil {
+		return preConsumedQuota, preConsumeTokenQuotaError(err)
+	}
+	err = model.CacheDecreaseUserQuota(ctx, meta.UserId, decreasedQuota)
+	if err != nil {
+		if preConsumedQuota > 0 || meta.BudgetReserved > 0 {
+			if err := model.PostConsumeTokenQuotaWithBudget(ctx, meta.TokenId, -preConsumedQuota, meta.BudgetReserved); err != nil {
+				logger.Error(ctx, "error return pre-consumed quota: "+err.Error())
+			}
 		}
+		return preConsumedQuota, openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
 	}
 	return preConsumedQuota, nil
 }
 
+// reserveQuota pre-consumes preConsumedQuota from the token, which also counts it against the token budgets.
+// When the quota isn't pre-consumed, the estimate is still reserved against the budgets and recorded in
+// meta.BudgetReserved, so concurrent requests can't together go over a budget; it's settled with the actual quota.
+func reserveQuota(ctx context.Context, meta *util.RelayMeta, preConsumedQuota int, estimatedQuota int) error {
+	if preConsumedQuota > 0 {
+		return model.PreConsumeTokenQuota(ctx, meta.TokenId, preConsumedQuota)
+	}
+	if !meta.BudgetEnabled {
+		return nil
+	}
+	err := model.ReserveTokenBudget(meta.TokenId, estimatedQuota)
+	if err != nil {
+		return err
+	}
+	meta.BudgetReserved = estimatedQuota
+	return nil
+}
+
+// preConsumeTokenQuotaError reports a token over its budget the way OpenAI reports insufficient quota.
+func preConsumeTokenQuotaError(err error) *relaymodel.ErrorWithStatusCode {
+	var budgetErr *model.TokenBudgetError
+	if errors.As(err, &budgetErr) {
+		bizErr := openai.ErrorWrapper(err, "insufficient_quota", http.StatusTooManyRequests)
+		bizErr.Type = "insufficient_quota"
+		bizErr.LocalError = true
+		return bizErr
+	}
+	return openai.ErrorWrapper(err, "pre_consume_token_quota_failed", http.StatusForbidden)
+}
+
+// weighUsageTokens counts the prompt and the completion of the usage in prompt tokens, the cached, cache creation,
+// reasoning and audio tokens are weighed with their own ratios, the rest with the completion ratio or as they are.
+func weighUsageTokens(usage *relaymodel.Usage, modelName string, modelRatio float64, completionRatio float64) (float64, float64, model.TokenDetails, string) {
+	details := model.TokenDetails{
+		CachedTokens:          usage.CachedTokens(),
+		CacheCreationTokens:   usage.CacheCreationTokens(),
+		ReasoningTokens:       usage.ReasoningTokens(),
+		AudioPromptTokens:     usage.PromptAudioTokens(),
+		AudioCompletionTokens: usage.CompletionAudioTokens(),
+	}
+	textPromptTokens := max(usage.PromptTokens-details.CachedTokens-details.CacheCreationTokens-details.AudioPromptTokens, 0)
+	textCompletionTokens := max(usage.CompletionTokens-details.ReasoningTokens-details.AudioCompletionTokens, 0)
+	prompt := float64(textPromptTokens)
+	completion := float64(textCompletionTokens) * completionRatio
+	ratioString := ""
+	if details.CachedTokens > 0 {
+		cacheRatio := common.GetCacheRatio(modelName)
+		prompt += float64(details.CachedTokens) * cacheRatio
+		ratioString += fmt.Sprintf("，缓存读取倍率 %.2f", cacheRatio)
+	}
+	if details.CacheCreationTokens > 0 {
+		cacheCreationRatio := common.GetCacheCreationRatio(modelName)
+		prompt += float64(details.CacheCreationTokens) * cacheCreationRatio
+		ratioString += fmt.Sprintf("，缓存写入倍率 %.2f", cacheCreationRatio)
+	}
+	if details.ReasoningTokens > 0 {
+		reasoningRatio := common.GetReasoningRatio(modelName)
+		completion += float64(details.ReasoningTokens) * completionRatio * reasoningRatio
+		ratioString += fmt.Sprintf("，推理倍率 %.2f", reasoningRatio)
+	}
+	if (details.AudioPromptTokens > 0 || details.AudioCompletionTokens > 0) && modelRatio != 0 {
+		// 音频倍率与模型倍率同单位，换算成文本输入 token
+		audioRatio := common.GetAudioRatio(modelName)
+		audioCompletionRatio := common.GetAudioCompletionRatio(modelName)
+		prompt += float64(details.AudioPromptTokens) * audioRatio / modelRatio
+		completion += float64(details.AudioCompletionTokens) * audioCompletionRatio * audioRatio / modelRatio
+		ratioString += fmt.Sprintf("，音频倍率 %.2f，音频补全倍率 %.2f", audioRatio, audioCompletionRatio)
+	}
+	return prompt, completion, details, ratioString
+}
+
 func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *util.RelayMeta, textRequest *relaymodel.GeneralOpenAIRequest, ratio float64, preConsumedQuota int, modelRatio float64, groupRatio float64, aitext string, duration int) {
 	if usage == nil {
 		logger.Error(ctx, "usage is nil, which is unexpected")
@@ -205,21 +322,26 @@ func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *util.R
 	completionRatio := common.GetCompletionRatio(textRequest.Model)
 	promptTokens := usage.PromptTokens
 	completionTokens := usage.CompletionTokens
-	quota = promptTokens + int(float64(completionTokens)*completionRatio)
+	weightedPrompt, weightedCompletion, tokenDetails, detailRatioString := weighUsageTokens(usage, textRequest.Model, modelRatio, completionRatio)
+	pricing := common.GetPricing(textRequest.Model, meta.Group, promptTokens, meta.StartTime)
+	quota = int(weightedPrompt*pricing.PromptMultiplier + weightedCompletion*pricing.CompletionMultiplier)
 
-	modelRatioString = fmt.Sprintf("模型倍率 %.2f，补全倍率%.2f", modelRatio, completionRatio)
+	modelRatioString = fmt.Sprintf("模型倍率 %.2f，补全倍率%.2f", modelRatio, completionRatio) + detailRatioString + pricing.Description
 	quota = int(float64(quota) * ratio)
 	if BillingByRequestEnabled {
 		shouldUseModelRatio2 := !ModelRatioEnabled || (ModelRatioEnabled && meta.BillingEnabled)
 		if shouldUseModelRatio2 {
 			modelRatio2, ok := common.GetModelRatio2(meta.OriginModelName)
 			if ok {
-				ratio = modelRatio2 * groupRatio
+				ratio = modelRatio2 * groupRatio * getBatchRatio(meta)
 				quota = int(ratio * config.QuotaPerUnit)
 				modelRatioString = "按次计费"
 			}
 		}
 	}
+	if meta.CacheHit {
+		quota = int(float64(quota) * config.ResponseCacheHitRatio)
+	}
 	if ratio != 0 && quota <= 0 {
 		quota = 1
 	}
@@ -244,12 +366,22 @@ func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *util.R
 	logger.Info(ctx, fmt.Sprintf("用户%d 扣费%d，预扣费 %d 实际扣费 %d。", meta.UserId, quotaDelta, preConsumedQuota, quota))
 
 	multiplier := fmt.Sprintf("%s，分组倍率 %.2f", modelRatioString, groupRatio)
+	if meta.BatchId != "" {
+		multiplier += fmt.Sprintf("，批处理倍率 %.2f", getBatchRatio(meta))
+	}
+	if meta.CacheHit {
+		multiplier += fmt.Sprintf("，缓存命中倍率 %.2f", config.ResponseCacheHitRatio)
+	}
+	// 按模型规则计价时注明所用的规则
+	if pattern := common.GetModelRatioPattern(textRequest.Model); pattern != "" {
+		multiplier += fmt.Sprintf("，按 %s 计价", pattern)
+	}
 	LogContentEnabled, _ := strconv.ParseBool(config.OptionMap["LogContentEnabled"])
 	logContent := ""
 	if LogContentEnabled {
 		logContent = fmt.Sprintf("用户: %s \nAI: %s", usertext, aitext)
 	}
-	err = model.PostConsumeTokenQuota(meta.TokenId, quotaDelta)
+	err = model.PostConsumeTokenQuotaWithBudget(ctx, meta.TokenId, quotaDelta, meta.BudgetReserved)
 	if err != nil {
 		logger.Error(ctx, "error consuming token remain quota: "+err.Error())
 	}
@@ -261,12 +393,9 @@ func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *util.R
 	//if err != nil {
 	//	logger.Error(ctx, "error update user quota cache: "+err.Error())
 	//}
-	logModel := textRequest.Model
-	if strings.HasPrefix(logModel, "gpt-4-gizmo") {
-		logModel = "gpt-4-gizmo-*"
-		logContent += fmt.Sprintf("，模型 %s", textRequest.Model)
-	}
+	model.RecordChannelTokens(meta.ChannelId, meta.OriginModelName, promptTokens+completionTokens, meta.LimitLease)
 	if quota != 0 {
+		ctx = context.WithValue(ctx, common.TokenDetailsKey, tokenDetails)
 		model.RecordConsumeLog(ctx, meta.UserId, meta.ChannelId, meta.ChannelName, promptTokens, completionTokens, textRequest.Model, meta.TokenName, quota, logContent, meta.TokenId, multiplier, userQuota, int(duration), meta.IsStream, meta.AttemptsLog, meta.RelayIp)
 		model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
 		model.UpdateChannelUsedQuota(meta.ChannelId, quota)
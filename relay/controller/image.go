@@ -113,8 +113,9 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	}
 	BillingByRequestEnabled, _ := strconv.ParseBool(config.OptionMap["BillingByRequestEnabled"])
 	ModelRatioEnabled, _ := strconv.ParseBool(config.OptionMap["ModelRatioEnabled"])
-	quota = int(ratio*sizeRatio*imageCostRatio*1000) * imageRequest.N
-	modelRatioString = fmt.Sprintf("模型倍率 %.2f", modelRatio)
+	pricing := common.GetPricing(imageRequest.Model, meta.Group, 0, meta.StartTime)
+	quota = int(ratio*sizeRatio*imageCostRatio*pricing.PromptMultiplier*1000) * imageRequest.N
+	modelRatioString = fmt.Sprintf("模型倍率 %.2f", modelRatio) + pricing.Description
 
 	if BillingByRequestEnabled {
 		shouldUseModelRatio2 := !ModelRatioEnabled || (ModelRatioEnabled && token.BillingEnabled)
@@ -131,6 +132,13 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	if userQuota-quota < 0 {
 		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusPaymentRequired)
 	}
+	if meta.BudgetEnabled {
+		// 图片请求结束后才扣费，请求前只检查令牌的周期预算
+		err = model.CheckTokenBudget(meta.TokenId, quota)
+		if err != nil {
+			return preConsumeTokenQuotaError(err)
+		}
+	}
 
 	// do request
 	resp, err := adaptor.DoRequest(c, meta, requestBody)
@@ -144,7 +152,7 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 		if resp != nil && resp.StatusCode != http.StatusOK {
 			return
 		}
-		err := model.PostConsumeTokenQuota(meta.TokenId, quota)
+		err := model.PostConsumeTokenQuota(ctx, meta.TokenId, quota)
 		if err != nil {
 			common.SysError("error consuming token remain quota: " + err.Error())
 		}
@@ -0,0 +1,282 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/common/client"
+	"one-api/common/logger"
+	"one-api/model"
+	"one-api/relay/channel/openai"
+	relaymodel "one-api/relay/model"
+	"one-api/relay/util"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+var realtimeUpgrader = websocket.Upgrader{
+	// 浏览器客户端通过 Sec-WebSocket-Protocol 传递密钥，来源由令牌鉴权把关
+	CheckOrigin:  func(r *http.Request) bool { return true },
+	Subprotocols: []string{"realtime"},
+}
+
+// realtimeSession relays one Realtime API session, every response.done is billed as it arrives.
+type realtimeSession struct {
+	ctx      context.Context
+	meta     *util.RelayMeta
+	client   *websocket.Conn
+	upstream *websocket.Conn
+	// client writes come from both directions of the relay
+	clientLock sync.Mutex
+	closeOnce  sync.Once
+
+	modelRatio           float64
+	completionRatio      float64
+	audioRatio           float64
+	audioCompletionRatio float64
+	groupRatio           float64
+	responseStartTime    time.Time
+}
+
+// RelayRealtimeHelper proxies /v1/realtime to an OpenAI or Azure channel, errors are only
+// returned before the websocket upgrade, later failures end the session with an error event.
+func RelayRealtimeHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := util.GetRelayMeta(c)
+	if meta.ChannelType != common.ChannelTypeOpenAI && meta.ChannelType != common.ChannelTypeAzure {
+		return openai.ErrorWrapper(errors.New("realtime api is only supported by openai and azure channels"), "invalid_api_type", http.StatusBadRequest)
+	}
+	meta.IsStream = true
+	meta.OriginModelName = c.Query("model")
+	meta.ActualModelName, _ = util.GetMappedModelName(meta.OriginModelName, meta.ModelMapping)
+
+	session := &realtimeSession{
+		ctx:                  ctx,
+		meta:                 meta,
+		modelRatio:           common.GetModelRatio(meta.ActualModelName),
+		completionRatio:      common.GetCompletionRatio(meta.ActualModelName),
+		audioRatio:           common.GetAudioRatio(meta.ActualModelName),
+		audioCompletionRatio: common.GetAudioCompletionRatio(meta.ActualModelName),
+		groupRatio:           common.GetGroupRatio(meta.Group),
+		responseStartTime:    time.Now(),
+	}
+	if meta.BudgetEnabled {
+		// 会话中的每个响应结束后才扣费，开始前只检查令牌的周期预算
+		if err := model.CheckTokenBudget(meta.TokenId, 0); err != nil {
+			return preConsumeTokenQuotaError(err)
+		}
+	}
+	if session.quotaExhausted() {
+		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusPaymentRequired)
+	}
+
+	dialer, err := client.GetProxiedWebsocketDialer(meta.ProxyURL)
+	if err != nil {
+		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	upstream, resp, err := dialer.DialContext(ctx, openai.GetRealtimeURL(meta), openai.GetRealtimeHeader(meta))
+	if err != nil {
+		logger.Errorf(ctx, "dial realtime upstream failed: %s", err.Error())
+		if resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+			openaiErr := util.RelayErrorHandler(resp)
+			util.ResetStatusCode(openaiErr, c.GetString("status_code_mapping"))
+			return openaiErr
+		}
+		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	session.upstream = upstream
+
+	clientConn, err := realtimeUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader has answered the client already
+		logger.Errorf(ctx, "upgrade realtime connection failed: %s", err.Error())
+		_ = upstream.Close()
+		return nil
+	}
+	session.client = clientConn
+	session.relay()
+	return nil
+}
+
+func (s *realtimeSession) relay() {
+	done := make(chan struct{}, 2)
+	go func() {
+		s.relayUpstream()
+		done <- struct{}{}
+	}()
+	go func() {
+		s.relayClient()
+		done <- struct{}{}
+	}()
+	<-done
+	s.close(websocket.CloseNormalClosure, "")
+	<-done
+}
+
+// relayClient forwards the client events, a new response is refused once the quota has run out.
+func (s *realtimeSession) relayClient() {
+	for {
+		messageType, message, err := s.client.ReadMessage()
+		if err != nil {
+			return
+		}
+		if messageType == websocket.TextMessage {
+			var event relaymodel.RealtimeEvent
+			if json.Unmarshal(message, &event) == nil && event.Type == "response.create" && s.quotaExhausted() {
+				s.closeForQuota()
+				return
+			}
+		}
+		err = s.upstream.WriteMessage(messageType, message)
+		if err != nil {
+			logger.Errorf(s.ctx, "write realtime upstream failed: %s", err.Error())
+			return
+		}
+	}
+}
+
+// relayUpstream forwards the server events and bills the usage of every finished response.
+func (s *realtimeSession) relayUpstream() {
+	for {
+		messageType, message, err := s.upstream.ReadMessage()
+		if err != nil {
+			return
+		}
+		var event relaymodel.RealtimeEvent
+		if messageType == websocket.TextMessage {
+			_ = json.Unmarshal(message, &event)
+		}
+		if event.Type == "response.created" {
+			s.responseStartTime = time.Now()
+		}
+		err = s.writeClient(messageType, message)
+		if err != nil {
+			return
+		}
+		if event.Type == "response.done" && event.Response != nil && event.Response.Usage != nil {
+			s.consume(event.Response.Usage)
+			if s.quotaExhausted() {
+				s.closeForQuota()
+				return
+			}
+		}
+	}
+}
+
+func (s *realtimeSession) writeClient(messageType int, message []byte) error {
+	s.clientLock.Lock()
+	defer s.clientLock.Unlock()
+	return s.client.WriteMessage(messageType, message)
+}
+
+// quotaExhausted reports whether the user, the token or one of the token budgets has run out.
+func (s *realtimeSession) quotaExhausted() bool {
+	userQuota, err := model.CacheGetUserQuota(s.ctx, s.meta.UserId)
+	if err != nil {
+		logger.Error(s.ctx, "get_user_quota_failed"+err.Error())
+		return false
+	}
+	if userQuota <= 0 {
+		return true
+	}
+	if s.meta.BudgetEnabled {
+		var budgetErr *model.TokenBudgetError
+		if err := model.CheckTokenBudget(s.meta.TokenId, 0); errors.As(err, &budgetErr) {
+			return true
+		}
+	}
+	if s.meta.UnlimitedQuota {
+		return false
+	}
+	token, err := model.GetTokenById(s.meta.TokenId)
+	if err != nil {
+		logger.Error(s.ctx, "get_token_failed"+err.Error())
+		return false
+	}
+	return token.RemainQuota <= 0
+}
+
+// closeForQuota tells the client why the session ends before closing it.
+func (s *realtimeSession) closeForQuota() {
+	event := relaymodel.RealtimeEvent{
+		Type: "error",
+		Error: &relaymodel.Error{
+			Message: "额度已用尽，实时会话已关闭",
+			Type:    "insufficient_quota",
+			Code:    "insufficient_quota",
+		},
+	}
+	message, _ := json.Marshal(event)
+	_ = s.writeClient(websocket.TextMessage, message)
+	s.close(websocket.CloseNormalClosure, "insufficient quota")
+}
+
+func (s *realtimeSession) close(code int, text string) {
+	s.closeOnce.Do(func() {
+		deadline := time.Now().Add(time.Second)
+		closeMessage := websocket.FormatCloseMessage(code, text)
+		_ = s.client.WriteControl(websocket.CloseMessage, closeMessage, deadline)
+		_ = s.upstream.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), deadline)
+		_ = s.client.Close()
+		_ = s.upstream.Close()
+	})
+}
+
+func (s *realtimeSession) consume(usage *relaymodel.RealtimeUsage) {
+	meta := s.meta
+	textInput := usage.InputTokenDetails.TextTokens
+	audioInput := usage.InputTokenDetails.AudioTokens
+	textOutput := usage.OutputTokenDetails.TextTokens
+	audioOutput := usage.OutputTokenDetails.AudioTokens
+	// 会话可能持续较久，按每次响应结束的时间计价
+	pricing := common.GetPricing(meta.ActualModelName, meta.Group, usage.InputTokens, time.Now())
+	quota := int(((float64(textInput)*s.modelRatio+float64(audioInput)*s.audioRatio)*pricing.PromptMultiplier +
+		(float64(textOutput)*s.modelRatio*s.completionRatio+float64(audioOutput)*s.audioRatio*s.audioCompletionRatio)*pricing.CompletionMultiplier) * s.groupRatio)
+	if s.modelRatio != 0 && usage.TotalTokens > 0 && quota <= 0 {
+		quota = 1
+	}
+	model.RecordChannelTokens(meta.ChannelId, meta.OriginModelName, usage.TotalTokens, meta.LimitLease)
+	if quota == 0 {
+		return
+	}
+	userQuota, err := model.CacheGetUserQuota(s.ctx, meta.UserId)
+	if err != nil {
+		logger.Error(s.ctx, "get_user_quota_failed"+err.Error())
+	}
+	// 用量在响应结束后才知道，超出剩余额度时扣完剩余额度，随后会话因额度耗尽而关闭
+	remainQuota := userQuota
+	if !meta.UnlimitedQuota {
+		token, err := model.GetTokenById(meta.TokenId)
+		if err == nil && token.RemainQuota < remainQuota {
+			remainQuota = token.RemainQuota
+		}
+	}
+	if err == nil && quota > remainQuota {
+		logger.Warnf(s.ctx, "realtime response costs %d, only %d quota left", quota, remainQuota)
+		quota = max(remainQuota, 0)
+	}
+	if quota == 0 {
+		return
+	}
+	err = model.PostConsumeTokenQuota(s.ctx, meta.TokenId, quota)
+	if err != nil {
+		logger.Error(s.ctx, "error consuming token remain quota: "+err.Error())
+	}
+	err = model.CacheDecreaseUserQuota(s.ctx, meta.UserId, quota)
+	if err != nil {
+		logger.Error(s.ctx, "decrease_user_quota_failed"+err.Error())
+	}
+	multiplier := fmt.Sprintf("文本输入 %d，文本输出 %d，音频输入 %d，音频输出 %d；模型倍率 %.2f，补全倍率 %.2f，音频倍率 %.2f，音频补全倍率 %.2f，分组倍率 %.2f",
+		textInput, textOutput, audioInput, audioOutput, s.modelRatio, s.completionRatio, s.audioRatio, s.audioCompletionRatio, s.groupRatio) + pricing.Description
+	useTimeSeconds := int(time.Since(s.responseStartTime).Seconds())
+	ctx := context.WithValue(s.ctx, common.TokenDetailsKey, model.TokenDetails{AudioPromptTokens: audioInput, AudioCompletionTokens: audioOutput})
+	model.RecordConsumeLog(ctx, meta.UserId, meta.ChannelId, meta.ChannelName, usage.InputTokens, usage.OutputTokens, meta.ActualModelName, meta.TokenName, quota, " ", meta.TokenId, multiplier, userQuota, useTimeSeconds, true, meta.AttemptsLog, meta.RelayIp)
+	model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
+	model.UpdateChannelUsedQuota(meta.ChannelId, quota)
+}
@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/common/config"
+	"one-api/common/logger"
+	"one-api/model"
+	"one-api/relay/channel"
+	"one-api/relay/channel/openai"
+	"one-api/relay/helper"
+	relaymodel "one-api/relay/model"
+	"one-api/relay/util"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// documentsPerSearchUnit follows Cohere, a search unit is a query with up to 100 documents
+const documentsPerSearchUnit = 100
+
+func getRerankTokens(request *relaymodel.RerankRequest) int {
+	text := request.Query
+	for _, document := range request.Documents {
+		switch document := document.(type) {
+		case string:
+			text += document
+		case map[string]any:
+			text += common.AsString(document["text"])
+		}
+	}
+	return openai.CountTokenText(text, request.Model)
+}
+
+func getRerankSearchUnits(request *relaymodel.RerankRequest) int {
+	return (len(request.Documents) + documentsPerSearchUnit - 1) / documentsPerSearchUnit
+}
+
+// getRerankQuota bills rerank models listed in RerankRatio per search unit, the others per token with ModelRatio.
+func getRerankQuota(modelName string, groupRatio float64, searchUnits int, tokens int) (int, string) {
+	if price, ok := common.GetRerankRatio(modelName); ok {
+		quota := int(price * float64(searchUnits) * groupRatio * config.QuotaPerUnit)
+		if price != 0 && quota <= 0 {
+			quota = 1
+		}
+		return quota, fmt.Sprintf("按搜索单元计费 %d 单元，单价 %.4f，分组倍率 %.2f", searchUnits, price, groupRatio)
+	}
+	modelRatio := common.GetModelRatio(modelName)
+	quota := int(float64(tokens) * modelRatio * groupRatio)
+	if modelRatio != 0 && quota <= 0 {
+		quota = 1
+	}
+	return quota, fmt.Sprintf("模型倍率 %.2f，分组倍率 %.2f", modelRatio, groupRatio)
+}
+
+// RelayRerankHelper serves /v1/rerank on the adaptors implementing channel.RerankAdaptor.
+func RelayRerankHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := util.GetRelayMeta(c)
+	var request relaymodel.RerankRequest
+	err := common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_rerank_request", http.StatusBadRequest)
+	}
+	if request.Model == "" || request.Query == "" || len(request.Documents) == 0 {
+		return openai.ErrorWrapper(errors.New("model, query and documents are required"), "invalid_rerank_request", http.StatusBadRequest)
+	}
+	meta.OriginModelName = request.Model
+	request.Model, _ = util.GetMappedModelName(request.Model, meta.ModelMapping)
+	meta.ActualModelName = request.Model
+
+	adaptor, ok := helper.GetAdaptor(meta.APIType).(channel.RerankAdaptor)
+	if !ok {
+		return openai.ErrorWrapper(fmt.Errorf("channel #%d does not support rerank", meta.ChannelId), "channel_not_support_rerank", http.StatusBadRequest)
+	}
+	adaptor.Init(meta)
+
+	groupRatio := common.GetGroupRatio(meta.Group)
+	promptTokens := getRerankTokens(&request)
+	meta.PromptTokens = promptTokens
+	preConsumedQuota, _ := getRerankQuota(request.Model, groupRatio, getRerankSearchUnits(&request), promptTokens)
+	preConsumedQuota, bizErr := preConsumeQuota(ctx, preConsumedQuota, meta)
+	if bizErr != nil {
+		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
+		return bizErr
+	}
+
+	convertedRequest, err := adaptor.ConvertRerankRequest(&request)
+	if err != nil {
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		return openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
+	}
+	jsonData, err := json.Marshal(convertedRequest)
+	if err != nil {
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		return openai.ErrorWrapper(err, "json_marshal_failed", http.StatusInternalServerError)
+	}
+	startTime := time.Now()
+	resp, err := adaptor.DoRequest(c, meta, bytes.NewBuffer(jsonData))
+	if err != nil {
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	statusCodeMappingStr := c.GetString("status_code_mapping")
+	if isErrorHappened(meta, resp) {
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		openaiErr := util.RelayErrorHandler(resp)
+		util.ResetStatusCode(openaiErr, statusCodeMappingStr)
+		return openaiErr
+	}
+	rerankResponse, respErr := adaptor.DoRerankResponse(c, resp, meta)
+	if respErr != nil {
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		util.ResetStatusCode(respErr, statusCodeMappingStr)
+		return respErr
+	}
+
+	searchUnits := getRerankSearchUnits(&request)
+	if rerankResponse.Meta != nil && rerankResponse.Meta.BilledUnits != nil && rerankResponse.Meta.BilledUnits.SearchUnits > 0 {
+		searchUnits = rerankResponse.Meta.BilledUnits.SearchUnits
+	}
+	if rerankResponse.Usage != nil && rerankResponse.Usage.TotalTokens > 0 {
+		promptTokens = rerankResponse.Usage.TotalTokens
+	}
+	quota, multiplier := getRerankQuota(request.Model, groupRatio, searchUnits, promptTokens)
+	go postConsumeRerankQuota(ctx, meta, quota, preConsumedQuota, promptTokens, multiplier, startTime)
+	return nil
+}
+
+func postConsumeRerankQuota(ctx context.Context, meta *util.RelayMeta, quota int, preConsumedQuota int, promptTokens int, multiplier string, startTime time.Time) {
+	userQuota, err := model.CacheGetUserQuota(ctx, meta.UserId)
+	if err != nil {
+		logger.Error(ctx, "get_user_quota_failed"+err.Error())
+	}
+	quotaDelta := quota - preConsumedQuota
+	err = model.PostConsumeTokenQuotaWithBudget(ctx, meta.TokenId, quotaDelta, meta.BudgetReserved)
+	if err != nil {
+		logger.Error(ctx, "error consuming token remain quota: "+err.Error())
+	}
+	err = model.CacheDecreaseUserQuota(ctx, meta.UserId, quotaDelta)
+	if err != nil {
+		logger.Error(ctx, "decrease_user_quota_failed"+err.Error())
+	}
+	if quota != 0 {
+		useTimeSeconds := time.Now().Unix() - startTime.Unix()
+		model.RecordConsumeLog(ctx, meta.UserId, meta.ChannelId, meta.ChannelName, promptTokens, 0, meta.ActualModelName, meta.TokenName, quota, " ", meta.TokenId, multiplier, userQuota, int(useTimeSeconds), false, meta.AttemptsLog, meta.RelayIp)
+		model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
+		model.UpdateChannelUsedQuota(meta.ChannelId, quota)
+	}
+}
@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/common/logger"
+	"one-api/relay/channel/openai"
+	"one-api/relay/constant"
+	"one-api/relay/helper"
+	"one-api/relay/model"
+	"one-api/relay/util"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isNativeResponsesChannel reports whether the channel serves /v1/responses itself,
+// other channels get the request translated to chat completions.
+func isNativeResponsesChannel(meta *util.RelayMeta) bool {
+	return meta.ChannelType == common.ChannelTypeOpenAI
+}
+
+// getResponsesRequestBody returns the body sent to a native channel, with the model mapped.
+func getResponsesRequestBody(c *gin.Context, meta *util.RelayMeta) (io.Reader, error) {
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return nil, err
+	}
+	if meta.OriginModelName == meta.ActualModelName {
+		return bytes.NewReader(requestBody), nil
+	}
+	var body map[string]json.RawMessage
+	err = json.Unmarshal(requestBody, &body)
+	if err != nil {
+		return nil, err
+	}
+	body["model"], _ = json.Marshal(meta.ActualModelName)
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(jsonData), nil
+}
+
+// RelayResponsesHelper serves /v1/responses, billed the same way as RelayTextHelper.
+func RelayResponsesHelper(c *gin.Context) *model.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := util.GetRelayMeta(c)
+	var request model.ResponsesRequest
+	err := common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_responses_request", http.StatusBadRequest)
+	}
+	if request.Model == "" {
+		return openai.ErrorWrapper(errors.New("model is required"), "invalid_responses_request", http.StatusBadRequest)
+	}
+	native := isNativeResponsesChannel(meta)
+	textRequest, err := openai.ResponsesRequest2ChatRequest(&request)
+	if err != nil {
+		if !native {
+			return openai.ErrorWrapper(err, "invalid_responses_request", http.StatusBadRequest)
+		}
+		// native channels understand the request as is, it is only used for billing here
+		textRequest = &model.GeneralOpenAIRequest{Model: request.Model, Stream: request.Stream}
+	}
+	meta.IsClaude = false
+	meta.IsStream = request.Stream
+	meta.OriginModelName = request.Model
+	textRequest.Model, _ = util.GetMappedModelName(request.Model, meta.ModelMapping)
+	meta.ActualModelName = textRequest.Model
+	promptTokens := openai.CountTokenChatRequest(textRequest, textRequest.Model)
+	meta.PromptTokens = promptTokens
+	ratio, modelRatio, groupRatio, preConsumedQuota := getTextQuotaRatio(meta, textRequest.Model, promptTokens)
+
+	preConsumedQuota, bizErr := preConsumeQuota(ctx, preConsumedQuota, meta)
+	if bizErr != nil {
+		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
+		return bizErr
+	}
+
+	adaptor := helper.GetAdaptor(meta.APIType)
+	if adaptor == nil {
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		return openai.ErrorWrapper(fmt.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+	if !native {
+		// the adaptors only know chat completions
+		meta.Mode = constant.RelayModeChatCompletions
+		meta.RequestURLPath = "/v1/chat/completions"
+	}
+	adaptor.Init(meta)
+	var requestBody io.Reader
+	if native {
+		requestBody, err = getResponsesRequestBody(c, meta)
+		if err != nil {
+			util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+			return openai.ErrorWrapper(err, "read_request_body_failed", http.StatusInternalServerError)
+		}
+	} else {
+		convertedRequest, err := adaptor.ConvertRequest(c, meta, textRequest)
+		if err != nil {
+			util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+			return openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
+		}
+		jsonData, err := json.Marshal(convertedRequest)
+		if err != nil {
+			util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+			return openai.ErrorWrapper(err, "json_marshal_failed", http.StatusInternalServerError)
+		}
+		logger.Debugf(ctx, "converted request: \n%s", string(jsonData))
+		requestBody = bytes.NewBuffer(jsonData)
+	}
+
+	startTime := time.Now()
+	resp, err := adaptor.DoRequest(c, meta, requestBody)
+	if err != nil {
+		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	statusCodeMappingStr := c.GetString("status_code_mapping")
+	if isErrorHappened(meta, resp) {
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		openaiErr := util.RelayErrorHandler(resp)
+		util.ResetStatusCode(openaiErr, statusCodeMappingStr)
+		return openaiErr
+	}
+
+	var aitext string
+	var usage *model.Usage
+	var respErr *model.ErrorWithStatusCode
+	if native {
+		if meta.IsStream {
+			respErr, usage, aitext = openai.ResponsesStreamHandler(c, resp)
+		} else {
+			respErr, usage, aitext = openai.ResponsesHandler(c, resp)
+		}
+		if respErr == nil && (usage == nil || usage.TotalTokens == 0) {
+			usage = openai.ResponseText2Usage(aitext, meta.ActualModelName, meta.PromptTokens)
+		}
+	} else {
+		writer := openai.NewResponsesWriter(c.Writer, &request)
+		c.Writer = writer
+		aitext, usage, respErr = adaptor.DoResponse(c, resp, meta)
+		c.Writer = writer.ResponseWriter
+		if respErr == nil {
+			err = writer.Finish(usage)
+			if err != nil {
+				logger.Errorf(ctx, "convert response failed: %s", err.Error())
+				if !meta.IsStream {
+					respErr = openai.ErrorWrapper(err, "convert_response_failed", http.StatusInternalServerError)
+				}
+			}
+		}
+	}
+	if respErr != nil {
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		util.ResetStatusCode(respErr, statusCodeMappingStr)
+		return respErr
+	}
+	duration := int(time.Since(startTime).Seconds())
+
+	go postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, aitext, duration)
+	return nil
+}
@@ -0,0 +1,237 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"one-api/common"
+	"one-api/common/config"
+	"one-api/relay/constant"
+	"one-api/relay/model"
+	"one-api/relay/util"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamFailoverWriter sits between the stream handlers and the client for chat completion streams.
+// It holds back the first events so a failing upstream can be replaced before the client sees anything,
+// and remembers what was sent so another channel can continue an interrupted response.
+// The [DONE] event is only sent once the relay knows the stream really finished.
+type streamFailoverWriter struct {
+	gin.ResponseWriter
+	bufferEvents int
+	pending      []byte   // 尚未组成完整事件的数据
+	buffered     [][]byte // 提交前暂存的事件
+	committed    bool     // 是否已开始向客户端发送
+	continuing   bool     // 当前尝试是否在接续中断的输出
+
+	// 已发送给客户端的内容
+	id        string
+	model     string
+	text      strings.Builder
+	toolCalls bool
+}
+
+type streamFailoverChunk struct {
+	Id      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Content   any             `json:"content"`
+			ToolCalls json.RawMessage `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+// getStreamFailoverWriter returns the failover writer of the request, creating it on the first attempt,
+// nil when mid-stream failover doesn't apply.
+func getStreamFailoverWriter(c *gin.Context, meta *util.RelayMeta) *streamFailoverWriter {
+	if !config.StreamFailoverEnabled || !meta.IsStream || meta.Mode != constant.RelayModeChatCompletions {
+		return nil
+	}
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return nil
+	}
+	if value, ok := c.Get("stream_failover"); ok {
+		return value.(*streamFailoverWriter)
+	}
+	bufferEvents := config.StreamFailoverBufferEvents
+	if bufferEvents <= 0 {
+		bufferEvents = 1
+	}
+	writer := &streamFailoverWriter{bufferEvents: bufferEvents}
+	c.Set("stream_failover", writer)
+	return writer
+}
+
+// ForkStreamFailover gives a hedged attempt its own copy of the failover writer of the request, the attempts
+// run concurrently and must not write to the same one. The copy of the attempt that answered is taken over
+// by the request afterwards.
+func ForkStreamFailover(c *gin.Context, attempt *gin.Context) {
+	value, ok := c.Get("stream_failover")
+	if !ok {
+		return
+	}
+	w, ok := value.(*streamFailoverWriter)
+	if !ok || w == nil {
+		return
+	}
+	fork := &streamFailoverWriter{
+		bufferEvents: w.bufferEvents,
+		committed:    w.committed,
+		continuing:   w.continuing,
+		id:           w.id,
+		model:        w.model,
+		toolCalls:    w.toolCalls,
+	}
+	fork.text.WriteString(w.text.String())
+	attempt.Set("stream_failover", fork)
+}
+
+// continueRequest appends the output the client already has as an assistant message,
+// so the next channel picks up where the interrupted one stopped.
+func (w *streamFailoverWriter) continueRequest(textRequest *model.GeneralOpenAIRequest) {
+	w.continuing = w.committed
+	if !w.continuing {
+		return
+	}
+	textRequest.Messages = append(textRequest.Messages, model.Message{
+		Role:    "assistant",
+		Content: w.text.String(),
+	})
+}
+
+// begin starts an attempt on the current writer of the context.
+func (w *streamFailoverWriter) begin(c *gin.Context) {
+	w.ResponseWriter = c.Writer
+	w.pending = nil
+	w.buffered = nil
+	c.Writer = w
+}
+
+// end gives the context its own writer back.
+func (w *streamFailoverWriter) end(c *gin.Context) {
+	c.Writer = w.ResponseWriter
+}
+
+// canContinue tells whether an interrupted response can be continued on another channel,
+// tool call arguments can't be stitched.
+func (w *streamFailoverWriter) canContinue() bool {
+	return w.committed && !w.toolCalls
+}
+
+func (w *streamFailoverWriter) Write(data []byte) (int, error) {
+	w.pending = append(w.pending, data...)
+	for {
+		index := bytes.Index(w.pending, []byte("\n\n"))
+		if index < 0 {
+			break
+		}
+		event := w.pending[:index+2]
+		w.pending = w.pending[index+2:]
+		w.handleEvent(event)
+	}
+	return len(data), nil
+}
+
+func (w *streamFailoverWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *streamFailoverWriter) Flush() {
+	if w.committed {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *streamFailoverWriter) handleEvent(event []byte) {
+	data := strings.TrimSpace(string(event))
+	if data == "data: [DONE]" {
+		return
+	}
+	if strings.HasPrefix(data, "data: ") {
+		var chunk streamFailoverChunk
+		if json.Unmarshal([]byte(data[6:]), &chunk) == nil {
+			if w.continuing {
+				event = w.stitch(data[6:], &chunk)
+				if event == nil {
+					return
+				}
+			} else if w.id == "" {
+				w.id, w.model = chunk.Id, chunk.Model
+			}
+			for _, choice := range chunk.Choices {
+				w.text.WriteString(common.AsString(choice.Delta.Content))
+				if len(choice.Delta.ToolCalls) > 0 && string(choice.Delta.ToolCalls) != "null" {
+					w.toolCalls = true
+				}
+			}
+		}
+	}
+	if w.committed {
+		_, _ = w.ResponseWriter.Write(event)
+		return
+	}
+	w.buffered = append(w.buffered, append([]byte{}, event...))
+	if len(w.buffered) >= w.bufferEvents {
+		w.commit()
+	}
+}
+
+// stitch rewrites a chunk of the continuing channel to look like the rest of the stream,
+// the leading chunk carrying only the role is dropped.
+func (w *streamFailoverWriter) stitch(data string, chunk *streamFailoverChunk) []byte {
+	empty := true
+	for _, choice := range chunk.Choices {
+		if common.AsString(choice.Delta.Content) != "" || choice.FinishReason != nil || len(choice.Delta.ToolCalls) > 0 {
+			empty = false
+		}
+	}
+	if empty && len(chunk.Choices) > 0 {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal([]byte(data), &fields) != nil {
+		return []byte("data: " + data + "\n\n")
+	}
+	if w.id != "" {
+		fields["id"], _ = json.Marshal(w.id)
+	}
+	if w.model != "" {
+		fields["model"], _ = json.Marshal(w.model)
+	}
+	jsonData, err := json.Marshal(fields)
+	if err != nil {
+		return []byte("data: " + data + "\n\n")
+	}
+	return []byte("data: " + string(jsonData) + "\n\n")
+}
+
+// commit sends the events held back so far, the rest of the stream goes to the client as it comes.
+func (w *streamFailoverWriter) commit() {
+	for _, event := range w.buffered {
+		_, _ = w.ResponseWriter.Write(event)
+	}
+	w.buffered = nil
+	w.committed = true
+	w.ResponseWriter.Flush()
+}
+
+// discard drops the events held back, the client has seen nothing of this attempt.
+func (w *streamFailoverWriter) discard() {
+	w.buffered = nil
+	w.pending = nil
+	if !w.committed {
+		w.text.Reset()
+		w.id, w.model = "", ""
+		w.toolCalls = false
+	}
+}
+
+// complete ends the stream for the client.
+func (w *streamFailoverWriter) complete() {
+	w.commit()
+	_, _ = w.ResponseWriter.Write([]byte("data: [DONE]\n\n"))
+	w.ResponseWriter.Flush()
+}
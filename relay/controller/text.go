@@ -2,19 +2,20 @@ package controller
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"one-api/common"
-	"one-api/common/config"
+	"one-api/common/ctxkey"
 	"one-api/common/logger"
 	"one-api/relay/channel/openai"
 	"one-api/relay/helper"
 	"one-api/relay/model"
 	"one-api/relay/util"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
@@ -41,32 +42,15 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 	meta.OriginModelName = textRequest.Model
 	textRequest.Model, _ = util.GetMappedModelName(textRequest.Model, meta.ModelMapping)
 	meta.ActualModelName = textRequest.Model
+	// 流式输出中途中断时，由下一个渠道接续已发送的内容
+	failover := getStreamFailoverWriter(c, meta)
+	if failover != nil {
+		failover.continueRequest(textRequest)
+	}
 	// get model ratio & group ratio
-	modelRatio := common.GetModelRatio(textRequest.Model)
-	groupRatio := common.GetGroupRatio(meta.Group)
 	promptTokens := getPromptTokens(textRequest, meta.Mode)
 	meta.PromptTokens = promptTokens
-	ratio := modelRatio * groupRatio
-	preConsumedTokens := promptTokens
-	if config.PreConsumedQuota <= 0 {
-		config.PreConsumedQuota = 500
-	}
-	if preConsumedTokens <= 0 {
-		preConsumedTokens = config.PreConsumedQuota
-	}
-	BillingByRequestEnabled, _ := strconv.ParseBool(config.OptionMap["BillingByRequestEnabled"])
-	ModelRatioEnabled, _ := strconv.ParseBool(config.OptionMap["ModelRatioEnabled"])
-	preConsumedQuota := int(float64(preConsumedTokens) * ratio)
-	if BillingByRequestEnabled {
-		shouldUseModelRatio2 := !ModelRatioEnabled || (ModelRatioEnabled && meta.BillingEnabled)
-		if shouldUseModelRatio2 {
-			modelRatio2, ok := common.GetModelRatio2(meta.OriginModelName)
-			if ok {
-				ratio = modelRatio2 * groupRatio
-				preConsumedQuota = int(ratio * config.QuotaPerUnit)
-			}
-		}
-	}
+	ratio, modelRatio, groupRatio, preConsumedQuota := getTextQuotaRatio(meta, textRequest.Model, promptTokens)
 
 	preConsumedQuota, bizErr := preConsumeQuota(ctx, preConsumedQuota, meta)
 
@@ -75,6 +59,26 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 		return bizErr
 	}
 
+	// 相同请求直接返回缓存的响应，按缓存命中倍率计费
+	cacheKey := ""
+	cacheTTL := getResponseCacheTTL(c, meta)
+	if cacheTTL > 0 && (failover == nil || !failover.continuing) {
+		cacheKey, err = getResponseCacheKey(meta, textRequest)
+		if err != nil {
+			logger.Errorf(ctx, "get response cache key failed: %s", err.Error())
+		}
+	}
+	if cacheKey != "" {
+		if cached, ok := getCachedResponse(cacheKey); ok {
+			replayCachedResponse(c, cached)
+			meta.CacheHit = true
+			meta.ChannelId = 0
+			meta.ChannelName = "响应缓存"
+			go postConsumeQuota(context.WithValue(ctx, common.ResponseCacheKey, true), &cached.Usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, cached.AIText, 0)
+			return nil
+		}
+	}
+
 	adaptor := helper.GetAdaptor(meta.APIType)
 	if adaptor == nil {
 		return openai.ErrorWrapper(fmt.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
@@ -145,25 +149,81 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 
 	if err != nil {
 		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
 		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
 	}
 
 	statusCodeMappingStr := c.GetString("status_code_mapping")
 	if isErrorHappened(meta, resp) {
-		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
 		openaiErr := util.RelayErrorHandler(resp)
 		// reset status code 重置状态码
 		util.ResetStatusCode(openaiErr, statusCodeMappingStr)
 		return openaiErr
 	}
 
+	c.Set(ctxkey.StreamInterrupted, false)
+	if failover != nil {
+		failover.begin(c)
+	}
+	var cacheWriter *responseCacheWriter
+	if cacheKey != "" {
+		c.Writer.Header().Set(common.ResponseCacheKey, "MISS")
+		cacheWriter = &responseCacheWriter{ResponseWriter: c.Writer}
+		c.Writer = cacheWriter
+	}
 	// 执行 DoResponse 方法
 	aitext, usage, respErr := adaptor.DoResponse(c, resp, meta)
+	// 对冲请求中落后的一方已被取消，只有先返回的一方计费
+	if c.GetBool(ctxkey.HedgeLost) {
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		return nil
+	}
+	if cacheWriter != nil {
+		c.Writer = cacheWriter.ResponseWriter
+		// 中断的流式响应不完整，不缓存
+		if respErr == nil && !c.GetBool(ctxkey.StreamInterrupted) {
+			saveCachedResponse(ctx, cacheKey, cacheTTL, cacheWriter, usage, aitext)
+		}
+	}
+	if failover != nil {
+		failover.end(c)
+		interrupted := respErr == nil && c.GetBool(ctxkey.StreamInterrupted)
+		if respErr != nil || interrupted {
+			failover.discard()
+		}
+		if interrupted && !failover.committed {
+			// 客户端尚未收到任何内容，换一个渠道重新请求
+			util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+			return openai.ErrorWrapper(errors.New("upstream stream interrupted"), "stream_interrupted", http.StatusBadGateway)
+		}
+		if interrupted && failover.canContinue() {
+			// 已发送的部分照常计费，剩余内容由下一个渠道接续
+			logger.Warnf(ctx, "stream interrupted on channel #%d, continuing on another channel", meta.ChannelId)
+			go postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, aitext, int(time.Since(startTime).Seconds()))
+			return openai.ErrorWrapper(errors.New("upstream stream interrupted"), "stream_interrupted", http.StatusBadGateway)
+		}
+		// 工具调用的参数无法拼接，客户端已收到的部分不能由其他渠道接续，不再重试，以错误事件结束而不是 [DONE]
+		if interrupted {
+			logger.Warnf(ctx, "stream interrupted on channel #%d and can't be continued", meta.ChannelId)
+			c.Set(ctxkey.StreamAborted, true)
+			go postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, aitext, int(time.Since(startTime).Seconds()))
+			return openai.ErrorWrapper(errors.New("upstream stream interrupted"), "stream_interrupted", http.StatusBadGateway)
+		}
+		if respErr != nil && failover.committed && !failover.canContinue() {
+			c.Set(ctxkey.StreamAborted, true)
+		}
+		if respErr == nil {
+			failover.complete()
+		}
+	}
 	if respErr != nil {
-		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+		util.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
 		util.ResetStatusCode(respErr, statusCodeMappingStr)
 		return respErr
 	}
+	// 对冲请求会在请求开始后补充尝试记录
+	meta.AttemptsLog = c.GetString("attemptsLog")
 	// 记录结束时间
 	endTime := time.Now()
 
@@ -0,0 +1,190 @@
+package model
+
+// https://platform.openai.com/docs/api-reference/assistants
+
+type AssistantRequest struct {
+	Model          *string           `json:"model,omitempty"`
+	Name           *string           `json:"name,omitempty"`
+	Description    *string           `json:"description,omitempty"`
+	Instructions   *string           `json:"instructions,omitempty"`
+	Tools          []Tool            `json:"tools,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+	Temperature    *float64          `json:"temperature,omitempty"`
+	TopP           *float64          `json:"top_p,omitempty"`
+	ResponseFormat any               `json:"response_format,omitempty"`
+}
+
+type AssistantObject struct {
+	Id             string            `json:"id"`
+	Object         string            `json:"object"`
+	CreatedAt      int64             `json:"created_at"`
+	Name           string            `json:"name"`
+	Description    string            `json:"description"`
+	Model          string            `json:"model"`
+	Instructions   string            `json:"instructions"`
+	Tools          []Tool            `json:"tools"`
+	Metadata       map[string]string `json:"metadata"`
+	Temperature    *float64          `json:"temperature"`
+	TopP           *float64          `json:"top_p"`
+	ResponseFormat any               `json:"response_format"`
+}
+
+type ThreadRequest struct {
+	Messages []ThreadMessageRequest `json:"messages,omitempty"`
+	Metadata map[string]string      `json:"metadata,omitempty"`
+}
+
+type ThreadObject struct {
+	Id        string            `json:"id"`
+	Object    string            `json:"object"`
+	CreatedAt int64             `json:"created_at"`
+	Metadata  map[string]string `json:"metadata"`
+}
+
+type ThreadMessageRequest struct {
+	Role     string            `json:"role"`
+	Content  any               `json:"content"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type MessageText struct {
+	Value       string `json:"value"`
+	Annotations []any  `json:"annotations"`
+}
+
+// MessageContent is a content part of a thread message
+type MessageContent struct {
+	Type      string       `json:"type"`
+	Text      *MessageText `json:"text,omitempty"`
+	ImageUrl  *ImageURL    `json:"image_url,omitempty"`
+	ImageFile any          `json:"image_file,omitempty"`
+}
+
+type ThreadMessageObject struct {
+	Id          string            `json:"id"`
+	Object      string            `json:"object"`
+	CreatedAt   int64             `json:"created_at"`
+	ThreadId    string            `json:"thread_id"`
+	Status      string            `json:"status"`
+	Role        string            `json:"role"`
+	Content     []MessageContent  `json:"content"`
+	AssistantId *string           `json:"assistant_id"`
+	RunId       *string           `json:"run_id"`
+	Attachments []any             `json:"attachments"`
+	Metadata    map[string]string `json:"metadata"`
+}
+
+type RunRequest struct {
+	AssistantId            string                 `json:"assistant_id"`
+	Model                  string                 `json:"model,omitempty"`
+	Instructions           *string                `json:"instructions,omitempty"`
+	AdditionalInstructions string                 `json:"additional_instructions,omitempty"`
+	AdditionalMessages     []ThreadMessageRequest `json:"additional_messages,omitempty"`
+	Tools                  []Tool                 `json:"tools,omitempty"`
+	Metadata               map[string]string      `json:"metadata,omitempty"`
+	Temperature            *float64               `json:"temperature,omitempty"`
+	TopP                   *float64               `json:"top_p,omitempty"`
+	MaxCompletionTokens    int                    `json:"max_completion_tokens,omitempty"`
+	Stream                 bool                   `json:"stream,omitempty"`
+	Thread                 *ThreadRequest         `json:"thread,omitempty"`
+}
+
+type RunError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type SubmitToolOutputs struct {
+	ToolCalls []Tool `json:"tool_calls"`
+}
+
+type RequiredAction struct {
+	Type              string            `json:"type"`
+	SubmitToolOutputs SubmitToolOutputs `json:"submit_tool_outputs"`
+}
+
+type RunObject struct {
+	Id                  string            `json:"id"`
+	Object              string            `json:"object"`
+	CreatedAt           int64             `json:"created_at"`
+	ThreadId            string            `json:"thread_id"`
+	AssistantId         string            `json:"assistant_id"`
+	Status              string            `json:"status"`
+	RequiredAction      *RequiredAction   `json:"required_action"`
+	LastError           *RunError         `json:"last_error"`
+	ExpiresAt           int64             `json:"expires_at,omitempty"`
+	StartedAt           int64             `json:"started_at,omitempty"`
+	CancelledAt         int64             `json:"cancelled_at,omitempty"`
+	FailedAt            int64             `json:"failed_at,omitempty"`
+	CompletedAt         int64             `json:"completed_at,omitempty"`
+	Model               string            `json:"model"`
+	Instructions        string            `json:"instructions"`
+	Tools               []Tool            `json:"tools"`
+	Metadata            map[string]string `json:"metadata"`
+	Usage               *Usage            `json:"usage"`
+	Temperature         *float64          `json:"temperature"`
+	TopP                *float64          `json:"top_p"`
+	MaxCompletionTokens int               `json:"max_completion_tokens,omitempty"`
+}
+
+type ToolOutput struct {
+	ToolCallId string `json:"tool_call_id"`
+	Output     string `json:"output"`
+}
+
+type SubmitToolOutputsRequest struct {
+	ToolOutputs []ToolOutput `json:"tool_outputs"`
+}
+
+type RunStepToolCall struct {
+	Id       string       `json:"id"`
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name      string  `json:"name"`
+	Arguments string  `json:"arguments"`
+	Output    *string `json:"output"`
+}
+
+type MessageCreation struct {
+	MessageId string `json:"message_id"`
+}
+
+type RunStepDetails struct {
+	Type            string            `json:"type"`
+	MessageCreation *MessageCreation  `json:"message_creation,omitempty"`
+	ToolCalls       []RunStepToolCall `json:"tool_calls,omitempty"`
+}
+
+type RunStepObject struct {
+	Id          string         `json:"id"`
+	Object      string         `json:"object"`
+	CreatedAt   int64          `json:"created_at"`
+	AssistantId string         `json:"assistant_id"`
+	ThreadId    string         `json:"thread_id"`
+	RunId       string         `json:"run_id"`
+	Type        string         `json:"type"`
+	Status      string         `json:"status"`
+	StepDetails RunStepDetails `json:"step_details"`
+	LastError   *RunError      `json:"last_error"`
+	CancelledAt int64          `json:"cancelled_at,omitempty"`
+	FailedAt    int64          `json:"failed_at,omitempty"`
+	CompletedAt int64          `json:"completed_at,omitempty"`
+	Usage       *Usage         `json:"usage"`
+}
+
+type ListResponse struct {
+	Object  string `json:"object"`
+	Data    any    `json:"data"`
+	FirstId string `json:"first_id"`
+	LastId  string `json:"last_id"`
+	HasMore bool   `json:"has_more"`
+}
+
+type DeleteResponse struct {
+	Id      string `json:"id"`
+	Object  string `json:"object"`
+	Deleted bool   `json:"deleted"`
+}
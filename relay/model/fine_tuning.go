@@ -0,0 +1,20 @@
+package model
+
+import "encoding/json"
+
+// FineTuningJob holds the fields of https://platform.openai.com/docs/api-reference/fine-tuning/object
+// the gateway keeps track of, the job object itself is passed through unchanged.
+type FineTuningJob struct {
+	Id             string  `json:"id"`
+	Object         string  `json:"object"`
+	Model          string  `json:"model"`
+	FineTunedModel *string `json:"fine_tuned_model"`
+	Status         string  `json:"status"`
+	CreatedAt      int64   `json:"created_at"`
+}
+
+type FineTuningJobListResponse struct {
+	Object  string            `json:"object"`
+	Data    []json.RawMessage `json:"data"`
+	HasMore bool              `json:"has_more"`
+}
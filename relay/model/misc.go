@@ -1,9 +1,60 @@
 package model
 
 type Usage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens            int                      `json:"prompt_tokens"`
+	CompletionTokens        int                      `json:"completion_tokens"`
+	TotalTokens             int                      `json:"total_tokens"`
+	PromptTokensDetails     *PromptTokensDetails     `json:"prompt_tokens_details,omitempty"`
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+}
+
+// PromptTokensDetails breaks down the prompt tokens, all of them are included in PromptTokens.
+// CacheCreationTokens are the Anthropic prompt tokens written to the cache.
+type PromptTokensDetails struct {
+	CachedTokens        int `json:"cached_tokens"`
+	CacheCreationTokens int `json:"cache_creation_tokens,omitempty"`
+	AudioTokens         int `json:"audio_tokens,omitempty"`
+}
+
+// CompletionTokensDetails breaks down the completion tokens, all of them are included in CompletionTokens.
+type CompletionTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+	AudioTokens     int `json:"audio_tokens,omitempty"`
+}
+
+func (u *Usage) CachedTokens() int {
+	if u.PromptTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.CachedTokens
+}
+
+func (u *Usage) CacheCreationTokens() int {
+	if u.PromptTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.CacheCreationTokens
+}
+
+func (u *Usage) PromptAudioTokens() int {
+	if u.PromptTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.AudioTokens
+}
+
+func (u *Usage) ReasoningTokens() int {
+	if u.CompletionTokensDetails == nil {
+		return 0
+	}
+	return u.CompletionTokensDetails.ReasoningTokens
+}
+
+func (u *Usage) CompletionAudioTokens() int {
+	if u.CompletionTokensDetails == nil {
+		return 0
+	}
+	return u.CompletionTokensDetails.AudioTokens
 }
 
 type Error struct {
@@ -15,5 +66,6 @@ type Error struct {
 
 type ErrorWithStatusCode struct {
 	Error
-	StatusCode int `json:"status_code"`
+	StatusCode int  `json:"status_code"`
+	LocalError bool `json:"-"` // 请求本身的问题，与渠道无关，不重试也不禁用渠道
 }
@@ -0,0 +1,35 @@
+package model
+
+// RealtimeEvent holds the fields of the Realtime API events the relay looks at,
+// https://platform.openai.com/docs/api-reference/realtime-server-events
+type RealtimeEvent struct {
+	Type     string            `json:"type"`
+	EventId  string            `json:"event_id,omitempty"`
+	Response *RealtimeResponse `json:"response,omitempty"`
+	Error    *Error            `json:"error,omitempty"`
+}
+
+type RealtimeResponse struct {
+	Id     string         `json:"id,omitempty"`
+	Status string         `json:"status,omitempty"`
+	Usage  *RealtimeUsage `json:"usage,omitempty"`
+}
+
+type RealtimeInputTokenDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+	TextTokens   int `json:"text_tokens"`
+	AudioTokens  int `json:"audio_tokens"`
+}
+
+type RealtimeOutputTokenDetails struct {
+	TextTokens  int `json:"text_tokens"`
+	AudioTokens int `json:"audio_tokens"`
+}
+
+type RealtimeUsage struct {
+	TotalTokens        int                        `json:"total_tokens"`
+	InputTokens        int                        `json:"input_tokens"`
+	OutputTokens       int                        `json:"output_tokens"`
+	InputTokenDetails  RealtimeInputTokenDetails  `json:"input_token_details"`
+	OutputTokenDetails RealtimeOutputTokenDetails `json:"output_token_details"`
+}
@@ -0,0 +1,34 @@
+package model
+
+// RerankRequest follows the request shared by Jina and Cohere,
+// https://jina.ai/reranker and https://docs.cohere.com/reference/rerank
+type RerankRequest struct {
+	Model           string `json:"model"`
+	Query           string `json:"query"`
+	Documents       []any  `json:"documents"`
+	TopN            int    `json:"top_n,omitempty"`
+	ReturnDocuments *bool  `json:"return_documents,omitempty"`
+	MaxChunksPerDoc int    `json:"max_chunks_per_doc,omitempty"`
+}
+
+type RerankResult struct {
+	Index          int     `json:"index"`
+	RelevanceScore float64 `json:"relevance_score"`
+	Document       any     `json:"document,omitempty"`
+}
+
+type RerankBilledUnits struct {
+	SearchUnits int `json:"search_units,omitempty"`
+}
+
+type RerankMeta struct {
+	BilledUnits *RerankBilledUnits `json:"billed_units,omitempty"`
+}
+
+type RerankResponse struct {
+	Id      string         `json:"id,omitempty"`
+	Model   string         `json:"model,omitempty"`
+	Results []RerankResult `json:"results"`
+	Usage   *Usage         `json:"usage,omitempty"`
+	Meta    *RerankMeta    `json:"meta,omitempty"`
+}
@@ -0,0 +1,126 @@
+package model
+
+import "encoding/json"
+
+// https://platform.openai.com/docs/api-reference/responses
+
+type ResponsesRequest struct {
+	Model              string          `json:"model"`
+	Input              json.RawMessage `json:"input,omitempty"`
+	Instructions       string          `json:"instructions,omitempty"`
+	MaxOutputTokens    uint            `json:"max_output_tokens,omitempty"`
+	Temperature        *float64        `json:"temperature,omitempty"`
+	TopP               *float64        `json:"top_p,omitempty"`
+	Stream             bool            `json:"stream,omitempty"`
+	Tools              []ResponseTool  `json:"tools,omitempty"`
+	ToolChoice         any             `json:"tool_choice,omitempty"`
+	ParallelToolCalls  *bool           `json:"parallel_tool_calls,omitempty"`
+	Text               *ResponseText   `json:"text,omitempty"`
+	PreviousResponseId string          `json:"previous_response_id,omitempty"`
+	Store              *bool           `json:"store,omitempty"`
+	Metadata           any             `json:"metadata,omitempty"`
+	User               string          `json:"user,omitempty"`
+}
+
+type ResponseTool struct {
+	Type        string `json:"type"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+	Strict      *bool  `json:"strict,omitempty"`
+}
+
+type ResponseText struct {
+	Format *ResponseTextFormat `json:"format,omitempty"`
+}
+
+type ResponseTextFormat struct {
+	Type   string `json:"type"`
+	Name   string `json:"name,omitempty"`
+	Schema any    `json:"schema,omitempty"`
+	Strict *bool  `json:"strict,omitempty"`
+}
+
+// ResponseInputItem is an item of the input list, either a message or a function call and its output
+type ResponseInputItem struct {
+	Type      string          `json:"type,omitempty"`
+	Role      string          `json:"role,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+	CallId    string          `json:"call_id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Arguments string          `json:"arguments,omitempty"`
+	Output    string          `json:"output,omitempty"`
+}
+
+type ResponseContent struct {
+	Type        string `json:"type"`
+	Text        string `json:"text,omitempty"`
+	ImageUrl    string `json:"image_url,omitempty"`
+	Detail      string `json:"detail,omitempty"`
+	Annotations []any  `json:"annotations,omitempty"`
+}
+
+type ResponseOutputItem struct {
+	Type      string            `json:"type"`
+	Id        string            `json:"id"`
+	Status    string            `json:"status"`
+	Role      string            `json:"role,omitempty"`
+	Content   []ResponseContent `json:"content,omitempty"`
+	CallId    string            `json:"call_id,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Arguments string            `json:"arguments,omitempty"`
+}
+
+type ResponseUsage struct {
+	InputTokens         int                          `json:"input_tokens"`
+	InputTokensDetails  *ResponseInputTokensDetails  `json:"input_tokens_details,omitempty"`
+	OutputTokens        int                          `json:"output_tokens"`
+	OutputTokensDetails *ResponseOutputTokensDetails `json:"output_tokens_details,omitempty"`
+	TotalTokens         int                          `json:"total_tokens"`
+}
+
+type ResponseInputTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+}
+
+type ResponseOutputTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+}
+
+type ResponseIncompleteDetails struct {
+	Reason string `json:"reason"`
+}
+
+type ResponseObject struct {
+	Id                string                     `json:"id"`
+	Object            string                     `json:"object"`
+	CreatedAt         int64                      `json:"created_at"`
+	Status            string                     `json:"status"`
+	Error             *Error                     `json:"error"`
+	IncompleteDetails *ResponseIncompleteDetails `json:"incomplete_details"`
+	Instructions      string                     `json:"instructions,omitempty"`
+	MaxOutputTokens   uint                       `json:"max_output_tokens,omitempty"`
+	Model             string                     `json:"model"`
+	Output            []ResponseOutputItem       `json:"output"`
+	Temperature       *float64                   `json:"temperature,omitempty"`
+	TopP              *float64                   `json:"top_p,omitempty"`
+	Tools             []ResponseTool             `json:"tools"`
+	ToolChoice        any                        `json:"tool_choice,omitempty"`
+	Usage             *ResponseUsage             `json:"usage,omitempty"`
+	Metadata          any                        `json:"metadata,omitempty"`
+}
+
+// ResponseStreamEvent is a server-sent event of a streamed response, only the fields of its type are set
+type ResponseStreamEvent struct {
+	Type           string              `json:"type"`
+	SequenceNumber int                 `json:"sequence_number"`
+	Response       *ResponseObject     `json:"response,omitempty"`
+	OutputIndex    *int                `json:"output_index,omitempty"`
+	ContentIndex   *int                `json:"content_index,omitempty"`
+	ItemId         string              `json:"item_id,omitempty"`
+	Item           *ResponseOutputItem `json:"item,omitempty"`
+	Part           *ResponseContent    `json:"part,omitempty"`
+	Delta          string              `json:"delta,omitempty"`
+	Text           string              `json:"text,omitempty"`
+	Arguments      string              `json:"arguments,omitempty"`
+}
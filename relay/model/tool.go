@@ -2,6 +2,7 @@ package model
 
 type Tool struct {
 	Id       string   `json:"id,omitempty"`
+	Index    *int     `json:"index,omitempty"` // 流式响应中标识所属的调用
 	Type     string   `json:"type"`
 	Function Function `json:"function"`
 }
@@ -6,14 +6,15 @@ import (
 	"one-api/model"
 )
 
-func ReturnPreConsumedQuota(ctx context.Context, preConsumedQuota int, tokenId int) {
-	if preConsumedQuota != 0 {
-		go func(ctx context.Context) {
+// ReturnPreConsumedQuota gives back the quota pre-consumed and the budget reserved for the request when it fails.
+func ReturnPreConsumedQuota(ctx context.Context, preConsumedQuota int, meta *RelayMeta) {
+	if preConsumedQuota != 0 || meta.BudgetReserved != 0 {
+		go func(ctx context.Context, tokenId int, budgetReserved int) {
 			// return pre-consumed quota
-			err := model.PostConsumeTokenQuota(tokenId, -preConsumedQuota)
+			err := model.PostConsumeTokenQuotaWithBudget(ctx, tokenId, -preConsumedQuota, budgetReserved)
 			if err != nil {
 				logger.Error(ctx, "error return pre-consumed quota: "+err.Error())
 			}
-		}(ctx)
+		}(ctx, meta.TokenId, meta.BudgetReserved)
 	}
 }
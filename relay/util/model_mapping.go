@@ -1,10 +1,17 @@
 package util
 
+import "one-api/common"
+
+// GetMappedModelName maps the model by its own entry of mapping, otherwise by the longest matching pattern.
 func GetMappedModelName(modelName string, mapping map[string]string) (string, bool) {
 	if mapping == nil {
 		return modelName, false
 	}
-	mappedModelName := mapping[modelName]
+	key, ok := common.FindModelKey(mapping, modelName)
+	if !ok {
+		return modelName, false
+	}
+	mappedModelName := mapping[key]
 	if mappedModelName != "" {
 		return mappedModelName, true
 	}
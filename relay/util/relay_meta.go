@@ -6,6 +6,7 @@ import (
 	"one-api/model"
 	"one-api/relay/constant"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -33,10 +34,17 @@ type RelayMeta struct {
 	PromptTokens    int // only for DoResponse
 	FixedContent    string
 	IsClaude        bool
+	ClaudeNative    bool // 渠道标记为直接处理 Claude 原生请求
 	BillingEnabled  bool
 	UnlimitedQuota  bool
+	BudgetEnabled   bool // 令牌设置了周期预算
+	BudgetReserved  int  // 未预扣费时按预估额度占用的预算，结算时扣除
 	ProxyURL        string
 	RelayIp         string
+	BatchId         string
+	CacheHit        bool                     // 响应来自缓存
+	StartTime       time.Time                // 请求开始时间，分时计价以此为准
+	LimitLease      *model.ChannelLimitLease // 选择渠道时预占的 TPM，记录实际用量时结算
 }
 
 func GetRelayMeta(c *gin.Context) *RelayMeta {
@@ -58,8 +66,12 @@ func GetRelayMeta(c *gin.Context) *RelayMeta {
 		AttemptsLog:    c.GetString("attemptsLog"),
 		BillingEnabled: c.GetBool("billing_enabled"),
 		UnlimitedQuota: c.GetBool("token_unlimited_quota"),
+		BudgetEnabled:  c.GetBool("token_budget_enabled"),
+		ClaudeNative:   c.GetBool(ctxkey.ClaudeNative),
 		ProxyURL:       c.GetString("proxy_url"),
 		RelayIp:        c.GetString("relayIp"),
+		BatchId:        c.GetString("batch_id"),
+		StartTime:      time.Now(),
 	}
 
 	if meta.BaseURL == "" {
@@ -69,6 +81,9 @@ func GetRelayMeta(c *gin.Context) *RelayMeta {
 	if ok {
 		meta.Config = cfg.(model.ChannelConfig)
 	}
+	if lease, ok := c.Get("channel_limit_lease"); ok {
+		meta.LimitLease, _ = lease.(*model.ChannelLimitLease)
+	}
 	meta.APIType = constant.ChannelType2APIType(meta.ChannelType)
 	return &meta
 }
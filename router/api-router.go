@@ -54,6 +54,9 @@ func SetApiRouter(router *gin.Engine) {
 				selfRoute.GET("/option", controller.GetUserOptions)
 				selfRoute.GET("/userwithdrawals", controller.GetWithdrawalOrdersEndpoint) // 获取用户自己的提现订单列表
 				selfRoute.GET("/group", controller.GetUserGroups)
+				selfRoute.GET("/plans", controller.GetPlans)
+				selfRoute.GET("/subscription", controller.GetSelfSubscriptions)
+				selfRoute.POST("/subscription/pay", controller.RequestSubscriptionEpay)
 			}
 
 			adminRoute := userRoute.Group("/")
@@ -85,7 +88,11 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/", controller.GetAllChannels)
 			channelRoute.GET("/search", controller.SearchChannels)
 			channelRoute.GET("/models", controller.ListChannelModels)
+			channelRoute.GET("/breaker", controller.GetChannelBreakers)
+			channelRoute.DELETE("/breaker/:id", controller.ResetChannelBreaker)
 			channelRoute.GET("/:id", controller.GetChannel)
+			channelRoute.GET("/:id/keys", controller.GetChannelKeys)
+			channelRoute.PUT("/:id/keys/:key_id", controller.UpdateChannelKeyStatus)
 			channelRoute.GET("/test", controller.TestAllChannels)
 			channelRoute.GET("/test/:id", controller.TestChannel)
 			channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
@@ -96,6 +103,9 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.DELETE("/:id", controller.DeleteChannel)
 			channelRoute.POST("/batch", controller.DeleteChannelBatch)
 			channelRoute.GET("/fetch_models/:id", controller.FetchUpstreamModels)
+			channelRoute.POST("/sync_models", controller.SyncAllChannelModels)
+			channelRoute.POST("/sync_models/:id", controller.SyncChannelModels)
+			channelRoute.GET("/model_changes", controller.GetChannelModelChanges)
 		}
 		tokenRoute := apiRouter.Group("/token")
 		tokenRoute.Use(middleware.UserAuth())
@@ -103,6 +113,7 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.GET("/", controller.GetAllTokens)
 			tokenRoute.GET("/search", controller.SearchTokens)
 			tokenRoute.GET("/:id", controller.GetToken)
+			tokenRoute.GET("/:id/budget", controller.GetTokenBudget)
 			tokenRoute.PUT("/", controller.UpdateToken)
 			tokenRoute.POST("/", controller.AddToken)
 			tokenRoute.PUT("/:id/billing_strategy", controller.UpdateTokenBillingStrategy)
@@ -127,6 +138,27 @@ func SetApiRouter(router *gin.Engine) {
 			topupsRoute.DELETE("/delete", controller.DeleteTopUp)
 		}
 
+		planRoute := apiRouter.Group("/plan")
+		planRoute.Use(middleware.AdminAuth())
+		{
+			planRoute.GET("/", controller.GetAllPlans)
+			planRoute.POST("/", controller.AddPlan)
+			planRoute.PUT("/", controller.UpdatePlan)
+			planRoute.DELETE("/:id", controller.DeletePlan)
+		}
+		subscriptionRoute := apiRouter.Group("/subscription")
+		subscriptionRoute.Use(middleware.AdminAuth())
+		{
+			subscriptionRoute.GET("/", controller.GetAllSubscriptions)
+			subscriptionRoute.POST("/", controller.GrantSubscription)
+			subscriptionRoute.DELETE("/:id", controller.CancelSubscription)
+		}
+		ledgerRoute := apiRouter.Group("/ledger")
+		ledgerRoute.Use(middleware.AdminAuth())
+		{
+			ledgerRoute.GET("/", controller.GetQuotaLedgers)
+			ledgerRoute.POST("/reconcile", controller.ReconcileQuotaLedger)
+		}
 		logRoute := apiRouter.Group("/log")
 		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
 		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
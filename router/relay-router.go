@@ -4,6 +4,7 @@ import (
 	"one-api/controller"
 	"one-api/middleware"
 	"one-api/relay/channel/midjourney"
+	relaycontroller "one-api/relay/controller"
 
 	"github.com/gin-gonic/gin"
 )
@@ -38,6 +39,58 @@ func SetRelayRouter(router *gin.Engine) {
 		modelsRouter.GET("", controller.ListModels)
 		modelsRouter.GET("/:model", controller.RetrieveModel)
 	}
+	filesRouter := router.Group("/v1/files")
+	filesRouter.Use(middleware.RelayPanicRecover(), middleware.TokenAuth())
+	{
+		filesRouter.GET("", controller.RelayFile)
+		filesRouter.POST("", controller.RelayFile)
+		filesRouter.GET("/:id", controller.RelayFile)
+		filesRouter.DELETE("/:id", controller.RelayFile)
+		filesRouter.GET("/:id/content", controller.RelayFile)
+	}
+	batchesRouter := router.Group("/v1/batches")
+	batchesRouter.Use(middleware.RelayPanicRecover(), middleware.TokenAuth())
+	{
+		batchesRouter.GET("", controller.RelayBatch)
+		batchesRouter.POST("", controller.RelayBatch)
+		batchesRouter.GET("/:id", controller.RelayBatch)
+		batchesRouter.POST("/:id/cancel", controller.RelayBatch)
+	}
+	fineTuningRouter := router.Group("/v1/fine_tuning/jobs")
+	fineTuningRouter.Use(middleware.RelayPanicRecover(), middleware.TokenAuth())
+	{
+		fineTuningRouter.POST("", controller.RelayFineTuning)
+		fineTuningRouter.GET("", controller.RelayFineTuning)
+		fineTuningRouter.GET("/:id", controller.RelayFineTuning)
+		fineTuningRouter.POST("/:id/cancel", controller.RelayFineTuning)
+		fineTuningRouter.GET("/:id/events", controller.RelayFineTuning)
+	}
+	assistantsRouter := router.Group("/v1")
+	assistantsRouter.Use(middleware.RelayPanicRecover(), middleware.TokenAuth())
+	{
+		assistantsRouter.POST("/assistants", controller.RelayAssistant(relaycontroller.CreateAssistant))
+		assistantsRouter.GET("/assistants", controller.RelayAssistant(relaycontroller.ListAssistants))
+		assistantsRouter.GET("/assistants/:id", controller.RelayAssistant(relaycontroller.RetrieveAssistant))
+		assistantsRouter.POST("/assistants/:id", controller.RelayAssistant(relaycontroller.ModifyAssistant))
+		assistantsRouter.DELETE("/assistants/:id", controller.RelayAssistant(relaycontroller.DeleteAssistant))
+		assistantsRouter.POST("/threads", controller.RelayAssistant(relaycontroller.CreateThread))
+		assistantsRouter.POST("/threads/runs", controller.RelayAssistant(controller.CreateThreadAndRun))
+		assistantsRouter.GET("/threads/:id", controller.RelayAssistant(relaycontroller.RetrieveThread))
+		assistantsRouter.POST("/threads/:id", controller.RelayAssistant(relaycontroller.ModifyThread))
+		assistantsRouter.DELETE("/threads/:id", controller.RelayAssistant(relaycontroller.DeleteThread))
+		assistantsRouter.POST("/threads/:id/messages", controller.RelayAssistant(relaycontroller.CreateThreadMessage))
+		assistantsRouter.GET("/threads/:id/messages", controller.RelayAssistant(relaycontroller.ListThreadMessages))
+		assistantsRouter.GET("/threads/:id/messages/:messageId", controller.RelayAssistant(relaycontroller.RetrieveThreadMessage))
+		assistantsRouter.POST("/threads/:id/messages/:messageId", controller.RelayAssistant(relaycontroller.ModifyThreadMessage))
+		assistantsRouter.POST("/threads/:id/runs", controller.RelayAssistant(controller.CreateRun))
+		assistantsRouter.GET("/threads/:id/runs", controller.RelayAssistant(relaycontroller.ListRuns))
+		assistantsRouter.GET("/threads/:id/runs/:runsId", controller.RelayAssistant(relaycontroller.RetrieveRun))
+		assistantsRouter.POST("/threads/:id/runs/:runsId", controller.RelayAssistant(relaycontroller.ModifyRun))
+		assistantsRouter.POST("/threads/:id/runs/:runsId/submit_tool_outputs", controller.RelayAssistant(controller.SubmitToolOutputs))
+		assistantsRouter.POST("/threads/:id/runs/:runsId/cancel", controller.RelayAssistant(controller.CancelRun))
+		assistantsRouter.GET("/threads/:id/runs/:runsId/steps", controller.RelayAssistant(relaycontroller.ListRunSteps))
+		assistantsRouter.GET("/threads/:id/runs/:runsId/steps/:stepId", controller.RelayAssistant(relaycontroller.RetrieveRunStep))
+	}
 	relayV1Router := router.Group("/v1")
 	relayV1Router.Use(middleware.RelayPanicRecover(), middleware.TokenAuth(), middleware.Distribute())
 	{
@@ -52,45 +105,24 @@ func SetRelayRouter(router *gin.Engine) {
 		relayV1Router.POST("/audio/transcriptions", controller.Relay)
 		relayV1Router.POST("/audio/translations", controller.Relay)
 		relayV1Router.POST("/audio/speech", controller.Relay)
-		relayV1Router.GET("/files", controller.RelayNotImplemented)
-		relayV1Router.POST("/files", controller.RelayNotImplemented)
-		relayV1Router.DELETE("/files/:id", controller.RelayNotImplemented)
-		relayV1Router.GET("/files/:id", controller.RelayNotImplemented)
-		relayV1Router.GET("/files/:id/content", controller.RelayNotImplemented)
-		relayV1Router.POST("/fine_tuning/jobs", controller.RelayNotImplemented)
-		relayV1Router.GET("/fine_tuning/jobs", controller.RelayNotImplemented)
-		relayV1Router.GET("/fine_tuning/jobs/:id", controller.RelayNotImplemented)
-		relayV1Router.POST("/fine_tuning/jobs/:id/cancel", controller.RelayNotImplemented)
-		relayV1Router.GET("/fine_tuning/jobs/:id/events", controller.RelayNotImplemented)
 		relayV1Router.DELETE("/models/:model", controller.RelayNotImplemented)
 		relayV1Router.POST("/moderations", controller.Relay)
-		relayV1Router.POST("/assistants", controller.RelayNotImplemented)
-		relayV1Router.GET("/assistants/:id", controller.RelayNotImplemented)
-		relayV1Router.POST("/assistants/:id", controller.RelayNotImplemented)
-		relayV1Router.DELETE("/assistants/:id", controller.RelayNotImplemented)
-		relayV1Router.GET("/assistants", controller.RelayNotImplemented)
 		relayV1Router.POST("/assistants/:id/files", controller.RelayNotImplemented)
 		relayV1Router.GET("/assistants/:id/files/:fileId", controller.RelayNotImplemented)
 		relayV1Router.DELETE("/assistants/:id/files/:fileId", controller.RelayNotImplemented)
 		relayV1Router.GET("/assistants/:id/files", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id", controller.RelayNotImplemented)
-		relayV1Router.DELETE("/threads/:id", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id/messages", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/messages/:messageId", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id/messages/:messageId", controller.RelayNotImplemented)
 		relayV1Router.GET("/threads/:id/messages/:messageId/files/:filesId", controller.RelayNotImplemented)
 		relayV1Router.GET("/threads/:id/messages/:messageId/files", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id/runs", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/runs/:runsId", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id/runs/:runsId", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/runs", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id/runs/:runsId/submit_tool_outputs", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id/runs/:runsId/cancel", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/runs/:runsId/steps/:stepId", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/runs/:runsId/steps", controller.RelayNotImplemented)
 		relayV1Router.POST("/messages", controller.Relay)
+		relayV1Router.POST("/responses", controller.Relay)
+		relayV1Router.POST("/rerank", controller.Relay)
+		relayV1Router.GET("/realtime", controller.Relay)
+	}
+	// https://ai.google.dev/api/generate-content
+	relayV1BetaRouter := router.Group("/v1beta")
+	relayV1BetaRouter.Use(middleware.RelayPanicRecover(), middleware.TokenAuth(), middleware.Distribute())
+	{
+		relayV1BetaRouter.POST("/models/*path", controller.Relay)
 	}
 	relayMjTurboRouter := router.Group("/mj-turbo/mj")
 	configureMidjourneyRoutes(relayMjTurboRouter)